@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/flthibaud/TwitchLiveNotifier/internal/config"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/discord/twitch"
+)
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `Usage: %s [--config file] [command]
+
+Without command, runs the bot.
+
+Commands:
+  config check                              validate the configuration (file and environment) and exit
+  subs list [--type t] [--status s]         list the EventSub subscriptions of the application
+  subs delete <id> | --all | --orphaned     delete subscriptions (orphaned: failed, revoked, or another callback)
+  subs create <type> <broadcaster>          create a subscription for a broadcaster ID or login
+  users resolve <login>...                  look up the IDs of Twitch users
+  simulate <event> [flags]                  post a signed EventSub message to the local webhook
+                                            (verification, stream.online, stream.offline,
+                                            channel.update, channel.raid, revocation)
+
+Subcommands of subs and users accept --json to print JSON.
+
+Flags:
+`, os.Args[0])
+	flag.PrintDefaults()
+}
+
+// runCommand runs a subcommand and returns the exit code of the process
+func runCommand(configPath string, args []string) int {
+	if args[0] == "simulate" {
+		return simulate(configPath, args[1:])
+	}
+	name := args[0]
+	if len(args) > 1 {
+		name += " " + args[1]
+	}
+	run := map[string]func(configPath string, args []string) int{
+		"config check":  configCheck,
+		"subs list":     subsList,
+		"subs delete":   subsDelete,
+		"subs create":   subsCreate,
+		"users resolve": usersResolve,
+	}[name]
+	if run == nil {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", strings.Join(args, " "))
+		flag.Usage()
+		return 2
+	}
+	return run(configPath, args[2:])
+}
+
+// parseFlags parses the flags of a subcommand wherever they are among its arguments,
+// and returns the other arguments
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	var rest []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return rest, nil
+		}
+		rest = append(rest, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// printJSON writes v as indented JSON on the standard output
+func printJSON(v interface{}) int {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// newTwitchClient creates the Twitch client of the subcommands, tests replace it to call a fake Twitch API
+var newTwitchClient = twitch.NewClient
+
+// twitchClient loads the configuration and returns a Twitch client with its credentials
+func twitchClient(configPath string) (*config.Config, *twitch.Client, error) {
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cfg, newTwitchClient(cfg.TwitchClientID, cfg.TwitchClientSecret), nil
+}
+
+// configCheck loads the configuration like the bot would, and reports the errors or a summary
+func configCheck(configPath string, args []string) int {
+	if len(args) > 0 {
+		fmt.Fprintf(os.Stderr, "unexpected arguments %q\n", strings.Join(args, " "))
+		return 2
+	}
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	source := "environment"
+	if cfg.File != "" {
+		source = cfg.File + " and environment"
+	}
+	fmt.Printf("Configuration OK (%s)\n", source)
+	fmt.Printf("  %d notifier(s):\n", len(cfg.Notifiers))
+	for _, n := range cfg.Notifiers {
+		role := ""
+		if n.RoleID != "" {
+			role = ", pings role " + n.RoleID
+		}
+		fmt.Printf("    - %s: channel %s%s\n", n.Name, n.ChannelID, role)
+	}
+	fmt.Printf("  %d broadcaster(s):\n", len(cfg.Broadcasters))
+	for _, b := range cfg.Broadcasters {
+		name := b.ID
+		if b.Login != "" {
+			name = b.Login + " (" + b.ID + ")"
+		}
+		fmt.Printf("    - %s → %s\n", name, cfg.NotifierFor(b.ID).Name)
+	}
+	return 0
+}
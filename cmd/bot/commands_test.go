@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestParseFlags(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		rest    []string
+		all     bool
+		subType string
+		err     bool
+	}{
+		{name: "none"},
+		{name: "positionals", args: []string{"a", "b"}, rest: []string{"a", "b"}},
+		{name: "flags first", args: []string{"--all", "--type", "stream.online", "a"}, rest: []string{"a"}, all: true, subType: "stream.online"},
+		{name: "flags last", args: []string{"a", "--type=stream.online", "--all"}, rest: []string{"a"}, all: true, subType: "stream.online"},
+		{name: "flags between positionals", args: []string{"a", "--all", "b", "--type", "stream.offline", "c"}, rest: []string{"a", "b", "c"}, all: true, subType: "stream.offline"},
+		{name: "after the terminator", args: []string{"a", "--", "--all"}, rest: []string{"a", "--all"}},
+		{name: "unknown flag", args: []string{"a", "--force"}, err: true},
+		{name: "missing value", args: []string{"a", "--type"}, err: true},
+	}
+	for _, tt := range tests {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.SetOutput(io.Discard)
+		all := fs.Bool("all", false, "")
+		subType := fs.String("type", "", "")
+		rest, err := parseFlags(fs, tt.args)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: parseFlags() error = %v, want error %v", tt.name, err, tt.err)
+			continue
+		}
+		if tt.err {
+			continue
+		}
+		if fmt.Sprint(rest) != fmt.Sprint(tt.rest) || *all != tt.all || *subType != tt.subType {
+			t.Errorf("%s: rest %q, --all %v, --type %q, want %q, %v, %q", tt.name, rest, *all, *subType, tt.rest, tt.all, tt.subType)
+		}
+	}
+}
+
+func TestRunCommandUnknown(t *testing.T) {
+	prev := flag.CommandLine.Output()
+	flag.CommandLine.SetOutput(io.Discard)
+	t.Cleanup(func() { flag.CommandLine.SetOutput(prev) })
+
+	for _, args := range [][]string{{"subs"}, {"subs", "purge"}, {"deploy", "now"}} {
+		if code := runCommand("", args); code != 2 {
+			t.Errorf("runCommand(%q) = %d, want 2", args, code)
+		}
+	}
+	if code := runCommand("", []string{"config", "check", "extra"}); code != 2 {
+		t.Errorf("config check with arguments = %d, want 2", code)
+	}
+}
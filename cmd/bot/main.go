@@ -2,21 +2,36 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
+	_ "time/tzdata" // guild timezones must resolve on hosts without a timezone database
 
 	"github.com/flthibaud/TwitchLiveNotifier/internal/config"
 	"github.com/flthibaud/TwitchLiveNotifier/internal/discord"
 	"github.com/flthibaud/TwitchLiveNotifier/internal/discord/twitch"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/storage"
 	"github.com/flthibaud/TwitchLiveNotifier/internal/utils"
 )
 
+// configPollInterval is the interval between two checks of the modification time of the configuration file
+const configPollInterval = 10 * time.Second
+
 func main() {
-	// Load configuration (.env, flags, etc.)
-	cfg, err := config.Load()
+	configPath := flag.String("config", "", "path of the YAML configuration file (default: $CONFIG_FILE)")
+	flag.Usage = usage
+	flag.Parse()
+
+	// Subcommands run and exit, the bot runs without arguments
+	if args := flag.Args(); len(args) > 0 {
+		os.Exit(runCommand(*configPath, args))
+	}
+
+	// Load configuration (file, .env, environment)
+	cfg, err := config.Load(*configPath)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to load config: %v\n", err)
 		os.Exit(1)
@@ -29,13 +44,26 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// Open the bot state store
+	store, err := storage.Open(cfg.StoragePath)
+	if err != nil {
+		logger.Fatalf("Failed to open storage: %v", err)
+	}
+
 	// Initialize Discord client
-	discordClient, err := discord.NewClient(cfg, logger)
+	discordClient, err := discord.NewClient(cfg, logger, store)
 	if err != nil {
 		logger.Fatalf("Failed to create Discord client: %v", err)
 	}
 
-	twitchServer := twitch.NewServer(cfg, logger, discordClient)
+	twitchServer := twitch.NewServer(cfg, logger, discordClient, store)
+
+	// Apply configuration changes without restarting (SIGHUP or file change)
+	reloader := config.NewReloader(cfg, logger)
+	reloader.OnReload(func(prev, next config.Reloadable) { utils.SetLevel(logger, next.LogLevel) })
+	reloader.OnReload(twitchServer.ApplyConfig)
+	twitchServer.SetReloader(reloader)
+	go reloader.Run(ctx, configPollInterval)
 
 	// Start Twitch webhook server
 	go func() {
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/flthibaud/TwitchLiveNotifier/internal/config"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/discord/twitch"
+)
+
+// simulatedEvents are the events the simulate command can send
+var simulatedEvents = []string{"verification", "stream.online", "stream.offline", "channel.update", "channel.raid", "revocation"}
+
+// simulate posts a signed EventSub message to the webhook of a running bot
+func simulate(configPath string, args []string) int {
+	fs := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	target := fs.String("url", "", "webhook URL (default: http://localhost:$PORT/webhook)")
+	broadcaster := fs.String("broadcaster", "", "broadcaster ID (default: the first configured broadcaster)")
+	login := fs.String("login", "testbroadcaster", "broadcaster login")
+	name := fs.String("name", "TestBroadcaster", "broadcaster display name")
+	from := fs.String("from", "10000001", "raiding broadcaster ID, for channel.raid")
+	viewers := fs.Int("viewers", 42, "viewers of the raid, for channel.raid")
+	title := fs.String("title", "Simulated stream", "stream title, for channel.update")
+	category := fs.String("category", "Just Chatting", "category name, for channel.update")
+	subType := fs.String("type", "stream.online", "subscription type, for verification and revocation")
+	status := fs.String("status", "authorization_revoked", "revocation reason, for revocation")
+	rest, err := parseFlags(fs, args)
+	if err != nil || len(rest) != 1 {
+		return usageError(fs, "simulate <"+strings.Join(simulatedEvents, "|")+"> [flags]")
+	}
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *target == "" {
+		*target = "http://localhost:" + cfg.Port + "/webhook"
+	}
+	b := twitch.SimulatedUser{ID: *broadcaster, Login: *login, Name: *name}
+	if b.ID == "" {
+		b.ID = "12345678"
+		if ids := cfg.BroadcasterIDs(); len(ids) > 0 {
+			b.ID = ids[0]
+		}
+	}
+
+	var msg *twitch.SimulatedMessage
+	switch rest[0] {
+	case "verification":
+		msg = twitch.SimulateVerification(*subType, b)
+	case "stream.online":
+		msg = twitch.SimulateStreamOnline(b)
+	case "stream.offline":
+		msg = twitch.SimulateStreamOffline(b)
+	case "channel.update":
+		msg = twitch.SimulateChannelUpdate(b, *title, "509658", *category)
+	case "channel.raid", "raid":
+		msg = twitch.SimulateRaid(twitch.SimulatedUser{ID: *from, Login: "raider", Name: "Raider"}, b, *viewers)
+	case "revocation":
+		msg = twitch.SimulateRevocation(*subType, b, *status)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown event %q (expected %s)\n", rest[0], strings.Join(simulatedEvents, ", "))
+		return 2
+	}
+	msg.Callback = cfg.CallbackURL + "/webhook"
+
+	req, err := msg.Request(*target, cfg.TwitchWebhookSecret)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	fmt.Printf("%s %s for %s → %s\n", msg.Type, msg.SubscriptionType, b.ID, resp.Status)
+	if resp.StatusCode/100 != 2 {
+		fmt.Fprintf(os.Stderr, "webhook rejected the message: %s\n", strings.TrimSpace(string(body)))
+		return 1
+	}
+	if msg.Type == twitch.MessageVerification && string(body) != msg.Challenge {
+		fmt.Fprintf(os.Stderr, "webhook answered %q instead of the challenge %q\n", body, msg.Challenge)
+		return 1
+	}
+	return 0
+}
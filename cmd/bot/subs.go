@@ -0,0 +1,204 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/flthibaud/TwitchLiveNotifier/internal/discord/twitch"
+)
+
+// subsList prints the EventSub subscriptions with their status, type, cost and callback
+func subsList(configPath string, args []string) int {
+	fs := flag.NewFlagSet("subs list", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print JSON")
+	subType := fs.String("type", "", "only list subscriptions of this type, e.g. stream.online")
+	status := fs.String("status", "", "only list subscriptions with this status, e.g. enabled")
+	rest, err := parseFlags(fs, args)
+	if err != nil || len(rest) > 0 {
+		return usageError(fs, "subs list [--type t] [--status s] [--json]")
+	}
+	_, helix, err := twitchClient(configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	// Helix filters by one criterion only
+	query := url.Values{}
+	if *subType != "" {
+		query.Set("type", *subType)
+	} else if *status != "" {
+		query.Set("status", *status)
+	}
+	list, err := helix.Subscriptions(query)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *subType != "" && *status != "" {
+		kept := list.Data[:0]
+		for _, sub := range list.Data {
+			if sub.Status == *status {
+				kept = append(kept, sub)
+			}
+		}
+		list.Data = kept
+	}
+
+	if *asJSON {
+		return printJSON(list)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATUS\tTYPE\tBROADCASTER\tCOST\tCALLBACK")
+	for _, sub := range list.Data {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n", sub.ID, sub.Status, sub.Type, subscriptionBroadcaster(sub), sub.Cost, sub.Transport.Callback)
+	}
+	w.Flush()
+	fmt.Printf("\n%d subscription(s), total cost %d/%d\n", len(list.Data), list.TotalCost, list.MaxTotalCost)
+	return 0
+}
+
+// subsDelete deletes a subscription by ID, every subscription, or the orphaned ones
+func subsDelete(configPath string, args []string) int {
+	fs := flag.NewFlagSet("subs delete", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print JSON")
+	all := fs.Bool("all", false, "delete every subscription of the application")
+	orphaned := fs.Bool("orphaned", false, "delete the subscriptions that failed, were revoked, or use another callback than CALLBACK_URL")
+	rest, err := parseFlags(fs, args)
+	modes := len(rest)
+	if *all {
+		modes++
+	}
+	if *orphaned {
+		modes++
+	}
+	if err != nil || len(rest) > 1 || modes != 1 {
+		return usageError(fs, "subs delete <id> | --all | --orphaned [--json]")
+	}
+	cfg, helix, err := twitchClient(configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	var targets []twitch.Subscription
+	if len(rest) == 1 {
+		targets = []twitch.Subscription{{ID: rest[0]}}
+	} else {
+		list, err := helix.Subscriptions(nil)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		callback := cfg.CallbackURL + "/webhook"
+		for _, sub := range list.Data {
+			if *all || isOrphaned(sub, callback) {
+				targets = append(targets, sub)
+			}
+		}
+	}
+
+	result := struct {
+		Deleted []string          `json:"deleted"`
+		Failed  map[string]string `json:"failed,omitempty"`
+	}{Deleted: []string{}, Failed: map[string]string{}}
+	for _, sub := range targets {
+		if err := helix.DeleteSubscription(sub.ID); err != nil {
+			result.Failed[sub.ID] = err.Error()
+			if !*asJSON {
+				fmt.Fprintf(os.Stderr, "failed to delete %s: %v\n", sub.ID, err)
+			}
+			continue
+		}
+		result.Deleted = append(result.Deleted, sub.ID)
+		if !*asJSON {
+			fmt.Printf("Deleted %s %s\n", sub.ID, strings.TrimSpace(sub.Type+" "+subscriptionBroadcaster(sub)))
+		}
+	}
+
+	code := 0
+	if len(result.Failed) > 0 {
+		code = 1
+	}
+	if *asJSON {
+		if c := printJSON(result); c != 0 {
+			return c
+		}
+		return code
+	}
+	fmt.Printf("%d subscription(s) deleted\n", len(result.Deleted))
+	return code
+}
+
+// isOrphaned reports whether a subscription doesn't deliver events to the bot: it failed,
+// was revoked, or sends them to another callback
+func isOrphaned(sub twitch.Subscription, callback string) bool {
+	switch sub.Status {
+	case "enabled", "webhook_callback_verification_pending":
+		return sub.Transport.Callback != callback
+	default:
+		return true
+	}
+}
+
+// subsCreate creates a subscription of a type for a broadcaster, given by ID or login
+func subsCreate(configPath string, args []string) int {
+	fs := flag.NewFlagSet("subs create", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print JSON")
+	rest, err := parseFlags(fs, args)
+	if err != nil || len(rest) != 2 {
+		return usageError(fs, "subs create <type> <broadcaster ID or login> [--json]")
+	}
+	cfg, helix, err := twitchClient(configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	subType, broadcaster := rest[0], rest[1]
+	if _, err := strconv.ParseUint(broadcaster, 10, 64); err != nil {
+		users, err := helix.GetUsers("", nil, []string{normalizeLogin(broadcaster)})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if len(users) == 0 {
+			fmt.Fprintf(os.Stderr, "unknown Twitch user %q\n", broadcaster)
+			return 1
+		}
+		broadcaster = users[0].ID
+	}
+	sub, err := helix.CreateSubscription(subType, twitch.SubscriptionCondition(subType, broadcaster), cfg.CallbackURL+"/webhook", cfg.TwitchWebhookSecret)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *asJSON {
+		return printJSON(sub)
+	}
+	fmt.Printf("Created %s (%s %s), status %s, cost %d\n", sub.ID, sub.Type, subscriptionBroadcaster(*sub), sub.Status, sub.Cost)
+	return 0
+}
+
+// subscriptionBroadcaster returns the broadcaster of the condition of a subscription
+func subscriptionBroadcaster(sub twitch.Subscription) string {
+	for _, key := range []string{"broadcaster_user_id", "to_broadcaster_user_id", "user_id"} {
+		if id := sub.Condition[key]; id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+// usageError prints the usage of a subcommand and returns the exit code of a usage error
+func usageError(fs *flag.FlagSet, synopsis string) int {
+	fmt.Fprintf(os.Stderr, "Usage: %s %s\n", os.Args[0], synopsis)
+	fs.SetOutput(os.Stderr)
+	fs.PrintDefaults()
+	return 2
+}
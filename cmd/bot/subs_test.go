@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/flthibaud/TwitchLiveNotifier/internal/discord/twitch"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/twitchtest"
+)
+
+// callback is the webhook of the bot in the test configuration
+const callback = "https://bot.example/webhook"
+
+// useTwitch makes the subcommands call a fake Twitch API, with a configuration read from the environment
+func useTwitch(t *testing.T) *twitchtest.Server {
+	t.Helper()
+	srv := twitchtest.NewServer(t)
+	prev := newTwitchClient
+	newTwitchClient = func(clientID, clientSecret string) *twitch.Client {
+		c := prev(clientID, clientSecret)
+		c.AuthURL = srv.AuthURL()
+		c.HelixURL = srv.HelixURL()
+		return c
+	}
+	t.Cleanup(func() { newTwitchClient = prev })
+
+	for env, value := range map[string]string{
+		"CONFIG_FILE":            "",
+		"PORT":                   "8080",
+		"BOT_TOKEN":              "test-bot-token",
+		"TWITCH_CLIENT_ID":       twitchtest.ClientID,
+		"TWITCH_CLIENT_SECRET":   twitchtest.ClientSecret,
+		"TWITCH_WEBHOOK_SECRET":  "test-webhook-secret",
+		"CALLBACK_URL":           "https://bot.example",
+		"NOTIFY_CHANNEL_ID":      "900000000000000001",
+		"TWITCH_BROADCASTER_IDS": "",
+		"TOKEN_ENCRYPTION_KEY":   "",
+	} {
+		t.Setenv(env, value)
+	}
+	return srv
+}
+
+// remaining returns the IDs of the subscriptions left on the server, sorted
+func remaining(srv *twitchtest.Server) []string {
+	var ids []string
+	for _, sub := range srv.Subscriptions() {
+		ids = append(ids, sub.ID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestIsOrphaned(t *testing.T) {
+	tests := []struct {
+		status, callback string
+		want             bool
+	}{
+		{twitchtest.StatusEnabled, callback, false},
+		{twitchtest.StatusVerificationPending, callback, false},
+		{twitchtest.StatusEnabled, "https://old.example/webhook", true},
+		{twitchtest.StatusVerificationPending, "https://old.example/webhook", true},
+		{twitchtest.StatusVerificationFailed, callback, true},
+		{"authorization_revoked", callback, true},
+		{"notification_failures_exceeded", callback, true},
+		{"user_removed", callback, true},
+	}
+	for _, tt := range tests {
+		sub := twitch.Subscription{Status: tt.status}
+		sub.Transport.Callback = tt.callback
+		if got := isOrphaned(sub, callback); got != tt.want {
+			t.Errorf("isOrphaned(%s, %s) = %v, want %v", tt.status, tt.callback, got, tt.want)
+		}
+	}
+}
+
+func TestSubsDelete(t *testing.T) {
+	srv := useTwitch(t)
+	add := func(status, cb string) string {
+		return srv.AddSubscription(twitchtest.Subscription{
+			Status:    status,
+			Type:      "stream.online",
+			Condition: map[string]string{"broadcaster_user_id": "1001"},
+			Transport: twitchtest.Transport{Callback: cb},
+		}).ID
+	}
+	enabled := add(twitchtest.StatusEnabled, callback)
+	pending := add(twitchtest.StatusVerificationPending, callback)
+	add(twitchtest.StatusVerificationFailed, callback)
+	add("authorization_revoked", callback)
+	add(twitchtest.StatusEnabled, "https://old.example/webhook")
+	all := remaining(srv)
+
+	// Exactly one of an ID, --all and --orphaned
+	for _, args := range [][]string{nil, {"--json"}, {enabled, pending}, {enabled, "--all"}, {"--orphaned", enabled}, {"--all", "--orphaned"}, {"--force"}} {
+		if code := subsDelete("", args); code != 2 {
+			t.Errorf("subs delete %q = %d, want a usage error", args, code)
+		}
+	}
+	if got := remaining(srv); fmt.Sprint(got) != fmt.Sprint(all) {
+		t.Fatalf("usage errors deleted subscriptions, left %q", got)
+	}
+
+	// Orphaned: failed, revoked, or another callback
+	if code := subsDelete("", []string{"--orphaned"}); code != 0 {
+		t.Errorf("subs delete --orphaned = %d, want 0", code)
+	}
+	want := []string{enabled, pending}
+	sort.Strings(want)
+	if got := remaining(srv); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("left %q, want the enabled and pending subscriptions of the bot %q", got, want)
+	}
+
+	// By ID, an unknown one fails
+	if code := runCommand("", []string{"subs", "delete", "--json", pending}); code != 0 {
+		t.Errorf("subs delete --json %s = %d, want 0", pending, code)
+	}
+	if code := subsDelete("", []string{pending}); code != 1 {
+		t.Errorf("subs delete of a deleted subscription = %d, want 1", code)
+	}
+	if got := remaining(srv); fmt.Sprint(got) != fmt.Sprint([]string{enabled}) {
+		t.Errorf("left %q, want %q", got, enabled)
+	}
+
+	if code := subsDelete("", []string{"--all"}); code != 0 {
+		t.Errorf("subs delete --all = %d, want 0", code)
+	}
+	if got := remaining(srv); len(got) != 0 {
+		t.Errorf("left %q after --all", got)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// usersResolve prints the IDs of Twitch users given by login
+func usersResolve(configPath string, args []string) int {
+	fs := flag.NewFlagSet("users resolve", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print JSON")
+	logins, err := parseFlags(fs, args)
+	if err != nil || len(logins) == 0 {
+		return usageError(fs, "users resolve <login>... [--json]")
+	}
+	_, helix, err := twitchClient(configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	for n, login := range logins {
+		logins[n] = normalizeLogin(login)
+	}
+	users, err := helix.GetUsers("", nil, logins)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	code := 0
+	found := map[string]bool{}
+	for _, user := range users {
+		found[user.Login] = true
+	}
+	for _, login := range logins {
+		if !found[login] {
+			fmt.Fprintf(os.Stderr, "unknown Twitch user %q\n", login)
+			code = 1
+		}
+	}
+	if *asJSON {
+		if c := printJSON(users); c != 0 {
+			return c
+		}
+		return code
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tLOGIN\tDISPLAY NAME")
+	for _, user := range users {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", user.ID, user.Login, user.DisplayName)
+	}
+	w.Flush()
+	return code
+}
+
+// normalizeLogin returns the login of a Twitch user written as @login or with capitals
+func normalizeLogin(login string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(login), "@"))
+}
@@ -1,71 +1,321 @@
 package config
 
 import (
+	"encoding/base64"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/flthibaud/TwitchLiveNotifier/internal/i18n"
 	"github.com/joho/godotenv"
+	"github.com/sirupsen/logrus"
 )
 
+// Log formats
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// DefaultNotifier is the name of the notifier built from NOTIFY_CHANNEL_ID when the
+// configuration file doesn't declare any
+const DefaultNotifier = "default"
+
+// Notifier is a Discord channel announcements are routed to
+type Notifier struct {
+	Name      string `yaml:"name"`
+	ChannelID string `yaml:"channel_id"`
+	RoleID    string `yaml:"role_id"` // pinged by announcements in the channel (optional)
+}
+
+// Broadcaster is a Twitch broadcaster whose streams are announced
+type Broadcaster struct {
+	ID       string `yaml:"id"`
+	Login    string `yaml:"login"`    // informative, the broadcaster is identified by its ID
+	Notifier string `yaml:"notifier"` // name of the notifier of its announcements (empty for the default one)
+}
+
 // Config holds configuration values for the bot
 type Config struct {
-	Port                 string // Port for the HTTP server
-	BotToken             string // Discord bot token
-	TwitchClientID       string // Twitch application client ID
-	TwitchClientSecret   string // Twitch application client secret
-	TwitchWebhookSecret  string // Twitch webhook secret
+	Port                 string        // Port for the HTTP server
+	BotToken             string        // Discord bot token
+	DiscordGuildID       string        // Development guild for slash commands (empty registers them globally)
+	TwitchClientID       string        // Twitch application client ID
+	TwitchClientSecret   string        // Twitch application client secret
+	TwitchWebhookSecret  string        // Twitch webhook secret
+	CallbackURL          string        // URL for Twitch webhook callback
+	StoragePath          string        // Path of the JSON file holding the bot state
+	TokenKey             []byte        // AES-256 key encrypting the OAuth tokens of linked accounts in the store (nil stores them in plaintext)
+	DefaultLanguage      string        // Language of announcements for guilds without a language setting
+	PresenceDetection    bool          // Detect streams of opted-in members from their Discord presence
+	LiveRoles            bool          // Give linked streamers a role while they stream (needs the GUILD_MEMBERS intent)
+	ScheduleSyncInterval time.Duration // Interval of the Twitch schedule sync into Discord events (0 disables it)
+	ClipsChannelID       string        // Discord channel ID where new clips are posted (empty disables it)
+	ClipsMinViews        int           // Minimum view count of a clip before it is posted
+	ClipsPollInterval    time.Duration // Interval between two clip checks
+	VODLinks             bool          // Reply to the announcements of ended streams with their VOD
+	WatchPollInterval    time.Duration // Interval between two polls of the category and team watchers
+	FlapGracePeriod      time.Duration // Time an ended stream can restart within and resume its session (0 disables it)
+	DeliveryWorkers      int           // Number of workers posting queued Discord messages
+	LogFormat            string        // Format of the logs: text or json
+	APIToken             string        // Bearer token of the admin API (empty disables it)
+	DashboardToken       string        // Login token of the web dashboard (empty disables it)
+	File                 string        // Path of the configuration file (empty when configured by environment only)
+
+	// Settings applied without restart when the configuration is reloaded, read them with
+	// the accessors (NotifyChannel, BroadcasterIDs, NotifierFor, ...) once the bot runs
+	Reloadable
+	mu sync.RWMutex
+}
+
+// Reloadable holds the settings that can change while the bot runs
+type Reloadable struct {
 	TwitchBroadcasterIDs []string
-	CallbackURL          string // URL for Twitch webhook callback
-	NotifyChannelID      string // Default Discord channel ID for notifications
+	Broadcasters         []Broadcaster // Tracked broadcasters, with their routing (TwitchBroadcasterIDs lists their IDs)
+	Notifiers            []Notifier    // Channels announcements are routed to, the first one is the default
+	NotifyChannelID      string        // Default Discord channel ID for notifications
+	NotifyRoleID         string        // Discord role pinged by announcements in the notification channel (optional)
+	LogLevel             string        // Minimum level of the logs
 }
 
-// Load reads configuration from environment variables (and .env file) and returns a Config
-func Load() (*Config, error) {
+// Load reads the configuration file at path (CONFIG_FILE when empty, no file when both are empty),
+// then the environment variables (and .env file), which override the settings of the file,
+// and returns a Config
+func Load(path string) (*Config, error) {
 	// Load .env in development if present
 	_ = godotenv.Load()
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
 
 	cfg := &Config{
-		Port:                os.Getenv("PORT"),
-		BotToken:            os.Getenv("BOT_TOKEN"),
-		TwitchClientID:      os.Getenv("TWITCH_CLIENT_ID"),
-		TwitchClientSecret:  os.Getenv("TWITCH_CLIENT_SECRET"),
-		TwitchWebhookSecret: os.Getenv("TWITCH_WEBHOOK_SECRET"),
-		CallbackURL:         os.Getenv("CALLBACK_URL"),
-		NotifyChannelID:     os.Getenv("NOTIFY_CHANNEL_ID"),
+		StoragePath:       "data/bot.json",
+		DefaultLanguage:   i18n.French,
+		ClipsPollInterval: 5 * time.Minute,
+		WatchPollInterval: 5 * time.Minute,
+		FlapGracePeriod:   5 * time.Minute,
+		DeliveryWorkers:   4,
+		LogFormat:         LogFormatText,
+		File:              path,
+		Reloadable:        Reloadable{LogLevel: "info"},
+	}
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.loadEnv(); err != nil {
+		return nil, err
+	}
+
+	// Routing: NOTIFY_CHANNEL_ID and NOTIFY_ROLE_ID are the default notifier
+	if len(cfg.Notifiers) == 0 && cfg.NotifyChannelID != "" {
+		cfg.Notifiers = []Notifier{{Name: DefaultNotifier}}
+	}
+	if len(cfg.Notifiers) > 0 {
+		cfg.Notifiers[0].ChannelID = cfg.NotifyChannelID
+		cfg.Notifiers[0].RoleID = cfg.NotifyRoleID
+	}
+	cfg.TwitchBroadcasterIDs = nil
+	for _, b := range cfg.Broadcasters {
+		cfg.TwitchBroadcasterIDs = append(cfg.TwitchBroadcasterIDs, b.ID)
 	}
 
 	// Validate required fields
+	required := []struct{ value, env, key string }{
+		{cfg.Port, "PORT", "http.port"},
+		{cfg.BotToken, "BOT_TOKEN", "discord.token"},
+		{cfg.TwitchClientID, "TWITCH_CLIENT_ID", "twitch.client_id"},
+		{cfg.TwitchClientSecret, "TWITCH_CLIENT_SECRET", "twitch.client_secret"},
+		{cfg.TwitchWebhookSecret, "TWITCH_WEBHOOK_SECRET", "twitch.webhook_secret"},
+		{cfg.CallbackURL, "CALLBACK_URL", "twitch.callback_url"},
+		{cfg.NotifyChannelID, "NOTIFY_CHANNEL_ID", "notifiers"},
+	}
 	missing := []string{}
-	if cfg.Port == "" {
-		missing = append(missing, "PORT")
+	for _, r := range required {
+		switch {
+		case r.value != "":
+		case path != "":
+			missing = append(missing, fmt.Sprintf("%s (or %s)", r.key, r.env))
+		default:
+			missing = append(missing, r.env)
+		}
 	}
-	if cfg.BotToken == "" {
-		missing = append(missing, "BOT_TOKEN")
+	if len(missing) > 0 && path != "" {
+		return nil, fmt.Errorf("missing required settings: %s", strings.Join(missing, ", "))
 	}
-	if cfg.TwitchClientID == "" {
-		missing = append(missing, "TWITCH_CLIENT_ID")
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing required environment variables: %v", missing)
 	}
-	if cfg.TwitchClientSecret == "" {
-		missing = append(missing, "TWITCH_CLIENT_SECRET")
+
+	return cfg, nil
+}
+
+// loadEnv applies the environment variables that are set
+func (cfg *Config) loadEnv() error {
+	for env, dst := range map[string]*string{
+		"PORT":                  &cfg.Port,
+		"BOT_TOKEN":             &cfg.BotToken,
+		"DISCORD_GUILD_ID":      &cfg.DiscordGuildID,
+		"TWITCH_CLIENT_ID":      &cfg.TwitchClientID,
+		"TWITCH_CLIENT_SECRET":  &cfg.TwitchClientSecret,
+		"TWITCH_WEBHOOK_SECRET": &cfg.TwitchWebhookSecret,
+		"CALLBACK_URL":          &cfg.CallbackURL,
+		"NOTIFY_CHANNEL_ID":     &cfg.NotifyChannelID,
+		"NOTIFY_ROLE_ID":        &cfg.NotifyRoleID,
+		"STORAGE_PATH":          &cfg.StoragePath,
+		"DEFAULT_LANGUAGE":      &cfg.DefaultLanguage,
+		"CLIPS_CHANNEL_ID":      &cfg.ClipsChannelID,
+		"LOG_LEVEL":             &cfg.LogLevel,
+		"LOG_FORMAT":            &cfg.LogFormat,
+		"API_TOKEN":             &cfg.APIToken,
+		"DASHBOARD_TOKEN":       &cfg.DashboardToken,
+	} {
+		setString(dst, os.Getenv(env))
 	}
-	if cfg.TwitchWebhookSecret == "" {
-		missing = append(missing, "TWITCH_WEBHOOK_SECRET")
+	if v := os.Getenv("PRESENCE_DETECTION"); v != "" {
+		cfg.PresenceDetection = v == "true"
 	}
-	if cfg.CallbackURL == "" {
-		missing = append(missing, "CALLBACK_URL")
+	if v := os.Getenv("LIVE_ROLES"); v != "" {
+		cfg.LiveRoles = v == "true"
 	}
-	idsEnv := os.Getenv("TWITCH_BROADCASTER_IDS")
-	if idsEnv != "" {
-		cfg.TwitchBroadcasterIDs = strings.Split(idsEnv, ",")
+	if v := os.Getenv("VOD_LINKS"); v != "" {
+		cfg.VODLinks = v == "true"
 	}
-	if cfg.NotifyChannelID == "" {
-		missing = append(missing, "NOTIFY_CHANNEL_ID")
+	if v := os.Getenv("TWITCH_BROADCASTER_IDS"); v != "" {
+		// Replaces the broadcasters of the file, announced by the default notifier
+		cfg.Broadcasters = nil
+		for _, id := range strings.Split(v, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				cfg.Broadcasters = append(cfg.Broadcasters, Broadcaster{ID: id})
+			}
+		}
 	}
 
-	if len(missing) > 0 {
-		return nil, fmt.Errorf("missing required environment variables: %v", missing)
+	if _, err := logrus.ParseLevel(cfg.LogLevel); err != nil {
+		return fmt.Errorf("invalid LOG_LEVEL %q (expected debug, info, warn or error)", cfg.LogLevel)
+	}
+	if cfg.LogFormat != LogFormatText && cfg.LogFormat != LogFormatJSON {
+		return fmt.Errorf("invalid LOG_FORMAT %q (expected %s or %s)", cfg.LogFormat, LogFormatText, LogFormatJSON)
+	}
+	if v := os.Getenv("SCHEDULE_SYNC_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < time.Minute {
+			return fmt.Errorf("invalid SCHEDULE_SYNC_INTERVAL %q (expected a duration of at least 1m, e.g. 30m)", v)
+		}
+		cfg.ScheduleSyncInterval = d
+	}
+	if v := os.Getenv("CLIPS_MIN_VIEWS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid CLIPS_MIN_VIEWS %q (expected a positive number)", v)
+		}
+		cfg.ClipsMinViews = n
+	}
+	if v := os.Getenv("CLIPS_POLL_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < time.Minute {
+			return fmt.Errorf("invalid CLIPS_POLL_INTERVAL %q (expected a duration of at least 1m, e.g. 5m)", v)
+		}
+		cfg.ClipsPollInterval = d
+	}
+	if v := os.Getenv("WATCH_POLL_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < time.Minute {
+			return fmt.Errorf("invalid WATCH_POLL_INTERVAL %q (expected a duration of at least 1m, e.g. 5m)", v)
+		}
+		cfg.WatchPollInterval = d
+	}
+	if v := os.Getenv("FLAP_GRACE_PERIOD"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid FLAP_GRACE_PERIOD %q (expected a duration, e.g. 5m, or 0 to disable)", v)
+		}
+		cfg.FlapGracePeriod = d
+	}
+	if v := os.Getenv("TOKEN_ENCRYPTION_KEY"); v != "" {
+		key, err := parseTokenKey(v)
+		if err != nil {
+			return fmt.Errorf("invalid TOKEN_ENCRYPTION_KEY: %w", err)
+		}
+		cfg.TokenKey = key
+	}
+	if v := os.Getenv("DELIVERY_WORKERS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid DELIVERY_WORKERS %q (expected a number of at least 1)", v)
+		}
+		cfg.DeliveryWorkers = n
+	}
+	if !i18n.Supported(cfg.DefaultLanguage) {
+		return fmt.Errorf("unsupported DEFAULT_LANGUAGE %q (supported: %v)", cfg.DefaultLanguage, i18n.Languages())
 	}
 
-	return cfg, nil
+	return nil
+}
+
+// parseTokenKey decodes a base64 encryption key of 32 bytes (e.g. from openssl rand -base64 32)
+func parseTokenKey(v string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(v)
+	if err != nil {
+		return nil, fmt.Errorf("not base64: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("%d bytes, expected 32", len(key))
+	}
+	return key, nil
+}
+
+// NotifyChannel returns the channel of the default notifier
+func (cfg *Config) NotifyChannel() string {
+	cfg.mu.RLock()
+	defer cfg.mu.RUnlock()
+	return cfg.NotifyChannelID
+}
+
+// BroadcasterIDs returns the IDs of the announced broadcasters
+func (cfg *Config) BroadcasterIDs() []string {
+	cfg.mu.RLock()
+	defer cfg.mu.RUnlock()
+	return append([]string(nil), cfg.TwitchBroadcasterIDs...)
+}
+
+// NotifierFor returns the notifier announcing the streams of a broadcaster: the one set for
+// the broadcaster in the configuration file, or the default one
+func (cfg *Config) NotifierFor(broadcasterID string) Notifier {
+	cfg.mu.RLock()
+	defer cfg.mu.RUnlock()
+	name := ""
+	for _, b := range cfg.Broadcasters {
+		if b.ID == broadcasterID {
+			name = b.Notifier
+		}
+	}
+	for _, n := range cfg.Notifiers {
+		if n.Name == name {
+			return n
+		}
+	}
+	if len(cfg.Notifiers) > 0 {
+		return cfg.Notifiers[0]
+	}
+	return Notifier{Name: DefaultNotifier, ChannelID: cfg.NotifyChannelID, RoleID: cfg.NotifyRoleID}
+}
+
+// PingRole returns the role pinged by announcements in a channel, empty when there is none
+func (cfg *Config) PingRole(channelID string) string {
+	cfg.mu.RLock()
+	defer cfg.mu.RUnlock()
+	for _, n := range cfg.Notifiers {
+		if n.ChannelID == channelID && n.RoleID != "" {
+			return n.RoleID
+		}
+	}
+	if channelID == cfg.NotifyChannelID {
+		return cfg.NotifyRoleID
+	}
+	return ""
 }
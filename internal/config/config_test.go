@@ -0,0 +1,229 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testFile is a complete configuration file, every required setting included
+const testFile = `http:
+  port: 8080
+discord:
+  token: file-token
+twitch:
+  client_id: file-client
+  client_secret: file-secret
+  webhook_secret: file-webhook-secret
+  callback_url: https://bot.example
+  flap_grace_period: 2m
+logging:
+  level: info
+notifiers:
+  - name: default
+    channel_id: "900000000000000001"
+  - name: esports
+    channel_id: "900000000000000002"
+broadcasters:
+  - id: "100000001"
+  - id: "100000002"
+    notifier: esports
+`
+
+// clearEnv unsets the environment variables read by Load for the duration of the test
+func clearEnv(t *testing.T) {
+	t.Helper()
+	for _, env := range []string{"CONFIG_FILE", "PORT", "BOT_TOKEN", "DISCORD_GUILD_ID", "TWITCH_CLIENT_ID", "TWITCH_CLIENT_SECRET",
+		"TWITCH_WEBHOOK_SECRET", "CALLBACK_URL", "NOTIFY_CHANNEL_ID", "NOTIFY_ROLE_ID", "STORAGE_PATH", "DEFAULT_LANGUAGE",
+		"CLIPS_CHANNEL_ID", "LOG_LEVEL", "LOG_FORMAT", "API_TOKEN", "DASHBOARD_TOKEN", "PRESENCE_DETECTION", "LIVE_ROLES",
+		"VOD_LINKS", "TWITCH_BROADCASTER_IDS", "SCHEDULE_SYNC_INTERVAL", "CLIPS_MIN_VIEWS", "CLIPS_POLL_INTERVAL",
+		"WATCH_POLL_INTERVAL", "FLAP_GRACE_PERIOD", "DELIVERY_WORKERS", "TOKEN_ENCRYPTION_KEY"} {
+		t.Setenv(env, "")
+	}
+}
+
+// writeFile writes a configuration file in a temporary directory and returns its path
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		problems []string // expected problems, in order (none when the file is valid)
+	}{
+		{"empty", ``, nil},
+		{"valid", testFile, nil},
+		{"unknown key", "discord:\n  tokn: abc\n", []string{`line 2: unknown setting "tokn"`}},
+		{"unknown section", "discrod:\n  token: abc\n", []string{`line 1: unknown setting "discrod"`}},
+		{"unknown keys and invalid duration", "twitch:\n  flap_grace_period: soon\n  clip: {}\nhttp:\n  prot: 80\n", []string{
+			`line 2: invalid duration "soon" (expected e.g. 30s, 5m or 1h)`,
+			`line 3: unknown setting "clip"`,
+			`line 5: unknown setting "prot"`,
+		}},
+		{"invalid values", `discord:
+  guild_id: my-guild
+  default_language: xx
+  delivery_workers: 0
+twitch:
+  callback_url: http://bot.example
+  watch_poll_interval: 10s
+http:
+  port: "99999"
+logging:
+  level: verbose
+  format: xml
+`, []string{
+			`discord.guild_id: "my-guild" is not a Discord ID`,
+			`discord.default_language: unsupported language "xx" (supported: [en fr])`,
+			`discord.delivery_workers: must be at least 1 (got 0)`,
+			`twitch.callback_url: "http://bot.example" is not an https URL`,
+			`twitch.watch_poll_interval: must be at least 1m (got 10s)`,
+			`http.port: "99999" is not a port number`,
+			`logging.level: unknown level "verbose" (expected debug, info, warn or error)`,
+			`logging.format: unknown format "xml" (expected text or json)`,
+		}},
+		{"invalid routing", `notifiers:
+  - name: main
+  - name: main
+    channel_id: "1"
+broadcasters:
+  - id: alice
+    notifier: other
+  - login: bob
+`, []string{
+			`notifiers[0].channel_id: required`,
+			`notifiers[1].name: duplicate notifier "main"`,
+			`broadcasters[0].id: "alice" is not a Twitch user ID`,
+			`broadcasters[0].notifier: unknown notifier "other"`,
+			`broadcasters[1].id: required`,
+		}},
+	}
+	for _, tt := range tests {
+		cfg := &Config{}
+		err := cfg.parseFile("config.yaml", []byte(tt.file))
+		if tt.problems == nil {
+			if err != nil {
+				t.Errorf("%s: parseFile() error = %v", tt.name, err)
+			}
+			continue
+		}
+		var verr *ValidationError
+		if !errors.As(err, &verr) {
+			t.Errorf("%s: parseFile() error = %v, want a ValidationError", tt.name, err)
+			continue
+		}
+		if got, want := strings.Join(verr.Problems, "\n"), strings.Join(tt.problems, "\n"); got != want {
+			t.Errorf("%s: problems\n%s\nwant\n%s", tt.name, got, want)
+		}
+		// Every problem is reported in a single message
+		for _, p := range tt.problems {
+			if !strings.Contains(err.Error(), p) {
+				t.Errorf("%s: error %q doesn't mention %q", tt.name, err, p)
+			}
+		}
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeFile(t, testFile)
+	tests := []struct {
+		name  string
+		env   map[string]string
+		check func(cfg *Config) string // returns what is wrong with cfg
+	}{
+		{"file only", nil, func(cfg *Config) string {
+			if cfg.BotToken != "file-token" || cfg.FlapGracePeriod != 2*time.Minute || cfg.NotifyChannelID != "900000000000000001" {
+				return "settings of the file not applied"
+			}
+			if cfg.StoragePath != "data/bot.json" || cfg.DeliveryWorkers != 4 {
+				return "defaults of the settings missing from the file not kept"
+			}
+			if strings.Join(cfg.TwitchBroadcasterIDs, ",") != "100000001,100000002" {
+				return "broadcasters of the file not applied"
+			}
+			return ""
+		}},
+		{"secrets from the environment", map[string]string{"BOT_TOKEN": "env-token", "TWITCH_CLIENT_SECRET": "env-secret"}, func(cfg *Config) string {
+			if cfg.BotToken != "env-token" || cfg.TwitchClientSecret != "env-secret" {
+				return "environment doesn't override the file"
+			}
+			if cfg.TwitchClientID != "file-client" {
+				return "settings missing from the environment not read from the file"
+			}
+			return ""
+		}},
+		{"durations and flags", map[string]string{"FLAP_GRACE_PERIOD": "0", "LOG_LEVEL": "debug", "VOD_LINKS": "true"}, func(cfg *Config) string {
+			if cfg.FlapGracePeriod != 0 || cfg.LogLevel != "debug" || !cfg.VODLinks {
+				return "environment doesn't override the file"
+			}
+			return ""
+		}},
+		{"default notifier channel", map[string]string{"NOTIFY_CHANNEL_ID": "900000000000000009"}, func(cfg *Config) string {
+			if cfg.NotifyChannel() != "900000000000000009" || cfg.Notifiers[0].ChannelID != "900000000000000009" {
+				return "NOTIFY_CHANNEL_ID doesn't override the first notifier"
+			}
+			if cfg.NotifierFor("100000002").ChannelID != "900000000000000002" {
+				return "routing of the file lost"
+			}
+			return ""
+		}},
+		{"broadcasters from the environment", map[string]string{"TWITCH_BROADCASTER_IDS": "100000003, 100000004"}, func(cfg *Config) string {
+			if strings.Join(cfg.BroadcasterIDs(), ",") != "100000003,100000004" {
+				return "TWITCH_BROADCASTER_IDS doesn't replace the broadcasters of the file"
+			}
+			return ""
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			cfg, err := Load(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if problem := tt.check(cfg); problem != "" {
+				t.Error(problem)
+			}
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		env  map[string]string
+		want string
+	}{
+		{"invalid file", "discord:\n  tokn: abc\n", nil, `unknown setting "tokn"`},
+		{"missing settings", "discord:\n  token: abc\n", nil, "missing required settings: http.port (or PORT), twitch.client_id (or TWITCH_CLIENT_ID)"},
+		{"invalid environment", testFile, map[string]string{"FLAP_GRACE_PERIOD": "-1m"}, `invalid FLAP_GRACE_PERIOD "-1m"`},
+		{"short encryption key", testFile, map[string]string{"TOKEN_ENCRYPTION_KEY": "c2hvcnQ="}, "invalid TOKEN_ENCRYPTION_KEY: 5 bytes, expected 32"},
+		{"invalid encryption key in the file", testFile + "storage:\n  token_key: not-base64\n", nil, "storage.token_key: not base64"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnv(t)
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			_, err := Load(writeFile(t, tt.file))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Load() error = %v, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+}
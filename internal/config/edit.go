@@ -0,0 +1,160 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Errors of the edits of the broadcasters
+var (
+	ErrNoFile              = errors.New("no configuration file to edit (start the bot with --config or CONFIG_FILE)")
+	ErrBroadcastersFromEnv = errors.New("the broadcasters are set by TWITCH_BROADCASTER_IDS, edit the environment instead")
+	ErrBroadcasterExists   = errors.New("broadcaster already in the configuration")
+	ErrUnknownBroadcaster  = errors.New("broadcaster not in the configuration")
+)
+
+// AddBroadcaster adds a broadcaster to the configuration file and reloads it, so its streams
+// are announced right away
+func (r *Reloader) AddBroadcaster(b Broadcaster) error {
+	return r.editBroadcasters(func(list *yaml.Node) error {
+		if broadcasterIndex(list, b.ID) >= 0 {
+			return ErrBroadcasterExists
+		}
+		entry := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		addField := func(key, value string, style yaml.Style) {
+			if value != "" {
+				entry.Content = append(entry.Content,
+					&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key},
+					&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value, Style: style})
+			}
+		}
+		// IDs are quoted, as they would read as numbers
+		addField("id", b.ID, yaml.DoubleQuotedStyle)
+		addField("login", b.Login, 0)
+		addField("notifier", b.Notifier, 0)
+		list.Content = append(list.Content, entry)
+		return nil
+	})
+}
+
+// RemoveBroadcaster removes a broadcaster from the configuration file and reloads it
+func (r *Reloader) RemoveBroadcaster(id string) error {
+	return r.editBroadcasters(func(list *yaml.Node) error {
+		n := broadcasterIndex(list, id)
+		if n < 0 {
+			return ErrUnknownBroadcaster
+		}
+		list.Content = append(list.Content[:n], list.Content[n+1:]...)
+		return nil
+	})
+}
+
+// editBroadcasters applies edit to the broadcasters list of the configuration file, checks the
+// edited file and replaces the file with it, then reloads the configuration. The rest of the
+// file is kept with its comments, but is indented again and loses its blank lines.
+func (r *Reloader) editBroadcasters(edit func(list *yaml.Node) error) error {
+	r.editMu.Lock()
+	defer r.editMu.Unlock()
+	path := r.cfg.File
+	if path == "" {
+		return ErrNoFile
+	}
+	if os.Getenv("TWITCH_BROADCASTER_IDS") != "" {
+		return ErrBroadcastersFromEnv
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("cannot read configuration file: %w", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("cannot read configuration file: %w", err)
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("invalid configuration file %s: %w", path, err)
+	}
+	if doc.Kind == 0 {
+		// Empty file
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("invalid configuration file %s: not a mapping of settings", path)
+	}
+	list := mappingValue(root, "broadcasters")
+	if list == nil || list.Kind != yaml.SequenceNode {
+		// Missing or empty (null) list
+		seq := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		if list == nil {
+			list = seq
+			root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "broadcasters"}, list)
+		} else {
+			*list = *seq
+		}
+	}
+	if err := edit(list); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+	if err := (&Config{}).parseFile(path, buf.Bytes()); err != nil {
+		return err
+	}
+
+	// Replace the file atomically, a reload never reads it half written
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".config-*.yaml")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	r.logger.Infof("%s edited", path)
+	return r.Reload()
+}
+
+// mappingValue returns the value of a key of a YAML mapping, nil when the key is missing
+func mappingValue(m *yaml.Node, key string) *yaml.Node {
+	for n := 0; n+1 < len(m.Content); n += 2 {
+		if m.Content[n].Value == key {
+			return m.Content[n+1]
+		}
+	}
+	return nil
+}
+
+// broadcasterIndex returns the index of a broadcaster in the broadcasters list, -1 when missing
+func broadcasterIndex(list *yaml.Node, id string) int {
+	for n, entry := range list.Content {
+		if v := mappingValue(entry, "id"); v != nil && v.Value == id {
+			return n
+		}
+	}
+	return -1
+}
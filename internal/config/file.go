@@ -0,0 +1,293 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/flthibaud/TwitchLiveNotifier/internal/i18n"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// fileConfig is the schema of the YAML configuration file. Every setting is optional:
+// unset settings keep their default, and environment variables override the file.
+type fileConfig struct {
+	Discord struct {
+		Token             string `yaml:"token"`
+		GuildID           string `yaml:"guild_id"`
+		DefaultLanguage   string `yaml:"default_language"`
+		PresenceDetection *bool  `yaml:"presence_detection"`
+		LiveRoles         *bool  `yaml:"live_roles"`
+		DeliveryWorkers   *int   `yaml:"delivery_workers"`
+	} `yaml:"discord"`
+	Twitch struct {
+		ClientID             string    `yaml:"client_id"`
+		ClientSecret         string    `yaml:"client_secret"`
+		WebhookSecret        string    `yaml:"webhook_secret"`
+		CallbackURL          string    `yaml:"callback_url"`
+		ScheduleSyncInterval *duration `yaml:"schedule_sync_interval"`
+		WatchPollInterval    *duration `yaml:"watch_poll_interval"`
+		FlapGracePeriod      *duration `yaml:"flap_grace_period"`
+		VODLinks             *bool     `yaml:"vod_links"`
+		Clips                struct {
+			ChannelID    string    `yaml:"channel_id"`
+			MinViews     *int      `yaml:"min_views"`
+			PollInterval *duration `yaml:"poll_interval"`
+		} `yaml:"clips"`
+	} `yaml:"twitch"`
+	HTTP struct {
+		Port           string `yaml:"port"`
+		APIToken       string `yaml:"api_token"`
+		DashboardToken string `yaml:"dashboard_token"`
+	} `yaml:"http"`
+	Storage struct {
+		Path     string `yaml:"path"`
+		TokenKey string `yaml:"token_key"`
+	} `yaml:"storage"`
+	Logging struct {
+		Level  string `yaml:"level"`
+		Format string `yaml:"format"`
+	} `yaml:"logging"`
+	Notifiers    []Notifier    `yaml:"notifiers"`
+	Broadcasters []Broadcaster `yaml:"broadcasters"`
+}
+
+// unknownField matches the errors of the decoder about unknown keys, which name Go types
+var unknownField = regexp.MustCompile(`^(line \d+): field (\S+) not found in type .*$`)
+
+// duration is a time.Duration written as a string in the file, e.g. 5m
+type duration struct {
+	time.Duration
+}
+
+func (d *duration) UnmarshalYAML(node *yaml.Node) error {
+	v, err := time.ParseDuration(node.Value)
+	if node.Kind != yaml.ScalarNode || err != nil {
+		// A TypeError lets the decoder go on and report the other errors of the file
+		return &yaml.TypeError{Errors: []string{fmt.Sprintf("line %d: invalid duration %q (expected e.g. 30s, 5m or 1h)", node.Line, node.Value)}}
+	}
+	d.Duration = v
+	return nil
+}
+
+// ValidationError lists every problem found in a configuration file
+type ValidationError struct {
+	Path     string
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid configuration file %s:\n  - %s", e.Path, strings.Join(e.Problems, "\n  - "))
+}
+
+// loadFile reads a YAML configuration file into cfg. Unknown keys are rejected, so a typo
+// doesn't silently leave a setting to its default.
+func (cfg *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("cannot read configuration file: %w", err)
+	}
+	return cfg.parseFile(path, data)
+}
+
+// parseFile reads the content of the configuration file at path into cfg
+func (cfg *Config) parseFile(path string, data []byte) error {
+	var file fileConfig
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			return fmt.Errorf("invalid configuration file %s: %w", path, err)
+		}
+		// Every decoding error of the file is reported, values are checked once they all decode
+		var problems []string
+		for _, e := range typeErr.Errors {
+			problems = append(problems, unknownField.ReplaceAllString(e, `$1: unknown setting "$2"`))
+		}
+		return &ValidationError{Path: path, Problems: problems}
+	}
+	if problems := file.validate(); len(problems) > 0 {
+		return &ValidationError{Path: path, Problems: problems}
+	}
+	file.apply(cfg)
+	return nil
+}
+
+// validate returns the problems of the file, each prefixed with the path of the setting
+func (f *fileConfig) validate() []string {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if f.Discord.GuildID != "" && !isID(f.Discord.GuildID) {
+		add("discord.guild_id: %q is not a Discord ID", f.Discord.GuildID)
+	}
+	if f.Discord.DefaultLanguage != "" && !i18n.Supported(f.Discord.DefaultLanguage) {
+		add("discord.default_language: unsupported language %q (supported: %v)", f.Discord.DefaultLanguage, i18n.Languages())
+	}
+	if f.Discord.DeliveryWorkers != nil && *f.Discord.DeliveryWorkers < 1 {
+		add("discord.delivery_workers: must be at least 1 (got %d)", *f.Discord.DeliveryWorkers)
+	}
+
+	if f.Twitch.CallbackURL != "" {
+		if u, err := url.Parse(f.Twitch.CallbackURL); err != nil || u.Scheme != "https" || u.Host == "" {
+			add("twitch.callback_url: %q is not an https URL", f.Twitch.CallbackURL)
+		}
+	}
+	if d := f.Twitch.ScheduleSyncInterval; d != nil && d.Duration < time.Minute {
+		add("twitch.schedule_sync_interval: must be at least 1m (got %s)", d.Duration)
+	}
+	if d := f.Twitch.WatchPollInterval; d != nil && d.Duration < time.Minute {
+		add("twitch.watch_poll_interval: must be at least 1m (got %s)", d.Duration)
+	}
+	if d := f.Twitch.FlapGracePeriod; d != nil && d.Duration < 0 {
+		add("twitch.flap_grace_period: must not be negative (got %s, 0 disables it)", d.Duration)
+	}
+	if id := f.Twitch.Clips.ChannelID; id != "" && !isID(id) {
+		add("twitch.clips.channel_id: %q is not a Discord ID", id)
+	}
+	if n := f.Twitch.Clips.MinViews; n != nil && *n < 0 {
+		add("twitch.clips.min_views: must not be negative (got %d)", *n)
+	}
+	if d := f.Twitch.Clips.PollInterval; d != nil && d.Duration < time.Minute {
+		add("twitch.clips.poll_interval: must be at least 1m (got %s)", d.Duration)
+	}
+
+	if p := f.HTTP.Port; p != "" {
+		if n, err := strconv.Atoi(p); err != nil || n < 1 || n > 65535 {
+			add("http.port: %q is not a port number", p)
+		}
+	}
+
+	if k := f.Storage.TokenKey; k != "" {
+		if _, err := parseTokenKey(k); err != nil {
+			add("storage.token_key: %v", err)
+		}
+	}
+
+	if l := f.Logging.Level; l != "" {
+		if _, err := logrus.ParseLevel(l); err != nil {
+			add("logging.level: unknown level %q (expected debug, info, warn or error)", l)
+		}
+	}
+	if format := f.Logging.Format; format != "" && format != LogFormatText && format != LogFormatJSON {
+		add("logging.format: unknown format %q (expected %s or %s)", format, LogFormatText, LogFormatJSON)
+	}
+
+	names := map[string]bool{}
+	for n, notifier := range f.Notifiers {
+		path := fmt.Sprintf("notifiers[%d]", n)
+		switch {
+		case notifier.Name == "":
+			add("%s.name: required", path)
+		case names[notifier.Name]:
+			add("%s.name: duplicate notifier %q", path, notifier.Name)
+		}
+		names[notifier.Name] = true
+		if notifier.ChannelID == "" {
+			add("%s.channel_id: required", path)
+		} else if !isID(notifier.ChannelID) {
+			add("%s.channel_id: %q is not a Discord ID", path, notifier.ChannelID)
+		}
+		if notifier.RoleID != "" && !isID(notifier.RoleID) {
+			add("%s.role_id: %q is not a Discord ID", path, notifier.RoleID)
+		}
+	}
+
+	ids := map[string]bool{}
+	for n, b := range f.Broadcasters {
+		path := fmt.Sprintf("broadcasters[%d]", n)
+		switch {
+		case b.ID == "":
+			add("%s.id: required", path)
+		case !isID(b.ID):
+			add("%s.id: %q is not a Twitch user ID", path, b.ID)
+		case ids[b.ID]:
+			add("%s.id: duplicate broadcaster %s", path, b.ID)
+		}
+		ids[b.ID] = true
+		if b.Notifier != "" && !names[b.Notifier] {
+			add("%s.notifier: unknown notifier %q", path, b.Notifier)
+		}
+	}
+	return problems
+}
+
+// apply copies the settings set in the file to cfg
+func (f *fileConfig) apply(cfg *Config) {
+	setString(&cfg.BotToken, f.Discord.Token)
+	setString(&cfg.DiscordGuildID, f.Discord.GuildID)
+	setString(&cfg.DefaultLanguage, f.Discord.DefaultLanguage)
+	if f.Discord.PresenceDetection != nil {
+		cfg.PresenceDetection = *f.Discord.PresenceDetection
+	}
+	if f.Discord.LiveRoles != nil {
+		cfg.LiveRoles = *f.Discord.LiveRoles
+	}
+	if f.Discord.DeliveryWorkers != nil {
+		cfg.DeliveryWorkers = *f.Discord.DeliveryWorkers
+	}
+
+	setString(&cfg.TwitchClientID, f.Twitch.ClientID)
+	setString(&cfg.TwitchClientSecret, f.Twitch.ClientSecret)
+	setString(&cfg.TwitchWebhookSecret, f.Twitch.WebhookSecret)
+	setString(&cfg.CallbackURL, f.Twitch.CallbackURL)
+	setDuration(&cfg.ScheduleSyncInterval, f.Twitch.ScheduleSyncInterval)
+	setDuration(&cfg.WatchPollInterval, f.Twitch.WatchPollInterval)
+	setDuration(&cfg.FlapGracePeriod, f.Twitch.FlapGracePeriod)
+	if f.Twitch.VODLinks != nil {
+		cfg.VODLinks = *f.Twitch.VODLinks
+	}
+	setString(&cfg.ClipsChannelID, f.Twitch.Clips.ChannelID)
+	if f.Twitch.Clips.MinViews != nil {
+		cfg.ClipsMinViews = *f.Twitch.Clips.MinViews
+	}
+	setDuration(&cfg.ClipsPollInterval, f.Twitch.Clips.PollInterval)
+
+	setString(&cfg.Port, f.HTTP.Port)
+	setString(&cfg.APIToken, f.HTTP.APIToken)
+	setString(&cfg.DashboardToken, f.HTTP.DashboardToken)
+	setString(&cfg.StoragePath, f.Storage.Path)
+	if f.Storage.TokenKey != "" {
+		cfg.TokenKey, _ = parseTokenKey(f.Storage.TokenKey)
+	}
+	setString(&cfg.LogLevel, f.Logging.Level)
+	setString(&cfg.LogFormat, f.Logging.Format)
+
+	if len(f.Notifiers) > 0 {
+		cfg.Notifiers = f.Notifiers
+		cfg.NotifyChannelID = f.Notifiers[0].ChannelID
+		cfg.NotifyRoleID = f.Notifiers[0].RoleID
+	}
+	if len(f.Broadcasters) > 0 {
+		cfg.Broadcasters = f.Broadcasters
+	}
+}
+
+func setString(dst *string, v string) {
+	if v != "" {
+		*dst = v
+	}
+}
+
+func setDuration(dst *time.Duration, d *duration) {
+	if d != nil {
+		*dst = d.Duration
+	}
+}
+
+// isID reports whether s looks like a Discord or Twitch ID (a number)
+func isID(s string) bool {
+	_, err := strconv.ParseUint(s, 10, 64)
+	return err == nil
+}
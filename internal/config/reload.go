@@ -0,0 +1,189 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Live returns a copy of the reloadable settings
+func (cfg *Config) Live() Reloadable {
+	cfg.mu.RLock()
+	defer cfg.mu.RUnlock()
+	return Reloadable{
+		TwitchBroadcasterIDs: append([]string(nil), cfg.TwitchBroadcasterIDs...),
+		Broadcasters:         append([]Broadcaster(nil), cfg.Broadcasters...),
+		Notifiers:            append([]Notifier(nil), cfg.Notifiers...),
+		NotifyChannelID:      cfg.NotifyChannelID,
+		NotifyRoleID:         cfg.NotifyRoleID,
+		LogLevel:             cfg.LogLevel,
+	}
+}
+
+func (cfg *Config) setLive(r Reloadable) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	cfg.Reloadable = r
+}
+
+// Reloader reloads the configuration on SIGHUP and when its file changes. The reloadable
+// settings of a valid configuration are applied to the running one and passed to the handlers,
+// an invalid configuration is rejected and the running one is kept.
+// Message templates aren't part of the configuration (announcements use the built-in translations),
+// so a reload doesn't change them.
+type Reloader struct {
+	cfg    *Config
+	logger *logrus.Logger
+
+	mu       sync.Mutex
+	handlers []func(prev, next Reloadable)
+	modTime  time.Time
+	editMu   sync.Mutex // serializes the edits of the file
+}
+
+// NewReloader creates a reloader of the running configuration cfg
+func NewReloader(cfg *Config, logger *logrus.Logger) *Reloader {
+	r := &Reloader{cfg: cfg, logger: logger}
+	r.modTime, _ = r.fileModTime()
+	return r
+}
+
+// OnReload registers a handler called with the previous and the new settings after a reload changed them
+func (r *Reloader) OnReload(h func(prev, next Reloadable)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.handlers = append(r.handlers, h)
+}
+
+// Run reloads the configuration on SIGHUP, and when the modification time of the file changes
+// (checked every interval), until ctx is done
+func (r *Reloader) Run(ctx context.Context, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			r.logger.Info("SIGHUP received, reloading the configuration")
+			r.Reload()
+		case <-ticker.C:
+			modTime, err := r.fileModTime()
+			r.mu.Lock()
+			changed := err == nil && !modTime.Equal(r.modTime)
+			r.mu.Unlock()
+			if changed {
+				r.logger.Infof("%s changed, reloading the configuration", r.cfg.File)
+				r.Reload()
+			}
+		}
+	}
+}
+
+func (r *Reloader) fileModTime() (time.Time, error) {
+	if r.cfg.File == "" {
+		return time.Time{}, os.ErrNotExist
+	}
+	info, err := os.Stat(r.cfg.File)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+// Reload loads the configuration again and applies its reloadable settings.
+// It returns the error of an invalid configuration, which is not applied.
+func (r *Reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if modTime, err := r.fileModTime(); err == nil {
+		r.modTime = modTime
+	}
+
+	next, err := Load(r.cfg.File)
+	if err != nil {
+		r.logger.Errorf("Configuration rejected, keeping the running one: %v", err)
+		return err
+	}
+	if restart := restartChanges(r.cfg, next); len(restart) > 0 {
+		r.logger.Warnf("Changed settings not applied until restart: %s", strings.Join(restart, ", "))
+	}
+	prev, live := r.cfg.Live(), next.Live()
+	changes := describeChanges(prev, live)
+	if len(changes) == 0 {
+		r.logger.Info("Configuration reloaded, nothing to apply")
+		return nil
+	}
+	r.cfg.setLive(live)
+	r.logger.Infof("Configuration reloaded: %s", strings.Join(changes, "; "))
+	for _, h := range r.handlers {
+		h(prev, live)
+	}
+	return nil
+}
+
+// restartChanges returns the names of the settings that differ and can't be applied while running
+func restartChanges(cur, next *Config) []string {
+	var names []string
+	a, b := reflect.ValueOf(cur).Elem(), reflect.ValueOf(next).Elem()
+	for n := 0; n < a.NumField(); n++ {
+		field := a.Type().Field(n)
+		if field.Anonymous || field.PkgPath != "" {
+			continue // reloadable or unexported
+		}
+		if !reflect.DeepEqual(a.Field(n).Interface(), b.Field(n).Interface()) {
+			names = append(names, field.Name)
+		}
+	}
+	return names
+}
+
+// describeChanges summarizes the differences of the reloadable settings
+func describeChanges(prev, next Reloadable) []string {
+	var changes []string
+	if added, removed := Diff(prev.TwitchBroadcasterIDs, next.TwitchBroadcasterIDs); len(added)+len(removed) > 0 {
+		changes = append(changes, fmt.Sprintf("broadcasters added %v, removed %v", added, removed))
+	}
+	if !reflect.DeepEqual(prev.Broadcasters, next.Broadcasters) || !reflect.DeepEqual(prev.Notifiers, next.Notifiers) ||
+		prev.NotifyChannelID != next.NotifyChannelID || prev.NotifyRoleID != next.NotifyRoleID {
+		changes = append(changes, "routing updated")
+	}
+	if prev.LogLevel != next.LogLevel {
+		changes = append(changes, fmt.Sprintf("log level %s → %s", prev.LogLevel, next.LogLevel))
+	}
+	return changes
+}
+
+// Diff returns the IDs of next missing from prev, and the IDs of prev missing from next
+func Diff(prev, next []string) (added, removed []string) {
+	in := func(list []string, id string) bool {
+		for _, v := range list {
+			if v == id {
+				return true
+			}
+		}
+		return false
+	}
+	for _, id := range next {
+		if !in(prev, id) {
+			added = append(added, id)
+		}
+	}
+	for _, id := range prev {
+		if !in(next, id) {
+			removed = append(removed, id)
+		}
+	}
+	return added, removed
+}
@@ -0,0 +1,148 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+// newTestReloader loads the configuration file content and returns a reloader of it,
+// logging to the returned buffer
+func newTestReloader(t *testing.T, content string) (*Reloader, *Config, *bytes.Buffer) {
+	t.Helper()
+	clearEnv(t)
+	cfg, err := Load(writeFile(t, content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs := &bytes.Buffer{}
+	logger := logrus.New()
+	logger.Out = logs
+	return NewReloader(cfg, logger), cfg, logs
+}
+
+// rewrite replaces the content of the configuration file
+func rewrite(t *testing.T, cfg *Config, content string) {
+	t.Helper()
+	if err := os.WriteFile(cfg.File, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadRejectsInvalidFile(t *testing.T) {
+	r, cfg, logs := newTestReloader(t, testFile)
+	called := false
+	r.OnReload(func(prev, next Reloadable) { called = true })
+
+	rewrite(t, cfg, strings.Replace(testFile, "level: info", "level: verbose", 1))
+	if err := r.Reload(); err == nil || !strings.Contains(err.Error(), `unknown level "verbose"`) {
+		t.Errorf("Reload() error = %v, want the invalid level", err)
+	}
+	if called {
+		t.Error("handlers called for a rejected configuration")
+	}
+	if cfg.Live().LogLevel != "info" || strings.Join(cfg.BroadcasterIDs(), ",") != "100000001,100000002" {
+		t.Errorf("running configuration changed: %+v", cfg.Live())
+	}
+	if !strings.Contains(logs.String(), "Configuration rejected, keeping the running one") {
+		t.Errorf("rejection not logged: %s", logs)
+	}
+
+	// A missing required setting is rejected too
+	rewrite(t, cfg, strings.Replace(testFile, "  token: file-token\n", "", 1))
+	if err := r.Reload(); err == nil {
+		t.Error("Reload() accepted a configuration without a bot token")
+	}
+	if cfg.BotToken != "file-token" {
+		t.Errorf("bot token = %q, want the running one", cfg.BotToken)
+	}
+}
+
+func TestReloadAppliesChanges(t *testing.T) {
+	r, cfg, logs := newTestReloader(t, testFile)
+	var got []Reloadable
+	r.OnReload(func(prev, next Reloadable) { got = append(got, prev, next) })
+
+	// Reloading the same file applies nothing
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got != nil {
+		t.Error("handlers called without changes")
+	}
+
+	next := strings.Replace(testFile, `  - id: "100000001"`, `  - id: "100000003"`, 1)
+	next = strings.Replace(next, "level: info", "level: debug", 1)
+	next = strings.Replace(next, "flap_grace_period: 2m", "flap_grace_period: 10m", 1)
+	rewrite(t, cfg, next)
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].LogLevel != "info" || got[1].LogLevel != "debug" {
+		t.Fatalf("handlers called with %+v, want the previous and the new settings", got)
+	}
+	if ids := strings.Join(cfg.BroadcasterIDs(), ","); ids != "100000003,100000002" {
+		t.Errorf("broadcasters = %s, want the reloaded ones", ids)
+	}
+	// Settings that need a restart are kept, with a warning
+	if cfg.FlapGracePeriod.String() != "2m0s" {
+		t.Errorf("flap grace period = %s, want the running one until restart", cfg.FlapGracePeriod)
+	}
+	if !strings.Contains(logs.String(), "Changed settings not applied until restart: FlapGracePeriod") {
+		t.Errorf("restart warning not logged: %s", logs)
+	}
+}
+
+func TestRestartChanges(t *testing.T) {
+	cur := &Config{Port: "8080", BotToken: "a", Reloadable: Reloadable{LogLevel: "info"}}
+	tests := []struct {
+		name string
+		next *Config
+		want string
+	}{
+		{"same", &Config{Port: "8080", BotToken: "a", Reloadable: Reloadable{LogLevel: "info"}}, ""},
+		{"reloadable only", &Config{Port: "8080", BotToken: "a", Reloadable: Reloadable{LogLevel: "debug", NotifyChannelID: "1"}}, ""},
+		{"restart only", &Config{Port: "9090", BotToken: "b", Reloadable: Reloadable{LogLevel: "info"}}, "Port, BotToken"},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(restartChanges(cur, tt.next), ", "); got != tt.want {
+			t.Errorf("%s: restartChanges() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDescribeChanges(t *testing.T) {
+	prev := Reloadable{
+		TwitchBroadcasterIDs: []string{"1", "2"},
+		Notifiers:            []Notifier{{Name: DefaultNotifier, ChannelID: "10"}},
+		NotifyChannelID:      "10",
+		LogLevel:             "info",
+	}
+	tests := []struct {
+		name   string
+		change func(r *Reloadable)
+		want   string
+	}{
+		{"nothing", func(r *Reloadable) {}, ""},
+		{"broadcasters", func(r *Reloadable) { r.TwitchBroadcasterIDs = []string{"2", "3"} }, "broadcasters added [3], removed [1]"},
+		{"routing", func(r *Reloadable) { r.Broadcasters = []Broadcaster{{ID: "1", Notifier: "esports"}} }, "routing updated"},
+		{"role", func(r *Reloadable) { r.NotifyRoleID = "20" }, "routing updated"},
+		{"log level", func(r *Reloadable) { r.LogLevel = "debug" }, "log level info → debug"},
+		{"everything", func(r *Reloadable) {
+			r.TwitchBroadcasterIDs = []string{"1"}
+			r.NotifyChannelID = "11"
+			r.LogLevel = "warn"
+		}, "broadcasters added [], removed [2]; routing updated; log level info → warn"},
+	}
+	for _, tt := range tests {
+		next := prev
+		next.TwitchBroadcasterIDs = append([]string(nil), prev.TwitchBroadcasterIDs...)
+		tt.change(&next)
+		if got := strings.Join(describeChanges(prev, next), "; "); got != tt.want {
+			t.Errorf("%s: describeChanges() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
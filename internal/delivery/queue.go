@@ -0,0 +1,435 @@
+// Package delivery posts Discord messages through a persistent queue, so a notification
+// survives Discord errors and restarts: messages are retried with exponential backoff
+// (honoring the retry_after of rate limits), delivered in order within a channel, and
+// moved to the dead letters when they can't be delivered.
+package delivery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/discordapi"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/metrics"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/storage"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// queueBucket holds the messages waiting for delivery by ID
+	queueBucket = "delivery_queue"
+
+	// deadLetterBucket holds the messages that couldn't be delivered by ID
+	deadLetterBucket = "dead_letters"
+
+	// MaxAttempts is the number of attempts before a message is moved to the dead letters
+	MaxAttempts = 8
+
+	// Bounds of the exponential backoff between two attempts
+	minBackoff = 2 * time.Second
+	maxBackoff = 10 * time.Minute
+
+	// recentAttempts is the number of delivery attempts kept in memory
+	recentAttempts = 50
+)
+
+// Outcomes of a delivery attempt
+const (
+	OutcomeDelivered  = "delivered"
+	OutcomeRetry      = "retry"
+	OutcomeDeadLetter = "dead_letter"
+)
+
+var (
+	discordSends = metrics.NewCounter("discord_messages_total", "Discord message deliveries by outcome (delivered, retry, dead_letter).", "outcome")
+)
+
+// Tag identifies what a message was posted for, so its handler can record the posted message
+type Tag struct {
+	Kind string `json:"kind,omitempty"`
+	Ref  string `json:"ref,omitempty"`
+}
+
+// Delivery is a message waiting to be posted on Discord
+type Delivery struct {
+	ID          string                 `json:"id"` // sortable by enqueue time
+	GuildID     string                 `json:"guild_id,omitempty"`
+	ChannelID   string                 `json:"channel_id"`
+	Message     *discordgo.MessageSend `json:"message"`
+	Tag         Tag                    `json:"tag"`
+	EnqueuedAt  time.Time              `json:"enqueued_at"`
+	Attempts    int                    `json:"attempts"`
+	NextAttempt time.Time              `json:"next_attempt"`
+	LastError   string                 `json:"last_error,omitempty"`
+}
+
+// Attempt is the outcome of an attempt to deliver a message
+type Attempt struct {
+	DeliveryID string    `json:"delivery_id"`
+	GuildID    string    `json:"guild_id,omitempty"`
+	ChannelID  string    `json:"channel_id"`
+	Kind       string    `json:"kind,omitempty"`
+	Outcome    string    `json:"outcome"`
+	Error      string    `json:"error,omitempty"`
+	At         time.Time `json:"at"`
+}
+
+// Handler is called with the posted message once a delivery with its tag kind succeeds
+type Handler func(d *Delivery, msg *discordgo.Message)
+
+// Queue delivers messages with a pool of workers. Messages of a channel are delivered one at a time,
+// in the order they were enqueued: a message waiting for a retry holds back the next ones.
+type Queue struct {
+	sender discordapi.Sender
+	store  *storage.Store
+	logger *logrus.Logger
+
+	mu       sync.Mutex
+	lastID   int64
+	pending  map[string][]*Delivery // by channel, in order
+	busy     map[string]bool        // channels with a delivery in progress
+	changed  chan struct{}          // closed when pending or busy change
+	handlers map[string]Handler
+	recent   []Attempt // last attempts, oldest first
+}
+
+// NewQueue creates a queue posting with sender, and loads the messages left by a previous run
+func NewQueue(sender discordapi.Sender, store *storage.Store, logger *logrus.Logger) *Queue {
+	q := &Queue{
+		sender:   sender,
+		store:    store,
+		logger:   logger,
+		pending:  map[string][]*Delivery{},
+		busy:     map[string]bool{},
+		changed:  make(chan struct{}),
+		handlers: map[string]Handler{},
+	}
+	for _, id := range store.Keys(queueBucket) {
+		var d Delivery
+		if ok, err := store.Get(queueBucket, id, &d); !ok || err != nil {
+			logger.Errorf("failed to load queued message %s: %v", id, err)
+			continue
+		}
+		q.pending[d.ChannelID] = append(q.pending[d.ChannelID], &d)
+	}
+	metrics.NewGauge("delivery_queue_depth", "Discord messages waiting for delivery.", func() float64 { return float64(q.Depth()) })
+	if n := q.Depth(); n > 0 {
+		logger.Infof("%d message(s) waiting for delivery from the previous run", n)
+	}
+	return q
+}
+
+// OnDelivered registers the handler of the deliveries tagged with kind
+func (q *Queue) OnDelivered(kind string, h Handler) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.handlers[kind] = h
+}
+
+// Enqueue persists a message for delivery and returns as soon as it is stored
+func (q *Queue) Enqueue(guildID, channelID string, msg *discordgo.MessageSend, tag Tag) (*Delivery, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	id := time.Now().UnixNano()
+	if id <= q.lastID {
+		id = q.lastID + 1
+	}
+	q.lastID = id
+	d := &Delivery{
+		ID:         fmt.Sprintf("%020d", id),
+		GuildID:    guildID,
+		ChannelID:  channelID,
+		Message:    msg,
+		Tag:        tag,
+		EnqueuedAt: time.Now(),
+	}
+	if err := q.store.Put(queueBucket, d.ID, d); err != nil {
+		return nil, fmt.Errorf("cannot queue message: %w", err)
+	}
+	q.pending[channelID] = append(q.pending[channelID], d)
+	q.notify()
+	return d, nil
+}
+
+// Depth returns the number of messages waiting for delivery
+func (q *Queue) Depth() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := 0
+	for _, list := range q.pending {
+		n += len(list)
+	}
+	return n
+}
+
+// notify wakes up the idle workers, q.mu must be held
+func (q *Queue) notify() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+// Run delivers messages with the given number of workers, until ctx is done
+func (q *Queue) Run(ctx context.Context, workers int) {
+	var wg sync.WaitGroup
+	for n := 0; n < workers; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (q *Queue) work(ctx context.Context) {
+	for {
+		d, wait, changed := q.next()
+		if d != nil {
+			q.deliver(d)
+			continue
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-changed:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// next claims the oldest due message of a channel without delivery in progress.
+// When none is due, it returns how long to wait and a channel closed on changes.
+func (q *Queue) next() (*Delivery, time.Duration, <-chan struct{}) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	wait := time.Hour
+	var due *Delivery
+	for channelID, list := range q.pending {
+		if q.busy[channelID] || len(list) == 0 {
+			continue
+		}
+		head := list[0]
+		if head.NextAttempt.After(now) {
+			if w := head.NextAttempt.Sub(now); w < wait {
+				wait = w
+			}
+			continue
+		}
+		if due == nil || head.ID < due.ID {
+			due = head
+		}
+	}
+	if due != nil {
+		q.busy[due.ChannelID] = true
+	}
+	return due, wait, q.changed
+}
+
+// deliver posts a message and, depending on the outcome, drops it from the queue,
+// schedules a retry or moves it to the dead letters
+func (q *Queue) deliver(d *Delivery) {
+	msg, err := q.sender.SendMessage(d.ChannelID, d.Message)
+	entry := q.logger.WithFields(logrus.Fields{"guild_id": d.GuildID, "channel_id": d.ChannelID, "delivery_id": d.ID})
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	defer q.notify()
+	delete(q.busy, d.ChannelID)
+
+	if err == nil {
+		discordSends.Inc(OutcomeDelivered)
+		q.record(d, OutcomeDelivered, nil)
+		q.pop(d)
+		if err := q.store.Delete(queueBucket, d.ID); err != nil {
+			entry.Errorf("failed to remove delivered message: %v", err)
+		}
+		if h := q.handlers[d.Tag.Kind]; h != nil && d.Tag.Kind != "" {
+			go h(d, msg)
+		}
+		return
+	}
+
+	d.Attempts++
+	d.LastError = err.Error()
+	retryAfter, permanent := classify(err)
+	if permanent || d.Attempts >= MaxAttempts {
+		discordSends.Inc(OutcomeDeadLetter)
+		q.record(d, OutcomeDeadLetter, err)
+		entry.Errorf("Message moved to the dead letters after %d attempt(s): %v", d.Attempts, err)
+		q.pop(d)
+		if err := q.store.Delete(queueBucket, d.ID); err != nil {
+			entry.Errorf("failed to remove dead letter from the queue: %v", err)
+		}
+		if err := q.store.Put(deadLetterBucket, d.ID, d); err != nil {
+			entry.Errorf("failed to save dead letter: %v", err)
+		}
+		return
+	}
+	discordSends.Inc(OutcomeRetry)
+	q.record(d, OutcomeRetry, err)
+	delay := backoff(d.Attempts)
+	if retryAfter > delay {
+		delay = retryAfter
+	}
+	d.NextAttempt = time.Now().Add(delay)
+	entry.Warnf("Delivery failed (attempt %d), retrying in %s: %v", d.Attempts, delay.Round(time.Second), err)
+	if err := q.store.Put(queueBucket, d.ID, d); err != nil {
+		entry.Errorf("failed to save queued message: %v", err)
+	}
+}
+
+// record keeps the outcome of an attempt in the recent attempts, q.mu must be held
+func (q *Queue) record(d *Delivery, outcome string, err error) {
+	a := Attempt{DeliveryID: d.ID, GuildID: d.GuildID, ChannelID: d.ChannelID, Kind: d.Tag.Kind, Outcome: outcome, At: time.Now()}
+	if err != nil {
+		a.Error = err.Error()
+	}
+	q.recent = append(q.recent, a)
+	if len(q.recent) > recentAttempts {
+		q.recent = q.recent[len(q.recent)-recentAttempts:]
+	}
+}
+
+// Recent returns the last delivery attempts, newest first
+func (q *Queue) Recent() []Attempt {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	attempts := make([]Attempt, len(q.recent))
+	for n, a := range q.recent {
+		attempts[len(q.recent)-1-n] = a
+	}
+	return attempts
+}
+
+// pop removes a delivery from the head of its channel, q.mu must be held
+func (q *Queue) pop(d *Delivery) {
+	list := q.pending[d.ChannelID]
+	for n, cur := range list {
+		if cur.ID == d.ID {
+			list = append(list[:n:n], list[n+1:]...)
+			break
+		}
+	}
+	if len(list) == 0 {
+		delete(q.pending, d.ChannelID)
+	} else {
+		q.pending[d.ChannelID] = list
+	}
+}
+
+// classify returns the delay requested by a rate limit, and whether the error is permanent
+// (the request is rejected and retrying won't help, e.g. missing permissions or unknown channel).
+// Rate limits (429) and timeouts (408) are the client errors worth retrying.
+func classify(err error) (time.Duration, bool) {
+	var rateLimit *discordgo.RateLimitError
+	if errors.As(err, &rateLimit) {
+		return rateLimit.RetryAfter, false
+	}
+	var restErr *discordgo.RESTError
+	if errors.As(err, &restErr) && restErr.Response != nil {
+		status := restErr.Response.StatusCode
+		if status == http.StatusTooManyRequests {
+			var body discordgo.TooManyRequests
+			if json.Unmarshal(restErr.ResponseBody, &body) == nil {
+				return body.RetryAfter, false
+			}
+			return 0, false
+		}
+		if status == http.StatusRequestTimeout {
+			return 0, false
+		}
+		return 0, status >= 400 && status < 500
+	}
+	return 0, false
+}
+
+// backoff returns the delay before the next attempt, doubling from minBackoff up to maxBackoff
+func backoff(attempts int) time.Duration {
+	delay := minBackoff
+	for n := 1; n < attempts && delay < maxBackoff; n++ {
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+	return delay
+}
+
+// DeadLetters returns the messages of a guild that couldn't be delivered, oldest first
+// (every guild when guildID is empty)
+func (q *Queue) DeadLetters(guildID string) []Delivery {
+	var letters []Delivery
+	for _, id := range q.store.Keys(deadLetterBucket) {
+		var d Delivery
+		if ok, err := q.store.Get(deadLetterBucket, id, &d); ok && err == nil && (guildID == "" || d.GuildID == guildID) {
+			letters = append(letters, d)
+		}
+	}
+	sort.Slice(letters, func(i, j int) bool { return letters[i].ID < letters[j].ID })
+	return letters
+}
+
+// Retry puts dead letters of a guild back in the queue: the one with the given ID, or all of them
+// when id is empty. It returns the number of messages queued again.
+func (q *Queue) Retry(guildID, id string) (int, error) {
+	// Held throughout, so a worker can't move a message to the dead letters while they are walked
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := 0
+	for _, d := range q.DeadLetters(guildID) {
+		if id != "" && d.ID != id {
+			continue
+		}
+		d := d
+		d.Attempts, d.NextAttempt, d.LastError = 0, time.Time{}, ""
+		if err := q.requeue(&d); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// requeue moves a dead letter back to the queue, keeping its ID (and so its place in the channel order).
+// q.mu must be held.
+func (q *Queue) requeue(d *Delivery) error {
+	if err := q.store.Put(queueBucket, d.ID, d); err != nil {
+		return err
+	}
+	if err := q.store.Delete(deadLetterBucket, d.ID); err != nil {
+		return err
+	}
+	list := append(q.pending[d.ChannelID], d)
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	q.pending[d.ChannelID] = list
+	q.notify()
+	return nil
+}
+
+// Discard deletes dead letters of a guild: the one with the given ID, or all of them when id is empty.
+// It returns the number of messages deleted.
+func (q *Queue) Discard(guildID, id string) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := 0
+	for _, d := range q.DeadLetters(guildID) {
+		if id != "" && d.ID != id {
+			continue
+		}
+		if err := q.store.Delete(deadLetterBucket, d.ID); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,346 @@
+package delivery
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/discordtest"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/storage"
+	"github.com/sirupsen/logrus"
+)
+
+// newTestQueue returns a queue posting to a Recorder, with an in-memory store
+func newTestQueue(t *testing.T) (*Queue, *discordtest.Recorder) {
+	t.Helper()
+	store, err := storage.Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := logrus.New()
+	logger.Out = io.Discard
+	rec := discordtest.NewRecorder()
+	return NewQueue(rec, store, logger), rec
+}
+
+// enqueue queues a message with the given content
+func enqueue(t *testing.T, q *Queue, channelID, content string) *Delivery {
+	t.Helper()
+	d, err := q.Enqueue("guild", channelID, &discordgo.MessageSend{Content: content}, Tag{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// deliverDue claims every message the workers could deliver now, delivers them in the order they
+// were claimed and returns their content
+func deliverDue(q *Queue) []string {
+	var claimed []*Delivery
+	for {
+		d, _, _ := q.next()
+		if d == nil {
+			break
+		}
+		claimed = append(claimed, d)
+	}
+	var sent []string
+	for _, d := range claimed {
+		q.deliver(d)
+		sent = append(sent, d.Message.Content)
+	}
+	return sent
+}
+
+// pending returns the content of the messages waiting in a channel, in order
+func pending(q *Queue, channelID string) []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var out []string
+	for _, d := range q.pending[channelID] {
+		out = append(out, d.Message.Content)
+	}
+	return out
+}
+
+// restError returns the error of a Discord response with the given status and body
+func restError(status int, body string) error {
+	return &discordgo.RESTError{Response: &http.Response{StatusCode: status}, ResponseBody: []byte(body)}
+}
+
+func TestChannelOrder(t *testing.T) {
+	q, rec := newTestQueue(t)
+	enqueue(t, q, "a", "a1")
+	enqueue(t, q, "a", "a2")
+	enqueue(t, q, "b", "b1")
+	enqueue(t, q, "a", "a3")
+	enqueue(t, q, "b", "b2")
+
+	// One message per channel at a time, the oldest first
+	rounds := [][]string{{"a1", "b1"}, {"a2", "b2"}, {"a3"}, nil}
+	for n, want := range rounds {
+		if got := deliverDue(q); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("round %d delivered %v, want %v", n+1, got, want)
+		}
+	}
+	if d := q.Depth(); d != 0 {
+		t.Errorf("depth = %d after delivering everything", d)
+	}
+	var order []string
+	for _, c := range rec.Calls() {
+		order = append(order, c.ChannelID+":"+c.Message.Content)
+	}
+	if fmt.Sprint(order) != "[a:a1 b:b1 a:a2 b:b2 a:a3]" {
+		t.Errorf("sent %v, want [a:a1 b:b1 a:a2 b:b2 a:a3]", order)
+	}
+}
+
+func TestFailedHeadBlocksChannel(t *testing.T) {
+	q, rec := newTestQueue(t)
+	rec.FailNext(discordtest.OpSend, errors.New("connection reset"))
+	head := enqueue(t, q, "a", "a1")
+	enqueue(t, q, "a", "a2")
+	enqueue(t, q, "b", "b1")
+	enqueue(t, q, "b", "b2")
+
+	if got := deliverDue(q); fmt.Sprint(got) != "[a1 b1]" {
+		t.Fatalf("first round delivered %v, want [a1 b1]", got)
+	}
+	if got := pending(q, "a"); fmt.Sprint(got) != "[a1 a2]" {
+		t.Fatalf("channel a = %v, want the failed message kept at its head", got)
+	}
+
+	// The other channel goes on while the failed message waits for its retry
+	if got := deliverDue(q); fmt.Sprint(got) != "[b2]" {
+		t.Fatalf("second round delivered %v, want only [b2]", got)
+	}
+	d, wait, _ := q.next()
+	if d != nil || wait <= 0 || wait > minBackoff {
+		t.Fatalf("next() = %v, waiting %s, want nothing until the retry in %s", d, wait, minBackoff)
+	}
+
+	// Once due, the failed message is delivered before the next one
+	q.mu.Lock()
+	head.NextAttempt = time.Time{}
+	q.mu.Unlock()
+	for n, want := range []string{"[a1]", "[a2]"} {
+		if got := deliverDue(q); fmt.Sprint(got) != want {
+			t.Errorf("round %d after the retry delivered %v, want %s", n+1, got, want)
+		}
+	}
+	if head.Attempts != 1 || head.LastError != "connection reset" {
+		t.Errorf("attempts = %d, last error %q", head.Attempts, head.LastError)
+	}
+}
+
+func TestClassify(t *testing.T) {
+	rateLimit := &discordgo.RateLimitError{RateLimit: &discordgo.RateLimit{TooManyRequests: &discordgo.TooManyRequests{RetryAfter: 3 * time.Second}}}
+	tests := []struct {
+		name       string
+		err        error
+		retryAfter time.Duration
+		permanent  bool
+	}{
+		{"rate limit error", rateLimit, 3 * time.Second, false},
+		{"wrapped rate limit error", fmt.Errorf("send: %w", rateLimit), 3 * time.Second, false},
+		{"429 with retry_after", restError(http.StatusTooManyRequests, `{"message": "You are being rate limited.", "retry_after": 1.5}`), 1500 * time.Millisecond, false},
+		{"429 without body", restError(http.StatusTooManyRequests, ``), 0, false},
+		{"429 from a proxy", restError(http.StatusTooManyRequests, `<html>Too Many Requests</html>`), 0, false},
+		{"wrapped 429", fmt.Errorf("send: %w", restError(http.StatusTooManyRequests, `{"retry_after": 2}`)), 2 * time.Second, false},
+		{"request timeout", restError(http.StatusRequestTimeout, ``), 0, false},
+		{"missing permissions", restError(http.StatusForbidden, `{"message": "Missing Permissions", "code": 50013}`), 0, true},
+		{"unknown channel", restError(http.StatusNotFound, `{"message": "Unknown Channel", "code": 10003}`), 0, true},
+		{"bad request", restError(http.StatusBadRequest, `{}`), 0, true},
+		{"server error", restError(http.StatusBadGateway, ``), 0, false},
+		{"no response", &discordgo.RESTError{}, 0, false},
+		{"network error", errors.New("dial tcp: i/o timeout"), 0, false},
+	}
+	for _, tt := range tests {
+		retryAfter, permanent := classify(tt.err)
+		if retryAfter != tt.retryAfter || permanent != tt.permanent {
+			t.Errorf("%s: classify = %s, %v, want %s, %v", tt.name, retryAfter, permanent, tt.retryAfter, tt.permanent)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, minBackoff},
+		{1, 2 * time.Second},
+		{2, 4 * time.Second},
+		{5, 32 * time.Second},
+		{9, 512 * time.Second},
+		{10, maxBackoff},
+		{100, maxBackoff},
+	}
+	for _, tt := range tests {
+		if got := backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestDeliveryOutcome(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		attempts int // before this one
+		outcome  string
+		delay    time.Duration // before the retry
+	}{
+		{"network error", errors.New("connection reset"), 0, OutcomeRetry, minBackoff},
+		{"backoff grows", errors.New("connection reset"), 3, OutcomeRetry, 16 * time.Second},
+		{"retry_after above the backoff", restError(http.StatusTooManyRequests, `{"retry_after": 30}`), 0, OutcomeRetry, 30 * time.Second},
+		{"retry_after below the backoff", restError(http.StatusTooManyRequests, `{"retry_after": 0.5}`), 2, OutcomeRetry, 8 * time.Second},
+		{"server error", restError(http.StatusInternalServerError, ``), 0, OutcomeRetry, minBackoff},
+		{"missing permissions", restError(http.StatusForbidden, `{"code": 50013}`), 0, OutcomeDeadLetter, 0},
+		{"unknown channel", restError(http.StatusNotFound, `{"code": 10003}`), 0, OutcomeDeadLetter, 0},
+		{"last attempt", errors.New("connection reset"), MaxAttempts - 1, OutcomeDeadLetter, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, rec := newTestQueue(t)
+			rec.FailNext(discordtest.OpSend, tt.err)
+			d := enqueue(t, q, "a", "a1")
+			d.Attempts = tt.attempts
+			start := time.Now()
+			deliverDue(q)
+
+			if got := q.Recent()[0].Outcome; got != tt.outcome {
+				t.Fatalf("outcome = %s, want %s", got, tt.outcome)
+			}
+			letters := q.DeadLetters("guild")
+			if tt.outcome == OutcomeDeadLetter {
+				if q.Depth() != 0 || len(letters) != 1 || letters[0].ID != d.ID || letters[0].LastError != tt.err.Error() {
+					t.Errorf("depth = %d, dead letters %+v, want the message moved to the dead letters", q.Depth(), letters)
+				}
+				if ok, _ := q.store.Get(queueBucket, d.ID, &Delivery{}); ok {
+					t.Error("dead letter still stored in the queue")
+				}
+				return
+			}
+			if len(letters) != 0 || q.Depth() != 1 {
+				t.Fatalf("depth = %d, %d dead letter(s), want the message kept in the queue", q.Depth(), len(letters))
+			}
+			if delay := d.NextAttempt.Sub(start); delay < tt.delay || delay > tt.delay+time.Second {
+				t.Errorf("retry in %s, want %s", delay, tt.delay)
+			}
+			var stored Delivery
+			if ok, err := q.store.Get(queueBucket, d.ID, &stored); !ok || err != nil || stored.Attempts != tt.attempts+1 {
+				t.Errorf("stored attempts = %d (%v, %v), want %d", stored.Attempts, ok, err, tt.attempts+1)
+			}
+		})
+	}
+}
+
+func TestRetryKeepsOrder(t *testing.T) {
+	q, rec := newTestQueue(t)
+	unknown := restError(http.StatusNotFound, `{"code": 10003}`)
+	rec.FailNext(discordtest.OpSend, unknown)
+	rec.FailNext(discordtest.OpSend, unknown)
+	first := enqueue(t, q, "a", "a1")
+	second := enqueue(t, q, "a", "a2")
+	deliverDue(q)
+	deliverDue(q)
+	if n := len(q.DeadLetters("")); n != 2 {
+		t.Fatalf("%d dead letter(s), want 2", n)
+	}
+	enqueue(t, q, "a", "a3")
+
+	// Retried dead letters get back their place before the messages queued since
+	if n, err := q.Retry("other guild", ""); n != 0 || err != nil {
+		t.Errorf("Retry(other guild) = %d, %v, want 0", n, err)
+	}
+	if n, err := q.Retry("guild", second.ID); n != 1 || err != nil {
+		t.Fatalf("Retry(%s) = %d, %v", second.ID, n, err)
+	}
+	if got := pending(q, "a"); fmt.Sprint(got) != "[a2 a3]" {
+		t.Errorf("after retrying a2, channel a = %v, want [a2 a3]", got)
+	}
+	if n, err := q.Retry("guild", ""); n != 1 || err != nil {
+		t.Fatalf("Retry(all) = %d, %v, want 1", n, err)
+	}
+	if got := pending(q, "a"); fmt.Sprint(got) != "[a1 a2 a3]" {
+		t.Errorf("after retrying all, channel a = %v, want [a1 a2 a3]", got)
+	}
+	if len(q.DeadLetters("")) != 0 {
+		t.Error("dead letters kept after the retry")
+	}
+
+	for n, want := range []string{"[a1]", "[a2]", "[a3]"} {
+		if got := deliverDue(q); fmt.Sprint(got) != want {
+			t.Errorf("round %d delivered %v, want %s", n+1, got, want)
+		}
+	}
+	if first.Attempts != 1 {
+		t.Errorf("original delivery changed by the retry: attempts = %d", first.Attempts)
+	}
+}
+
+func TestConcurrentRetryAndDiscard(t *testing.T) {
+	q, rec := newTestQueue(t)
+	// Saving the store to a file widens the window between listing the dead letters and moving them
+	store, err := storage.Open(filepath.Join(t.TempDir(), "store.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.store = store
+	const n = 20
+	for i := 0; i < n; i++ {
+		rec.FailNext(discordtest.OpSend, restError(http.StatusNotFound, `{"code": 10003}`))
+		enqueue(t, q, strconv.Itoa(i), strconv.Itoa(i))
+	}
+	deliverDue(q)
+	if got := len(q.DeadLetters("")); got != n {
+		t.Fatalf("%d dead letter(s), want %d", got, n)
+	}
+
+	// Every dead letter is either retried or discarded, exactly once
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	retried, discarded := 0, 0
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			r, err := q.Retry("guild", "")
+			if err != nil {
+				t.Error(err)
+			}
+			mu.Lock()
+			retried += r
+			mu.Unlock()
+		}()
+		go func() {
+			defer wg.Done()
+			d, err := q.Discard("guild", "")
+			if err != nil {
+				t.Error(err)
+			}
+			mu.Lock()
+			discarded += d
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if retried+discarded != n {
+		t.Errorf("%d retried and %d discarded, want %d in total", retried, discarded, n)
+	}
+	if d := q.Depth(); d != retried {
+		t.Errorf("queue depth = %d, want the %d retried messages", d, retried)
+	}
+	if got := len(q.DeadLetters("")); got != 0 {
+		t.Errorf("%d dead letter(s) left", got)
+	}
+}
@@ -0,0 +1,167 @@
+package discord
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/delivery"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/i18n"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/quiet"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// quietDigestBucket holds, by guild ID, the announcements queued during quiet hours
+const quietDigestBucket = "quiet_digest"
+
+// queuedAnnouncement is an announcement held back until the end of the quiet hours
+type queuedAnnouncement struct {
+	ChannelID string    `json:"channel_id"`
+	Summary   string    `json:"summary"`
+	QueuedAt  time.Time `json:"queued_at"`
+	Until     time.Time `json:"until"` // end of the quiet hours
+}
+
+// Announce queues a live announcement, honoring the quiet hours of the guild of the channel:
+// outside quiet hours, the role of the notifier of the channel (e.g. NOTIFY_ROLE_ID) is pinged;
+// during quiet hours, the announcement is dropped, sent silently, or queued as a one-line summary
+// for the digest posted at the end of the window. The handler registered for the kind of tag
+// receives the posted message. It reports whether the announcement was queued for delivery.
+// Logs go to the logger carried by ctx.
+func (c *Client) Announce(ctx context.Context, channelID string, embed *discordgo.MessageEmbed, summary string, tag delivery.Tag) (bool, error) {
+	if channelID == "" {
+		channelID = c.cfg.NotifyChannel()
+	}
+	msg := &discordgo.MessageSend{Embeds: []*discordgo.MessageEmbed{embed}}
+	role := c.cfg.PingRole(channelID)
+	ping := role != ""
+
+	guildID := c.ChannelGuildID(channelID)
+	entry := utils.Log(ctx, c.logger).WithFields(logrus.Fields{"guild_id": guildID, "channel_id": channelID})
+	if g, err := c.settings.Guild(guildID); err == nil && guildID != "" {
+		if active, end := g.QuietHours.Window(time.Now(), g.Location()); active {
+			switch g.QuietHours.EffectiveMode() {
+			case quiet.ModeSuppress:
+				entry.Info("Quiet hours: announcement suppressed")
+				return false, nil
+			case quiet.ModeDigest:
+				entry.Info("Quiet hours: announcement queued for the digest")
+				return false, c.queueAnnouncement(guildID, queuedAnnouncement{ChannelID: channelID, Summary: summary, QueuedAt: time.Now(), Until: end})
+			default:
+				entry.Info("Quiet hours: announcement sent silently")
+				ping = false
+				msg.Flags = discordgo.MessageFlagsSuppressNotifications
+			}
+		}
+	}
+
+	if ping {
+		msg.Content = "<@&" + role + ">"
+		msg.AllowedMentions = &discordgo.MessageAllowedMentions{Roles: []string{role}}
+	} else {
+		msg.AllowedMentions = &discordgo.MessageAllowedMentions{}
+	}
+	if err := c.enqueue(channelID, msg, tag); err != nil {
+		return false, err
+	}
+	entry.Debug("Announcement queued")
+	return true, nil
+}
+
+func (c *Client) queueAnnouncement(guildID string, a queuedAnnouncement) error {
+	c.quietMu.Lock()
+	defer c.quietMu.Unlock()
+	var queue []queuedAnnouncement
+	if _, err := c.store.Get(quietDigestBucket, guildID, &queue); err != nil {
+		return err
+	}
+	return c.store.Put(quietDigestBucket, guildID, append(queue, a))
+}
+
+// runQuietDigests posts, every minute, the digests of the quiet hours that ended
+func (c *Client) runQuietDigests(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		c.flushQuietDigests()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// flushQuietDigests queues one digest message per channel for the queues whose quiet hours are over
+func (c *Client) flushQuietDigests() {
+	c.quietMu.Lock()
+	defer c.quietMu.Unlock()
+
+	now := time.Now()
+	for _, guildID := range c.store.Keys(quietDigestBucket) {
+		var queue []queuedAnnouncement
+		if _, err := c.store.Get(quietDigestBucket, guildID, &queue); err != nil {
+			c.logger.Errorf("failed to load the quiet hours digest of %s: %v", guildID, err)
+			continue
+		}
+		var due, pending []queuedAnnouncement
+		for _, a := range queue {
+			if now.Before(a.Until) {
+				pending = append(pending, a)
+			} else {
+				due = append(due, a)
+			}
+		}
+		if len(due) == 0 {
+			continue
+		}
+
+		// Group by channel, keeping the order of the announcements
+		var channels []string
+		byChannel := map[string][]string{}
+		for _, a := range due {
+			if _, ok := byChannel[a.ChannelID]; !ok {
+				channels = append(channels, a.ChannelID)
+			}
+			byChannel[a.ChannelID] = append(byChannel[a.ChannelID], "• "+a.Summary)
+		}
+		for _, channelID := range channels {
+			lang := c.ChannelLanguage(channelID)
+			content := i18n.T(lang, "quiet.digest", len(byChannel[channelID])) + "\n" + strings.Join(byChannel[channelID], "\n")
+			if runes := []rune(content); len(runes) > 2000 {
+				content = string(runes[:1997]) + "..."
+			}
+			err := c.enqueue(channelID, &discordgo.MessageSend{
+				Content:         content,
+				AllowedMentions: &discordgo.MessageAllowedMentions{},
+			}, delivery.Tag{})
+			if err != nil {
+				c.logger.Errorf("failed to queue the quiet hours digest in %s: %v", channelID, err)
+				pending = append(pending, filterChannel(due, channelID)...)
+			}
+		}
+
+		var err error
+		if len(pending) == 0 {
+			err = c.store.Delete(quietDigestBucket, guildID)
+		} else {
+			err = c.store.Put(quietDigestBucket, guildID, pending)
+		}
+		if err != nil {
+			c.logger.Errorf("failed to save the quiet hours digest of %s: %v", guildID, err)
+		}
+	}
+}
+
+// filterChannel returns the announcements of a channel
+func filterChannel(queue []queuedAnnouncement, channelID string) []queuedAnnouncement {
+	var out []queuedAnnouncement
+	for _, a := range queue {
+		if a.ChannelID == channelID {
+			out = append(out, a)
+		}
+	}
+	return out
+}
@@ -0,0 +1,200 @@
+package discord
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/config"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/delivery"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/discordtest"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/i18n"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/quiet"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/settings"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/storage"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	testGuild    = "900000000000000001"
+	testChannel  = "900000000000000002"
+	testRole     = "900000000000000003"
+	otherChannel = "900000000000000004"
+)
+
+// newTestClient returns a client announcing in testChannel, pinging testRole, with its Discord
+// calls recorded. The delivery queue runs until the end of the test.
+func newTestClient(t *testing.T) (*Client, *discordtest.Recorder) {
+	t.Helper()
+	cfg := &config.Config{
+		BotToken:        "test-bot-token",
+		DefaultLanguage: i18n.English,
+		DeliveryWorkers: 1,
+		Reloadable: config.Reloadable{
+			Notifiers:       []config.Notifier{{Name: config.DefaultNotifier, ChannelID: testChannel, RoleID: testRole}},
+			NotifyChannelID: testChannel,
+			NotifyRoleID:    testRole,
+		},
+	}
+	store, err := storage.Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := discordtest.NewRecorder()
+	recorder.AddChannel(&discordgo.Channel{ID: testChannel, GuildID: testGuild, Type: discordgo.ChannelTypeGuildText})
+	recorder.AddChannel(&discordgo.Channel{ID: otherChannel, GuildID: testGuild, Type: discordgo.ChannelTypeGuildText})
+
+	logger := logrus.New()
+	logger.Out = io.Discard
+	c, err := NewClientWithSender(cfg, logger, store, recorder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go c.Queue().Run(ctx, 1)
+	return c, recorder
+}
+
+// setQuietHours makes the whole day quiet in testGuild, with the given mode
+func setQuietHours(t *testing.T, c *Client, mode string) {
+	t.Helper()
+	_, err := c.settings.UpdateGuild(testGuild, func(g *settings.Guild) {
+		g.Timezone = "UTC"
+		g.QuietHours = quiet.Hours{Mode: mode, Rules: []quiet.Rule{{Start: "00:00", End: "00:00"}}}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// quietQueue returns the announcements queued for the digest of testGuild
+func quietQueue(t *testing.T, c *Client) []queuedAnnouncement {
+	t.Helper()
+	var queue []queuedAnnouncement
+	if _, err := c.store.Get(quietDigestBucket, testGuild, &queue); err != nil {
+		t.Fatal(err)
+	}
+	return queue
+}
+
+func TestAnnounceQuietHours(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    string // no quiet hours when empty
+		queued  bool   // queued for delivery
+		content string // of the sent message
+		flags   discordgo.MessageFlags
+		roles   []string // allowed mentions
+		digest  bool     // queued for the digest
+	}{
+		{name: "outside quiet hours", queued: true, content: "<@&" + testRole + ">", roles: []string{testRole}},
+		{name: "suppress", mode: quiet.ModeSuppress},
+		{name: "silent", mode: quiet.ModeSilent, queued: true, flags: discordgo.MessageFlagsSuppressNotifications},
+		{name: "digest", mode: quiet.ModeDigest, digest: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, recorder := newTestClient(t)
+			if tt.mode != "" {
+				setQuietHours(t, c, tt.mode)
+			}
+
+			embed := &discordgo.MessageEmbed{Title: "🔴 Alice is live!"}
+			queued, err := c.Announce(context.Background(), "", embed, "**Alice** — Celeste", delivery.Tag{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if queued != tt.queued {
+				t.Errorf("Announce() = %v, want %v", queued, tt.queued)
+			}
+
+			if tt.queued {
+				msg := recorder.Wait(t, 1)[0]
+				if msg.Op != discordtest.OpSend || msg.ChannelID != testChannel || msg.Message.Embeds[0] != embed {
+					t.Fatalf("%s in %s %+v, want the announcement", msg.Op, msg.ChannelID, msg.Message)
+				}
+				if msg.Message.Content != tt.content || msg.Message.Flags != tt.flags {
+					t.Errorf("content %q, flags %d, want %q, %d", msg.Message.Content, msg.Message.Flags, tt.content, tt.flags)
+				}
+				if roles := msg.Message.AllowedMentions.Roles; fmt.Sprint(roles) != fmt.Sprint(tt.roles) {
+					t.Errorf("allowed role mentions %v, want %v", roles, tt.roles)
+				}
+			} else if d := c.Queue().Depth(); d != 0 || len(recorder.Calls()) != 0 {
+				t.Errorf("announcement sent during quiet hours: %+v", recorder.Calls())
+			}
+
+			queue := quietQueue(t, c)
+			if !tt.digest {
+				if len(queue) != 0 {
+					t.Errorf("queued for the digest: %+v", queue)
+				}
+				return
+			}
+			midnight := time.Now().UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+			if len(queue) != 1 || queue[0].ChannelID != testChannel || queue[0].Summary != "**Alice** — Celeste" || !queue[0].Until.Equal(midnight) {
+				t.Errorf("digest queue %+v, want the summary until %s", queue, midnight)
+			}
+		})
+	}
+}
+
+func TestFlushQuietDigests(t *testing.T) {
+	c, recorder := newTestClient(t)
+	ended, later := time.Now().Add(-time.Minute), time.Now().Add(time.Hour)
+	for _, a := range []queuedAnnouncement{
+		{ChannelID: testChannel, Summary: "**Alice** — Celeste", Until: ended},
+		{ChannelID: otherChannel, Summary: "**Bob** — Chess", Until: ended},
+		{ChannelID: testChannel, Summary: "**Carol** — Just Chatting", Until: ended},
+		{ChannelID: testChannel, Summary: "**Dave** — Tetris", Until: later}, // quiet hours not over yet
+	} {
+		if err := c.queueAnnouncement(testGuild, a); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// One digest per channel, of the announcements whose quiet hours are over
+	c.flushQuietDigests()
+	calls := recorder.Wait(t, 2)
+	want := map[string]string{
+		testChannel:  "🌙 **2 stream(s) started during the quiet hours:**\n• **Alice** — Celeste\n• **Carol** — Just Chatting",
+		otherChannel: "🌙 **1 stream(s) started during the quiet hours:**\n• **Bob** — Chess",
+	}
+	for _, call := range calls {
+		if call.Op != discordtest.OpSend || call.Message.Content != want[call.ChannelID] {
+			t.Errorf("%s in %s %q, want %q", call.Op, call.ChannelID, call.Message.Content, want[call.ChannelID])
+		}
+		if call.Message.AllowedMentions == nil || len(call.Message.AllowedMentions.Roles) != 0 {
+			t.Errorf("digest in %s may ping: %+v", call.ChannelID, call.Message.AllowedMentions)
+		}
+		delete(want, call.ChannelID)
+	}
+	if queue := quietQueue(t, c); len(queue) != 1 || !strings.Contains(queue[0].Summary, "Dave") {
+		t.Errorf("digest queue %+v, want the pending announcement only", queue)
+	}
+
+	// Nothing more is posted until the pending announcement is due
+	c.flushQuietDigests()
+	time.Sleep(50 * time.Millisecond) // the queue delivers in the background
+	if n := len(recorder.Calls()); n != 2 {
+		t.Errorf("%d Discord calls, want the 2 digests", n)
+	}
+
+	// The queue is removed once every announcement is posted
+	queue := quietQueue(t, c)
+	queue[0].Until = ended
+	if err := c.store.Put(quietDigestBucket, testGuild, queue); err != nil {
+		t.Fatal(err)
+	}
+	c.flushQuietDigests()
+	if last := recorder.Wait(t, 3)[2]; last.ChannelID != testChannel || !strings.HasSuffix(last.Message.Content, "\n• **Dave** — Tetris") {
+		t.Errorf("%s in %s %q, want the digest of the pending announcement", last.Op, last.ChannelID, last.Message.Content)
+	}
+	if keys := c.store.Keys(quietDigestBucket); len(keys) != 0 {
+		t.Errorf("digest queues %v left", keys)
+	}
+}
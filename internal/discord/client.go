@@ -3,23 +3,45 @@ package discord
 import (
 	"context"
 	"fmt"
+	"sync"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/flthibaud/TwitchLiveNotifier/internal/config"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/delivery"
 	"github.com/flthibaud/TwitchLiveNotifier/internal/discord/commands"
 	"github.com/flthibaud/TwitchLiveNotifier/internal/discord/events"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/discordapi"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/i18n"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/permissions"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/settings"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/storage"
 	"github.com/sirupsen/logrus"
 )
 
 // Client wraps the Discord session and provides start/stop functionality
 type Client struct {
-	session *discordgo.Session
-	cfg     *config.Config
-	logger  *logrus.Logger
+	session     *discordgo.Session
+	sender      discordapi.Sender
+	cfg         *config.Config
+	logger      *logrus.Logger
+	store       *storage.Store
+	router      *commands.Router
+	permissions *permissions.Manager
+	settings    *settings.Manager
+	queue       *delivery.Queue
+
+	quietMu sync.Mutex // serializes the quiet hours digest queues
 }
 
 // NewClient creates a new Discord client and registers event handlers
-func NewClient(cfg *config.Config, logger *logrus.Logger) (*Client, error) {
+func NewClient(cfg *config.Config, logger *logrus.Logger, store *storage.Store) (*Client, error) {
+	return NewClientWithSender(cfg, logger, store, nil)
+}
+
+// NewClientWithSender creates a Discord client calling the Discord API through sender (e.g. a
+// discordtest.Recorder) instead of its session, which still receives the gateway events.
+// A nil sender uses the session.
+func NewClientWithSender(cfg *config.Config, logger *logrus.Logger, store *storage.Store, sender discordapi.Sender) (*Client, error) {
 	dg, err := discordgo.New("Bot " + cfg.BotToken)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create discord session: %w", err)
@@ -27,17 +49,43 @@ func NewClient(cfg *config.Config, logger *logrus.Logger) (*Client, error) {
 
 	// Set required intents
 	dg.Identify.Intents = discordgo.IntentsGuilds | discordgo.IntentsGuildMessages
+	if cfg.LiveRoles {
+		// Privileged, it must be enabled in the Developer Portal
+		dg.Identify.Intents |= discordgo.IntentsGuildMembers
+	}
+	if cfg.PresenceDetection {
+		// Also privileged
+		dg.Identify.Intents |= discordgo.IntentsGuildPresences
+	}
 
+	if sender == nil {
+		sender = discordapi.NewSession(dg)
+	}
 	client := &Client{
-		session: dg,
-		cfg:     cfg,
-		logger:  logger,
+		session:     dg,
+		sender:      sender,
+		cfg:         cfg,
+		logger:      logger,
+		store:       store,
+		router:      commands.NewRouter(logger),
+		permissions: permissions.NewManager(store, logger),
+		settings:    settings.NewManager(store),
+		queue:       delivery.NewQueue(sender, store, logger),
 	}
 
+	// Slash commands, dispatched by the router after the permission check
+	client.router.SetAuthorizer(client.permissions)
+	client.router.Add(&commands.Command{Definition: commands.PingCommand, Handler: commands.PingHandler})
+	client.router.Add(commands.NewPermissionsCommand(client.permissions, client.router.Has, logger))
+	client.router.Add(commands.NewLanguageCommand(client.settings, logger))
+	client.router.Add(commands.NewTimezoneCommand(client.settings, logger))
+	client.router.Add(commands.NewQuietCommand(client.settings, logger))
+	client.router.Add(commands.NewDeliveriesCommand(client.queue, logger))
+
 	// Register event handlers
 	dg.AddHandler(events.OnReady)
 	dg.AddHandler(events.OnMessageCreate)
-	dg.AddHandler(commands.PingHandler)
+	dg.AddHandler(client.router.Handle)
 
 	return client, nil
 }
@@ -50,17 +98,24 @@ func (c *Client) Start(ctx context.Context) error {
 	}
 	c.logger.Info("Discord session opened")
 
-	// Register slash commands now that session is open and app info is available
-	commands.Register(c.session)
+	// Sync slash commands now that session is open and app info is available.
+	// Commands are kept across restarts, they are only overwritten when definitions change.
+	if updated, err := commands.Register(c.session, c.cfg.DiscordGuildID, c.router.Definitions()); err != nil {
+		c.logger.Errorf("failed to sync commands: %v", err)
+	} else if updated {
+		c.logger.Infof("Slash commands updated (%s)", c.commandScope())
+	} else {
+		c.logger.Infof("Slash commands up to date (%s)", c.commandScope())
+	}
+
+	// Outbound messages, including the ones left by the previous run
+	go c.queue.Run(ctx, c.cfg.DeliveryWorkers)
+
+	// Digests of the announcements held back by quiet hours
+	go c.runQuietDigests(ctx)
 
 	// Ensure cleanup on shutdown
 	defer func() {
-		// Cleanup application commands
-		for _, cmd := range commands.List {
-			if err := c.session.ApplicationCommandDelete(c.session.State.User.ID, "", cmd.ID); err != nil {
-				c.logger.Errorf("failed to delete command %s: %v", cmd.Name, err)
-			}
-		}
 		c.session.Close()
 		c.logger.Info("Discord session closed")
 	}()
@@ -70,14 +125,154 @@ func (c *Client) Start(ctx context.Context) error {
 	return nil
 }
 
+// AddCommand registers a slash command. It must be called before Start to be synchronized with Discord.
+func (c *Client) AddCommand(cmd *commands.Command) {
+	c.router.Add(cmd)
+}
+
+// Permissions returns the permission manager enforcing command policies
+func (c *Client) Permissions() *permissions.Manager {
+	return c.permissions
+}
+
+// Settings returns the guild settings manager
+func (c *Client) Settings() *settings.Manager {
+	return c.settings
+}
+
+// ChannelLanguage returns the language configured for the guild owning a channel,
+// or the default language of the bot
+func (c *Client) ChannelLanguage(channelID string) string {
+	if channelID == "" {
+		channelID = c.cfg.NotifyChannel()
+	}
+	guildID := c.ChannelGuildID(channelID)
+	if guildID == "" {
+		return c.cfg.DefaultLanguage
+	}
+	g, err := c.settings.Guild(guildID)
+	if err != nil || !i18n.Supported(g.Language) {
+		return c.cfg.DefaultLanguage
+	}
+	return g.Language
+}
+
+// AddHandler registers a discordgo event handler on the session
+func (c *Client) AddHandler(handler interface{}) {
+	c.session.AddHandler(handler)
+}
+
+// AddRole gives a role to a guild member
+func (c *Client) AddRole(guildID, userID, roleID string) error {
+	return c.sender.AddRole(guildID, userID, roleID)
+}
+
+// RemoveRole removes a role from a guild member
+func (c *Client) RemoveRole(guildID, userID, roleID string) error {
+	return c.sender.RemoveRole(guildID, userID, roleID)
+}
+
+// GuildMember returns a member of a guild, from the state cache when possible
+func (c *Client) GuildMember(guildID, userID string) (*discordgo.Member, error) {
+	if m, err := c.session.State.Member(guildID, userID); err == nil {
+		return m, nil
+	}
+	return c.sender.Member(guildID, userID)
+}
+
+// GuildMembers lists every member of a guild (requires the GUILD_MEMBERS intent)
+func (c *Client) GuildMembers(guildID string) ([]*discordgo.Member, error) {
+	var members []*discordgo.Member
+	after := ""
+	for {
+		page, err := c.sender.Members(guildID, after, 1000)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, page...)
+		if len(page) < 1000 {
+			return members, nil
+		}
+		after = page[len(page)-1].User.ID
+	}
+}
+
+// commandScope describes where slash commands are registered
+func (c *Client) commandScope() string {
+	if c.cfg.DiscordGuildID != "" {
+		return "guild " + c.cfg.DiscordGuildID
+	}
+	return "global"
+}
+
 func (c *Client) Stop() {
 	c.session.Close()
 }
 
+// SendEmbed queues an embed for a channel (the notification channel when empty)
 func (c *Client) SendEmbed(channelID string, embed *discordgo.MessageEmbed) error {
 	if channelID == "" {
-		channelID = c.cfg.NotifyChannelID
+		channelID = c.cfg.NotifyChannel()
 	}
-	_, err := c.session.ChannelMessageSendEmbed(channelID, embed)
+	return c.enqueue(channelID, &discordgo.MessageSend{Embeds: []*discordgo.MessageEmbed{embed}}, delivery.Tag{})
+}
+
+// enqueue queues a message for the delivery workers
+func (c *Client) enqueue(channelID string, msg *discordgo.MessageSend, tag delivery.Tag) error {
+	_, err := c.queue.Enqueue(c.ChannelGuildID(channelID), channelID, msg, tag)
 	return err
 }
+
+// OnDelivered registers the handler called with the posted message of the deliveries tagged with kind
+func (c *Client) OnDelivered(kind string, h delivery.Handler) {
+	c.queue.OnDelivered(kind, h)
+}
+
+// Ready reports whether the Discord session is connected and received its initial state
+func (c *Client) Ready() bool {
+	c.session.RLock()
+	defer c.session.RUnlock()
+	return c.session.DataReady
+}
+
+// Queue returns the outbound message queue
+func (c *Client) Queue() *delivery.Queue {
+	return c.queue
+}
+
+// ChannelGuildID returns the guild owning a channel, or an empty string when unknown
+func (c *Client) ChannelGuildID(channelID string) string {
+	if ch, err := c.session.State.Channel(channelID); err == nil {
+		return ch.GuildID
+	}
+	ch, err := c.sender.Channel(channelID)
+	if err != nil {
+		return ""
+	}
+	return ch.GuildID
+}
+
+// CreateScheduledEvent creates a Guild Scheduled Event (requires the Manage Events permission)
+func (c *Client) CreateScheduledEvent(guildID string, params *discordgo.GuildScheduledEventParams) (*discordgo.GuildScheduledEvent, error) {
+	return c.sender.CreateScheduledEvent(guildID, params)
+}
+
+// EditScheduledEvent updates a Guild Scheduled Event
+func (c *Client) EditScheduledEvent(guildID, eventID string, params *discordgo.GuildScheduledEventParams) (*discordgo.GuildScheduledEvent, error) {
+	return c.sender.EditScheduledEvent(guildID, eventID, params)
+}
+
+// SendReply queues an embed replying to a message
+func (c *Client) SendReply(channelID, messageID string, embed *discordgo.MessageEmbed) error {
+	return c.enqueue(channelID, &discordgo.MessageSend{
+		Embeds:    []*discordgo.MessageEmbed{embed},
+		Reference: &discordgo.MessageReference{MessageID: messageID, ChannelID: channelID},
+		// Don't ping the author of the announcement (the bot)
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	}, delivery.Tag{})
+}
+
+// EditEmbed replaces the embed of a message
+func (c *Client) EditEmbed(channelID, messageID string, embed *discordgo.MessageEmbed) (*discordgo.Message, error) {
+	return c.sender.EditMessage(discordgo.NewMessageEdit(channelID, messageID).SetEmbed(embed))
+}
@@ -0,0 +1,92 @@
+package commands
+
+import (
+	"io"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/delivery"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/discordtest"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/permissions"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/settings"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/storage"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	testGuild   = "700000000000000001"
+	testChannel = "700000000000000002"
+	testAdmin   = "700000000000000003"
+	testMember  = "700000000000000004"
+)
+
+// newTestRouter returns a router with the commands of the bot, checked by the permission manager
+func newTestRouter(t *testing.T, rec *discordtest.Recorder) *Router {
+	t.Helper()
+	store, err := storage.Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := logrus.New()
+	logger.Out = io.Discard
+
+	r := NewRouter(logger)
+	perms := permissions.NewManager(store, logger)
+	m := settings.NewManager(store)
+	r.SetAuthorizer(perms)
+	r.Add(&Command{Definition: PingCommand, Handler: PingHandler})
+	r.Add(NewPermissionsCommand(perms, r.Has, logger))
+	r.Add(NewLanguageCommand(m, logger))
+	r.Add(NewTimezoneCommand(m, logger))
+	r.Add(NewQuietCommand(m, logger))
+	r.Add(NewDeliveriesCommand(delivery.NewQueue(rec, store, logger), logger))
+	return r
+}
+
+// interaction builds a slash command interaction of a guild member with the given permissions
+func interaction(userID string, perms int64, locale discordgo.Locale, name string, opts ...*discordgo.ApplicationCommandInteractionDataOption) *discordgo.InteractionCreate {
+	return &discordgo.InteractionCreate{Interaction: &discordgo.Interaction{
+		ID:        "interaction-" + name,
+		Type:      discordgo.InteractionApplicationCommand,
+		GuildID:   testGuild,
+		ChannelID: testChannel,
+		Locale:    locale,
+		Member:    &discordgo.Member{User: &discordgo.User{ID: userID}, Permissions: perms},
+		Data:      discordgo.ApplicationCommandInteractionData{Name: name, Options: opts},
+	}}
+}
+
+func subCommand(name string, opts ...*discordgo.ApplicationCommandInteractionDataOption) *discordgo.ApplicationCommandInteractionDataOption {
+	return &discordgo.ApplicationCommandInteractionDataOption{Name: name, Type: discordgo.ApplicationCommandOptionSubCommand, Options: opts}
+}
+
+func stringOption(name, value string) *discordgo.ApplicationCommandInteractionDataOption {
+	return &discordgo.ApplicationCommandInteractionDataOption{Name: name, Type: discordgo.ApplicationCommandOptionString, Value: value}
+}
+
+func TestCommandsGolden(t *testing.T) {
+	admin := int64(discordgo.PermissionManageServer)
+	tests := []struct {
+		golden string
+		i      *discordgo.InteractionCreate
+	}{
+		{"ping_en", interaction(testMember, 0, discordgo.EnglishUS, "ping")},
+		{"ping_fr", interaction(testMember, 0, discordgo.French, "ping")},
+		{"language_set", interaction(testAdmin, admin, discordgo.EnglishUS, "language", stringOption("language", "fr"))},
+		{"language_denied", interaction(testMember, 0, discordgo.EnglishUS, "language", stringOption("language", "fr"))},
+		{"timezone_invalid", interaction(testAdmin, admin, discordgo.French, "timezone", stringOption("timezone", "Mars/Olympus_Mons"))},
+		{"quiet_mode", interaction(testAdmin, admin, discordgo.EnglishUS, "quiet", subCommand("mode", stringOption("mode", "digest")))},
+		{"quiet_show", interaction(testAdmin, admin, discordgo.EnglishUS, "quiet", subCommand("show"))},
+		{"permissions_show", interaction(testAdmin, admin, discordgo.EnglishUS, "permissions", subCommand("show"))},
+		{"deliveries_status", interaction(testAdmin, admin, discordgo.EnglishUS, "deliveries", subCommand("status"))},
+	}
+	rec := discordtest.NewRecorder()
+	r := newTestRouter(t, rec)
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			rec.Reset()
+			r.Dispatch(rec, tt.i)
+			discordtest.Golden(t, tt.golden, rec.Calls())
+		})
+	}
+}
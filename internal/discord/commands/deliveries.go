@@ -0,0 +1,106 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/delivery"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/discordapi"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/i18n"
+	"github.com/sirupsen/logrus"
+)
+
+// maxDeadLetterLines bounds the dead letters listed by /deliveries status
+const maxDeadLetterLines = 10
+
+// DeliveriesCommand defines the /deliveries command
+var DeliveriesCommand = i18n.Localize(&discordgo.ApplicationCommand{
+	Name:                     "deliveries",
+	DefaultMemberPermissions: &manageGuild,
+	DMPermission:             &guildOnly,
+	Options: []*discordgo.ApplicationCommandOption{
+		{Type: discordgo.ApplicationCommandOptionSubCommand, Name: "status"},
+		{
+			Type: discordgo.ApplicationCommandOptionSubCommand,
+			Name: "retry",
+			Options: []*discordgo.ApplicationCommandOption{
+				{Type: discordgo.ApplicationCommandOptionString, Name: "id"},
+			},
+		},
+		{
+			Type: discordgo.ApplicationCommandOptionSubCommand,
+			Name: "discard",
+			Options: []*discordgo.ApplicationCommandOption{
+				{Type: discordgo.ApplicationCommandOptionString, Name: "id"},
+			},
+		},
+	},
+})
+
+// NewDeliveriesCommand builds the /deliveries command, showing the messages of the guild
+// that couldn't be delivered and putting them back in the queue
+func NewDeliveriesCommand(q *delivery.Queue, logger *logrus.Logger) *Command {
+	return &Command{
+		Definition: DeliveriesCommand,
+		Handler: func(s discordapi.Sender, i *discordgo.InteractionCreate) {
+			lang := Lang(i)
+			path, opts := SubCommand(i.ApplicationCommandData().Options)
+			id := ""
+			if opt, ok := opts["id"]; ok {
+				id = strings.TrimSpace(opt.StringValue())
+			}
+
+			var reply string
+			switch path {
+			case "status":
+				reply = describeDeliveries(lang, q, i.GuildID)
+			case "retry":
+				n, err := q.Retry(i.GuildID, id)
+				if err != nil {
+					logger.Errorf("Cannot retry dead letters: %v", err)
+					reply = i18n.T(lang, "deliveries.error", err)
+				} else if n == 0 {
+					reply = i18n.T(lang, "deliveries.none")
+				} else {
+					reply = i18n.T(lang, "deliveries.retried", n)
+				}
+			case "discard":
+				n, err := q.Discard(i.GuildID, id)
+				if err != nil {
+					logger.Errorf("Cannot discard dead letters: %v", err)
+					reply = i18n.T(lang, "deliveries.error", err)
+				} else if n == 0 {
+					reply = i18n.T(lang, "deliveries.none")
+				} else {
+					reply = i18n.T(lang, "deliveries.discarded", n)
+				}
+			}
+			if err := RespondEphemeral(s, i, reply); err != nil {
+				logger.Errorf("Cannot respond to /deliveries: %v", err)
+			}
+		},
+	}
+}
+
+// describeDeliveries formats the queue depth and the dead letters of a guild
+func describeDeliveries(lang string, q *delivery.Queue, guildID string) string {
+	lines := []string{i18n.T(lang, "deliveries.queued", q.Depth())}
+	letters := q.DeadLetters(guildID)
+	if len(letters) == 0 {
+		return strings.Join(append(lines, i18n.T(lang, "deliveries.none")), "\n")
+	}
+	lines = append(lines, i18n.T(lang, "deliveries.dead", len(letters)))
+	for n, d := range letters {
+		if n == maxDeadLetterLines {
+			lines = append(lines, fmt.Sprintf("+%d", len(letters)-n))
+			break
+		}
+		lines = append(lines, fmt.Sprintf("`%s` <#%s> <t:%d:R> — %s", d.ID, d.ChannelID, d.EnqueuedAt.Unix(), d.LastError))
+	}
+	content := strings.Join(lines, "\n")
+	if runes := []rune(content); len(runes) > 2000 {
+		content = string(runes[:1997]) + "..."
+	}
+	return content
+}
@@ -0,0 +1,56 @@
+package commands
+
+import (
+	"github.com/bwmarrin/discordgo"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/discordapi"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/i18n"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/settings"
+	"github.com/sirupsen/logrus"
+)
+
+// LanguageCommand defines the /language command
+var LanguageCommand = i18n.Localize(&discordgo.ApplicationCommand{
+	Name:                     "language",
+	DefaultMemberPermissions: &manageGuild,
+	DMPermission:             &guildOnly,
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type:     discordgo.ApplicationCommandOptionString,
+			Name:     "language",
+			Required: true,
+			Choices:  languageChoices(),
+		},
+	},
+})
+
+// languageChoices lists the supported languages, each named in its own language
+func languageChoices() []*discordgo.ApplicationCommandOptionChoice {
+	var choices []*discordgo.ApplicationCommandOptionChoice
+	for _, lang := range i18n.Languages() {
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+			Name:  i18n.T(lang, "language.name."+lang),
+			Value: lang,
+		})
+	}
+	return choices
+}
+
+// NewLanguageCommand builds the /language command, storing the guild language in settings
+func NewLanguageCommand(m *settings.Manager, logger *logrus.Logger) *Command {
+	return &Command{
+		Definition: LanguageCommand,
+		Handler: func(s discordapi.Sender, i *discordgo.InteractionCreate) {
+			lang := Lang(i)
+			_, opts := SubCommand(i.ApplicationCommandData().Options)
+			chosen := opts["language"].StringValue()
+
+			reply := i18n.T(lang, "language.updated", i18n.T(lang, "language.name."+chosen))
+			if _, err := m.UpdateGuild(i.GuildID, func(g *settings.Guild) { g.Language = chosen }); err != nil {
+				reply = i18n.T(lang, "language.save_failed", err)
+			}
+			if err := RespondEphemeral(s, i, reply); err != nil {
+				logger.Errorf("Cannot respond to /language: %v", err)
+			}
+		},
+	}
+}
@@ -0,0 +1,209 @@
+package commands
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/discordapi"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/i18n"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/permissions"
+	"github.com/sirupsen/logrus"
+)
+
+// manageGuild is the default permission required by administrative commands
+var manageGuild int64 = discordgo.PermissionManageServer
+
+// guildOnly disables administrative commands in DMs
+var guildOnly = false
+
+// PermissionsCommand defines the /permissions command
+var PermissionsCommand = i18n.Localize(&discordgo.ApplicationCommand{
+	Name:                     "permissions",
+	DefaultMemberPermissions: &manageGuild,
+	DMPermission:             &guildOnly,
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type: discordgo.ApplicationCommandOptionSubCommand,
+			Name: "show",
+		},
+		{
+			Type: discordgo.ApplicationCommandOptionSubCommandGroup,
+			Name: "role",
+			Options: []*discordgo.ApplicationCommandOption{
+				targetSubCommand("add", discordgo.ApplicationCommandOptionRole, "role"),
+				targetSubCommand("remove", discordgo.ApplicationCommandOptionRole, "role"),
+			},
+		},
+		{
+			Type: discordgo.ApplicationCommandOptionSubCommandGroup,
+			Name: "user",
+			Options: []*discordgo.ApplicationCommandOption{
+				targetSubCommand("add", discordgo.ApplicationCommandOptionUser, "user"),
+				targetSubCommand("remove", discordgo.ApplicationCommandOptionUser, "user"),
+			},
+		},
+		{
+			Type:    discordgo.ApplicationCommandOptionSubCommand,
+			Name:    "reset",
+			Options: []*discordgo.ApplicationCommandOption{commandOption()},
+		},
+	},
+})
+
+// commandOption is the optional "command" option scoping a permission change to one command
+func commandOption() *discordgo.ApplicationCommandOption {
+	return &discordgo.ApplicationCommandOption{
+		Type: discordgo.ApplicationCommandOptionString,
+		Name: "command",
+	}
+}
+
+func targetSubCommand(name string, kind discordgo.ApplicationCommandOptionType, target string) *discordgo.ApplicationCommandOption {
+	return &discordgo.ApplicationCommandOption{
+		Type: discordgo.ApplicationCommandOptionSubCommand,
+		Name: name,
+		Options: []*discordgo.ApplicationCommandOption{
+			{Type: kind, Name: target, Required: true},
+			commandOption(),
+		},
+	}
+}
+
+// NewPermissionsCommand builds the /permissions command backed by the permission manager.
+// known reports whether a command name exists, to validate per-command overrides.
+func NewPermissionsCommand(m *permissions.Manager, known func(name string) bool, logger *logrus.Logger) *Command {
+	return &Command{
+		Definition: PermissionsCommand,
+		Handler: func(s discordapi.Sender, i *discordgo.InteractionCreate) {
+			reply := permissionsReply(m, known, i)
+			if err := RespondEphemeral(s, i, reply); err != nil {
+				logger.Errorf("Cannot respond to /permissions: %v", err)
+			}
+		},
+	}
+}
+
+func permissionsReply(m *permissions.Manager, known func(string) bool, i *discordgo.InteractionCreate) string {
+	lang := Lang(i)
+	path, opts := SubCommand(i.ApplicationCommandData().Options)
+
+	command := ""
+	if opt, ok := opts["command"]; ok {
+		command = strings.TrimPrefix(opt.StringValue(), "/")
+		if !known(command) {
+			return i18n.T(lang, "permissions.unknown_command", command)
+		}
+	}
+
+	if path == "show" {
+		p, err := m.Policy(i.GuildID)
+		if err != nil {
+			return i18n.T(lang, "permissions.read_failed", err)
+		}
+		return describePolicy(lang, p)
+	}
+
+	var target string
+	switch {
+	case strings.HasPrefix(path, "role "):
+		target = opts["role"].RoleValue(nil, "").ID
+	case strings.HasPrefix(path, "user "):
+		target = opts["user"].UserValue(nil).ID
+	}
+
+	_, err := m.Update(i.GuildID, func(p *permissions.Policy) {
+		rule := &p.Rule
+		var override permissions.Rule
+		if command != "" {
+			override = p.Commands[command]
+			rule = &override
+		}
+		switch path {
+		case "role add":
+			rule.Roles = permissions.Add(rule.Roles, target)
+		case "role remove":
+			rule.Roles = permissions.Remove(rule.Roles, target)
+		case "user add":
+			rule.Users = permissions.Add(rule.Users, target)
+		case "user remove":
+			rule.Users = permissions.Remove(rule.Users, target)
+		case "reset":
+			*rule = permissions.Rule{}
+			if command == "" {
+				p.Commands = nil
+			}
+		}
+		if command != "" {
+			if p.Commands == nil {
+				p.Commands = make(map[string]permissions.Rule)
+			}
+			p.Commands[command] = override
+		}
+	})
+	if err != nil {
+		return i18n.T(lang, "permissions.save_failed", err)
+	}
+
+	detail := path
+	if target != "" {
+		detail += " " + target
+	}
+	if command != "" {
+		detail += " (/" + command + ")"
+	}
+	m.Audit(permissions.AuditEntry{
+		GuildID: i.GuildID,
+		UserID:  InvokerID(i),
+		Command: PermissionsCommand.Name,
+		Action:  "policy_changed",
+		Detail:  detail,
+	})
+	return i18n.T(lang, "permissions.updated", detail)
+}
+
+func describePolicy(lang string, p permissions.Policy) string {
+	var b strings.Builder
+	b.WriteString(i18n.T(lang, "permissions.title") + "\n")
+	b.WriteString(describeRule(p.Rule, i18n.T(lang, "permissions.default")))
+	names := make([]string, 0, len(p.Commands))
+	for name := range p.Commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&b, "\n**/%s**\n%s", name, describeRule(p.Commands[name], "-"))
+	}
+	return b.String()
+}
+
+func describeRule(r permissions.Rule, empty string) string {
+	if r.Empty() {
+		return empty + "\n"
+	}
+	var parts []string
+	for _, role := range r.Roles {
+		parts = append(parts, "<@&"+role+">")
+	}
+	for _, user := range r.Users {
+		parts = append(parts, "<@"+user+">")
+	}
+	return strings.Join(parts, ", ") + "\n"
+}
+
+// SubCommand walks subcommand groups and subcommands of an interaction.
+// It returns the subcommand path (e.g. "role add") and the options of the leaf by name.
+func SubCommand(opts []*discordgo.ApplicationCommandInteractionDataOption) (string, map[string]*discordgo.ApplicationCommandInteractionDataOption) {
+	var path []string
+	for len(opts) == 1 && (opts[0].Type == discordgo.ApplicationCommandOptionSubCommandGroup ||
+		opts[0].Type == discordgo.ApplicationCommandOptionSubCommand) {
+		path = append(path, opts[0].Name)
+		opts = opts[0].Options
+	}
+	byName := make(map[string]*discordgo.ApplicationCommandInteractionDataOption, len(opts))
+	for _, opt := range opts {
+		byName[opt.Name] = opt
+	}
+	return strings.Join(path, " "), byName
+}
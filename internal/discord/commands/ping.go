@@ -2,23 +2,24 @@ package commands
 
 import (
 	"github.com/bwmarrin/discordgo"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/discordapi"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/i18n"
 )
 
 // PingCommand defines the /ping command
-var PingCommand = &discordgo.ApplicationCommand{
-	Name:        "ping",
-	Description: "Répond pong",
-}
+var PingCommand = i18n.Localize(&discordgo.ApplicationCommand{
+	Name: "ping",
+})
 
 // PingHandler responds to /ping with "Pong!"
-func PingHandler(s *discordgo.Session, i *discordgo.InteractionCreate) {
+func PingHandler(s discordapi.Sender, i *discordgo.InteractionCreate) {
 	if i.ApplicationCommandData().Name != "ping" {
 		return
 	}
-	s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+	s.Respond(i.Interaction, &discordgo.InteractionResponse{
 		Type: discordgo.InteractionResponseChannelMessageWithSource,
 		Data: &discordgo.InteractionResponseData{
-			Content: "Pong!",
+			Content: i18n.T(Lang(i), "ping.reply"),
 		},
 	})
 }
@@ -0,0 +1,162 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/discordapi"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/i18n"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/quiet"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/settings"
+	"github.com/sirupsen/logrus"
+)
+
+// QuietCommand defines the /quiet command
+var QuietCommand = i18n.Localize(&discordgo.ApplicationCommand{
+	Name:                     "quiet",
+	DefaultMemberPermissions: &manageGuild,
+	DMPermission:             &guildOnly,
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type: discordgo.ApplicationCommandOptionSubCommand,
+			Name: "add",
+			Options: []*discordgo.ApplicationCommandOption{
+				{Type: discordgo.ApplicationCommandOptionString, Name: "start", Required: true},
+				{Type: discordgo.ApplicationCommandOptionString, Name: "end", Required: true},
+				{Type: discordgo.ApplicationCommandOptionString, Name: "days"},
+			},
+		},
+		{
+			Type: discordgo.ApplicationCommandOptionSubCommand,
+			Name: "remove",
+			Options: []*discordgo.ApplicationCommandOption{
+				{Type: discordgo.ApplicationCommandOptionInteger, Name: "number", Required: true},
+			},
+		},
+		{
+			Type: discordgo.ApplicationCommandOptionSubCommand,
+			Name: "mode",
+			Options: []*discordgo.ApplicationCommandOption{
+				{Type: discordgo.ApplicationCommandOptionString, Name: "mode", Required: true, Choices: quietModeChoices()},
+			},
+		},
+		{Type: discordgo.ApplicationCommandOptionSubCommand, Name: "show"},
+		{Type: discordgo.ApplicationCommandOptionSubCommand, Name: "clear"},
+	},
+})
+
+// quietModeChoices lists the quiet hours modes
+func quietModeChoices() []*discordgo.ApplicationCommandOptionChoice {
+	var choices []*discordgo.ApplicationCommandOptionChoice
+	for _, mode := range quiet.Modes {
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{
+			Name:  i18n.T(i18n.Default, "quiet.mode."+mode),
+			Value: mode,
+		})
+	}
+	return choices
+}
+
+// NewQuietCommand builds the /quiet command managing the quiet hours of a guild
+func NewQuietCommand(m *settings.Manager, logger *logrus.Logger) *Command {
+	return &Command{
+		Definition: QuietCommand,
+		Handler: func(s discordapi.Sender, i *discordgo.InteractionCreate) {
+			lang := Lang(i)
+			path, opts := SubCommand(i.ApplicationCommandData().Options)
+			reply, err := handleQuiet(m, lang, i.GuildID, path, opts)
+			if err != nil {
+				logger.Errorf("Cannot handle /quiet %s: %v", path, err)
+				reply = i18n.T(lang, "quiet.save_failed", err)
+			}
+			if err := RespondEphemeral(s, i, reply); err != nil {
+				logger.Errorf("Cannot respond to /quiet: %v", err)
+			}
+		},
+	}
+}
+
+func handleQuiet(m *settings.Manager, lang, guildID, path string, opts map[string]*discordgo.ApplicationCommandInteractionDataOption) (string, error) {
+	switch path {
+	case "add":
+		rule := quiet.Rule{
+			Start: strings.TrimSpace(opts["start"].StringValue()),
+			End:   strings.TrimSpace(opts["end"].StringValue()),
+		}
+		for _, clock := range []*string{&rule.Start, &rule.End} {
+			minutes, err := quiet.ParseClock(*clock)
+			if err != nil {
+				return i18n.T(lang, "quiet.invalid", err), nil
+			}
+			*clock = fmt.Sprintf("%02d:%02d", minutes/60, minutes%60)
+		}
+		if opt, ok := opts["days"]; ok {
+			days, err := quiet.ParseDays(opt.StringValue())
+			if err != nil {
+				return i18n.T(lang, "quiet.invalid", err), nil
+			}
+			rule.Days = days
+		}
+		g, err := m.UpdateGuild(guildID, func(g *settings.Guild) { g.QuietHours.Rules = append(g.QuietHours.Rules, rule) })
+		if err != nil {
+			return "", err
+		}
+		return i18n.T(lang, "quiet.added", rule) + "\n" + describeQuiet(lang, &g), nil
+
+	case "remove":
+		n := int(opts["number"].IntValue())
+		g, err := m.Guild(guildID)
+		if err != nil {
+			return "", err
+		}
+		if n < 1 || n > len(g.QuietHours.Rules) {
+			return i18n.T(lang, "quiet.unknown_rule", n), nil
+		}
+		g, err = m.UpdateGuild(guildID, func(g *settings.Guild) {
+			g.QuietHours.Rules = append(g.QuietHours.Rules[:n-1], g.QuietHours.Rules[n:]...)
+		})
+		if err != nil {
+			return "", err
+		}
+		return i18n.T(lang, "quiet.removed", n) + "\n" + describeQuiet(lang, &g), nil
+
+	case "mode":
+		mode := opts["mode"].StringValue()
+		if _, err := m.UpdateGuild(guildID, func(g *settings.Guild) { g.QuietHours.Mode = mode }); err != nil {
+			return "", err
+		}
+		return i18n.T(lang, "quiet.mode_set", i18n.T(lang, "quiet.mode."+mode)), nil
+
+	case "clear":
+		if _, err := m.UpdateGuild(guildID, func(g *settings.Guild) { g.QuietHours = quiet.Hours{} }); err != nil {
+			return "", err
+		}
+		return i18n.T(lang, "quiet.cleared"), nil
+
+	case "show":
+		g, err := m.Guild(guildID)
+		if err != nil {
+			return "", err
+		}
+		return describeQuiet(lang, &g), nil
+	}
+	return "", fmt.Errorf("unknown subcommand %q", path)
+}
+
+// describeQuiet formats the quiet hours of a guild, with their current state
+func describeQuiet(lang string, g *settings.Guild) string {
+	if len(g.QuietHours.Rules) == 0 {
+		return i18n.T(lang, "quiet.none")
+	}
+	loc := g.Location()
+	lines := []string{i18n.T(lang, "quiet.title", i18n.T(lang, "quiet.mode."+g.QuietHours.EffectiveMode()), loc.String())}
+	for n, rule := range g.QuietHours.Rules {
+		lines = append(lines, fmt.Sprintf("%d. %s", n+1, rule))
+	}
+	if active, end := g.QuietHours.Window(time.Now(), loc); active {
+		lines = append(lines, i18n.T(lang, "quiet.active", end.Format("Mon 15:04")))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -1,24 +1,137 @@
 package commands
 
 import (
+	"encoding/json"
 	"fmt"
+	"sort"
 
 	"github.com/bwmarrin/discordgo"
 )
 
-// List holds registered slash commands
-var List []*discordgo.ApplicationCommand
+// Register synchronizes the given slash commands with Discord.
+// Commands are registered globally, or on guildID only when it is set (development mode,
+// guild commands update instantly). In that case the global commands of the application are
+// removed, or every command would show up twice in the guild. The bulk overwrite is only issued
+// when the local definitions differ from what Discord already has, so restarts don't hit rate limits.
+// It reports whether an overwrite was performed.
+func Register(s *discordgo.Session, guildID string, cmds []*discordgo.ApplicationCommand) (bool, error) {
+	appID := s.State.User.ID
 
-// Register installs all slash commands on the session
-func Register(s *discordgo.Session) {
-	// Collect commands
-	List = []*discordgo.ApplicationCommand{PingCommand}
-
-	// Create or update each command
-	for _, cmd := range List {
-		_, err := s.ApplicationCommandCreate(s.State.User.ID, "", cmd)
+	updated := false
+	if guildID != "" {
+		global, err := s.ApplicationCommands(appID, "")
 		if err != nil {
-			fmt.Printf("Cannot create command %s: %v\n", cmd.Name, err)
+			return false, fmt.Errorf("cannot list global commands: %w", err)
+		}
+		if len(global) > 0 {
+			if _, err := s.ApplicationCommandBulkOverwrite(appID, "", []*discordgo.ApplicationCommand{}); err != nil {
+				return false, fmt.Errorf("cannot remove global commands: %w", err)
+			}
+			updated = true
+		}
+	}
+
+	existing, err := s.ApplicationCommands(appID, guildID)
+	if err != nil {
+		return updated, fmt.Errorf("cannot list commands: %w", err)
+	}
+
+	if Equal(existing, cmds) {
+		return updated, nil
+	}
+
+	created, err := s.ApplicationCommandBulkOverwrite(appID, guildID, cmds)
+	if err != nil {
+		return updated, fmt.Errorf("cannot overwrite commands: %w", err)
+	}
+
+	// Keep the IDs assigned by Discord on our definitions
+	ids := make(map[string]string, len(created))
+	for _, cmd := range created {
+		ids[cmd.Name] = cmd.ID
+	}
+	for _, cmd := range cmds {
+		cmd.ID = ids[cmd.Name]
+	}
+	return true, nil
+}
+
+// Equal reports whether two sets of command definitions are the same,
+// ignoring ordering and the fields assigned by Discord (IDs, version).
+func Equal(a, b []*discordgo.ApplicationCommand) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	return fingerprint(a) == fingerprint(b)
+}
+
+// fingerprint returns a canonical JSON form of the given commands
+func fingerprint(cmds []*discordgo.ApplicationCommand) string {
+	normalized := make([]*discordgo.ApplicationCommand, 0, len(cmds))
+	for _, cmd := range cmds {
+		normalized = append(normalized, normalize(cmd))
+	}
+	sort.Slice(normalized, func(i, j int) bool {
+		return normalized[i].Name < normalized[j].Name
+	})
+	data, _ := json.Marshal(normalized)
+	return string(data)
+}
+
+// normalize copies a command, dropping server-assigned fields and applying Discord defaults
+func normalize(cmd *discordgo.ApplicationCommand) *discordgo.ApplicationCommand {
+	c := &discordgo.ApplicationCommand{
+		Type:                     cmd.Type,
+		Name:                     cmd.Name,
+		NameLocalizations:        emptyLocalizations(cmd.NameLocalizations),
+		DefaultMemberPermissions: cmd.DefaultMemberPermissions,
+		DMPermission:             cmd.DMPermission,
+		NSFW:                     cmd.NSFW,
+		Description:              cmd.Description,
+		DescriptionLocalizations: emptyLocalizations(cmd.DescriptionLocalizations),
+		Options:                  normalizeOptions(cmd.Options),
+	}
+	if c.Type == 0 {
+		c.Type = discordgo.ChatApplicationCommand
+	}
+	if c.DMPermission == nil {
+		dm := true
+		c.DMPermission = &dm
+	}
+	if c.NSFW != nil && !*c.NSFW {
+		c.NSFW = nil
+	}
+	return c
+}
+
+func normalizeOptions(opts []*discordgo.ApplicationCommandOption) []*discordgo.ApplicationCommandOption {
+	if len(opts) == 0 {
+		return nil
+	}
+	out := make([]*discordgo.ApplicationCommandOption, 0, len(opts))
+	for _, opt := range opts {
+		o := *opt
+		o.Options = normalizeOptions(opt.Options)
+		if len(o.ChannelTypes) == 0 {
+			o.ChannelTypes = nil
+		}
+		if len(o.Choices) == 0 {
+			o.Choices = nil
+		}
+		if len(o.NameLocalizations) == 0 {
+			o.NameLocalizations = nil
 		}
+		if len(o.DescriptionLocalizations) == 0 {
+			o.DescriptionLocalizations = nil
+		}
+		out = append(out, &o)
+	}
+	return out
+}
+
+func emptyLocalizations(m *map[discordgo.Locale]string) *map[discordgo.Locale]string {
+	if m == nil || len(*m) == 0 {
+		return nil
 	}
+	return m
 }
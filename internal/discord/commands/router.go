@@ -0,0 +1,137 @@
+package commands
+
+import (
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/discordapi"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/i18n"
+	"github.com/sirupsen/logrus"
+)
+
+// HandlerFunc handles a slash command interaction
+type HandlerFunc func(s discordapi.Sender, i *discordgo.InteractionCreate)
+
+// Command binds a slash command definition to its handler
+type Command struct {
+	Definition *discordgo.ApplicationCommand
+	Handler    HandlerFunc
+}
+
+// Authorizer decides whether the invoker of an interaction may run a command.
+// When it denies, the returned message is sent back to the user.
+type Authorizer interface {
+	Authorize(s discordapi.Sender, i *discordgo.InteractionCreate, cmd *discordgo.ApplicationCommand) (bool, string)
+}
+
+// Router dispatches slash command interactions to their handler
+type Router struct {
+	mu       sync.RWMutex
+	commands map[string]*Command
+	order    []string
+	auth     Authorizer
+	logger   *logrus.Logger
+}
+
+// NewRouter creates an empty command router
+func NewRouter(logger *logrus.Logger) *Router {
+	return &Router{
+		commands: make(map[string]*Command),
+		logger:   logger,
+	}
+}
+
+// SetAuthorizer installs the authorizer checked before every command
+func (r *Router) SetAuthorizer(auth Authorizer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.auth = auth
+}
+
+// Add registers a command, replacing any command with the same name
+func (r *Router) Add(cmd *Command) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	name := cmd.Definition.Name
+	if _, ok := r.commands[name]; !ok {
+		r.order = append(r.order, name)
+	}
+	r.commands[name] = cmd
+}
+
+// Has reports whether a command with this name is registered
+func (r *Router) Has(name string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, ok := r.commands[name]
+	return ok
+}
+
+// Definitions returns the definitions of all registered commands, in registration order
+func (r *Router) Definitions() []*discordgo.ApplicationCommand {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	defs := make([]*discordgo.ApplicationCommand, 0, len(r.order))
+	for _, name := range r.order {
+		defs = append(defs, r.commands[name].Definition)
+	}
+	return defs
+}
+
+// Handle is the discordgo InteractionCreate handler
+func (r *Router) Handle(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	r.Dispatch(discordapi.NewSession(s), i)
+}
+
+// Dispatch runs the command of an interaction after the authorization check, replying through s
+func (r *Router) Dispatch(s discordapi.Sender, i *discordgo.InteractionCreate) {
+	if i.Type != discordgo.InteractionApplicationCommand {
+		return
+	}
+
+	r.mu.RLock()
+	cmd, ok := r.commands[i.ApplicationCommandData().Name]
+	auth := r.auth
+	r.mu.RUnlock()
+	if !ok {
+		return
+	}
+
+	if auth != nil {
+		if allowed, message := auth.Authorize(s, i, cmd.Definition); !allowed {
+			if err := RespondEphemeral(s, i, message); err != nil {
+				r.logger.Errorf("failed to send denial for /%s: %v", cmd.Definition.Name, err)
+			}
+			return
+		}
+	}
+
+	cmd.Handler(s, i)
+}
+
+// RespondEphemeral replies to an interaction with a message only the invoker can see
+func RespondEphemeral(s discordapi.Sender, i *discordgo.InteractionCreate, content string) error {
+	return s.Respond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: content,
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	})
+}
+
+// InvokerID returns the ID of the user who triggered the interaction
+func InvokerID(i *discordgo.InteractionCreate) string {
+	if i.Member != nil && i.Member.User != nil {
+		return i.Member.User.ID
+	}
+	if i.User != nil {
+		return i.User.ID
+	}
+	return ""
+}
+
+// Lang returns the supported language matching the locale of the invoking user
+func Lang(i *discordgo.InteractionCreate) string {
+	return i18n.FromLocale(i.Locale, i18n.Default)
+}
@@ -0,0 +1,46 @@
+package commands
+
+import (
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/discordapi"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/i18n"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/settings"
+	"github.com/sirupsen/logrus"
+)
+
+// TimezoneCommand defines the /timezone command
+var TimezoneCommand = i18n.Localize(&discordgo.ApplicationCommand{
+	Name:                     "timezone",
+	DefaultMemberPermissions: &manageGuild,
+	DMPermission:             &guildOnly,
+	Options: []*discordgo.ApplicationCommandOption{
+		{Type: discordgo.ApplicationCommandOptionString, Name: "timezone", Required: true},
+	},
+})
+
+// NewTimezoneCommand builds the /timezone command, storing the guild timezone (used by quiet hours and digests) in settings
+func NewTimezoneCommand(m *settings.Manager, logger *logrus.Logger) *Command {
+	return &Command{
+		Definition: TimezoneCommand,
+		Handler: func(s discordapi.Sender, i *discordgo.InteractionCreate) {
+			lang := Lang(i)
+			_, opts := SubCommand(i.ApplicationCommandData().Options)
+			tz := strings.TrimSpace(opts["timezone"].StringValue())
+
+			var reply string
+			if loc, err := time.LoadLocation(tz); err != nil || tz == "" || strings.EqualFold(tz, "local") {
+				reply = i18n.T(lang, "timezone.invalid", tz)
+			} else if _, err := m.UpdateGuild(i.GuildID, func(g *settings.Guild) { g.Timezone = loc.String() }); err != nil {
+				reply = i18n.T(lang, "timezone.save_failed", err)
+			} else {
+				reply = i18n.T(lang, "timezone.updated", loc.String(), time.Now().In(loc).Format("15:04"))
+			}
+			if err := RespondEphemeral(s, i, reply); err != nil {
+				logger.Errorf("Cannot respond to /timezone: %v", err)
+			}
+		},
+	}
+}
@@ -0,0 +1,491 @@
+package twitch
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/config"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/delivery"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/discord"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/i18n"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/storage"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// sessionBucket holds the live sessions by broadcaster ID
+	sessionBucket = "sessions"
+
+	// announcementKind tags the deliveries of live announcements, their ref is the session key
+	announcementKind = "announcement"
+
+	// sessionSampleInterval is the interval between two samples of the viewers and category of live sessions
+	sessionSampleInterval = 5 * time.Minute
+)
+
+// Session is a live session of a broadcaster, opened when it goes live and closed when it goes offline
+type Session struct {
+	BroadcasterID    string       `json:"broadcaster_id"`
+	BroadcasterLogin string       `json:"broadcaster_login"`
+	BroadcasterName  string       `json:"broadcaster_name"`
+	StreamID         string       `json:"stream_id,omitempty"`
+	StartedAt        time.Time    `json:"started_at"`
+	Source           string       `json:"source"`
+	Announced        bool         `json:"announced"`
+	Pending          bool         `json:"pending,omitempty"` // announcement not queued yet (e.g. Helix was unavailable)
+	Messages         []MessageRef `json:"messages,omitempty"`
+	EndedAt          *time.Time   `json:"ended_at,omitempty"` // set while the end of the session is held back by the flap grace period
+	PeakViewers      int          `json:"peak_viewers,omitempty"`
+	Games            []GameSpan   `json:"games,omitempty"`  // categories streamed, in order
+	Guilds           []string     `json:"guilds,omitempty"` // guilds of the announcements, kept in the history without the messages
+}
+
+// GameSpan is a category streamed during a session, from Since until the next span or the end of the session
+type GameSpan struct {
+	Name  string    `json:"name"`
+	Since time.Time `json:"since"`
+}
+
+// observe records the viewer count and category of a sample of the stream
+func (sess *Session) observe(stream *Stream, at time.Time) {
+	if stream.ViewerCount > sess.PeakViewers {
+		sess.PeakViewers = stream.ViewerCount
+	}
+	if n := len(sess.Games); n == 0 || sess.Games[n-1].Name != stream.GameName {
+		since := at
+		if n == 0 {
+			since = sess.StartedAt
+		}
+		sess.Games = append(sess.Games, GameSpan{Name: stream.GameName, Since: since})
+	}
+}
+
+// key identifies the session, to match deliveries with it
+func (sess *Session) key() string {
+	return fmt.Sprintf("%s/%d", sess.BroadcasterID, sess.StartedAt.Unix())
+}
+
+// MessageRef points to a Discord message posted for a session
+type MessageRef struct {
+	ChannelID string `json:"channel_id"`
+	MessageID string `json:"message_id"`
+}
+
+// Announcer keeps track of live sessions and posts their announcements.
+// Every source of stream events (EventSub, presence, ...) goes through it, so
+// a broadcaster is announced only once per session.
+type Announcer struct {
+	cfg           *config.Config
+	discordClient *discord.Client
+	helix         *Client
+	filters       *Filters
+	store         *storage.Store
+	logger        *logrus.Logger
+
+	mu         sync.Mutex
+	announcing map[string]bool // broadcasters whose announcement is being prepared
+}
+
+// NewAnnouncer creates an announcer posting to the notification channel the streams allowed by filters
+func NewAnnouncer(cfg *config.Config, discordClient *discord.Client, helix *Client, filters *Filters, store *storage.Store, logger *logrus.Logger) *Announcer {
+	a := &Announcer{
+		cfg:           cfg,
+		discordClient: discordClient,
+		helix:         helix,
+		filters:       filters,
+		store:         store,
+		logger:        logger,
+		announcing:    map[string]bool{},
+	}
+	discordClient.OnDelivered(announcementKind, a.delivered)
+	return a
+}
+
+// Online opens a session for the broadcaster and announces it when announce is set.
+// The announcement is prepared in the background: the session is saved as pending first,
+// so an announcement interrupted by a restart or a Helix error is retried.
+// It reports whether a new session was opened (false when the broadcaster is already live, or
+// comes back online within the flap grace period and resumes its session, even with a new stream ID
+// as Twitch issues one on every reconnect). When a live session is for another stream than the event,
+// the session is replaced, and returned so it can be closed: the offline event of that stream was missed.
+func (a *Announcer) Online(ctx context.Context, ev *StreamEvent, source string, fallback *Stream, announce bool) (bool, *Session) {
+	log := utils.Log(ctx, a.logger)
+	a.mu.Lock()
+	var stale *Session
+	sess, ok := a.Session(ev.BroadcasterID)
+	if ok && sess.EndedAt != nil {
+		// Back online within the grace period: resume the session instead of announcing again
+		sess.EndedAt = nil
+		if ev.StreamID != "" {
+			sess.StreamID = ev.StreamID
+		}
+		a.save(sess)
+		a.mu.Unlock()
+		log.Infof("%s is back online, resuming the previous session", ev.BroadcasterName)
+		go a.refresh(sess)
+		return false, nil
+	} else if ok && sess.StreamID != "" && ev.StreamID != "" && sess.StreamID != ev.StreamID {
+		log.Warnf("%s started stream %s while session of stream %s was still open, replacing it", ev.BroadcasterName, ev.StreamID, sess.StreamID)
+		stale = sess
+	} else if ok {
+		// Already live: EventSub completes a session opened from another source
+		if sess.StreamID == "" && ev.StreamID != "" {
+			sess.StreamID = ev.StreamID
+			sess.Source = source
+			a.save(sess)
+		}
+		a.mu.Unlock()
+		log.Infof("%s is already live (%s), ignoring %s event", ev.BroadcasterName, sess.Source, source)
+		return false, nil
+	}
+	sess = &Session{
+		BroadcasterID:    ev.BroadcasterID,
+		BroadcasterLogin: ev.BroadcasterLogin,
+		BroadcasterName:  ev.BroadcasterName,
+		StreamID:         ev.StreamID,
+		StartedAt:        ev.StartedAt,
+		Source:           source,
+		Announced:        announce,
+		Pending:          announce,
+	}
+	if sess.StartedAt.IsZero() {
+		sess.StartedAt = time.Now()
+	}
+	a.save(sess)
+	a.mu.Unlock()
+
+	if announce {
+		go a.announce(ctx, sess, fallback)
+	}
+	return true, stale
+}
+
+// Offline closes the session of the broadcaster. Presence can only close sessions it opened,
+// EventSub closes any session. It returns the closed session, or nil when none was closed.
+func (a *Announcer) Offline(ev *StreamEvent, source string) *Session {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	sess, ok := a.Session(ev.BroadcasterID)
+	if !ok {
+		return nil
+	}
+	if source == SourcePresence && sess.Source != SourcePresence {
+		return nil
+	}
+	if err := a.store.Delete(sessionBucket, ev.BroadcasterID); err != nil {
+		a.logger.Errorf("failed to delete session of %s: %v", ev.BroadcasterID, err)
+	}
+	return sess
+}
+
+// Suspend marks the session of the broadcaster as ended without closing it, so it can be resumed
+// if the broadcaster comes back online. It returns the end mark to pass to Finish, and false when
+// there is no session the source can close.
+func (a *Announcer) Suspend(ev *StreamEvent, source string) (time.Time, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	sess, ok := a.Session(ev.BroadcasterID)
+	if !ok || (source == SourcePresence && sess.Source != SourcePresence) {
+		return time.Time{}, false
+	}
+	if sess.EndedAt == nil {
+		now := time.Now()
+		sess.EndedAt = &now
+		a.save(sess)
+	}
+	return *sess.EndedAt, true
+}
+
+// Finish closes a suspended session, unless it was resumed (or suspended again) since the given end mark.
+// It returns the closed session, or nil when the session is still going.
+func (a *Announcer) Finish(broadcasterID string, endedAt time.Time) *Session {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	sess, ok := a.Session(broadcasterID)
+	if !ok || sess.EndedAt == nil || !sess.EndedAt.Equal(endedAt) {
+		return nil
+	}
+	if err := a.store.Delete(sessionBucket, broadcasterID); err != nil {
+		a.logger.Errorf("failed to delete session of %s: %v", broadcasterID, err)
+	}
+	return sess
+}
+
+// Session returns the live session of a broadcaster
+func (a *Announcer) Session(broadcasterID string) (*Session, bool) {
+	var sess Session
+	ok, err := a.store.Get(sessionBucket, broadcasterID, &sess)
+	if err != nil {
+		a.logger.Errorf("failed to load session of %s: %v", broadcasterID, err)
+	}
+	if !ok || err != nil {
+		return nil, false
+	}
+	return &sess, true
+}
+
+// Sessions returns every live session
+func (a *Announcer) Sessions() []Session {
+	var sessions []Session
+	for _, id := range a.store.Keys(sessionBucket) {
+		if sess, ok := a.Session(id); ok {
+			sessions = append(sessions, *sess)
+		}
+	}
+	return sessions
+}
+
+// Reconcile resumes the suspended sessions of broadcasters back online while the bot wasn't running,
+// and returns the sessions of the broadcasters that are offline, for the caller to close
+func (a *Announcer) Reconcile() []Session {
+	sessions := a.Sessions()
+	if len(sessions) == 0 {
+		return nil
+	}
+	ids := make([]string, 0, len(sessions))
+	for _, sess := range sessions {
+		ids = append(ids, sess.BroadcasterID)
+	}
+	streams, err := a.helix.GetStreams(ids)
+	if err != nil {
+		a.logger.Errorf("failed to reconcile live sessions: %v", err)
+		return nil
+	}
+	live := map[string]bool{}
+	for _, stream := range streams {
+		live[stream.UserID] = true
+	}
+	var offline []Session
+	for _, sess := range sessions {
+		ctx := withBroadcaster(context.Background(), a.logger, sess.BroadcasterID)
+		switch {
+		case !live[sess.BroadcasterID]:
+			offline = append(offline, sess)
+		case sess.EndedAt != nil:
+			// Came back online while we were down
+			a.Online(ctx, &StreamEvent{BroadcasterID: sess.BroadcasterID, BroadcasterName: sess.BroadcasterName}, sess.Source, nil, false)
+		}
+	}
+	a.announcePending()
+	return offline
+}
+
+// announcePending retries the announcements of live sessions that weren't queued
+func (a *Announcer) announcePending() {
+	for _, sess := range a.Sessions() {
+		if sess.Pending && sess.EndedAt == nil {
+			sess := sess
+			go a.announce(withBroadcaster(context.Background(), a.logger, sess.BroadcasterID), &sess, nil)
+		}
+	}
+}
+
+func (a *Announcer) save(sess *Session) {
+	if err := a.store.Put(sessionBucket, sess.BroadcasterID, sess); err != nil {
+		a.logger.Errorf("failed to save session of %s: %v", sess.BroadcasterID, err)
+	}
+}
+
+// announce queues the live announcement of a session for the channel of its notifier.
+// The session stays pending when the stream can't be fetched, to be retried with the next sample.
+func (a *Announcer) announce(ctx context.Context, sess *Session, fallback *Stream) {
+	a.mu.Lock()
+	if a.announcing[sess.BroadcasterID] {
+		a.mu.Unlock()
+		return
+	}
+	a.announcing[sess.BroadcasterID] = true
+	a.mu.Unlock()
+	defer func() {
+		a.mu.Lock()
+		delete(a.announcing, sess.BroadcasterID)
+		a.mu.Unlock()
+	}()
+
+	log := utils.Log(ctx, a.logger)
+	log.Infof("📣 %s est en live !", sess.BroadcasterName)
+	stream, err := a.helix.GetStreamInfo(sess.BroadcasterID)
+	if err != nil {
+		log.Errorf("Error fetching stream info: %v", err)
+		return
+	}
+	if stream == nil {
+		stream = fallback
+	}
+	if stream == nil {
+		return
+	}
+	a.mu.Lock()
+	if cur, ok := a.Session(sess.BroadcasterID); ok {
+		cur.observe(stream, time.Now())
+		a.save(cur)
+	}
+	a.mu.Unlock()
+
+	channelID := a.cfg.NotifierFor(sess.BroadcasterID).ChannelID
+	if !a.filters.Allow(channelID, stream) {
+		log.Infof("Announcement of %s filtered out", sess.BroadcasterName)
+	} else {
+		embed := LiveEmbed(a.discordClient.ChannelLanguage(channelID), stream)
+		queued, err := a.discordClient.Announce(ctx, channelID, embed, Summary(stream), delivery.Tag{Kind: announcementKind, Ref: sess.key()})
+		if err != nil {
+			log.Errorf("Envoi Discord raté : %v", err)
+			return
+		}
+		if queued {
+			log.Info("Embed Discord en file d'envoi ✅")
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if cur, ok := a.Session(sess.BroadcasterID); ok && cur.key() == sess.key() {
+		cur.Pending = false
+		a.save(cur)
+	}
+}
+
+// delivered records the message of a posted announcement in its session
+func (a *Announcer) delivered(d *delivery.Delivery, msg *discordgo.Message) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, sess := range a.Sessions() {
+		if sess.key() == d.Tag.Ref {
+			notificationLatency.Observe(time.Since(sess.StartedAt).Seconds())
+			sess.Messages = append(sess.Messages, MessageRef{ChannelID: msg.ChannelID, MessageID: msg.ID})
+			a.save(&sess)
+			return
+		}
+	}
+}
+
+// Run samples the live sessions at the given interval, until ctx is done
+func (a *Announcer) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.Sample()
+		}
+	}
+}
+
+// Sample records the viewer count and category of every live session, for the digests,
+// and retries the pending announcements
+func (a *Announcer) Sample() {
+	a.announcePending()
+
+	var ids []string
+	for _, sess := range a.Sessions() {
+		if sess.EndedAt == nil {
+			ids = append(ids, sess.BroadcasterID)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+	streams, err := a.helix.GetStreams(ids)
+	if err != nil {
+		a.logger.Errorf("failed to sample live sessions: %v", err)
+		return
+	}
+	now := time.Now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for i := range streams {
+		if sess, ok := a.Session(streams[i].UserID); ok {
+			sess.observe(&streams[i], now)
+			a.save(sess)
+		}
+	}
+}
+
+// refresh updates the announcements of a resumed session with the current stream
+func (a *Announcer) refresh(sess *Session) {
+	if len(sess.Messages) == 0 {
+		return
+	}
+	stream, err := a.helix.GetStreamInfo(sess.BroadcasterID)
+	if err != nil {
+		a.logger.Errorf("Error fetching stream info: %v", err)
+		return
+	}
+	if stream == nil {
+		return
+	}
+	for _, ref := range sess.Messages {
+		embed := LiveEmbed(a.discordClient.ChannelLanguage(ref.ChannelID), stream)
+		if _, err := a.discordClient.EditEmbed(ref.ChannelID, ref.MessageID, embed); err != nil {
+			a.logger.Errorf("failed to update the announcement of %s: %v", sess.BroadcasterName, err)
+		}
+	}
+}
+
+// Summary describes a live stream in one line, for digests
+func Summary(stream *Stream) string {
+	login := stream.UserLogin
+	if login == "" {
+		login = stream.UserName
+	}
+	return fmt.Sprintf("**%s** — %s (%s) <https://twitch.tv/%s>", stream.UserName, stream.Title, stream.GameName, login)
+}
+
+// LiveEmbed builds the live announcement embed of a stream in the given language
+func LiveEmbed(lang string, stream *Stream) *discordgo.MessageEmbed {
+	login := stream.UserLogin
+	if login == "" {
+		login = stream.UserName
+	}
+	// build embed with stream.UserName, stream.Title, stream.GameName, stream.ViewerCount, stream.StartedAt
+	return &discordgo.MessageEmbed{
+		Title: i18n.T(lang, "announce.title", stream.UserName),
+		URL:   fmt.Sprintf("https://twitch.tv/%s", login),
+
+		Color: 0x9146FF, // Twitch purple
+
+		Author: &discordgo.MessageEmbedAuthor{
+			Name:    stream.UserName,
+			URL:     fmt.Sprintf("https://twitch.tv/%s", login),
+			IconURL: fmt.Sprintf("https://static-cdn.jtvnw.net/jtv_user_pictures/%s-profile_image-70x70.png", stream.UserID),
+			// IconURL: "https://static-cdn.jtvnw.net/user-default-pictures-uv/ead5c8b2-a4c9-4724-b1dd-9f00b46cbd3d-profile_image-70x70.png",
+		},
+
+		Image: &discordgo.MessageEmbedImage{
+			URL:    fmt.Sprintf("https://static-cdn.jtvnw.net/previews-ttv/live_user_%s-440x248.jpg", login),
+			Width:  440,
+			Height: 248,
+		},
+
+		Fields: []*discordgo.MessageEmbedField{
+			{
+				Name:   i18n.T(lang, "announce.field.title"),
+				Value:  stream.Title,
+				Inline: false,
+			},
+			{
+				Name:   i18n.T(lang, "announce.field.game"),
+				Value:  stream.GameName,
+				Inline: true,
+			},
+			{
+				Name:   i18n.T(lang, "announce.field.viewers"),
+				Value:  fmt.Sprintf("%d", stream.ViewerCount),
+				Inline: true,
+			},
+		},
+
+		Timestamp: stream.StartedAt.Format(time.RFC3339), // RFC3339 string
+
+		Footer: &discordgo.MessageEmbedFooter{
+			Text:    i18n.T(lang, "announce.footer"),
+			IconURL: "https://static.twitchcdn.net/assets/favicon-32-e29e246c157142c94346.png",
+		},
+	}
+}
@@ -0,0 +1,374 @@
+package twitch
+
+import (
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/config"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/delivery"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/i18n"
+)
+
+// apiPrefix is the path of the admin API, served when API_TOKEN is set
+const apiPrefix = "/api/v1/"
+
+// openAPISpec describes the admin API, served at /api/v1/openapi.json
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// APIBroadcaster is a followed broadcaster, with its routing and live state
+type APIBroadcaster struct {
+	ID        string   `json:"id"`
+	Login     string   `json:"login,omitempty"`
+	Notifier  string   `json:"notifier"`
+	ChannelID string   `json:"channel_id"`
+	Live      bool     `json:"live"`
+	Session   *Session `json:"session,omitempty"`
+}
+
+// SetReloader lets the admin API edit the broadcasters of the configuration file through r
+func (s *WebhookServer) SetReloader(r *config.Reloader) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reloader = r
+}
+
+// handleAPI serves the admin API. Every endpoint but the OpenAPI document needs the bearer token.
+func (s *WebhookServer) handleAPI(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, apiPrefix), "/")
+	if path == "openapi.json" {
+		methods(w, r, map[string]http.HandlerFunc{http.MethodGet: func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(openAPISpec)
+		}})
+		return
+	}
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+		writeAPIError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+		return
+	}
+
+	parts := strings.Split(path, "/")
+	switch {
+	case path == "broadcasters":
+		methods(w, r, map[string]http.HandlerFunc{http.MethodGet: s.apiListBroadcasters, http.MethodPost: s.apiAddBroadcaster})
+	case len(parts) == 2 && parts[0] == "broadcasters":
+		methods(w, r, map[string]http.HandlerFunc{http.MethodDelete: func(w http.ResponseWriter, r *http.Request) {
+			s.apiRemoveBroadcaster(w, r, parts[1])
+		}})
+	case path == "sessions":
+		methods(w, r, map[string]http.HandlerFunc{http.MethodGet: s.apiListSessions})
+	case path == "deliveries/failed":
+		methods(w, r, map[string]http.HandlerFunc{http.MethodGet: s.apiListFailedDeliveries})
+	case path == "deliveries/failed/retry":
+		methods(w, r, map[string]http.HandlerFunc{http.MethodPost: func(w http.ResponseWriter, r *http.Request) {
+			s.apiRetryDeliveries(w, r, "")
+		}})
+	case len(parts) == 4 && parts[0] == "deliveries" && parts[1] == "failed" && parts[3] == "retry":
+		methods(w, r, map[string]http.HandlerFunc{http.MethodPost: func(w http.ResponseWriter, r *http.Request) {
+			s.apiRetryDeliveries(w, r, parts[2])
+		}})
+	case path == "subscriptions":
+		methods(w, r, map[string]http.HandlerFunc{http.MethodGet: s.apiListSubscriptions})
+	case path == "announcements/test":
+		methods(w, r, map[string]http.HandlerFunc{http.MethodPost: s.apiTestAnnouncement})
+	default:
+		writeAPIError(w, http.StatusNotFound, "unknown endpoint")
+	}
+}
+
+// authorized reports whether a request carries the API token in a bearer Authorization header
+func (s *WebhookServer) authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && s.cfg.APIToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg.APIToken)) == 1
+}
+
+// methods calls the handler of the method of the request, or answers 405
+func methods(w http.ResponseWriter, r *http.Request, handlers map[string]http.HandlerFunc) {
+	if h, ok := handlers[r.Method]; ok {
+		h(w, r)
+		return
+	}
+	allowed := make([]string, 0, len(handlers))
+	for method := range handlers {
+		allowed = append(allowed, method)
+	}
+	sort.Strings(allowed)
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, code int, message string) {
+	writeJSON(w, code, map[string]string{"error": message})
+}
+
+// decodeBody decodes the JSON body of a request into v, an empty body leaves v unchanged
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(v)
+	if err != nil && !errors.Is(err, io.EOF) {
+		writeAPIError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// followedBroadcasters returns the followed broadcasters with their routing and live session
+func (s *WebhookServer) followedBroadcasters() []APIBroadcaster {
+	live := s.cfg.Live()
+	logins := map[string]string{}
+	for _, b := range live.Broadcasters {
+		logins[b.ID] = b.Login
+	}
+	list := make([]APIBroadcaster, 0, len(live.TwitchBroadcasterIDs))
+	for _, id := range live.TwitchBroadcasterIDs {
+		notifier := s.cfg.NotifierFor(id)
+		item := APIBroadcaster{ID: id, Login: logins[id], Notifier: notifier.Name, ChannelID: notifier.ChannelID}
+		if sess, ok := s.announcer.Session(id); ok {
+			item.Live, item.Session = sess.EndedAt == nil, sess
+			if item.Login == "" {
+				item.Login = sess.BroadcasterLogin
+			}
+		}
+		list = append(list, item)
+	}
+	return list
+}
+
+func (s *WebhookServer) apiListBroadcasters(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": s.followedBroadcasters()})
+}
+
+func (s *WebhookServer) apiAddBroadcaster(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		ID       string `json:"id"`
+		Login    string `json:"login"`
+		Notifier string `json:"notifier"`
+	}
+	if !decodeBody(w, r, &body) {
+		return
+	}
+	if (body.ID == "") == (body.Login == "") {
+		writeAPIError(w, http.StatusBadRequest, "set either id or login")
+		return
+	}
+	if body.Notifier != "" && !hasNotifier(s.cfg.Live().Notifiers, body.Notifier) {
+		writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("unknown notifier %q", body.Notifier))
+		return
+	}
+
+	var ids, logins []string
+	if body.ID != "" {
+		ids = []string{body.ID}
+	} else {
+		logins = []string{strings.ToLower(body.Login)}
+	}
+	users, err := s.helix.GetUsers("", ids, logins)
+	if err != nil {
+		writeAPIError(w, http.StatusBadGateway, "cannot look up the Twitch user: "+err.Error())
+		return
+	}
+	if len(users) == 0 {
+		writeAPIError(w, http.StatusNotFound, "unknown Twitch user")
+		return
+	}
+	user := users[0]
+
+	reloader := s.configReloader()
+	if reloader == nil {
+		writeAPIError(w, http.StatusConflict, config.ErrNoFile.Error())
+		return
+	}
+	if err := reloader.AddBroadcaster(config.Broadcaster{ID: user.ID, Login: user.Login, Notifier: body.Notifier}); err != nil {
+		writeConfigError(w, err)
+		return
+	}
+	s.logger.Infof("API: broadcaster %s (%s) added", user.Login, user.ID)
+	for _, b := range s.followedBroadcasters() {
+		if b.ID == user.ID {
+			writeJSON(w, http.StatusCreated, b)
+			return
+		}
+	}
+	writeAPIError(w, http.StatusInternalServerError, "broadcaster added to the file but not applied, see the logs")
+}
+
+func (s *WebhookServer) apiRemoveBroadcaster(w http.ResponseWriter, r *http.Request, id string) {
+	reloader := s.configReloader()
+	if reloader == nil {
+		writeAPIError(w, http.StatusConflict, config.ErrNoFile.Error())
+		return
+	}
+	if err := reloader.RemoveBroadcaster(id); err != nil {
+		writeConfigError(w, err)
+		return
+	}
+	s.logger.Infof("API: broadcaster %s removed", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *WebhookServer) configReloader() *config.Reloader {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reloader
+}
+
+// writeConfigError answers with the status matching an error of an edit of the configuration
+func writeConfigError(w http.ResponseWriter, err error) {
+	var invalid *config.ValidationError
+	switch {
+	case errors.Is(err, config.ErrUnknownBroadcaster):
+		writeAPIError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, config.ErrBroadcasterExists), errors.Is(err, config.ErrNoFile), errors.Is(err, config.ErrBroadcastersFromEnv):
+		writeAPIError(w, http.StatusConflict, err.Error())
+	case errors.As(err, &invalid):
+		writeAPIError(w, http.StatusBadRequest, strings.Join(invalid.Problems, "; "))
+	default:
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+func hasNotifier(notifiers []config.Notifier, name string) bool {
+	for _, n := range notifiers {
+		if n.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *WebhookServer) apiListSessions(w http.ResponseWriter, r *http.Request) {
+	sessions := s.announcer.Sessions()
+	if sessions == nil {
+		sessions = []Session{}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].StartedAt.Before(sessions[j].StartedAt) })
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": sessions})
+}
+
+func (s *WebhookServer) apiListFailedDeliveries(w http.ResponseWriter, r *http.Request) {
+	letters := s.discordClient.Queue().DeadLetters(r.URL.Query().Get("guild_id"))
+	if letters == nil {
+		letters = []delivery.Delivery{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": letters})
+}
+
+// apiRetryDeliveries queues again the failed delivery with the given ID, or every failed delivery
+// (of the guild_id of the query, if any) when id is empty
+func (s *WebhookServer) apiRetryDeliveries(w http.ResponseWriter, r *http.Request, id string) {
+	n, err := s.discordClient.Queue().Retry(r.URL.Query().Get("guild_id"), id)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if id != "" && n == 0 {
+		writeAPIError(w, http.StatusNotFound, "no failed delivery with this ID")
+		return
+	}
+	s.logger.Infof("API: %d failed deliveries queued again", n)
+	writeJSON(w, http.StatusOK, map[string]int{"retried": n})
+}
+
+func (s *WebhookServer) apiListSubscriptions(w http.ResponseWriter, r *http.Request) {
+	query := url.Values{}
+	for _, key := range []string{"status", "type", "user_id"} {
+		if v := r.URL.Query().Get(key); v != "" {
+			query.Set(key, v)
+		}
+	}
+	list, err := s.helix.Subscriptions(query)
+	if err != nil {
+		writeAPIError(w, http.StatusBadGateway, "cannot list the subscriptions: "+err.Error())
+		return
+	}
+	if list.Data == nil {
+		list.Data = []Subscription{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data":           list.Data,
+		"total":          list.Total,
+		"total_cost":     list.TotalCost,
+		"max_total_cost": list.MaxTotalCost,
+		"failing":        s.failingSubscriptions(),
+	})
+}
+
+// apiTestAnnouncement queues the announcement of a broadcaster, without ping, filters nor session,
+// to check the bot can post in a channel. An offline broadcaster gets a placeholder stream.
+func (s *WebhookServer) apiTestAnnouncement(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		BroadcasterID string `json:"broadcaster_id"`
+		ChannelID     string `json:"channel_id"`
+	}
+	if !decodeBody(w, r, &body) {
+		return
+	}
+	if body.BroadcasterID == "" {
+		ids := s.cfg.BroadcasterIDs()
+		if len(ids) == 0 {
+			writeAPIError(w, http.StatusBadRequest, "no followed broadcaster, set broadcaster_id")
+			return
+		}
+		body.BroadcasterID = ids[0]
+	}
+	if body.ChannelID == "" {
+		body.ChannelID = s.cfg.NotifierFor(body.BroadcasterID).ChannelID
+	}
+	lang := s.discordClient.ChannelLanguage(body.ChannelID)
+
+	stream, err := s.helix.GetStreamInfo(body.BroadcasterID)
+	if err != nil {
+		writeAPIError(w, http.StatusBadGateway, "cannot fetch the stream: "+err.Error())
+		return
+	}
+	if stream == nil {
+		users, err := s.helix.GetUsers("", []string{body.BroadcasterID}, nil)
+		if err != nil {
+			writeAPIError(w, http.StatusBadGateway, "cannot look up the Twitch user: "+err.Error())
+			return
+		}
+		if len(users) == 0 {
+			writeAPIError(w, http.StatusNotFound, "unknown Twitch user")
+			return
+		}
+		stream = &Stream{
+			UserID:    users[0].ID,
+			UserLogin: users[0].Login,
+			UserName:  users[0].DisplayName,
+			Title:     i18n.T(lang, "announce.test.title"),
+			GameName:  "—",
+			StartedAt: time.Now().UTC(),
+		}
+	}
+
+	msg := &discordgo.MessageSend{
+		Content: i18n.T(lang, "announce.test"),
+		Embeds:  []*discordgo.MessageEmbed{LiveEmbed(lang, stream)},
+	}
+	d, err := s.discordClient.Queue().Enqueue(s.discordClient.ChannelGuildID(body.ChannelID), body.ChannelID, msg, delivery.Tag{})
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.logger.Infof("API: test announcement of %s queued for channel %s", stream.UserName, body.ChannelID)
+	// The queue updates d while delivering it, only its identity is sent back
+	writeJSON(w, http.StatusAccepted, map[string]string{"id": d.ID, "guild_id": d.GuildID, "channel_id": d.ChannelID})
+}
@@ -0,0 +1,289 @@
+package twitch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/config"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/discordtest"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/twitchtest"
+	"github.com/sirupsen/logrus"
+)
+
+// api calls the admin API with a token (none when empty) and decodes the JSON response into out
+func (e *e2e) api(t *testing.T, method, path, token string, body interface{}, out interface{}) int {
+	t.Helper()
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	e.server.httpServer.Handler.ServeHTTP(rec, req)
+	if out != nil && rec.Body.Len() > 0 {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: %v: %s", method, path, err, rec.Body)
+		}
+	}
+	return rec.Code
+}
+
+// withConfigFile makes the broadcasters of e editable through the API, with a configuration
+// file following alice and a second notifier, esports
+func (e *e2e) withConfigFile(t *testing.T) string {
+	t.Helper()
+	// Port and callback URL only apply at startup, the running ones are kept
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	file := fmt.Sprintf(`http:
+  port: 8080
+discord:
+  token: e2e-bot-token
+twitch:
+  client_id: %s
+  client_secret: %s
+  webhook_secret: %s
+  callback_url: https://bot.example
+
+notifiers:
+  - name: default
+    channel_id: "%s"
+    role_id: "%s"
+  - name: esports
+    channel_id: "900000000000000010"
+
+# Broadcasters announced by the bot
+broadcasters:
+  - id: "%s"
+    login: alice
+`, twitchtest.ClientID, twitchtest.ClientSecret, e2eWebhookSecret, e2eChannel, e2eRole, alice.ID)
+	if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	e.cfg.File = path
+	e.cfg.Broadcasters = []config.Broadcaster{{ID: alice.ID, Login: alice.Login}}
+	e.cfg.Notifiers = append(e.cfg.Notifiers, config.Notifier{Name: "esports", ChannelID: "900000000000000010"})
+
+	logger := logrus.New()
+	logger.Out = io.Discard
+	reloader := config.NewReloader(e.cfg, logger)
+	reloader.OnReload(e.server.ApplyConfig)
+	e.server.SetReloader(reloader)
+	return path
+}
+
+// hasSubscription reports whether the fake Twitch has a subscription of a broadcaster
+func (e *e2e) hasSubscription(broadcasterID string) bool {
+	for _, sub := range e.twitch.Subscriptions() {
+		if sub.Condition["broadcaster_user_id"] == broadcasterID {
+			return true
+		}
+	}
+	return false
+}
+
+func TestAPIAuth(t *testing.T) {
+	e := newE2E(t, alice)
+	tests := []struct {
+		method, path, token string
+		want                int
+	}{
+		{"GET", "/api/v1/sessions", "", http.StatusUnauthorized},
+		{"GET", "/api/v1/sessions", "wrong-token", http.StatusUnauthorized},
+		{"GET", "/api/v1/sessions", e2eAPIToken, http.StatusOK},
+		{"GET", "/api/v1/openapi.json", "", http.StatusOK},
+		{"DELETE", "/api/v1/sessions", e2eAPIToken, http.StatusMethodNotAllowed},
+		{"GET", "/api/v1/nothing", e2eAPIToken, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		var body map[string]interface{}
+		if code := e.api(t, tt.method, tt.path, tt.token, nil, &body); code != tt.want {
+			t.Errorf("%s %s with token %q answered %d %v, want %d", tt.method, tt.path, tt.token, code, body, tt.want)
+		}
+	}
+
+	var spec struct {
+		OpenAPI string                 `json:"openapi"`
+		Paths   map[string]interface{} `json:"paths"`
+	}
+	e.api(t, "GET", "/api/v1/openapi.json", "", nil, &spec)
+	if spec.OpenAPI == "" || spec.Paths["/broadcasters"] == nil {
+		t.Errorf("openapi.json = %+v, want the document of the API", spec)
+	}
+
+	// The token is only read from a bearer Authorization header
+	for _, header := range []string{e2eAPIToken, "Basic " + e2eAPIToken, "bearer" + e2eAPIToken} {
+		req := httptest.NewRequest("GET", "/api/v1/sessions", nil)
+		req.Header.Set("Authorization", header)
+		rec := httptest.NewRecorder()
+		e.server.httpServer.Handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("Authorization %q answered %d, want 401", header, rec.Code)
+		}
+	}
+
+	// An empty token never matches
+	e.cfg.APIToken = ""
+	if code := e.api(t, "GET", "/api/v1/sessions", "", nil, nil); code != http.StatusUnauthorized {
+		t.Errorf("API with an empty token answered %d, want 401", code)
+	}
+}
+
+func TestAPIBroadcasters(t *testing.T) {
+	e := newE2E(t, alice)
+	e.twitch.AddUser(bob)
+	path := e.withConfigFile(t)
+	e.start(t)
+
+	var added struct {
+		APIBroadcaster
+		Error string
+	}
+	if code := e.api(t, "POST", "/api/v1/broadcasters", e2eAPIToken, map[string]string{"login": "Bob", "notifier": "esports"}, &added); code != http.StatusCreated {
+		t.Fatalf("add bob answered %d %s", code, added.Error)
+	}
+	if added.ID != bob.ID || added.Notifier != "esports" || added.ChannelID != "900000000000000010" {
+		t.Errorf("added %+v, want bob routed to esports", added)
+	}
+	eventually(t, "subscriptions of bob", func() bool { return e.hasSubscription(bob.ID) })
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), "# Broadcasters announced by the bot") || !strings.Contains(string(data), `id: "1002"`) {
+		t.Errorf("configuration file after the addition:\n%s", data)
+	}
+
+	var list struct{ Data []APIBroadcaster }
+	e.api(t, "GET", "/api/v1/broadcasters", e2eAPIToken, nil, &list)
+	if len(list.Data) != 2 || list.Data[0].ID != alice.ID || list.Data[1].ID != bob.ID {
+		t.Errorf("broadcasters = %+v, want alice and bob", list.Data)
+	}
+
+	errors := []struct {
+		name string
+		body map[string]string
+		want int
+	}{
+		{"duplicate", map[string]string{"id": bob.ID}, http.StatusConflict},
+		{"unknown user", map[string]string{"login": "nobody"}, http.StatusNotFound},
+		{"unknown notifier", map[string]string{"id": carol.ID, "notifier": "nope"}, http.StatusBadRequest},
+		{"id and login", map[string]string{"id": carol.ID, "login": "carol"}, http.StatusBadRequest},
+	}
+	for _, tt := range errors {
+		var body map[string]string
+		if code := e.api(t, "POST", "/api/v1/broadcasters", e2eAPIToken, tt.body, &body); code != tt.want {
+			t.Errorf("%s: answered %d %v, want %d", tt.name, code, body, tt.want)
+		}
+	}
+
+	if code := e.api(t, "DELETE", "/api/v1/broadcasters/"+bob.ID, e2eAPIToken, nil, nil); code != http.StatusNoContent {
+		t.Fatalf("remove bob answered %d", code)
+	}
+	eventually(t, "subscriptions of bob deleted", func() bool { return !e.hasSubscription(bob.ID) })
+	if ids := e.cfg.BroadcasterIDs(); len(ids) != 1 || ids[0] != alice.ID {
+		t.Errorf("broadcasters after the removal = %v, want alice", ids)
+	}
+	if code := e.api(t, "DELETE", "/api/v1/broadcasters/"+bob.ID, e2eAPIToken, nil, nil); code != http.StatusNotFound {
+		t.Errorf("second removal answered %d, want 404", code)
+	}
+
+	// The environment replaces the broadcasters of the file, editing it would have no effect
+	t.Setenv("TWITCH_BROADCASTER_IDS", alice.ID)
+	if code := e.api(t, "POST", "/api/v1/broadcasters", e2eAPIToken, map[string]string{"id": bob.ID}, nil); code != http.StatusConflict {
+		t.Errorf("addition with TWITCH_BROADCASTER_IDS answered %d, want 409", code)
+	}
+}
+
+func TestAPIWithoutConfigFile(t *testing.T) {
+	e := newE2E(t, alice)
+	e.twitch.AddUser(bob)
+	var body map[string]string
+	if code := e.api(t, "POST", "/api/v1/broadcasters", e2eAPIToken, map[string]string{"id": bob.ID}, &body); code != http.StatusConflict {
+		t.Errorf("addition without configuration file answered %d %v, want 409", code, body)
+	}
+}
+
+func TestAPISessionsAndSubscriptions(t *testing.T) {
+	e := newE2E(t, alice, bob)
+	e.start(t)
+	e.twitch.SetLive(twitchtest.Stream{UserID: alice.ID, UserLogin: alice.Login, UserName: alice.DisplayName,
+		GameName: "Celeste", Title: "Any% practice", ViewerCount: 42, StartedAt: goldenStartedAt})
+	e.postEvent(t, SimulateStreamOnline(simulatedUser(alice)))
+	e.discord.Wait(t, 1)
+
+	var sessions struct{ Data []Session }
+	e.api(t, "GET", "/api/v1/sessions", e2eAPIToken, nil, &sessions)
+	if len(sessions.Data) != 1 || sessions.Data[0].BroadcasterID != alice.ID || !sessions.Data[0].Announced {
+		t.Errorf("sessions = %+v, want the announced session of alice", sessions.Data)
+	}
+
+	var subs struct {
+		Data    []Subscription
+		Total   int
+		Failing []string
+	}
+	if code := e.api(t, "GET", "/api/v1/subscriptions?type=stream.online", e2eAPIToken, nil, &subs); code != http.StatusOK {
+		t.Fatalf("subscriptions answered %d", code)
+	}
+	if len(subs.Data) != 2 || len(subs.Failing) != 0 {
+		t.Errorf("subscriptions = %+v, want the stream.online subscriptions of alice and bob, none failing", subs)
+	}
+
+	e.twitch.Fail("GET", "/helix/eventsub/subscriptions", http.StatusInternalServerError, 1)
+	if code := e.api(t, "GET", "/api/v1/subscriptions", e2eAPIToken, nil, nil); code != http.StatusBadGateway {
+		t.Errorf("subscriptions with Twitch failing answered %d, want 502", code)
+	}
+}
+
+func TestAPITestAnnouncementAndRetry(t *testing.T) {
+	e := newE2E(t, alice)
+	e.start(t)
+	e.twitch.SetLive(twitchtest.Stream{UserID: alice.ID, UserLogin: alice.Login, UserName: alice.DisplayName,
+		GameName: "Celeste", Title: "Any% practice", ViewerCount: 42, StartedAt: goldenStartedAt})
+
+	// The first attempt is rejected: the bot can't post in the channel yet
+	e.discord.FailNext(discordtest.OpSend, &discordgo.RESTError{
+		Response:     &http.Response{StatusCode: http.StatusForbidden, Status: "403 Forbidden"},
+		ResponseBody: []byte(`{"message": "Missing Permissions", "code": 50013}`),
+	})
+	var queued struct{ ID string }
+	if code := e.api(t, "POST", "/api/v1/announcements/test", e2eAPIToken, nil, &queued); code != http.StatusAccepted {
+		t.Fatalf("test announcement answered %d", code)
+	}
+
+	var failed struct {
+		Data []struct {
+			ID        string `json:"id"`
+			LastError string `json:"last_error"`
+		}
+	}
+	eventually(t, "failed delivery", func() bool {
+		e.api(t, "GET", "/api/v1/deliveries/failed", e2eAPIToken, nil, &failed)
+		return len(failed.Data) == 1
+	})
+	if failed.Data[0].ID != queued.ID || !strings.Contains(failed.Data[0].LastError, "Missing Permissions") {
+		t.Errorf("failed deliveries = %+v, want the test announcement %s", failed.Data, queued.ID)
+	}
+
+	if code := e.api(t, "POST", "/api/v1/deliveries/failed/unknown/retry", e2eAPIToken, nil, nil); code != http.StatusNotFound {
+		t.Errorf("retry of an unknown delivery answered %d, want 404", code)
+	}
+	var retried struct{ Retried int }
+	if code := e.api(t, "POST", "/api/v1/deliveries/failed/"+queued.ID+"/retry", e2eAPIToken, nil, &retried); code != http.StatusOK || retried.Retried != 1 {
+		t.Fatalf("retry answered %d %+v", code, retried)
+	}
+	discordtest.Golden(t, "api_test_announcement", e.discord.Wait(t, 2))
+}
@@ -0,0 +1,205 @@
+package twitch
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/config"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/discord"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/i18n"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/storage"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// clipBucket holds the posted clips (clip ID -> creation time of the clip)
+	clipBucket = "clips"
+
+	// clipWatchBucket holds, by broadcaster ID, when the watcher started watching its clips
+	clipWatchBucket = "clip_watch"
+
+	// clipLookback is how far back clips are checked, so clips reaching the minimum view count late are posted
+	clipLookback = 24 * time.Hour
+
+	// clipRetention is how long posted clip IDs are remembered (longer than the lookback)
+	clipRetention = 7 * 24 * time.Hour
+)
+
+// Clip represents a Twitch clip returned by Helix /clips
+type Clip struct {
+	ID              string    `json:"id"`
+	URL             string    `json:"url"`
+	BroadcasterID   string    `json:"broadcaster_id"`
+	BroadcasterName string    `json:"broadcaster_name"`
+	CreatorID       string    `json:"creator_id"`
+	CreatorName     string    `json:"creator_name"`
+	VideoID         string    `json:"video_id"`
+	GameID          string    `json:"game_id"`
+	Language        string    `json:"language"`
+	Title           string    `json:"title"`
+	ViewCount       int       `json:"view_count"`
+	CreatedAt       time.Time `json:"created_at"`
+	ThumbnailURL    string    `json:"thumbnail_url"`
+	Duration        float64   `json:"duration"`
+}
+
+// GetClips fetches the clips of a broadcaster created between start and end, following pagination
+func (c *Client) GetClips(broadcasterID string, start, end time.Time) ([]Clip, error) {
+	var clips []Clip
+	cursor := ""
+	for {
+		q := url.Values{
+			"broadcaster_id": {broadcasterID},
+			"started_at":     {start.UTC().Format(time.RFC3339)},
+			"ended_at":       {end.UTC().Format(time.RFC3339)},
+			"first":          {"100"},
+		}
+		if cursor != "" {
+			q.Set("after", cursor)
+		}
+		var data struct {
+			Data       []Clip `json:"data"`
+			Pagination struct {
+				Cursor string `json:"cursor"`
+			} `json:"pagination"`
+		}
+		if err := c.get("/clips", q, "", &data); err != nil {
+			return nil, err
+		}
+		clips = append(clips, data.Data...)
+		if data.Pagination.Cursor == "" || len(data.Data) == 0 {
+			return clips, nil
+		}
+		cursor = data.Pagination.Cursor
+	}
+}
+
+// ClipWatcher posts the new clips of followed broadcasters to the clips channel
+type ClipWatcher struct {
+	cfg           *config.Config
+	discordClient *discord.Client
+	helix         *Client
+	store         *storage.Store
+	logger        *logrus.Logger
+}
+
+// NewClipWatcher creates a clip watcher posting to CLIPS_CHANNEL_ID
+func NewClipWatcher(cfg *config.Config, discordClient *discord.Client, helix *Client, store *storage.Store, logger *logrus.Logger) *ClipWatcher {
+	return &ClipWatcher{
+		cfg:           cfg,
+		discordClient: discordClient,
+		helix:         helix,
+		store:         store,
+		logger:        logger,
+	}
+}
+
+// Run polls the clips every interval until ctx is done
+func (w *ClipWatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		w.PollAll()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PollAll posts the new clips of every followed broadcaster and forgets old posted clips
+func (w *ClipWatcher) PollAll() {
+	for _, broadcasterID := range w.cfg.BroadcasterIDs() {
+		if err := w.poll(broadcasterID); err != nil {
+			w.logger.Errorf("Clip watch failed for %s: %v", broadcasterID, err)
+		}
+	}
+	w.prune()
+}
+
+// poll posts the clips of a broadcaster that reached the minimum view count and weren't posted yet.
+// Clips created before the broadcaster was first watched are ignored, so enabling the watcher doesn't flood the channel.
+func (w *ClipWatcher) poll(broadcasterID string) error {
+	now := time.Now()
+	var since time.Time
+	ok, err := w.store.Get(clipWatchBucket, broadcasterID, &since)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return w.store.Put(clipWatchBucket, broadcasterID, now)
+	}
+	if start := now.Add(-clipLookback); since.Before(start) {
+		since = start
+	}
+
+	clips, err := w.helix.GetClips(broadcasterID, since, now)
+	if err != nil {
+		return err
+	}
+	sort.Slice(clips, func(i, j int) bool { return clips[i].CreatedAt.Before(clips[j].CreatedAt) })
+
+	lang := w.discordClient.ChannelLanguage(w.cfg.ClipsChannelID)
+	for _, clip := range clips {
+		if clip.ViewCount < w.cfg.ClipsMinViews {
+			continue
+		}
+		var createdAt time.Time
+		if posted, _ := w.store.Get(clipBucket, clip.ID, &createdAt); posted {
+			continue
+		}
+		if err := w.discordClient.SendEmbed(w.cfg.ClipsChannelID, ClipEmbed(lang, &clip)); err != nil {
+			return fmt.Errorf("failed to post clip %s: %w", clip.ID, err)
+		}
+		w.logger.Infof("🎬 Clip posted for %s: %s", clip.BroadcasterName, clip.URL)
+		// The clip is posted: a failed save mustn't hold back the next clips of the poll
+		if err := w.store.Put(clipBucket, clip.ID, clip.CreatedAt); err != nil {
+			w.logger.Errorf("failed to save posted clip %s, it may be posted again after a restart: %v", clip.ID, err)
+		}
+	}
+	return nil
+}
+
+// prune forgets the posted clips older than the retention, they can't be fetched again
+func (w *ClipWatcher) prune() {
+	limit := time.Now().Add(-clipRetention)
+	for _, id := range w.store.Keys(clipBucket) {
+		var createdAt time.Time
+		if ok, err := w.store.Get(clipBucket, id, &createdAt); ok && err == nil && createdAt.Before(limit) {
+			w.store.Delete(clipBucket, id)
+		}
+	}
+}
+
+// ClipEmbed builds the embed of a clip in the given language
+func ClipEmbed(lang string, clip *Clip) *discordgo.MessageEmbed {
+	return &discordgo.MessageEmbed{
+		Title: clip.Title,
+		URL:   clip.URL,
+		Color: 0x9146FF, // Twitch purple
+		Author: &discordgo.MessageEmbedAuthor{
+			Name: i18n.T(lang, "clip.author", clip.BroadcasterName),
+		},
+		Image: &discordgo.MessageEmbedImage{
+			URL: clip.ThumbnailURL,
+		},
+		Fields: []*discordgo.MessageEmbedField{
+			{
+				Name:   i18n.T(lang, "clip.field.creator"),
+				Value:  clip.CreatorName,
+				Inline: true,
+			},
+			{
+				Name:   i18n.T(lang, "clip.field.views"),
+				Value:  fmt.Sprintf("%d", clip.ViewCount),
+				Inline: true,
+			},
+		},
+		Timestamp: clip.CreatedAt.Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,138 @@
+package twitch
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/flthibaud/TwitchLiveNotifier/internal/discordtest"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/storage"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/twitchtest"
+	"github.com/sirupsen/logrus"
+)
+
+// newClipWatcher returns a watcher of the clips of the e2e broadcasters keeping its state in store,
+// posting clips with at least 10 views to e2eChannel. The delivery queue runs until the end of the test.
+func newClipWatcher(t *testing.T, e *e2e, store *storage.Store) *ClipWatcher {
+	t.Helper()
+	e.cfg.ClipsChannelID = e2eChannel
+	e.cfg.ClipsMinViews = 10
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go e.client.Queue().Run(ctx, 1)
+
+	logger := logrus.New()
+	logger.Out = io.Discard
+	return NewClipWatcher(e.cfg, e.client, e.server.helix, store, logger)
+}
+
+// openStore opens the store file at path
+func openStore(t *testing.T, path string) *storage.Store {
+	t.Helper()
+	store, err := storage.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+// watchClipsSince makes the watcher check the clips of a broadcaster created since start
+func watchClipsSince(t *testing.T, store *storage.Store, broadcasterID string, start time.Time) {
+	t.Helper()
+	if err := store.Put(clipWatchBucket, broadcasterID, start); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// postedClips returns the titles of the clips posted to Discord
+func postedClips(e *e2e) []string {
+	var titles []string
+	for _, c := range e.discord.Calls() {
+		if c.Op == discordtest.OpSend {
+			titles = append(titles, c.Message.Embeds[0].Title)
+		}
+	}
+	return titles
+}
+
+func TestClipWatcher(t *testing.T) {
+	e := newE2E(t, alice)
+	path := filepath.Join(t.TempDir(), "bot.json")
+	w := newClipWatcher(t, e, openStore(t, path))
+	now := time.Now()
+	e.twitch.AddClip(twitchtest.Clip{ID: "old", BroadcasterID: alice.ID, Title: "Old", ViewCount: 100, CreatedAt: now.Add(-30 * time.Minute)})
+
+	// The first poll only starts watching, the existing clips aren't posted
+	if err := w.poll(alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	var since time.Time
+	if ok, _ := w.store.Get(clipWatchBucket, alice.ID, &since); !ok || since.Before(now) {
+		t.Fatalf("watch start = %s, want the first poll", since)
+	}
+	if err := w.poll(alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	if d := e.client.Queue().Depth(); d != 0 || len(postedClips(e)) != 0 {
+		t.Fatalf("clips created before the first poll posted: %v", postedClips(e))
+	}
+
+	// Clips below the minimum view count wait until they reach it
+	watchClipsSince(t, w.store, alice.ID, now.Add(-25*time.Minute))
+	e.twitch.AddClip(twitchtest.Clip{ID: "quiet", BroadcasterID: alice.ID, Title: "Quiet", ViewCount: 3, CreatedAt: now.Add(-20 * time.Minute)})
+	e.twitch.AddClip(twitchtest.Clip{ID: "popular", BroadcasterID: alice.ID, Title: "Popular", ViewCount: 10, CreatedAt: now.Add(-10 * time.Minute)})
+	if err := w.poll(alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	e.discord.Wait(t, 1)
+	if got := postedClips(e); len(got) != 1 || got[0] != "Popular" {
+		t.Fatalf("posted clips %v, want [Popular]", got)
+	}
+
+	// Posted clips aren't posted again, even after a restart
+	if err := w.poll(alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	restarted := NewClipWatcher(e.cfg, e.client, e.server.helix, openStore(t, path), w.logger)
+	if err := restarted.poll(alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "queue drained", func() bool { return e.client.Queue().Depth() == 0 })
+	if got := postedClips(e); len(got) != 1 {
+		t.Errorf("posted clips %v, want no new one", got)
+	}
+}
+
+func TestClipWatcherSaveFailure(t *testing.T) {
+	e := newE2E(t, alice)
+	path := filepath.Join(t.TempDir(), "bot.json")
+	w := newClipWatcher(t, e, openStore(t, path))
+	now := time.Now()
+	watchClipsSince(t, w.store, alice.ID, now.Add(-time.Hour))
+	e.twitch.AddClip(twitchtest.Clip{ID: "first", BroadcasterID: alice.ID, Title: "First", ViewCount: 10, CreatedAt: now.Add(-20 * time.Minute)})
+	e.twitch.AddClip(twitchtest.Clip{ID: "second", BroadcasterID: alice.ID, Title: "Second", ViewCount: 10, CreatedAt: now.Add(-10 * time.Minute)})
+
+	// The store can't be written: its temporary file is a directory
+	if err := os.Mkdir(path+".tmp", 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.poll(alice.ID); err != nil {
+		t.Fatalf("poll() error = %v, want the failed saves logged", err)
+	}
+	e.discord.Wait(t, 2)
+	if got := postedClips(e); len(got) != 2 || got[0] != "First" || got[1] != "Second" {
+		t.Fatalf("posted clips %v, want every clip of the poll", got)
+	}
+
+	// The running watcher still knows they were posted
+	if err := w.poll(alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "queue drained", func() bool { return e.client.Queue().Depth() == 0 })
+	if got := postedClips(e); len(got) != 2 {
+		t.Errorf("posted clips %v, want no new one", got)
+	}
+}
@@ -0,0 +1,92 @@
+package twitch
+
+import (
+	"github.com/bwmarrin/discordgo"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/discord/commands"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/discordapi"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/i18n"
+	"github.com/sirupsen/logrus"
+)
+
+// TwitchCommand defines the /twitch command
+var TwitchCommand = i18n.Localize(&discordgo.ApplicationCommand{
+	Name: "twitch",
+	Options: []*discordgo.ApplicationCommandOption{
+		{Type: discordgo.ApplicationCommandOptionSubCommand, Name: "link"},
+		{Type: discordgo.ApplicationCommandOptionSubCommand, Name: "unlink"},
+		{Type: discordgo.ApplicationCommandOptionSubCommand, Name: "status"},
+	},
+})
+
+// NewTwitchCommand builds the /twitch command linking the invoker to their Twitch account
+func NewTwitchCommand(linker *Linker, logger *logrus.Logger) *commands.Command {
+	return &commands.Command{
+		Definition: TwitchCommand,
+		Handler: func(s discordapi.Sender, i *discordgo.InteractionCreate) {
+			lang := commands.Lang(i)
+			userID := commands.InvokerID(i)
+			path, _ := commands.SubCommand(i.ApplicationCommandData().Options)
+
+			var err error
+			switch path {
+			case "link":
+				var authURL string
+				authURL, err = linker.AuthorizeURL(userID, lang)
+				if err != nil {
+					break
+				}
+				err = s.Respond(i.Interaction, &discordgo.InteractionResponse{
+					Type: discordgo.InteractionResponseChannelMessageWithSource,
+					Data: &discordgo.InteractionResponseData{
+						Content: i18n.T(lang, "twitch.link.prompt"),
+						Flags:   discordgo.MessageFlagsEphemeral,
+						Components: []discordgo.MessageComponent{
+							discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+								discordgo.Button{
+									Label: i18n.T(lang, "twitch.link.button"),
+									Style: discordgo.LinkButton,
+									URL:   authURL,
+								},
+							}},
+						},
+					},
+				})
+
+			case "unlink":
+				var link *Link
+				var revoked bool
+				link, revoked, err = linker.Unlink(userID)
+				if err != nil {
+					break
+				}
+				reply := i18n.T(lang, "twitch.not_linked")
+				if link != nil && revoked {
+					reply = i18n.T(lang, "twitch.unlinked", link.TwitchLogin)
+				} else if link != nil {
+					reply = i18n.T(lang, "twitch.unlinked_not_revoked", link.TwitchLogin)
+				}
+				err = commands.RespondEphemeral(s, i, reply)
+
+			case "status":
+				var link *Link
+				var ok bool
+				link, ok, err = linker.Get(userID)
+				if err != nil {
+					break
+				}
+				reply := i18n.T(lang, "twitch.not_linked")
+				if ok {
+					reply = i18n.T(lang, "twitch.linked", link.TwitchLogin)
+				}
+				err = commands.RespondEphemeral(s, i, reply)
+			}
+
+			if err != nil {
+				logger.Errorf("Cannot handle /twitch %s: %v", path, err)
+				if err := commands.RespondEphemeral(s, i, i18n.T(lang, "twitch.error")); err != nil {
+					logger.Errorf("Cannot respond to /twitch: %v", err)
+				}
+			}
+		},
+	}
+}
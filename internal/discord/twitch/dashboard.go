@@ -0,0 +1,279 @@
+package twitch
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/flthibaud/TwitchLiveNotifier/internal/delivery"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/i18n"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/utils"
+)
+
+const (
+	// dashboardPath is the path of the web dashboard, served when DASHBOARD_TOKEN is set
+	dashboardPath = "/dashboard"
+
+	// dashboardCookie holds the login of the dashboard: its expiry, signed with the dashboard token,
+	// so changing the token signs everyone out
+	dashboardCookie = "dashboard_session"
+
+	// dashboardSessionDuration is the time a login to the dashboard lasts
+	dashboardSessionDuration = 12 * time.Hour
+
+	// dashboardRows is the number of recent events, deliveries and errors shown
+	dashboardRows = 20
+
+	// recentErrorCount is the number of warnings and errors kept for the dashboard
+	recentErrorCount = 50
+)
+
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+var dashboardTemplates = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"t":        i18n.T,
+	"clock":    clock,
+	"duration": humanDuration,
+}).ParseFS(dashboardFiles, "dashboard/templates/*.html"))
+
+// dashboardView is the data of the dashboard page
+type dashboardView struct {
+	Lang                 string
+	Refresh              bool // signed in, the page refreshes itself
+	Invalid              bool // login page after a wrong token
+	Now                  time.Time
+	Ready                bool
+	Checks               []dashboardCheck
+	Uptime               time.Duration
+	QueueDepth           int
+	FailedDeliveries     int
+	Broadcasters         []dashboardBroadcaster
+	SubscriptionCount    int
+	FailingSubscriptions []string
+	Events               []RecentEvent
+	Deliveries           []delivery.Attempt
+	Errors               []utils.LogEntry
+}
+
+// dashboardCheck is a readiness check
+type dashboardCheck struct {
+	Name   string
+	Status string
+	OK     bool
+}
+
+// dashboardBroadcaster is a followed broadcaster with the state of its subscriptions
+type dashboardBroadcaster struct {
+	APIBroadcaster
+	Game    string   // category of the live stream
+	Failing []string // types of its failing subscriptions
+}
+
+// registerDashboard serves the dashboard on mux and starts keeping the recent warnings and errors
+func (s *WebhookServer) registerDashboard(mux *http.ServeMux) {
+	s.recentErrors = utils.NewRecentErrors(recentErrorCount)
+	s.logger.AddHook(s.recentErrors)
+
+	static, err := fs.Sub(dashboardFiles, "dashboard/static")
+	if err != nil {
+		panic(err)
+	}
+	mux.HandleFunc(dashboardPath, s.handleDashboard)
+	mux.HandleFunc(dashboardPath+"/login", s.handleDashboardLogin)
+	mux.HandleFunc(dashboardPath+"/logout", s.handleDashboardLogout)
+	mux.Handle(dashboardPath+"/static/", http.StripPrefix(dashboardPath+"/static/", http.FileServer(http.FS(static))))
+}
+
+// handleDashboard renders the dashboard, or sends to the login page
+func (s *WebhookServer) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.signedIn(r) {
+		http.Redirect(w, r, dashboardPath+"/login", http.StatusSeeOther)
+		return
+	}
+	s.renderDashboard(w, http.StatusOK, "index", s.dashboardView())
+}
+
+// handleDashboardLogin shows the login form, and signs in with the dashboard token
+func (s *WebhookServer) handleDashboardLogin(w http.ResponseWriter, r *http.Request) {
+	view := &dashboardView{Lang: s.cfg.DefaultLanguage}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		s.renderDashboard(w, http.StatusOK, "login", view)
+	case http.MethodPost:
+		token := r.PostFormValue("token")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg.DashboardToken)) != 1 {
+			s.logger.Warnf("Dashboard: failed sign in from %s", r.RemoteAddr)
+			view.Invalid = true
+			s.renderDashboard(w, http.StatusUnauthorized, "login", view)
+			return
+		}
+		expires := time.Now().Add(dashboardSessionDuration)
+		http.SetCookie(w, &http.Cookie{
+			Name:     dashboardCookie,
+			Value:    s.dashboardSession(expires),
+			Path:     dashboardPath,
+			Expires:  expires,
+			HttpOnly: true,
+			Secure:   secureRequest(r),
+			SameSite: http.SameSiteStrictMode,
+		})
+		s.logger.Infof("Dashboard: signed in from %s", r.RemoteAddr)
+		http.Redirect(w, r, dashboardPath, http.StatusSeeOther)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleDashboardLogout signs out
+func (s *WebhookServer) handleDashboardLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: dashboardCookie, Path: dashboardPath, MaxAge: -1, HttpOnly: true, Secure: secureRequest(r)})
+	http.Redirect(w, r, dashboardPath+"/login", http.StatusSeeOther)
+}
+
+// renderDashboard renders a page of the dashboard. Pages show the bot state, they are never cached
+// nor framed by other sites.
+func (s *WebhookServer) renderDashboard(w http.ResponseWriter, code int, page string, view *dashboardView) {
+	var buf strings.Builder
+	if err := dashboardTemplates.ExecuteTemplate(&buf, page, view); err != nil {
+		s.logger.Errorf("Dashboard: cannot render %s: %v", page, err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'self'; form-action 'self'; frame-ancestors 'none'")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	fmt.Fprint(w, buf.String())
+}
+
+// dashboardSession returns the value of the login cookie expiring at expires
+func (s *WebhookServer) dashboardSession(expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(s.cfg.DashboardToken))
+	mac.Write([]byte("dashboard:" + exp))
+	return exp + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+// signedIn reports whether a request carries an unexpired login cookie signed with the current token
+func (s *WebhookServer) signedIn(r *http.Request) bool {
+	cookie, err := r.Cookie(dashboardCookie)
+	if err != nil || s.cfg.DashboardToken == "" {
+		return false
+	}
+	exp, _, _ := strings.Cut(cookie.Value, ".")
+	unix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return false
+	}
+	expires := time.Unix(unix, 0)
+	return time.Now().Before(expires) && hmac.Equal([]byte(cookie.Value), []byte(s.dashboardSession(expires)))
+}
+
+// secureRequest reports whether a request came over HTTPS, directly or through a reverse proxy
+func secureRequest(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+// dashboardView gathers the state shown on the dashboard
+func (s *WebhookServer) dashboardView() *dashboardView {
+	now := time.Now()
+	view := &dashboardView{
+		Lang:             s.cfg.DefaultLanguage,
+		Refresh:          true,
+		Now:              now,
+		Uptime:           now.Sub(s.startedAt),
+		QueueDepth:       s.discordClient.Queue().Depth(),
+		FailedDeliveries: len(s.discordClient.Queue().DeadLetters("")),
+	}
+
+	ready, checks := s.readiness()
+	view.Ready = ready
+	for name, status := range checks {
+		view.Checks = append(view.Checks, dashboardCheck{Name: name, Status: status, OK: status == "ok"})
+	}
+	sort.Slice(view.Checks, func(i, j int) bool { return view.Checks[i].Name < view.Checks[j].Name })
+
+	s.mu.Lock()
+	view.SubscriptionCount = len(s.subStates)
+	failing := map[string][]string{}
+	for key, state := range s.subStates {
+		if subType, broadcasterID, ok := strings.Cut(key, "/"); ok && state != "" {
+			failing[broadcasterID] = append(failing[broadcasterID], subType)
+		}
+	}
+	s.mu.Unlock()
+	view.FailingSubscriptions = s.failingSubscriptions()
+
+	for _, b := range s.followedBroadcasters() {
+		row := dashboardBroadcaster{APIBroadcaster: b, Failing: failing[b.ID]}
+		sort.Strings(row.Failing)
+		if b.Session != nil && len(b.Session.Games) > 0 {
+			row.Game = b.Session.Games[len(b.Session.Games)-1].Name
+		}
+		view.Broadcasters = append(view.Broadcasters, row)
+	}
+
+	view.Events = s.RecentEvents()
+	if len(view.Events) > dashboardRows {
+		view.Events = view.Events[:dashboardRows]
+	}
+	view.Deliveries = s.discordClient.Queue().Recent()
+	if len(view.Deliveries) > dashboardRows {
+		view.Deliveries = view.Deliveries[:dashboardRows]
+	}
+	view.Errors = s.recentErrors.Entries()
+	if len(view.Errors) > dashboardRows {
+		view.Errors = view.Errors[:dashboardRows]
+	}
+	return view
+}
+
+// clock formats a time (or a *time.Time) for the dashboard, in the timezone of the bot
+func clock(v interface{}) string {
+	switch t := v.(type) {
+	case time.Time:
+		return t.Local().Format("2006-01-02 15:04:05")
+	case *time.Time:
+		if t != nil {
+			return clock(*t)
+		}
+	}
+	return ""
+}
+
+// humanDuration formats a duration in days, hours and minutes, e.g. 2d 3h 5m
+func humanDuration(d time.Duration) string {
+	d = d.Truncate(time.Minute)
+	days, hours, minutes := int(d/(24*time.Hour)), int(d%(24*time.Hour)/time.Hour), int(d%time.Hour/time.Minute)
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%dd %dh %dm", days, hours, minutes)
+	case hours > 0:
+		return fmt.Sprintf("%dh %dm", hours, minutes)
+	default:
+		return fmt.Sprintf("%dm", minutes)
+	}
+}
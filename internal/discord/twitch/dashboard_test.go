@@ -0,0 +1,150 @@
+package twitch
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/flthibaud/TwitchLiveNotifier/internal/twitchtest"
+)
+
+// page requests a page of the dashboard with the given cookies
+func (e *e2e) page(t *testing.T, method, path string, form url.Values, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	t.Helper()
+	var req *http.Request
+	if form != nil {
+		req = httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		req = httptest.NewRequest(method, path, nil)
+	}
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	rec := httptest.NewRecorder()
+	e.server.httpServer.Handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// signIn signs in the dashboard and returns the login cookie
+func (e *e2e) signIn(t *testing.T) *http.Cookie {
+	t.Helper()
+	rec := e.page(t, "POST", "/dashboard/login", url.Values{"token": {e2eDashboard}})
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/dashboard" {
+		t.Fatalf("sign in answered %d to %q", rec.Code, rec.Header().Get("Location"))
+	}
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == dashboardCookie {
+			if !c.HttpOnly || c.SameSite != http.SameSiteStrictMode {
+				t.Errorf("login cookie %+v, want HttpOnly and SameSite=Strict", c)
+			}
+			return c
+		}
+	}
+	t.Fatal("sign in set no login cookie")
+	return nil
+}
+
+func TestDashboardSignIn(t *testing.T) {
+	e := newE2E(t, alice)
+
+	if rec := e.page(t, "GET", "/dashboard", nil); rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/dashboard/login" {
+		t.Errorf("dashboard without login answered %d to %q, want the login page", rec.Code, rec.Header().Get("Location"))
+	}
+	if rec := e.page(t, "GET", "/dashboard/login", nil); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `name="token"`) {
+		t.Errorf("login page answered %d:\n%s", rec.Code, rec.Body)
+	}
+	rec := e.page(t, "POST", "/dashboard/login", url.Values{"token": {"wrong"}})
+	if rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Body.String(), "Invalid token.") || len(rec.Result().Cookies()) > 0 {
+		t.Errorf("wrong token answered %d with cookies %v:\n%s", rec.Code, rec.Result().Cookies(), rec.Body)
+	}
+
+	cookie := e.signIn(t)
+	if rec := e.page(t, "GET", "/dashboard", nil, cookie); rec.Code != http.StatusOK {
+		t.Errorf("dashboard with login answered %d", rec.Code)
+	}
+
+	// Forged, expired and outdated cookies are refused
+	expired := time.Now().Add(-time.Minute)
+	forged := []*http.Cookie{
+		{Name: dashboardCookie, Value: strings.Replace(cookie.Value, ".", ".0", 1)},
+		{Name: dashboardCookie, Value: "9999999999." + strings.SplitN(cookie.Value, ".", 2)[1]},
+		{Name: dashboardCookie, Value: e.server.dashboardSession(expired)},
+	}
+	for _, c := range forged {
+		if rec := e.page(t, "GET", "/dashboard", nil, c); rec.Code != http.StatusSeeOther {
+			t.Errorf("dashboard with cookie %q answered %d, want the login page", c.Value, rec.Code)
+		}
+	}
+	e.cfg.DashboardToken = "another-token"
+	if rec := e.page(t, "GET", "/dashboard", nil, cookie); rec.Code != http.StatusSeeOther {
+		t.Errorf("dashboard with a login of the previous token answered %d, want the login page", rec.Code)
+	}
+	e.cfg.DashboardToken = e2eDashboard
+
+	rec = e.page(t, "POST", "/dashboard/logout", nil, cookie)
+	if rec.Code != http.StatusSeeOther || len(rec.Result().Cookies()) != 1 || rec.Result().Cookies()[0].MaxAge >= 0 {
+		t.Errorf("logout answered %d with cookies %v, want the login cookie deleted", rec.Code, rec.Result().Cookies())
+	}
+
+	if rec := e.page(t, "GET", "/dashboard/static/style.css", nil); rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/css") {
+		t.Errorf("stylesheet answered %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+}
+
+func TestDashboardContent(t *testing.T) {
+	e := newE2E(t, alice, bob)
+	e.start(t)
+	e.twitch.SetLive(twitchtest.Stream{UserID: alice.ID, UserLogin: alice.Login, UserName: alice.DisplayName,
+		GameName: "Celeste", Title: "Any% practice", ViewerCount: 42, StartedAt: goldenStartedAt})
+	e.postEvent(t, SimulateStreamOnline(simulatedUser(alice)))
+	e.discord.Wait(t, 1)
+	eventually(t, "delivery recorded", func() bool { return len(e.client.Queue().Recent()) == 1 })
+	e.postEvent(t, SimulateRevocation("stream.offline", simulatedUser(bob), "authorization_revoked"))
+
+	rec := e.page(t, "GET", "/dashboard", nil, e.signIn(t))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("dashboard answered %d", rec.Code)
+	}
+	if csp := rec.Header().Get("Content-Security-Policy"); !strings.Contains(csp, "frame-ancestors 'none'") {
+		t.Errorf("Content-Security-Policy = %q", csp)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		"⚠️ Something is wrong",             // no Discord session in the tests
+		"❌ session not ready",               // the failing check
+		`<a href="https://twitch.tv/alice"`, // followed broadcasters
+		"🔴 Live since",
+		"Celeste",
+		"Offline",
+		"1 problem(s) with the 4 subscriptions:", // bob's revoked subscription
+		"stream.offline/1002: revoked (authorization_revoked)",
+		"went live",
+		"subscription revoked by Twitch",
+		"announcement",
+		"✅ sent",
+		"Subscription révoquée par Twitch", // the warning logged for the revocation
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("dashboard doesn't show %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestHumanDuration(t *testing.T) {
+	tests := map[time.Duration]string{
+		30 * time.Second:               "0m",
+		5*time.Minute + 10*time.Second: "5m",
+		3*time.Hour + 5*time.Minute:    "3h 5m",
+		50*time.Hour + 59*time.Second:  "2d 2h 0m",
+		24*time.Hour + 90*time.Minute:  "1d 1h 30m",
+	}
+	for d, want := range tests {
+		if got := humanDuration(d); got != want {
+			t.Errorf("humanDuration(%s) = %q, want %q", d, got, want)
+		}
+	}
+}
@@ -0,0 +1,503 @@
+package twitch
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/config"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/discord"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/discord/commands"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/discordapi"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/i18n"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/schedule"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/settings"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/storage"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// historyBucket holds the ended sessions of announced streams, keyed by start time and broadcaster ID
+	historyBucket = "stream_history"
+
+	// historyRetention is how long ended sessions are kept, a bit more than the longest digest period
+	historyRetention = 35 * 24 * time.Hour
+
+	// Periods summarized by a digest
+	DigestDay  = "day"
+	DigestWeek = "week"
+
+	// maxDigestLines bounds the lines of each digest field, Discord limits fields to 1024 characters
+	maxDigestLines = 10
+)
+
+// defaultDigestSchedules are the schedules used when /digest set doesn't specify one
+var defaultDigestSchedules = map[string]string{
+	DigestDay:  "0 9 * * *", // every day at 9:00
+	DigestWeek: "0 9 * * 1", // every Monday at 9:00
+}
+
+// digestPeriod returns the time span summarized by a period
+func digestPeriod(period string) time.Duration {
+	if period == DigestWeek {
+		return 7 * 24 * time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// Digests records the ended sessions of announced streams and posts, on the schedule of each guild,
+// a summary of the last day or week: who streamed, for how long, the top categories and peak viewers,
+// followed by the upcoming scheduled streams of followed broadcasters.
+type Digests struct {
+	cfg           *config.Config
+	discordClient *discord.Client
+	helix         *Client
+	announcer     *Announcer
+	settings      *settings.Manager
+	store         *storage.Store
+	logger        *logrus.Logger
+}
+
+// NewDigests creates the digest scheduler
+func NewDigests(cfg *config.Config, discordClient *discord.Client, helix *Client, announcer *Announcer, store *storage.Store, logger *logrus.Logger) *Digests {
+	return &Digests{
+		cfg:           cfg,
+		discordClient: discordClient,
+		helix:         helix,
+		announcer:     announcer,
+		settings:      discordClient.Settings(),
+		store:         store,
+		logger:        logger,
+	}
+}
+
+// StreamOnline is a no-op, sessions are recorded when they end
+func (d *Digests) StreamOnline(ctx context.Context, ev *StreamEvent) {}
+
+// StreamOffline records the ended session of an announced stream
+func (d *Digests) StreamOffline(ctx context.Context, ev *StreamEvent) {
+	if ev.Session == nil || !ev.Session.Announced {
+		return
+	}
+	sess := *ev.Session
+	if sess.EndedAt == nil {
+		now := time.Now()
+		sess.EndedAt = &now
+	}
+	sess.Guilds = d.guildsOf(&sess)
+	sess.Messages = nil
+	key := fmt.Sprintf("%d-%s", sess.StartedAt.Unix(), sess.BroadcasterID)
+	if err := d.store.Put(historyBucket, key, sess); err != nil {
+		utils.Log(ctx, d.logger).Errorf("failed to record the session of %s: %v", sess.BroadcasterName, err)
+	}
+	d.prune()
+}
+
+// prune forgets the sessions older than the retention
+func (d *Digests) prune() {
+	limit := time.Now().Add(-historyRetention)
+	for _, key := range d.store.Keys(historyBucket) {
+		var sess Session
+		if ok, err := d.store.Get(historyBucket, key, &sess); ok && err == nil && sess.EndedAt.Before(limit) {
+			d.store.Delete(historyBucket, key)
+		}
+	}
+}
+
+// Run posts the digests that are due every minute, until ctx is done
+func (d *Digests) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		d.PostDue()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PostDue posts the digest of every guild whose schedule fired since its last digest.
+// A digest missed while the bot was down is posted once when it comes back.
+func (d *Digests) PostDue() {
+	now := time.Now()
+	for _, guildID := range d.settings.GuildIDs() {
+		g, err := d.settings.Guild(guildID)
+		if err != nil || g.Digest.ChannelID == "" {
+			continue
+		}
+		cron, err := schedule.Parse(g.Digest.Schedule)
+		if err != nil {
+			d.logger.Errorf("invalid digest schedule of guild %s: %v", guildID, err)
+			continue
+		}
+		next := cron.Next(g.Digest.LastRun.In(g.Location()))
+		if next.IsZero() || next.After(now) {
+			continue
+		}
+		if err := d.post(guildID, &g, now); err != nil {
+			d.logger.Errorf("failed to post the digest of guild %s: %v", guildID, err)
+			continue
+		}
+		if _, err := d.settings.UpdateGuild(guildID, func(g *settings.Guild) { g.Digest.LastRun = now }); err != nil {
+			d.logger.Errorf("failed to save the digest of guild %s: %v", guildID, err)
+		}
+	}
+}
+
+// post sends the digest of a guild to its channel
+func (d *Digests) post(guildID string, g *settings.Guild, now time.Time) error {
+	lang := d.discordClient.ChannelLanguage(g.Digest.ChannelID)
+	embed := d.Embed(guildID, lang, g.Digest.Period, now, g.Location())
+	return d.discordClient.SendEmbed(g.Digest.ChannelID, embed)
+}
+
+// guildsOf returns the guilds where a session was announced
+func (d *Digests) guildsOf(sess *Session) []string {
+	var guilds []string
+	for _, msg := range sess.Messages {
+		if guildID := d.discordClient.ChannelGuildID(msg.ChannelID); guildID != "" && !containsString(guilds, guildID) {
+			guilds = append(guilds, guildID)
+		}
+	}
+	return guilds
+}
+
+// sessions returns the sessions announced in a guild overlapping [from, to]: the recorded ones and the live ones
+func (d *Digests) sessions(guildID string, from, to time.Time) []Session {
+	var sessions []Session
+	for _, key := range d.store.Keys(historyBucket) {
+		var sess Session
+		if ok, err := d.store.Get(historyBucket, key, &sess); ok && err == nil && sess.StartedAt.Before(to) && sess.EndedAt.After(from) &&
+			containsString(sess.Guilds, guildID) {
+			sessions = append(sessions, sess)
+		}
+	}
+	for _, sess := range d.announcer.Sessions() {
+		if sess.Announced && sess.StartedAt.Before(to) && containsString(d.guildsOf(&sess), guildID) {
+			sessions = append(sessions, sess)
+		}
+	}
+	return sessions
+}
+
+func containsString(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// overlap returns the part of [start, end] within [from, to]
+func overlap(start, end, from, to time.Time) time.Duration {
+	if start.Before(from) {
+		start = from
+	}
+	if end.After(to) {
+		end = to
+	}
+	if !end.After(start) {
+		return 0
+	}
+	return end.Sub(start)
+}
+
+// streamerStats sums up the sessions of a broadcaster over a digest period
+type streamerStats struct {
+	Name        string
+	Sessions    int
+	Duration    time.Duration
+	PeakViewers int
+}
+
+// ranked is a name with a duration, sorted by decreasing duration
+type ranked struct {
+	Name     string
+	Duration time.Duration
+}
+
+// Embed builds the digest of a guild for the period ending at now
+func (d *Digests) Embed(guildID, lang, period string, now time.Time, loc *time.Location) *discordgo.MessageEmbed {
+	from := now.Add(-digestPeriod(period))
+	stats := map[string]*streamerStats{}
+	games := map[string]time.Duration{}
+	var total time.Duration
+	for _, sess := range d.sessions(guildID, from, now) {
+		end := now
+		if sess.EndedAt != nil && sess.EndedAt.Before(now) {
+			end = *sess.EndedAt
+		}
+		st, ok := stats[sess.BroadcasterID]
+		if !ok {
+			st = &streamerStats{Name: sess.BroadcasterName}
+			stats[sess.BroadcasterID] = st
+		}
+		duration := overlap(sess.StartedAt, end, from, now)
+		st.Sessions++
+		st.Duration += duration
+		total += duration
+		if sess.PeakViewers > st.PeakViewers {
+			st.PeakViewers = sess.PeakViewers
+		}
+		for n, span := range sess.Games {
+			spanEnd := end
+			if n+1 < len(sess.Games) {
+				spanEnd = sess.Games[n+1].Since
+			}
+			if span.Name != "" {
+				games[span.Name] += overlap(span.Since, spanEnd, from, now)
+			}
+		}
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title:       i18n.T(lang, "digest.title."+period),
+		Description: i18n.T(lang, "digest.range", from.In(loc).Format("02/01 15:04"), now.In(loc).Format("02/01 15:04")),
+		Color:       0x9146FF, // Twitch purple
+		Timestamp:   now.Format(time.RFC3339),
+	}
+	if len(stats) == 0 {
+		embed.Description += "\n" + i18n.T(lang, "digest.empty")
+	} else {
+		streamers := make([]*streamerStats, 0, len(stats))
+		for _, st := range stats {
+			streamers = append(streamers, st)
+		}
+		sort.Slice(streamers, func(i, j int) bool {
+			if streamers[i].Duration != streamers[j].Duration {
+				return streamers[i].Duration > streamers[j].Duration
+			}
+			return streamers[i].Name < streamers[j].Name
+		})
+		var lines []string
+		peak := streamers[0]
+		for _, st := range streamers {
+			lines = append(lines, i18n.T(lang, "digest.streamer", st.Name, formatDuration(st.Duration), st.Sessions, st.PeakViewers))
+			if st.PeakViewers > peak.PeakViewers {
+				peak = st
+			}
+		}
+		embed.Fields = append(embed.Fields,
+			&discordgo.MessageEmbedField{Name: i18n.T(lang, "digest.field.streamers", len(streamers), formatDuration(total)), Value: joinLines(lines)},
+		)
+
+		top := make([]ranked, 0, len(games))
+		for name, duration := range games {
+			top = append(top, ranked{Name: name, Duration: duration})
+		}
+		sort.Slice(top, func(i, j int) bool {
+			if top[i].Duration != top[j].Duration {
+				return top[i].Duration > top[j].Duration
+			}
+			return top[i].Name < top[j].Name
+		})
+		lines = nil
+		for n, game := range top {
+			if n == 5 {
+				break
+			}
+			lines = append(lines, fmt.Sprintf("%d. %s — %s", n+1, game.Name, formatDuration(game.Duration)))
+		}
+		if len(lines) > 0 {
+			embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: i18n.T(lang, "digest.field.games"), Value: joinLines(lines), Inline: true})
+		}
+		if peak.PeakViewers > 0 {
+			embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+				Name:   i18n.T(lang, "digest.field.peak"),
+				Value:  i18n.T(lang, "digest.peak", peak.Name, peak.PeakViewers),
+				Inline: true,
+			})
+		}
+	}
+
+	if upcoming := d.upcoming(guildID, now, now.Add(digestPeriod(period))); len(upcoming) > 0 {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{Name: i18n.T(lang, "digest.field.upcoming"), Value: joinLines(upcoming)})
+	}
+	return embed
+}
+
+// upcoming lists the scheduled streams between from and to of the followed broadcasters announced in a guild
+func (d *Digests) upcoming(guildID string, from, to time.Time) []string {
+	type entry struct {
+		start time.Time
+		line  string
+	}
+	var entries []entry
+	for _, broadcasterID := range d.cfg.BroadcasterIDs() {
+		if d.discordClient.ChannelGuildID(d.cfg.NotifierFor(broadcasterID).ChannelID) != guildID {
+			continue
+		}
+		sched, err := d.helix.GetSchedule(broadcasterID, from, to)
+		if err != nil {
+			d.logger.Errorf("failed to fetch the schedule of %s: %v", broadcasterID, err)
+			continue
+		}
+		for _, seg := range sched.Segments {
+			if seg.CanceledUntil != nil {
+				continue
+			}
+			line := fmt.Sprintf("<t:%d:f> **%s** — %s", seg.StartTime.Unix(), sched.BroadcasterName, seg.Title)
+			if seg.Category != nil && seg.Category.Name != "" {
+				line += " (" + seg.Category.Name + ")"
+			}
+			entries = append(entries, entry{start: seg.StartTime, line: line})
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].start.Before(entries[j].start) })
+	lines := make([]string, 0, len(entries))
+	for _, e := range entries {
+		lines = append(lines, e.line)
+	}
+	return lines
+}
+
+// joinLines joins at most maxDigestLines lines, within the 1024 characters of an embed field
+func joinLines(lines []string) string {
+	more := 0
+	if len(lines) > maxDigestLines {
+		more = len(lines) - maxDigestLines
+		lines = lines[:maxDigestLines]
+	}
+	value := strings.Join(lines, "\n")
+	if more > 0 {
+		value += fmt.Sprintf("\n+%d", more)
+	}
+	if runes := []rune(value); len(runes) > 1024 {
+		value = string(runes[:1021]) + "..."
+	}
+	return value
+}
+
+// formatDuration formats a duration in hours and minutes, e.g. 3h05
+func formatDuration(d time.Duration) string {
+	minutes := int(d.Round(time.Minute) / time.Minute)
+	return fmt.Sprintf("%dh%02d", minutes/60, minutes%60)
+}
+
+// DigestCommand defines the /digest command
+var DigestCommand = i18n.Localize(&discordgo.ApplicationCommand{
+	Name:                     "digest",
+	DefaultMemberPermissions: &manageGuild,
+	DMPermission:             &guildOnly,
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type: discordgo.ApplicationCommandOptionSubCommand,
+			Name: "set",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:         discordgo.ApplicationCommandOptionChannel,
+					Name:         "channel",
+					Required:     true,
+					ChannelTypes: []discordgo.ChannelType{discordgo.ChannelTypeGuildText, discordgo.ChannelTypeGuildNews},
+				},
+				{
+					Type:     discordgo.ApplicationCommandOptionString,
+					Name:     "period",
+					Required: true,
+					Choices: []*discordgo.ApplicationCommandOptionChoice{
+						{Name: i18n.T(i18n.Default, "digest.period."+DigestDay), Value: DigestDay},
+						{Name: i18n.T(i18n.Default, "digest.period."+DigestWeek), Value: DigestWeek},
+					},
+				},
+				{Type: discordgo.ApplicationCommandOptionString, Name: "schedule"},
+			},
+		},
+		{Type: discordgo.ApplicationCommandOptionSubCommand, Name: "show"},
+		{Type: discordgo.ApplicationCommandOptionSubCommand, Name: "now"},
+		{Type: discordgo.ApplicationCommandOptionSubCommand, Name: "disable"},
+	},
+})
+
+// Command builds the /digest command
+func (d *Digests) Command() *commands.Command {
+	return &commands.Command{
+		Definition: DigestCommand,
+		Handler: func(s discordapi.Sender, i *discordgo.InteractionCreate) {
+			lang := commands.Lang(i)
+			path, opts := commands.SubCommand(i.ApplicationCommandData().Options)
+			reply, err := d.handleCommand(lang, i.GuildID, path, opts)
+			if err != nil {
+				d.logger.Errorf("Cannot handle /digest %s: %v", path, err)
+				reply = i18n.T(lang, "digest.error", err)
+			}
+			if err := commands.RespondEphemeral(s, i, reply); err != nil {
+				d.logger.Errorf("Cannot respond to /digest: %v", err)
+			}
+		},
+	}
+}
+
+func (d *Digests) handleCommand(lang, guildID, path string, opts map[string]*discordgo.ApplicationCommandInteractionDataOption) (string, error) {
+	switch path {
+	case "set":
+		digest := settings.Digest{
+			ChannelID: opts["channel"].ChannelValue(nil).ID,
+			Period:    opts["period"].StringValue(),
+			Schedule:  defaultDigestSchedules[opts["period"].StringValue()],
+			LastRun:   time.Now(),
+		}
+		if opt, ok := opts["schedule"]; ok {
+			digest.Schedule = strings.TrimSpace(opt.StringValue())
+		}
+		if _, err := schedule.Parse(digest.Schedule); err != nil {
+			return i18n.T(lang, "digest.invalid", err), nil
+		}
+		g, err := d.settings.UpdateGuild(guildID, func(g *settings.Guild) { g.Digest = digest })
+		if err != nil {
+			return "", err
+		}
+		return i18n.T(lang, "digest.set") + "\n" + describeDigest(lang, &g), nil
+
+	case "disable":
+		if _, err := d.settings.UpdateGuild(guildID, func(g *settings.Guild) { g.Digest = settings.Digest{} }); err != nil {
+			return "", err
+		}
+		return i18n.T(lang, "digest.disabled"), nil
+
+	case "show":
+		g, err := d.settings.Guild(guildID)
+		if err != nil {
+			return "", err
+		}
+		return describeDigest(lang, &g), nil
+
+	case "now":
+		g, err := d.settings.Guild(guildID)
+		if err != nil {
+			return "", err
+		}
+		if g.Digest.ChannelID == "" {
+			return i18n.T(lang, "digest.none"), nil
+		}
+		// Fetching the schedules can take longer than the interaction deadline
+		go func() {
+			if err := d.post(guildID, &g, time.Now()); err != nil {
+				d.logger.Errorf("failed to post the digest of guild %s: %v", guildID, err)
+			}
+		}()
+		return i18n.T(lang, "digest.posting", g.Digest.ChannelID), nil
+	}
+	return "", fmt.Errorf("unknown subcommand %q", path)
+}
+
+// describeDigest formats the digest settings of a guild, with the time of the next digest
+func describeDigest(lang string, g *settings.Guild) string {
+	if g.Digest.ChannelID == "" {
+		return i18n.T(lang, "digest.none")
+	}
+	loc := g.Location()
+	line := i18n.T(lang, "digest.show", i18n.T(lang, "digest.period."+g.Digest.Period), g.Digest.ChannelID, g.Digest.Schedule, loc.String())
+	if cron, err := schedule.Parse(g.Digest.Schedule); err == nil {
+		if next := cron.Next(time.Now().In(loc)); !next.IsZero() {
+			line += "\n" + i18n.T(lang, "digest.next", next.Unix())
+		}
+	}
+	return line
+}
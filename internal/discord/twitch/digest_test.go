@@ -0,0 +1,182 @@
+package twitch
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/i18n"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/twitchtest"
+)
+
+// Another guild, with its own announcement channel
+const (
+	otherGuild   = "900000000000000011"
+	otherChannel = "900000000000000012"
+)
+
+// digestFields formats the fields of a digest as "name: value"
+func digestFields(embed *discordgo.MessageEmbed) []string {
+	var fields []string
+	for _, f := range embed.Fields {
+		fields = append(fields, f.Name+": "+f.Value)
+	}
+	return fields
+}
+
+// withPeak returns a session with its peak viewer count set
+func withPeak(sess Session, viewers int) Session {
+	sess.PeakViewers = viewers
+	return sess
+}
+
+func TestDigestEmbed(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	at := func(hours float64) time.Time { return now.Add(time.Duration(hours * float64(time.Hour))) }
+	ended := func(hours float64) *time.Time { end := at(hours); return &end }
+	announced := func(b string, name string, start float64, end *time.Time, guilds ...string) Session {
+		return Session{BroadcasterID: b, BroadcasterName: name, Announced: true, StartedAt: at(start), EndedAt: end, Guilds: guilds}
+	}
+
+	tests := []struct {
+		name    string
+		history []Session
+		live    []Session
+		want    []string // fields, none when the digest is empty
+	}{
+		{"empty", nil, nil, nil},
+		{"clipped to the period", []Session{
+			announced(alice.ID, "Alice", -30, ended(-22), e2eGuild),
+			announced(bob.ID, "Bob", -50, ended(-25), e2eGuild), // before the period
+		}, nil, []string{
+			"1 streamer(s) · 2h00 live: **Alice** — 2h00 (1 stream(s), peak 0 viewers)",
+		}},
+		{"time per category", []Session{func() Session {
+			sess := announced(alice.ID, "Alice", -5, ended(-1), e2eGuild)
+			sess.Games = []GameSpan{{Name: "Celeste", Since: at(-5)}, {Name: "Just Chatting", Since: at(-2)}, {Name: "Celeste", Since: at(-1.5)}}
+			return sess
+		}()}, nil, []string{
+			"1 streamer(s) · 4h00 live: **Alice** — 4h00 (1 stream(s), peak 0 viewers)",
+			"Top categories: 1. Celeste — 3h30\n2. Just Chatting — 0h30",
+		}},
+		{"peak viewers", []Session{
+			withPeak(announced(alice.ID, "Alice", -3, ended(-2), e2eGuild), 50),
+			withPeak(announced(bob.ID, "Bob", -6, ended(-3), e2eGuild), 20),
+			withPeak(announced(alice.ID, "Alice", -10, ended(-9), e2eGuild), 30),
+		}, nil, []string{
+			"2 streamer(s) · 5h00 live: **Bob** — 3h00 (1 stream(s), peak 20 viewers)\n**Alice** — 2h00 (2 stream(s), peak 50 viewers)",
+			"Peak viewers: **Alice** with 50 viewers",
+		}},
+		{"live session", nil, []Session{func() Session {
+			sess := announced(carol.ID, "Carol", -1.5, nil)
+			sess.Messages = []MessageRef{{ChannelID: e2eChannel, MessageID: "1"}}
+			return sess
+		}()}, []string{
+			"1 streamer(s) · 1h30 live: **Carol** — 1h30 (1 stream(s), peak 0 viewers)",
+		}},
+		{"other guild", []Session{
+			announced(alice.ID, "Alice", -3, ended(-2), otherGuild),
+		}, []Session{func() Session {
+			sess := announced(carol.ID, "Carol", -1.5, nil)
+			sess.Messages = []MessageRef{{ChannelID: otherChannel, MessageID: "1"}}
+			return sess
+		}()}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newE2E(t)
+			e.discord.AddChannel(&discordgo.Channel{ID: otherChannel, GuildID: otherGuild, Type: discordgo.ChannelTypeGuildText})
+			for n, sess := range tt.history {
+				if err := e.store.Put(historyBucket, fmt.Sprint(n), sess); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, sess := range tt.live {
+				if err := e.store.Put(sessionBucket, sess.BroadcasterID, sess); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			embed := e.server.digests.Embed(e2eGuild, i18n.English, DigestDay, now, time.UTC)
+			if embed.Title != "📊 Daily recap" || !strings.HasPrefix(embed.Description, "From 09/03 12:00 to 10/03 12:00") {
+				t.Errorf("title %q, description %q", embed.Title, embed.Description)
+			}
+			if empty := strings.Contains(embed.Description, "Nobody streamed"); empty != (tt.want == nil) {
+				t.Errorf("description %q, want the empty digest: %v", embed.Description, tt.want == nil)
+			}
+			if got, want := strings.Join(digestFields(embed), "\n--\n"), strings.Join(tt.want, "\n--\n"); got != want {
+				t.Errorf("fields\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestDigestRecordsGuilds(t *testing.T) {
+	e := newE2E(t)
+	e.discord.AddChannel(&discordgo.Channel{ID: otherChannel, GuildID: otherGuild, Type: discordgo.ChannelTypeGuildText})
+	sess := &Session{BroadcasterID: alice.ID, BroadcasterName: "Alice", Announced: true, StartedAt: time.Now().Add(-time.Hour),
+		Messages: []MessageRef{{ChannelID: e2eChannel, MessageID: "1"}, {ChannelID: otherChannel, MessageID: "2"}, {ChannelID: e2eChannel, MessageID: "3"}}}
+	e.server.digests.StreamOffline(context.Background(), &StreamEvent{BroadcasterID: alice.ID, Session: sess})
+
+	keys := e.store.Keys(historyBucket)
+	if len(keys) != 1 {
+		t.Fatalf("%d recorded sessions, want 1", len(keys))
+	}
+	var recorded Session
+	if _, err := e.store.Get(historyBucket, keys[0], &recorded); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(recorded.Guilds, ",") != e2eGuild+","+otherGuild || recorded.Messages != nil || recorded.EndedAt == nil {
+		t.Errorf("recorded %+v, want the guilds of the announcements without the messages", recorded)
+	}
+}
+
+func TestDigestUpcoming(t *testing.T) {
+	e := newE2E(t, alice)
+	e.discord.AddChannel(&discordgo.Channel{ID: otherChannel, GuildID: otherGuild, Type: discordgo.ChannelTypeGuildText})
+	now := time.Now().UTC().Truncate(time.Second)
+	e.twitch.SetSchedule(alice.ID, []twitchtest.Segment{{ID: "1", StartTime: now.Add(3 * time.Hour), Title: "Speedrun", Category: &twitchtest.Category{Name: "Celeste"}}})
+
+	// Only listed in the digest of the guild alice is announced in
+	embed := e.server.digests.Embed(e2eGuild, i18n.English, DigestDay, now, time.UTC)
+	want := fmt.Sprintf("Upcoming streams: <t:%d:f> **Alice** — Speedrun (Celeste)", now.Add(3*time.Hour).Unix())
+	if fields := digestFields(embed); len(fields) != 1 || fields[0] != want {
+		t.Errorf("fields %q, want %q", fields, want)
+	}
+	if embed := e.server.digests.Embed(otherGuild, i18n.English, DigestDay, now, time.UTC); len(embed.Fields) != 0 {
+		t.Errorf("digest of another guild lists %q", digestFields(embed))
+	}
+}
+
+func TestJoinLines(t *testing.T) {
+	lines := func(n, length int) []string {
+		var lines []string
+		for i := 0; i < n; i++ {
+			lines = append(lines, strings.Repeat(fmt.Sprint(i%10), length))
+		}
+		return lines
+	}
+	tests := []struct {
+		name  string
+		lines []string
+		want  string
+	}{
+		{"none", nil, ""},
+		{"few", []string{"a", "b", "c"}, "a\nb\nc"},
+		{"at the line limit", lines(10, 1), "0\n1\n2\n3\n4\n5\n6\n7\n8\n9"},
+		{"over the line limit", lines(12, 1), "0\n1\n2\n3\n4\n5\n6\n7\n8\n9\n+2"},
+		{"over the length limit", lines(10, 200), strings.Join(lines(10, 200), "\n")[:1021] + "..."},
+	}
+	for _, tt := range tests {
+		got := joinLines(tt.lines)
+		if got != tt.want {
+			t.Errorf("%s: joinLines() = %q, want %q", tt.name, got, tt.want)
+		}
+		if n := len([]rune(got)); n > 1024 {
+			t.Errorf("%s: %d characters, over the limit of a field", tt.name, n)
+		}
+	}
+}
@@ -0,0 +1,554 @@
+package twitch
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/config"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/discord"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/discordtest"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/i18n"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/metrics"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/storage"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/twitchtest"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	e2eWebhookSecret = "e2e-webhook-secret"
+	e2eGuild         = "900000000000000001"
+	e2eChannel       = "900000000000000002"
+	e2eRole          = "900000000000000003"
+	e2eAPIToken      = "e2e-api-token"
+	e2eDashboard     = "e2e-dashboard-token"
+)
+
+// e2e runs a WebhookServer against the fake Twitch and Discord APIs
+type e2e struct {
+	twitch  *twitchtest.Server
+	discord *discordtest.Recorder
+	server  *WebhookServer
+	client  *discord.Client
+	cfg     *config.Config
+	store   *storage.Store
+	webhook string // URL Twitch posts to
+	cancel  context.CancelFunc
+	done    chan error
+}
+
+// newE2E builds a WebhookServer announcing the given broadcasters in e2eChannel, pinging e2eRole.
+// Discord calls are recorded.
+func newE2E(t *testing.T, broadcasters ...twitchtest.User) *e2e {
+	t.Helper()
+	e := &e2e{twitch: twitchtest.NewServer(t), discord: discordtest.NewRecorder()}
+	e.discord.AddChannel(&discordgo.Channel{ID: e2eChannel, GuildID: e2eGuild, Type: discordgo.ChannelTypeGuildText})
+
+	// The handler is only known once the server is built, which needs the callback URL
+	var handler http.Handler
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(hook.Close)
+	e.webhook = hook.URL + "/webhook"
+
+	ids := []string{}
+	for _, b := range broadcasters {
+		e.twitch.AddUser(b)
+		ids = append(ids, b.ID)
+	}
+	e.cfg = &config.Config{
+		Port:                "0",
+		BotToken:            "e2e-bot-token",
+		TwitchClientID:      twitchtest.ClientID,
+		TwitchClientSecret:  twitchtest.ClientSecret,
+		TwitchWebhookSecret: e2eWebhookSecret,
+		CallbackURL:         hook.URL,
+		APIToken:            e2eAPIToken,
+		DashboardToken:      e2eDashboard,
+		DefaultLanguage:     i18n.English,
+		WatchPollInterval:   time.Hour,
+		DeliveryWorkers:     1,
+		LiveRoles:           true,
+		Reloadable: config.Reloadable{
+			TwitchBroadcasterIDs: ids,
+			Notifiers:            []config.Notifier{{Name: config.DefaultNotifier, ChannelID: e2eChannel, RoleID: e2eRole}},
+			NotifyChannelID:      e2eChannel,
+			NotifyRoleID:         e2eRole,
+			LogLevel:             "info",
+		},
+	}
+
+	var err error
+	if e.store, err = storage.Open(""); err != nil {
+		t.Fatal(err)
+	}
+	logger := logrus.New()
+	logger.Out = io.Discard
+	e.client, err = discord.NewClientWithSender(e.cfg, logger, e.store, e.discord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.server = NewServer(e.cfg, logger, e.client, e.store)
+	e.server.helix.AuthURL = e.twitch.AuthURL()
+	e.server.helix.HelixURL = e.twitch.HelixURL()
+	handler = e.server.httpServer.Handler
+	return e
+}
+
+// start starts the server and the delivery queue, and waits for the subscriptions to be created
+func (e *e2e) start(t *testing.T) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	e.cancel = cancel
+	e.done = make(chan error, 1)
+	go e.client.Queue().Run(ctx, 1)
+	go func() { e.done <- e.server.Start(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		<-e.done
+	})
+
+	eventually(t, "subscriptions created", func() bool {
+		select {
+		case err := <-e.done:
+			t.Fatalf("server stopped: %v", err)
+		default:
+		}
+		e.server.mu.Lock()
+		defer e.server.mu.Unlock()
+		return e.server.subscribed
+	})
+	e.twitch.WaitVerified()
+}
+
+// post sends a simulated EventSub message to the webhook, signed with secret
+func (e *e2e) post(t *testing.T, msg *SimulatedMessage, secret string) (int, string) {
+	t.Helper()
+	msg.Callback = e.webhook
+	req, err := msg.Request(e.webhook, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+// readiness returns the checks of /readyz
+func (e *e2e) readiness(t *testing.T) map[string]string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	e.server.handleReadyz(rec, httptest.NewRequest("GET", "/readyz", nil))
+	var body struct {
+		Checks map[string]string `json:"checks"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("readyz: %v: %s", err, rec.Body)
+	}
+	return body.Checks
+}
+
+// eventually fails the test when cond isn't true within a few seconds
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+var (
+	alice = twitchtest.User{ID: "1001", Login: "alice", DisplayName: "Alice"}
+	bob   = twitchtest.User{ID: "1002", Login: "bob", DisplayName: "Bob"}
+	carol = twitchtest.User{ID: "1003", Login: "carol", DisplayName: "Carol"}
+)
+
+func TestE2ESubscriptionReconciliation(t *testing.T) {
+	e := newE2E(t, alice, bob, carol)
+	e.twitch.PageSize = 1 // every listing follows the pages
+
+	// Left by previous runs: alice with an outdated callback, bob up to date, another
+	// application callback for a broadcaster the bot doesn't track
+	outdated := e.twitch.AddSubscription(twitchtest.Subscription{
+		Type:      "stream.online",
+		Condition: map[string]string{"broadcaster_user_id": alice.ID},
+		Transport: twitchtest.Transport{Callback: "https://old.example/webhook"},
+	})
+	kept := e.twitch.AddSubscription(twitchtest.Subscription{
+		Type:      "stream.online",
+		Condition: map[string]string{"broadcaster_user_id": bob.ID},
+		Transport: twitchtest.Transport{Callback: e.webhook, Secret: e2eWebhookSecret},
+	})
+	other := e.twitch.AddSubscription(twitchtest.Subscription{
+		Type:      "stream.online",
+		Condition: map[string]string{"broadcaster_user_id": "9999"},
+		Transport: twitchtest.Transport{Callback: "https://other.example/webhook"},
+	})
+
+	e.start(t)
+
+	byKey := map[string][]twitchtest.Subscription{}
+	ids := map[string]bool{}
+	for _, sub := range e.twitch.Subscriptions() {
+		key := subscriptionKey(sub.Type, sub.Condition["broadcaster_user_id"])
+		byKey[key] = append(byKey[key], sub)
+		ids[sub.ID] = true
+	}
+	for _, b := range []twitchtest.User{alice, bob, carol} {
+		for _, subType := range subscriptionTypes {
+			subs := byKey[subscriptionKey(subType, b.ID)]
+			if len(subs) != 1 {
+				t.Errorf("%s of %s: %d subscriptions, want 1", subType, b.Login, len(subs))
+				continue
+			}
+			if subs[0].Transport.Callback != e.webhook || subs[0].Status != twitchtest.StatusEnabled {
+				t.Errorf("%s of %s: callback %s, status %s", subType, b.Login, subs[0].Transport.Callback, subs[0].Status)
+			}
+		}
+	}
+	if ids[outdated.ID] {
+		t.Error("subscription with an outdated callback not deleted")
+	}
+	if !ids[kept.ID] {
+		t.Error("up to date subscription recreated")
+	}
+	if !ids[other.ID] {
+		t.Error("subscription of an untracked broadcaster deleted")
+	}
+
+	if checks := e.readiness(t); checks["subscriptions"] != "ok" || checks["twitch_token"] != "ok" {
+		t.Errorf("readyz checks = %v", checks)
+	}
+}
+
+func TestE2EChallenge(t *testing.T) {
+	e := newE2E(t, alice)
+	e.start(t)
+
+	msg := SimulateVerification("stream.online", SimulatedUser{ID: alice.ID, Login: alice.Login, Name: alice.DisplayName})
+	if code, body := e.post(t, msg, e2eWebhookSecret); code != http.StatusOK || body != msg.Challenge {
+		t.Errorf("challenge answered %d %q, want 200 %q", code, body, msg.Challenge)
+	}
+	if code, _ := e.post(t, msg, "not-the-secret"); code != http.StatusUnauthorized {
+		t.Errorf("challenge with a bad signature answered %d, want 401", code)
+	}
+
+	// Revoked subscriptions make the bot unready
+	revocation := SimulateRevocation("stream.online", SimulatedUser{ID: alice.ID}, "authorization_revoked")
+	if code, _ := e.post(t, revocation, e2eWebhookSecret); code != http.StatusNoContent {
+		t.Errorf("revocation answered %d, want 204", code)
+	}
+	if checks := e.readiness(t); !strings.Contains(checks["subscriptions"], "stream.online/"+alice.ID) {
+		t.Errorf("revoked subscription not reported: %v", checks)
+	}
+}
+
+// metricValue returns the value of a metric series, e.g. twitchnotifier_webhooks_received_total{message_type="notification",subscription_type="other"}
+func metricValue(t *testing.T, series string) float64 {
+	t.Helper()
+	var out strings.Builder
+	metrics.Default.Write(&out)
+	for _, line := range strings.Split(out.String(), "\n") {
+		if value := strings.TrimPrefix(line, series+" "); value != line {
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return v
+		}
+	}
+	return 0
+}
+
+func TestE2EWebhookMetrics(t *testing.T) {
+	e := newE2E(t, alice)
+	e.start(t)
+	received := func(msgType, subType string) float64 {
+		return metricValue(t, `twitchnotifier_webhooks_received_total{message_type="`+msgType+`",subscription_type="`+subType+`"}`)
+	}
+	otherBefore := received("other", "other")
+	unknownBefore := received(MessageNotification, "other")
+	failuresBefore := metricValue(t, "twitchnotifier_webhook_signature_failures_total")
+
+	// Unsigned messages only count as signature failures, whatever their headers
+	forged := &SimulatedMessage{Type: "forged-type", SubscriptionType: "forged.subscription"}
+	if code, _ := e.post(t, forged, "not-the-secret"); code != http.StatusUnauthorized {
+		t.Errorf("forged message answered %d, want 401", code)
+	}
+	if n := metricValue(t, "twitchnotifier_webhook_signature_failures_total"); n != failuresBefore+1 {
+		t.Errorf("%v signature failures, want %v", n, failuresBefore+1)
+	}
+
+	// Signed messages of unknown types share the "other" series
+	if code, _ := e.post(t, forged, e2eWebhookSecret); code != http.StatusNoContent {
+		t.Errorf("unknown message type answered %d, want 204", code)
+	}
+	e.postEvent(t, &SimulatedMessage{Type: MessageNotification, SubscriptionType: "channel.follow", Condition: SubscriptionCondition("channel.follow", alice.ID)})
+	if n := received("other", "other"); n != otherBefore+1 {
+		t.Errorf("%v webhooks of unknown type, want %v", n, otherBefore+1)
+	}
+	if n := received(MessageNotification, "other"); n != unknownBefore+1 {
+		t.Errorf("%v notifications of unknown subscription type, want %v", n, unknownBefore+1)
+	}
+	var out strings.Builder
+	metrics.Default.Write(&out)
+	if strings.Contains(out.String(), "forged") || strings.Contains(out.String(), "channel.follow") {
+		t.Errorf("header values exposed as labels:\n%s", out.String())
+	}
+}
+
+func TestE2EAnnouncement(t *testing.T) {
+	e := newE2E(t, alice, bob)
+	e.start(t)
+	e.twitch.SetLive(twitchtest.Stream{
+		UserID:    alice.ID,
+		UserLogin: alice.Login,
+		UserName:  alice.DisplayName,
+		GameName:  "Celeste",
+		Title:     "Any% practice",
+		Language:  "en",
+	})
+
+	online := SimulateStreamOnline(SimulatedUser{ID: alice.ID, Login: alice.Login, Name: alice.DisplayName})
+	if code, _ := e.post(t, online, e2eWebhookSecret); code != http.StatusNoContent {
+		t.Fatalf("stream.online answered %d, want 204", code)
+	}
+	msg := e.discord.Wait(t, 1)[0]
+	if msg.Op != discordtest.OpSend || msg.ChannelID != e2eChannel {
+		t.Fatalf("%s in %s, want an announcement in %s", msg.Op, msg.ChannelID, e2eChannel)
+	}
+	if msg.Message.Content != "<@&"+e2eRole+">" {
+		t.Errorf("content = %q, want the role ping", msg.Message.Content)
+	}
+	if len(msg.Message.Embeds) != 1 {
+		t.Fatalf("%d embeds, want 1", len(msg.Message.Embeds))
+	}
+	embed := msg.Message.Embeds[0]
+	if embed.Title != "🔴 Alice is live!" || embed.URL != "https://twitch.tv/alice" {
+		t.Errorf("embed title %q, URL %q", embed.Title, embed.URL)
+	}
+	if len(embed.Fields) == 0 || embed.Fields[0].Value != "Any% practice" {
+		t.Errorf("embed fields = %+v", embed.Fields)
+	}
+	eventually(t, "announcement recorded in the session", func() bool {
+		sess, ok := e.server.announcer.Session(alice.ID)
+		return ok && !sess.Pending && len(sess.Messages) == 1
+	})
+
+	// Twitch retries a notification it doesn't consider delivered: no second announcement
+	if code, _ := e.post(t, online, e2eWebhookSecret); code != http.StatusNoContent {
+		t.Errorf("repeated stream.online answered %d, want 204", code)
+	}
+	// Not announced: bob isn't live for Helix yet
+	if code, _ := e.post(t, SimulateStreamOnline(SimulatedUser{ID: bob.ID, Login: bob.Login, Name: bob.DisplayName}), e2eWebhookSecret); code != http.StatusNoContent {
+		t.Errorf("stream.online of bob answered %d, want 204", code)
+	}
+	eventually(t, "pending announcement of bob", func() bool {
+		sess, ok := e.server.announcer.Session(bob.ID)
+		return ok && sess.Pending
+	})
+	if n := len(e.discord.Calls()); n != 1 {
+		t.Errorf("%d Discord calls, want 1", n)
+	}
+}
+
+func TestE2EMissedOffline(t *testing.T) {
+	e := newE2E(t, alice)
+	e.start(t)
+	e.twitch.SetLive(twitchtest.Stream{UserID: alice.ID, UserLogin: alice.Login, UserName: alice.DisplayName, GameName: "Celeste"})
+
+	first := SimulateStreamOnline(simulatedUser(alice))
+	e.postEvent(t, first)
+	e.discord.Wait(t, 1)
+	eventually(t, "announcement recorded in the session", func() bool {
+		sess, ok := e.server.announcer.Session(alice.ID)
+		return ok && len(sess.Messages) == 1
+	})
+
+	// The stream.offline of the first stream never came: the next stream is announced anyway
+	second := SimulateStreamOnline(simulatedUser(alice))
+	second.Event["started_at"] = time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	e.postEvent(t, second)
+	calls := e.discord.Wait(t, 2)
+	if calls[1].Op != discordtest.OpSend || calls[1].ChannelID != e2eChannel {
+		t.Fatalf("%s in %s, want a second announcement in %s", calls[1].Op, calls[1].ChannelID, e2eChannel)
+	}
+	eventually(t, "session of the second stream", func() bool {
+		sess, ok := e.server.announcer.Session(alice.ID)
+		return ok && sess.StreamID == second.Event["id"] && len(sess.Messages) == 1
+	})
+
+	// Events of the current stream are still ignored
+	e.postEvent(t, second)
+	e.postEvent(t, SimulateStreamOffline(simulatedUser(alice)))
+	eventually(t, "session closed", func() bool {
+		_, ok := e.server.announcer.Session(alice.ID)
+		return !ok
+	})
+	sent := 0
+	for _, c := range e.discord.Calls() {
+		if c.Op == discordtest.OpSend {
+			sent++
+		}
+	}
+	if sent != 2 {
+		t.Errorf("%d announcements, want 2", sent)
+	}
+}
+
+func TestE2EReconnectInGracePeriod(t *testing.T) {
+	e := newE2E(t, alice)
+	e.cfg.FlapGracePeriod = time.Hour
+	e.start(t)
+	e.twitch.SetLive(twitchtest.Stream{UserID: alice.ID, UserLogin: alice.Login, UserName: alice.DisplayName, GameName: "Celeste"})
+
+	first := SimulateStreamOnline(simulatedUser(alice))
+	e.postEvent(t, first)
+	e.discord.Wait(t, 1)
+	eventually(t, "announcement recorded in the session", func() bool {
+		sess, ok := e.server.announcer.Session(alice.ID)
+		return ok && len(sess.Messages) == 1
+	})
+	e.postEvent(t, SimulateStreamOffline(simulatedUser(alice)))
+	eventually(t, "session suspended", func() bool {
+		sess, ok := e.server.announcer.Session(alice.ID)
+		return ok && sess.EndedAt != nil
+	})
+
+	// Twitch issues a new stream ID when the streamer reconnects: the session is resumed anyway
+	second := SimulateStreamOnline(simulatedUser(alice))
+	if second.Event["id"] == first.Event["id"] {
+		t.Fatal("simulated streams share their ID")
+	}
+	e.postEvent(t, second)
+	eventually(t, "session resumed with the new stream", func() bool {
+		sess, ok := e.server.announcer.Session(alice.ID)
+		return ok && sess.EndedAt == nil && sess.StreamID == second.Event["id"] && len(sess.Messages) == 1
+	})
+	sent := 0
+	for _, c := range e.discord.Calls() {
+		if c.Op == discordtest.OpSend {
+			sent++
+		}
+	}
+	if sent != 1 {
+		t.Errorf("%d announcements, want 1", sent)
+	}
+}
+
+// offlineListener records the offline events dispatched to listeners
+type offlineListener struct {
+	mu     sync.Mutex
+	events []*StreamEvent
+}
+
+func (l *offlineListener) StreamOnline(ctx context.Context, ev *StreamEvent) {}
+
+func (l *offlineListener) StreamOffline(ctx context.Context, ev *StreamEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, ev)
+}
+
+// closed returns the broadcasters of the sessions closed so far
+func (l *offlineListener) closed() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var ids []string
+	for _, ev := range l.events {
+		if ev.Session != nil {
+			ids = append(ids, ev.Session.BroadcasterID)
+		}
+	}
+	return ids
+}
+
+func TestE2EReconcileClosesSessions(t *testing.T) {
+	e := newE2E(t, alice, bob, carol)
+	e.cfg.FlapGracePeriod = 300 * time.Millisecond
+	listener := &offlineListener{}
+	e.server.AddListener(listener)
+
+	// Sessions left by the previous run, none of the broadcasters is live anymore
+	now := time.Now()
+	expired, suspended := now.Add(-time.Hour), now
+	for _, sess := range []Session{
+		{BroadcasterID: alice.ID, BroadcasterName: alice.DisplayName, StreamID: "1", StartedAt: now.Add(-2 * time.Hour)},
+		{BroadcasterID: bob.ID, BroadcasterName: bob.DisplayName, StreamID: "2", StartedAt: now.Add(-2 * time.Hour), EndedAt: &expired},
+		{BroadcasterID: carol.ID, BroadcasterName: carol.DisplayName, StreamID: "3", StartedAt: now.Add(-time.Hour), EndedAt: &suspended},
+	} {
+		if err := e.store.Put(sessionBucket, sess.BroadcasterID, sess); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e.start(t)
+
+	// Listeners are notified as for an offline event, the grace period of carol goes on
+	eventually(t, "stale sessions closed", func() bool { return len(listener.closed()) >= 2 })
+	if _, ok := e.server.announcer.Session(carol.ID); !ok && time.Since(now) < e.cfg.FlapGracePeriod {
+		t.Error("suspended session closed before the end of its grace period")
+	}
+	eventually(t, "suspended session closed", func() bool { return len(listener.closed()) == 3 })
+	for _, id := range []string{alice.ID, bob.ID, carol.ID} {
+		if _, ok := e.server.announcer.Session(id); ok {
+			t.Errorf("session of %s still open", id)
+		}
+	}
+}
+
+func TestE2EHelixFailures(t *testing.T) {
+	t.Run("token", func(t *testing.T) {
+		e := newE2E(t, alice)
+		e.twitch.Fail("POST", "/oauth2/token", http.StatusInternalServerError, 1)
+		if err := e.server.Start(context.Background()); err == nil || !strings.Contains(err.Error(), "OAuth token") {
+			t.Errorf("Start error = %v, want an OAuth token error", err)
+		}
+	})
+
+	t.Run("subscriptions", func(t *testing.T) {
+		e := newE2E(t, alice)
+		e.twitch.Fail("POST", "/helix/eventsub/subscriptions", http.StatusInternalServerError, 0)
+		e.start(t)
+		if checks := e.readiness(t); !strings.Contains(checks["subscriptions"], "2 failing") {
+			t.Errorf("failed subscriptions not reported: %v", checks)
+		}
+	})
+
+	t.Run("rate limit", func(t *testing.T) {
+		// Subscriptions that couldn't be checked once the limit is reached fail until a retry creates them
+		e := newE2E(t, alice)
+		e.twitch.SetRateLimit(2)
+		e.start(t)
+		if checks := e.readiness(t); !strings.Contains(checks["subscriptions"], "1 failing") || !strings.Contains(checks["subscriptions"], "rate limited") {
+			t.Errorf("rate limited subscription not reported: %v", checks)
+		}
+		if subs := e.twitch.Subscriptions(); len(subs) != 1 {
+			t.Errorf("%d subscriptions created within the rate limit, want 1", len(subs))
+		}
+
+		e.twitch.SetRateLimit(0)
+		e.server.retryFailingSubscriptions()
+		if checks := e.readiness(t); checks["subscriptions"] != "ok" {
+			t.Errorf("readyz checks after the retry = %v", checks)
+		}
+		if subs := e.twitch.Subscriptions(); len(subs) != 2 {
+			t.Errorf("%d subscriptions after the retry, want 2", len(subs))
+		}
+	})
+}
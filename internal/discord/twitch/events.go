@@ -0,0 +1,190 @@
+package twitch
+
+import (
+	"context"
+	"time"
+
+	"github.com/flthibaud/TwitchLiveNotifier/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// Sources of stream events
+const (
+	SourceEventSub = "eventsub"
+	SourcePresence = "presence"
+)
+
+// Types of the recent events
+const (
+	EventOnline     = "online"
+	EventOffline    = "offline"
+	EventRevocation = "revocation"
+)
+
+// recentEventCount is the number of recent events kept in memory
+const recentEventCount = 50
+
+// RecentEvent is a stream event or a subscription revocation received by the server
+type RecentEvent struct {
+	At              time.Time `json:"at"`
+	Type            string    `json:"type"`
+	Source          string    `json:"source"`
+	BroadcasterID   string    `json:"broadcaster_id"`
+	BroadcasterName string    `json:"broadcaster_name,omitempty"`
+	Detail          string    `json:"detail,omitempty"`
+}
+
+// StreamEvent describes a broadcaster going live or offline
+type StreamEvent struct {
+	BroadcasterID    string
+	BroadcasterLogin string
+	BroadcasterName  string
+	StreamID         string    // only set when going live
+	StartedAt        time.Time // only set when going live
+	Session          *Session  // closed session, only set when going offline after a known session
+}
+
+// withBroadcaster returns a copy of ctx logging with the ID of a broadcaster
+func withBroadcaster(ctx context.Context, logger *logrus.Logger, broadcasterID string) context.Context {
+	return utils.WithLogger(ctx, utils.Log(ctx, logger).WithField("broadcaster_id", broadcasterID))
+}
+
+// StreamListener is notified of stream events of every tracked broadcaster.
+// ctx carries the logger of the event (see utils.Log).
+type StreamListener interface {
+	StreamOnline(ctx context.Context, ev *StreamEvent)
+	StreamOffline(ctx context.Context, ev *StreamEvent)
+}
+
+// streamOnline handles a broadcaster going live: a session is opened, followed broadcasters
+// are announced, and listeners (live roles, ...) are notified. Events for a broadcaster
+// already live (e.g. detected by both EventSub and presence) are ignored, unless they are
+// for a new stream: the previous session is then closed as if its offline event was received.
+// Events from presence are announced even if the broadcaster isn't followed, fallback
+// is used when Helix doesn't know the stream yet.
+func (s *WebhookServer) streamOnline(ctx context.Context, ev *StreamEvent, source string, fallback *Stream) {
+	s.recordEvent(RecentEvent{Type: EventOnline, Source: source, BroadcasterID: ev.BroadcasterID, BroadcasterName: ev.BroadcasterName})
+	announce := source != SourceEventSub || s.isFollowed(ev.BroadcasterID)
+	opened, stale := s.announcer.Online(ctx, ev, source, fallback, announce)
+	switch {
+	case stale != nil:
+		s.dispatchReplaced(ctx, &StreamEvent{
+			BroadcasterID:    ev.BroadcasterID,
+			BroadcasterLogin: ev.BroadcasterLogin,
+			BroadcasterName:  ev.BroadcasterName,
+			Session:          stale,
+		}, ev)
+	case opened:
+		s.dispatchOnline(ctx, ev)
+	}
+}
+
+// streamOffline handles a broadcaster going offline. EventSub is authoritative:
+// its offline events are always dispatched, even without a known session.
+// With a flap grace period, the session is only closed (and listeners notified) if the
+// broadcaster doesn't come back online within the period, so a dropped connection doesn't
+// trigger a new announcement.
+func (s *WebhookServer) streamOffline(ctx context.Context, ev *StreamEvent, source string) {
+	utils.Log(ctx, s.logger).Infof("📴 %s n'est plus en live", ev.BroadcasterName)
+	s.recordEvent(RecentEvent{Type: EventOffline, Source: source, BroadcasterID: ev.BroadcasterID, BroadcasterName: ev.BroadcasterName})
+	if grace := s.cfg.FlapGracePeriod; grace > 0 {
+		if endedAt, ok := s.announcer.Suspend(ev, source); ok {
+			s.finishAfter(ctx, ev, endedAt, grace)
+			return
+		}
+	}
+	sess := s.announcer.Offline(ev, source)
+	if sess != nil || source == SourceEventSub {
+		ev.Session = sess
+		s.dispatchOffline(ctx, ev)
+	}
+}
+
+// finishAfter closes a suspended session once the delay is over and notifies the listeners,
+// unless the session was resumed or replaced in the meantime
+func (s *WebhookServer) finishAfter(ctx context.Context, ev *StreamEvent, endedAt time.Time, delay time.Duration) {
+	time.AfterFunc(delay, func() {
+		if sess := s.announcer.Finish(ev.BroadcasterID, endedAt); sess != nil {
+			ev.Session = sess
+			s.dispatchOffline(ctx, ev)
+		}
+	})
+}
+
+// reconcileSessions closes the sessions of broadcasters that went offline while the bot wasn't running,
+// like their offline events would have: listeners are notified, and the sessions suspended before the
+// restart are closed at the end of their grace period
+func (s *WebhookServer) reconcileSessions() {
+	for _, sess := range s.announcer.Reconcile() {
+		ctx := withBroadcaster(s.eventsCtx, s.logger, sess.BroadcasterID)
+		ev := &StreamEvent{BroadcasterID: sess.BroadcasterID, BroadcasterLogin: sess.BroadcasterLogin, BroadcasterName: sess.BroadcasterName}
+		if sess.EndedAt != nil {
+			s.finishAfter(ctx, ev, *sess.EndedAt, time.Until(sess.EndedAt.Add(s.cfg.FlapGracePeriod)))
+			continue
+		}
+		utils.Log(ctx, s.logger).Infof("Closing stale session of %s", sess.BroadcasterName)
+		if ev.Session = s.announcer.Offline(ev, SourceEventSub); ev.Session != nil {
+			s.dispatchOffline(ctx, ev)
+		}
+	}
+}
+
+// AddListener registers a listener for stream events
+func (s *WebhookServer) AddListener(l StreamListener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listeners = append(s.listeners, l)
+}
+
+// dispatchOnline notifies listeners in the background, so Twitch is acknowledged without waiting for them
+func (s *WebhookServer) dispatchOnline(ctx context.Context, ev *StreamEvent) {
+	for _, l := range s.snapshotListeners() {
+		go l.StreamOnline(ctx, ev)
+	}
+}
+
+// dispatchOffline notifies listeners in the background
+func (s *WebhookServer) dispatchOffline(ctx context.Context, ev *StreamEvent) {
+	for _, l := range s.snapshotListeners() {
+		go l.StreamOffline(ctx, ev)
+	}
+}
+
+// dispatchReplaced notifies listeners of the end of a stale session, then of the new one.
+// Each listener gets both events in order, so the new session isn't closed by the old one.
+func (s *WebhookServer) dispatchReplaced(ctx context.Context, offline, online *StreamEvent) {
+	for _, l := range s.snapshotListeners() {
+		go func(l StreamListener) {
+			l.StreamOffline(ctx, offline)
+			l.StreamOnline(ctx, online)
+		}(l)
+	}
+}
+
+func (s *WebhookServer) snapshotListeners() []StreamListener {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]StreamListener(nil), s.listeners...)
+}
+
+// recordEvent keeps an event in the recent events, dropping the oldest one when they are too many
+func (s *WebhookServer) recordEvent(e RecentEvent) {
+	e.At = time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, e)
+	if len(s.events) > recentEventCount {
+		s.events = s.events[len(s.events)-recentEventCount:]
+	}
+}
+
+// RecentEvents returns the last stream events and revocations, newest first
+func (s *WebhookServer) RecentEvents() []RecentEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	events := make([]RecentEvent, len(s.events))
+	for n, e := range s.events {
+		events[len(s.events)-1-n] = e
+	}
+	return events
+}
@@ -0,0 +1,118 @@
+package twitch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// subscriptionVersions are the EventSub versions of the subscription types that aren't at version 1
+var subscriptionVersions = map[string]string{
+	"channel.update": "2",
+}
+
+// Subscription is an EventSub subscription returned by Helix
+type Subscription struct {
+	ID        string            `json:"id"`
+	Status    string            `json:"status"` // enabled, webhook_callback_verification_pending, authorization_revoked, ...
+	Type      string            `json:"type"`
+	Version   string            `json:"version"`
+	Cost      int               `json:"cost"`
+	Condition map[string]string `json:"condition"`
+	Transport struct {
+		Method   string `json:"method"`
+		Callback string `json:"callback,omitempty"`
+		Secret   string `json:"secret,omitempty"` // only sent when creating a subscription
+	} `json:"transport"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SubscriptionList is a list of subscriptions with the cost of every subscription of the application
+type SubscriptionList struct {
+	Data         []Subscription `json:"data"`
+	Total        int            `json:"total"`
+	TotalCost    int            `json:"total_cost"`
+	MaxTotalCost int            `json:"max_total_cost"`
+}
+
+// Subscriptions lists the EventSub subscriptions of the application, following the pages.
+// query filters them, e.g. by type or status.
+func (c *Client) Subscriptions(query url.Values) (*SubscriptionList, error) {
+	list := &SubscriptionList{}
+	q := url.Values{}
+	for k, v := range query {
+		q[k] = v
+	}
+	for {
+		var page struct {
+			SubscriptionList
+			Pagination struct {
+				Cursor string `json:"cursor"`
+			} `json:"pagination"`
+		}
+		if err := c.get("/eventsub/subscriptions", q, "", &page); err != nil {
+			return nil, err
+		}
+		list.Data = append(list.Data, page.Data...)
+		list.Total, list.TotalCost, list.MaxTotalCost = page.Total, page.TotalCost, page.MaxTotalCost
+		if page.Pagination.Cursor == "" || len(page.Data) == 0 {
+			return list, nil
+		}
+		q.Set("after", page.Pagination.Cursor)
+	}
+}
+
+// CreateSubscription creates a webhook subscription, Twitch then verifies the callback with a challenge
+// signed with secret
+func (c *Client) CreateSubscription(subType string, condition map[string]string, callback, secret string) (*Subscription, error) {
+	sub := Subscription{Type: subType, Version: "1", Condition: condition}
+	if v, ok := subscriptionVersions[subType]; ok {
+		sub.Version = v
+	}
+	sub.Transport.Method = "webhook"
+	sub.Transport.Callback = callback
+	sub.Transport.Secret = secret
+	body, err := json.Marshal(struct {
+		Type      string            `json:"type"`
+		Version   string            `json:"version"`
+		Condition map[string]string `json:"condition"`
+		Transport interface{}       `json:"transport"`
+	}{sub.Type, sub.Version, sub.Condition, sub.Transport})
+	if err != nil {
+		return nil, err
+	}
+	req, err := c.newRequest("POST", "/eventsub/subscriptions", nil, "", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	var data struct {
+		Data []Subscription `json:"data"`
+	}
+	if err := c.do(req, &data); err != nil {
+		return nil, fmt.Errorf("error creating subscription: %w", err)
+	}
+	if len(data.Data) == 0 {
+		return nil, fmt.Errorf("error creating subscription: empty response")
+	}
+	return &data.Data[0], nil
+}
+
+// DeleteSubscription deletes an EventSub subscription
+func (c *Client) DeleteSubscription(id string) error {
+	req, err := c.newRequest("DELETE", "/eventsub/subscriptions", url.Values{"id": {id}}, "", nil)
+	if err != nil {
+		return err
+	}
+	return c.do(req, nil)
+}
+
+// SubscriptionCondition returns the condition of a subscription type for a broadcaster
+func SubscriptionCondition(subType, broadcasterID string) map[string]string {
+	if subType == "channel.raid" {
+		// Raids of other channels into the broadcaster
+		return map[string]string{"to_broadcaster_user_id": broadcasterID}
+	}
+	return map[string]string{"broadcaster_user_id": broadcasterID}
+}
@@ -0,0 +1,316 @@
+package twitch
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/discord"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/discord/commands"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/discordapi"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/i18n"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/rules"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/storage"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// filterBucket maps a guild ID to its filters by scope
+	filterBucket = "filters"
+
+	// guildScope is the scope of the filter applying to every broadcaster of a guild
+	guildScope = "*"
+)
+
+// Filter is a filter expression of a guild, for every broadcaster or for one of them
+type Filter struct {
+	Expression       string `json:"expression"`
+	BroadcasterLogin string `json:"broadcaster_login,omitempty"` // empty for the guild filter
+}
+
+// Channel represents the Twitch channel information returned by Helix /channels
+type Channel struct {
+	BroadcasterID       string `json:"broadcaster_id"`
+	BroadcasterLogin    string `json:"broadcaster_login"`
+	BroadcasterName     string `json:"broadcaster_name"`
+	BroadcasterLanguage string `json:"broadcaster_language"`
+	GameID              string `json:"game_id"`
+	GameName            string `json:"game_name"`
+	Title               string `json:"title"`
+}
+
+// GetChannel fetches the channel information of a broadcaster, live or not
+func (c *Client) GetChannel(broadcasterID string) (*Channel, error) {
+	var data struct {
+		Data []Channel `json:"data"`
+	}
+	if err := c.get("/channels", url.Values{"broadcaster_id": {broadcasterID}}, "", &data); err != nil {
+		return nil, err
+	}
+	if len(data.Data) == 0 {
+		return nil, nil
+	}
+	return &data.Data[0], nil
+}
+
+// Filters decides, with the filter rules of each guild, whether a stream is announced.
+// A stream must pass both the guild filter and the filter of its broadcaster.
+type Filters struct {
+	discordClient *discord.Client
+	helix         *Client
+	store         *storage.Store
+	logger        *logrus.Logger
+}
+
+// NewFilters creates the filters manager
+func NewFilters(discordClient *discord.Client, helix *Client, store *storage.Store, logger *logrus.Logger) *Filters {
+	return &Filters{
+		discordClient: discordClient,
+		helix:         helix,
+		store:         store,
+		logger:        logger,
+	}
+}
+
+// Guild returns the filters of a guild by scope (guildScope or broadcaster ID)
+func (f *Filters) Guild(guildID string) (map[string]Filter, error) {
+	filters := map[string]Filter{}
+	_, err := f.store.Get(filterBucket, guildID, &filters)
+	return filters, err
+}
+
+// update loads the filters of a guild, applies fn and saves the result
+func (f *Filters) update(guildID string, fn func(map[string]Filter)) error {
+	filters := map[string]Filter{}
+	return f.store.Update(filterBucket, guildID, &filters, func(bool) error {
+		fn(filters)
+		if len(filters) == 0 {
+			return storage.ErrDelete
+		}
+		return nil
+	})
+}
+
+// Allow reports whether a stream can be announced in a channel, according to the filters of its guild.
+// Streams are allowed when the filters can't be read, a broken filter shouldn't hide every stream.
+func (f *Filters) Allow(channelID string, stream *Stream) bool {
+	guildID := f.discordClient.ChannelGuildID(channelID)
+	if guildID == "" {
+		return true
+	}
+	ok, trace, err := f.Explain(guildID, stream, "")
+	if err != nil {
+		f.logger.Errorf("failed to evaluate the filters of guild %s: %v", guildID, err)
+		return true
+	}
+	if !ok {
+		f.logger.WithFields(logrus.Fields{"guild_id": guildID, "broadcaster": stream.UserLogin}).
+			Debugf("Stream of %s filtered out:\n%s", stream.UserName, strings.Join(trace, "\n"))
+	}
+	return ok
+}
+
+// Explain evaluates the filters of a guild for a stream and returns the trace of the evaluation.
+// When expression is set, it replaces the guild filter (dry-run of a new filter).
+func (f *Filters) Explain(guildID string, stream *Stream, expression string) (bool, []string, error) {
+	filters, err := f.Guild(guildID)
+	if err != nil {
+		return false, nil, err
+	}
+	if expression != "" {
+		filters[guildScope] = Filter{Expression: expression}
+	}
+	input := &rules.Stream{
+		Broadcaster: stream.UserLogin,
+		Game:        stream.GameName,
+		Title:       stream.Title,
+		Language:    stream.Language,
+		Viewers:     stream.ViewerCount,
+		Mature:      stream.IsMature,
+	}
+
+	allowed := true
+	var trace []string
+	for _, scope := range []string{guildScope, stream.UserID} {
+		filter, ok := filters[scope]
+		if !ok {
+			continue
+		}
+		rule, err := rules.Parse(filter.Expression)
+		if err != nil {
+			return false, nil, fmt.Errorf("invalid filter %q: %w", filter.Expression, err)
+		}
+		ok, lines := rule.Explain(input)
+		allowed = allowed && ok
+		trace = append(trace, fmt.Sprintf("[%s] %s", filter.scopeName(), filter.Expression))
+		trace = append(trace, lines...)
+	}
+	return allowed, trace, nil
+}
+
+// scopeName names the scope of a filter in traces and listings
+func (filter Filter) scopeName() string {
+	if filter.BroadcasterLogin == "" {
+		return guildScope
+	}
+	return filter.BroadcasterLogin
+}
+
+// FilterCommand defines the /filter command
+var FilterCommand = i18n.Localize(&discordgo.ApplicationCommand{
+	Name:                     "filter",
+	DefaultMemberPermissions: &manageGuild,
+	DMPermission:             &guildOnly,
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type: discordgo.ApplicationCommandOptionSubCommand,
+			Name: "set",
+			Options: []*discordgo.ApplicationCommandOption{
+				{Type: discordgo.ApplicationCommandOptionString, Name: "expression", Required: true},
+				{Type: discordgo.ApplicationCommandOptionString, Name: "broadcaster"},
+			},
+		},
+		{Type: discordgo.ApplicationCommandOptionSubCommand, Name: "show"},
+		{
+			Type: discordgo.ApplicationCommandOptionSubCommand,
+			Name: "clear",
+			Options: []*discordgo.ApplicationCommandOption{
+				{Type: discordgo.ApplicationCommandOptionString, Name: "broadcaster"},
+			},
+		},
+		{
+			Type: discordgo.ApplicationCommandOptionSubCommand,
+			Name: "test",
+			Options: []*discordgo.ApplicationCommandOption{
+				{Type: discordgo.ApplicationCommandOptionString, Name: "broadcaster", Required: true},
+				{Type: discordgo.ApplicationCommandOptionString, Name: "expression"},
+			},
+		},
+	},
+})
+
+// Command builds the /filter command
+func (f *Filters) Command() *commands.Command {
+	return &commands.Command{
+		Definition: FilterCommand,
+		Handler: func(s discordapi.Sender, i *discordgo.InteractionCreate) {
+			lang := commands.Lang(i)
+			path, opts := commands.SubCommand(i.ApplicationCommandData().Options)
+			reply, err := f.handleCommand(lang, i.GuildID, path, opts)
+			if err != nil {
+				f.logger.Errorf("Cannot handle /filter %s: %v", path, err)
+				reply = i18n.T(lang, "filter.error", err)
+			}
+			if err := commands.RespondEphemeral(s, i, reply); err != nil {
+				f.logger.Errorf("Cannot respond to /filter: %v", err)
+			}
+		},
+	}
+}
+
+func (f *Filters) handleCommand(lang, guildID, path string, opts map[string]*discordgo.ApplicationCommandInteractionDataOption) (string, error) {
+	// Optional broadcaster of the filter
+	var user *User
+	if opt, ok := opts["broadcaster"]; ok {
+		users, err := f.helix.GetUsers("", nil, []string{strings.ToLower(strings.TrimSpace(opt.StringValue()))})
+		if err != nil {
+			return "", err
+		}
+		if len(users) == 0 {
+			return i18n.T(lang, "filter.unknown_user", opt.StringValue()), nil
+		}
+		user = &users[0]
+	}
+	scope, filter := guildScope, Filter{}
+	if user != nil {
+		scope, filter = user.ID, Filter{BroadcasterLogin: user.Login}
+	}
+
+	switch path {
+	case "set":
+		filter.Expression = strings.TrimSpace(opts["expression"].StringValue())
+		if _, err := rules.Parse(filter.Expression); err != nil {
+			return i18n.T(lang, "filter.invalid", err), nil
+		}
+		if err := f.update(guildID, func(m map[string]Filter) { m[scope] = filter }); err != nil {
+			return "", err
+		}
+		return i18n.T(lang, "filter.set", filter.scopeName(), filter.Expression), nil
+
+	case "clear":
+		if err := f.update(guildID, func(m map[string]Filter) { delete(m, scope) }); err != nil {
+			return "", err
+		}
+		return i18n.T(lang, "filter.cleared", filter.scopeName()), nil
+
+	case "show":
+		filters, err := f.Guild(guildID)
+		if err != nil {
+			return "", err
+		}
+		if len(filters) == 0 {
+			return i18n.T(lang, "filter.empty"), nil
+		}
+		lines := make([]string, 0, len(filters))
+		for _, filter := range filters {
+			lines = append(lines, fmt.Sprintf("`%s` → `%s`", filter.scopeName(), filter.Expression))
+		}
+		sort.Strings(lines)
+		return strings.Join(lines, "\n"), nil
+
+	case "test":
+		expression := ""
+		if opt, ok := opts["expression"]; ok {
+			expression = strings.TrimSpace(opt.StringValue())
+			if _, err := rules.Parse(expression); err != nil {
+				return i18n.T(lang, "filter.invalid", err), nil
+			}
+		}
+		stream, live, err := f.currentStream(user)
+		if err != nil {
+			return "", err
+		}
+		ok, trace, err := f.Explain(guildID, stream, expression)
+		if err != nil {
+			return "", err
+		}
+		key := "filter.test.blocked"
+		if ok {
+			key = "filter.test.allowed"
+		}
+		header := i18n.T(lang, key, user.Login)
+		if !live {
+			header += "\n" + i18n.T(lang, "filter.test.offline")
+		}
+		if len(trace) == 0 {
+			trace = []string{i18n.T(lang, "filter.empty")}
+		}
+		return header + "\n```\n" + strings.Join(trace, "\n") + "\n```", nil
+	}
+	return "", fmt.Errorf("unknown subcommand %q", path)
+}
+
+// currentStream returns the live stream of a broadcaster, or, when offline, a stream
+// built from its channel information (without viewers nor mature flag)
+func (f *Filters) currentStream(user *User) (*Stream, bool, error) {
+	stream, err := f.helix.GetStreamInfo(user.ID)
+	if err != nil {
+		return nil, false, err
+	}
+	if stream != nil {
+		return stream, true, nil
+	}
+	channel, err := f.helix.GetChannel(user.ID)
+	if err != nil {
+		return nil, false, err
+	}
+	stream = &Stream{UserID: user.ID, UserLogin: user.Login, UserName: user.DisplayName}
+	if channel != nil {
+		stream.GameName = channel.GameName
+		stream.Title = channel.Title
+		stream.Language = channel.BroadcasterLanguage
+	}
+	return stream, false, nil
+}
@@ -0,0 +1,131 @@
+package twitch
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/flthibaud/TwitchLiveNotifier/internal/metrics"
+)
+
+var (
+	webhooksReceived    = metrics.NewCounter("webhooks_received_total", "EventSub webhooks received by message and subscription type.", "message_type", "subscription_type")
+	signatureFailures   = metrics.NewCounter("webhook_signature_failures_total", "EventSub webhooks rejected for an invalid signature.")
+	helixRequests       = metrics.NewCounter("helix_requests_total", "Twitch Helix API calls by HTTP status (error when no response).", "status")
+	notificationLatency = metrics.NewHistogram("notification_latency_seconds", "Time from the start of a stream to its announcement on Discord.", []float64{5, 10, 30, 60, 120, 300, 600, 1800})
+)
+
+// messageTypes are the EventSub message types counted by webhooksReceived
+var messageTypes = []string{MessageVerification, MessageNotification, MessageRevocation}
+
+// metricLabel returns value when it is one of known, "other" otherwise: labels taken from request
+// headers can't create new metric series
+func metricLabel(value string, known []string) string {
+	for _, k := range known {
+		if value == k {
+			return value
+		}
+	}
+	return "other"
+}
+
+// handleHealthz reports that the process is alive
+func (s *WebhookServer) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte("ok\n"))
+}
+
+// handleReadyz reports whether the bot can do its job, it answers 503 with the failing checks otherwise
+func (s *WebhookServer) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	ready, checks := s.readiness()
+	status, code := "ok", http.StatusOK
+	if !ready {
+		status, code = "unavailable", http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": status, "checks": checks})
+}
+
+// readiness runs the readiness checks: Discord session open, Twitch app token valid and every
+// EventSub subscription created and not revoked. Checks are "ok" or describe the problem.
+func (s *WebhookServer) readiness() (bool, map[string]string) {
+	checks := map[string]string{
+		"discord":       "ok",
+		"twitch_token":  "ok",
+		"subscriptions": "ok",
+	}
+	if !s.discordClient.Ready() {
+		checks["discord"] = "session not ready"
+	}
+	if !s.helix.TokenValid() {
+		checks["twitch_token"] = "no valid app token"
+	}
+	if failing := s.failingSubscriptions(); len(failing) > 0 {
+		checks["subscriptions"] = fmt.Sprintf("%d failing: %v", len(failing), failing)
+	}
+	for _, check := range checks {
+		if check != "ok" {
+			return false, checks
+		}
+	}
+	return true, checks
+}
+
+// subscriptionKey identifies an EventSub subscription of a broadcaster
+func subscriptionKey(subType, broadcasterID string) string {
+	return subType + "/" + broadcasterID
+}
+
+// setSubscriptionError records the state of a subscription, a nil error marks it active
+func (s *WebhookServer) setSubscriptionError(subType, broadcasterID string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := subscriptionKey(subType, broadcasterID)
+	if err == nil {
+		s.subStates[key] = ""
+	} else {
+		s.subStates[key] = err.Error()
+	}
+}
+
+// forgetSubscription drops the state of a deleted subscription
+func (s *WebhookServer) forgetSubscription(subType, broadcasterID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.subStates, subscriptionKey(subType, broadcasterID))
+}
+
+// failingSubscriptionKeys returns the keys of the failing subscriptions of the tracked broadcasters
+func (s *WebhookServer) failingSubscriptionKeys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for key, state := range s.subStates {
+		if _, broadcasterID, _ := strings.Cut(key, "/"); state != "" && s.tracked[broadcasterID] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// failingSubscriptions returns the subscriptions that couldn't be created or were revoked, with their error.
+// Subscriptions aren't healthy until they were created at startup.
+func (s *WebhookServer) failingSubscriptions() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.subscribed {
+		return []string{"not created yet"}
+	}
+	failing := []string{}
+	for key, state := range s.subStates {
+		if state != "" {
+			failing = append(failing, key+": "+state)
+		}
+	}
+	sort.Strings(failing)
+	return failing
+}
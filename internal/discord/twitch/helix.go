@@ -0,0 +1,236 @@
+package twitch
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default Twitch endpoints
+const (
+	DefaultHelixURL = "https://api.twitch.tv/helix"
+	DefaultAuthURL  = "https://id.twitch.tv/oauth2"
+)
+
+// Client calls the Twitch Helix API and OAuth endpoints
+type Client struct {
+	ClientID     string
+	ClientSecret string
+	HelixURL     string // base URL of the Helix API
+	AuthURL      string // base URL of the OAuth endpoints
+	HTTPClient   *http.Client
+
+	mu             sync.Mutex
+	appToken       string
+	appTokenExpiry time.Time
+}
+
+// Token is an OAuth token returned by Twitch
+type Token struct {
+	AccessToken  string   `json:"access_token"`
+	RefreshToken string   `json:"refresh_token"`
+	ExpiresIn    int      `json:"expires_in"`
+	Scope        []string `json:"scope"`
+	TokenType    string   `json:"token_type"`
+}
+
+// User represents a Twitch user returned by Helix /users
+type User struct {
+	ID              string `json:"id"`
+	Login           string `json:"login"`
+	DisplayName     string `json:"display_name"`
+	ProfileImageURL string `json:"profile_image_url"`
+}
+
+// NewClient creates a Twitch client for the given application credentials
+func NewClient(clientID, clientSecret string) *Client {
+	return &Client{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		HelixURL:     DefaultHelixURL,
+		AuthURL:      DefaultAuthURL,
+		HTTPClient:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// TokenValid reports whether a valid app access token is cached
+func (c *Client) TokenValid() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.appToken != "" && time.Now().Before(c.appTokenExpiry)
+}
+
+// AppToken returns an app access token, requesting a new one when the cached token expired
+func (c *Client) AppToken() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.appToken != "" && time.Now().Before(c.appTokenExpiry) {
+		return c.appToken, nil
+	}
+
+	tok, err := c.requestToken(url.Values{
+		"grant_type": {"client_credentials"},
+	})
+	if err != nil {
+		return "", err
+	}
+	c.appToken = tok.AccessToken
+	// Renew a minute early to avoid using a token about to expire
+	c.appTokenExpiry = time.Now().Add(time.Duration(tok.ExpiresIn)*time.Second - time.Minute)
+	return c.appToken, nil
+}
+
+// ExchangeCode exchanges an authorization code for a user access token
+func (c *Client) ExchangeCode(code, redirectURI string) (*Token, error) {
+	return c.requestToken(url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {redirectURI},
+	})
+}
+
+// RevokeToken revokes an access token. Tokens that are already invalid are not an error.
+func (c *Client) RevokeToken(token string) error {
+	form := url.Values{
+		"client_id": {c.ClientID},
+		"token":     {token},
+	}
+	resp, err := c.HTTPClient.PostForm(c.AuthURL+"/revoke", form)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusBadRequest {
+		data, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("error revoking token: %s: %s", resp.Status, data)
+	}
+	return nil
+}
+
+// AuthorizeURL returns the URL of the authorization code flow consent page
+func (c *Client) AuthorizeURL(redirectURI, state string, scopes []string) string {
+	q := url.Values{
+		"client_id":     {c.ClientID},
+		"redirect_uri":  {redirectURI},
+		"response_type": {"code"},
+		"scope":         {strings.Join(scopes, " ")},
+		"state":         {state},
+	}
+	return c.AuthURL + "/authorize?" + q.Encode()
+}
+
+// requestToken calls the OAuth token endpoint with the client credentials and the given grant
+func (c *Client) requestToken(form url.Values) (*Token, error) {
+	form.Set("client_id", c.ClientID)
+	form.Set("client_secret", c.ClientSecret)
+	resp, err := c.HTTPClient.PostForm(c.AuthURL+"/token", form)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		data, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("error requesting token: %s: %s", resp.Status, data)
+	}
+	var tok Token
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return nil, err
+	}
+	return &tok, nil
+}
+
+// GetUsers looks up users by ID or login. With a user token and no filter,
+// Twitch returns the user owning the token. An empty token uses the app token.
+func (c *Client) GetUsers(token string, ids, logins []string) ([]User, error) {
+	q := url.Values{}
+	for _, id := range ids {
+		q.Add("id", id)
+	}
+	for _, login := range logins {
+		q.Add("login", login)
+	}
+	var data struct {
+		Data []User `json:"data"`
+	}
+	if err := c.get("/users", q, token, &data); err != nil {
+		return nil, err
+	}
+	return data.Data, nil
+}
+
+// get performs an authenticated GET request on Helix and decodes the JSON response into out
+func (c *Client) get(path string, query url.Values, token string, out interface{}) error {
+	req, err := c.newRequest("GET", path, query, token, nil)
+	if err != nil {
+		return err
+	}
+	return c.do(req, out)
+}
+
+// newRequest builds an authenticated Helix request. An empty token uses the app token.
+func (c *Client) newRequest(method, path string, query url.Values, token string, body io.Reader) (*http.Request, error) {
+	if token == "" {
+		var err error
+		if token, err = c.AppToken(); err != nil {
+			return nil, fmt.Errorf("error getting OAuth token: %w", err)
+		}
+	}
+	u := c.HelixURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Client-ID", c.ClientID)
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
+
+// do sends a Helix request and decodes the JSON response into out (when not nil)
+func (c *Client) do(req *http.Request, out interface{}) error {
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		helixRequests.Inc("error")
+		return err
+	}
+	defer resp.Body.Close()
+	helixRequests.Inc(strconv.Itoa(resp.StatusCode))
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		// The app token was revoked or expired early, force a new one on next call
+		c.mu.Lock()
+		if req.Header.Get("Authorization") == "Bearer "+c.appToken {
+			c.appToken = ""
+		}
+		c.mu.Unlock()
+	}
+	if resp.StatusCode/100 != 2 {
+		data, _ := io.ReadAll(resp.Body)
+		return &APIError{Status: resp.StatusCode, Body: string(data)}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// APIError is returned when Helix answers with a non-2xx status
+type APIError struct {
+	Status int
+	Body   string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("twitch API error: %d %s: %s", e.Status, http.StatusText(e.Status), e.Body)
+}
@@ -0,0 +1,488 @@
+package twitch
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/discord"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/discord/commands"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/discordapi"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/i18n"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/settings"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/storage"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// liveMembersBucket maps a guild ID to its members mapping (Discord user ID -> Twitch user ID)
+	liveMembersBucket = "live_members"
+
+	// liveRoleHoldersBucket maps a guild ID to the members the bot gave a live role to (role ID -> Discord user IDs).
+	// The bot only removes the roles it gave, so a live role can be a role members already have.
+	liveRoleHoldersBucket = "live_role_holders"
+)
+
+// LiveRoles gives the configured live role of each guild to its streamers while they are live.
+// Streamers are the members mapped by admins with /liverole, and members who linked their account with /twitch link.
+type LiveRoles struct {
+	discordClient *discord.Client
+	settings      *settings.Manager
+	helix         *Client
+	linker        *Linker
+	store         *storage.Store
+	track         func(broadcasterID string)
+	untrack       func(broadcasterIDs ...string)
+	logger        *logrus.Logger
+
+	mu sync.Mutex // serializes role updates, so the Discord role changes of concurrent events can't interleave
+}
+
+// NewLiveRoles creates the live role manager. track is called for every mapped
+// Twitch user, so stream events are received for them, and untrack for the users
+// whose mapping is removed or replaced.
+func NewLiveRoles(discordClient *discord.Client, helix *Client, linker *Linker, store *storage.Store, track func(string), untrack func(...string), logger *logrus.Logger) *LiveRoles {
+	return &LiveRoles{
+		discordClient: discordClient,
+		settings:      discordClient.Settings(),
+		helix:         helix,
+		linker:        linker,
+		store:         store,
+		track:         track,
+		untrack:       untrack,
+		logger:        logger,
+	}
+}
+
+// TrackAll tracks the Twitch users of every mapping and link
+func (r *LiveRoles) TrackAll() {
+	for _, guildID := range r.store.Keys(liveMembersBucket) {
+		mapping, err := r.mapping(guildID)
+		if err != nil {
+			r.logger.Errorf("failed to load live members of %s: %v", guildID, err)
+			continue
+		}
+		for _, twitchID := range mapping {
+			r.track(twitchID)
+		}
+	}
+	links, err := r.linker.Links()
+	if err != nil {
+		r.logger.Errorf("failed to load Twitch links: %v", err)
+	}
+	for _, link := range links {
+		r.track(link.TwitchUserID)
+	}
+}
+
+// mapping returns the members mapping set by admins for a guild
+func (r *LiveRoles) mapping(guildID string) (map[string]string, error) {
+	mapping := map[string]string{}
+	_, err := r.store.Get(liveMembersBucket, guildID, &mapping)
+	return mapping, err
+}
+
+// streamers returns the Twitch user ID of every streamer of a guild by Discord user ID.
+// Linked accounts are included, admin mappings take precedence over them.
+func (r *LiveRoles) streamers(guildID string) (map[string]string, error) {
+	streamers := map[string]string{}
+	links, err := r.linker.Links()
+	if err != nil {
+		return nil, err
+	}
+	for _, link := range links {
+		streamers[link.DiscordUserID] = link.TwitchUserID
+	}
+	mapping, err := r.mapping(guildID)
+	if err != nil {
+		return nil, err
+	}
+	for discordID, twitchID := range mapping {
+		streamers[discordID] = twitchID
+	}
+	return streamers, nil
+}
+
+// roleGuilds returns the guilds with a live role configured, by guild ID
+func (r *LiveRoles) roleGuilds() map[string]string {
+	guilds := map[string]string{}
+	for _, guildID := range r.settings.GuildIDs() {
+		g, err := r.settings.Guild(guildID)
+		if err == nil && g.LiveRoleID != "" {
+			guilds[guildID] = g.LiveRoleID
+		}
+	}
+	return guilds
+}
+
+// StreamOnline gives the live role to the members streaming on this broadcaster
+func (r *LiveRoles) StreamOnline(ctx context.Context, ev *StreamEvent) {
+	r.setRole(ctx, ev.BroadcasterID, true)
+}
+
+// StreamOffline removes the live role from the members streaming on this broadcaster
+func (r *LiveRoles) StreamOffline(ctx context.Context, ev *StreamEvent) {
+	r.setRole(ctx, ev.BroadcasterID, false)
+}
+
+func (r *LiveRoles) setRole(ctx context.Context, broadcasterID string, live bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for guildID, roleID := range r.roleGuilds() {
+		streamers, err := r.streamers(guildID)
+		if err != nil {
+			utils.Log(ctx, r.logger).Errorf("failed to load streamers of guild %s: %v", guildID, err)
+			continue
+		}
+		holders, err := r.holders(guildID)
+		if err != nil {
+			utils.Log(ctx, r.logger).Errorf("failed to load live role holders of guild %s: %v", guildID, err)
+			continue
+		}
+		for userID, twitchID := range streamers {
+			if twitchID != broadcasterID {
+				continue
+			}
+			// Linked accounts are global, skip users who aren't members of this guild
+			m, err := r.discordClient.GuildMember(guildID, userID)
+			if err != nil {
+				continue
+			}
+			// Members who have the role without the bot keep it
+			if live && !hasRole(m.Roles, roleID) || !live && holders.has(roleID, userID) {
+				r.apply(ctx, guildID, userID, roleID, live)
+			}
+		}
+	}
+}
+
+// apply adds or removes the live role of a member, and records whether the bot gave it
+func (r *LiveRoles) apply(ctx context.Context, guildID, userID, roleID string, live bool) {
+	var err error
+	if live {
+		err = r.discordClient.AddRole(guildID, userID, roleID)
+	} else {
+		err = r.discordClient.RemoveRole(guildID, userID, roleID)
+	}
+	entry := utils.Log(ctx, r.logger).WithFields(logrus.Fields{"guild_id": guildID, "user_id": userID, "live": live})
+	if err != nil {
+		entry.Errorf("failed to update live role: %v", err)
+		return
+	}
+	entry.Info("Live role updated")
+	if err := r.updateHolders(guildID, func(h roleHolders) { h.set(roleID, userID, live) }); err != nil {
+		entry.Errorf("failed to save live role holders: %v", err)
+	}
+}
+
+// releaseRole removes a live role that is no longer used from the members the bot gave it to
+func (r *LiveRoles) releaseRole(guildID, roleID string) {
+	if roleID == "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	holders, err := r.holders(guildID)
+	if err != nil {
+		r.logger.Errorf("failed to load live role holders of guild %s: %v", guildID, err)
+		return
+	}
+	for _, userID := range holders[roleID] {
+		r.apply(context.Background(), guildID, userID, roleID, false)
+	}
+	// Members who left the guild can't lose the role, forget them too
+	if err := r.updateHolders(guildID, func(h roleHolders) { delete(h, roleID) }); err != nil {
+		r.logger.Errorf("failed to save live role holders of guild %s: %v", guildID, err)
+	}
+}
+
+// Reconcile fixes the live role of every member of every guild, in case stream events were missed
+// (e.g. while the bot was offline)
+func (r *LiveRoles) Reconcile() {
+	for guildID, roleID := range r.roleGuilds() {
+		if err := r.reconcileGuild(guildID, roleID); err != nil {
+			r.logger.Errorf("failed to reconcile live role of guild %s: %v", guildID, err)
+		}
+	}
+}
+
+func (r *LiveRoles) reconcileGuild(guildID, roleID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	streamers, err := r.streamers(guildID)
+	if err != nil {
+		return err
+	}
+	ids := make([]string, 0, len(streamers))
+	for _, twitchID := range streamers {
+		ids = append(ids, twitchID)
+	}
+	streams, err := r.helix.GetStreams(ids)
+	if err != nil {
+		return fmt.Errorf("failed to fetch streams: %w", err)
+	}
+	live := map[string]bool{}
+	for _, stream := range streams {
+		live[stream.UserID] = true
+	}
+
+	holders, err := r.holders(guildID)
+	if err != nil {
+		return fmt.Errorf("failed to load live role holders: %w", err)
+	}
+	members, err := r.discordClient.GuildMembers(guildID)
+	if err != nil {
+		return fmt.Errorf("failed to list members: %w", err)
+	}
+	for _, m := range members {
+		twitchID, ok := streamers[m.User.ID]
+		shouldHave := ok && live[twitchID]
+		has, given := hasRole(m.Roles, roleID), holders.has(roleID, m.User.ID)
+		switch {
+		case shouldHave && !has:
+			r.apply(context.Background(), guildID, m.User.ID, roleID, true)
+		case !shouldHave && has && given:
+			r.apply(context.Background(), guildID, m.User.ID, roleID, false)
+		case !has && given:
+			// The role was removed by someone else, it is no longer the bot's
+			if err := r.updateHolders(guildID, func(h roleHolders) { h.set(roleID, m.User.ID, false) }); err != nil {
+				return fmt.Errorf("failed to save live role holders: %w", err)
+			}
+		}
+	}
+	r.logger.Infof("Live role reconciled for guild %s (%d streamers, %d live)", guildID, len(streamers), len(streams))
+	return nil
+}
+
+// roleHolders lists the members the bot gave each live role to (role ID -> Discord user IDs)
+type roleHolders map[string][]string
+
+// has reports whether the bot gave the role to the user
+func (h roleHolders) has(roleID, userID string) bool {
+	for _, id := range h[roleID] {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// set records that the user has, or no longer has, the role given by the bot
+func (h roleHolders) set(roleID, userID string, given bool) {
+	users := []string{}
+	for _, id := range h[roleID] {
+		if id != userID {
+			users = append(users, id)
+		}
+	}
+	if given {
+		users = append(users, userID)
+	}
+	if len(users) == 0 {
+		delete(h, roleID)
+		return
+	}
+	h[roleID] = users
+}
+
+// holders returns the members the bot gave live roles to in a guild
+func (r *LiveRoles) holders(guildID string) (roleHolders, error) {
+	holders := roleHolders{}
+	_, err := r.store.Get(liveRoleHoldersBucket, guildID, &holders)
+	return holders, err
+}
+
+// updateHolders applies fn to the live role holders of a guild and saves them
+func (r *LiveRoles) updateHolders(guildID string, fn func(roleHolders)) error {
+	holders := roleHolders{}
+	return r.store.Update(liveRoleHoldersBucket, guildID, &holders, func(bool) error {
+		fn(holders)
+		if len(holders) == 0 {
+			return storage.ErrDelete
+		}
+		return nil
+	})
+}
+
+func hasRole(roles []string, roleID string) bool {
+	for _, role := range roles {
+		if role == roleID {
+			return true
+		}
+	}
+	return false
+}
+
+// OnReady reconciles roles each time the Discord session is (re)established
+func (r *LiveRoles) OnReady(s *discordgo.Session, _ *discordgo.Ready) {
+	go r.Reconcile()
+}
+
+// LiveRoleCommand defines the /liverole command
+var LiveRoleCommand = i18n.Localize(&discordgo.ApplicationCommand{
+	Name:                     "liverole",
+	DefaultMemberPermissions: &manageRoles,
+	DMPermission:             &guildOnly,
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type: discordgo.ApplicationCommandOptionSubCommand,
+			Name: "role",
+			Options: []*discordgo.ApplicationCommandOption{
+				{Type: discordgo.ApplicationCommandOptionRole, Name: "role", Required: true},
+			},
+		},
+		{Type: discordgo.ApplicationCommandOptionSubCommand, Name: "disable"},
+		{
+			Type: discordgo.ApplicationCommandOptionSubCommand,
+			Name: "link",
+			Options: []*discordgo.ApplicationCommandOption{
+				{Type: discordgo.ApplicationCommandOptionUser, Name: "member", Required: true},
+				{Type: discordgo.ApplicationCommandOptionString, Name: "twitch", Required: true},
+			},
+		},
+		{
+			Type: discordgo.ApplicationCommandOptionSubCommand,
+			Name: "unlink",
+			Options: []*discordgo.ApplicationCommandOption{
+				{Type: discordgo.ApplicationCommandOptionUser, Name: "member", Required: true},
+			},
+		},
+		{Type: discordgo.ApplicationCommandOptionSubCommand, Name: "list"},
+	},
+})
+
+var (
+	manageRoles int64 = discordgo.PermissionManageRoles
+	guildOnly         = false
+)
+
+// Command builds the /liverole command
+func (r *LiveRoles) Command() *commands.Command {
+	return &commands.Command{
+		Definition: LiveRoleCommand,
+		Handler: func(s discordapi.Sender, i *discordgo.InteractionCreate) {
+			lang := commands.Lang(i)
+			path, opts := commands.SubCommand(i.ApplicationCommandData().Options)
+			reply, err := r.handleCommand(lang, i.GuildID, path, opts)
+			if err != nil {
+				r.logger.Errorf("Cannot handle /liverole %s: %v", path, err)
+				reply = i18n.T(lang, "liverole.error", err)
+			}
+			if err := commands.RespondEphemeral(s, i, reply); err != nil {
+				r.logger.Errorf("Cannot respond to /liverole: %v", err)
+			}
+		},
+	}
+}
+
+func (r *LiveRoles) handleCommand(lang, guildID, path string, opts map[string]*discordgo.ApplicationCommandInteractionDataOption) (string, error) {
+	switch path {
+	case "role":
+		roleID := opts["role"].RoleValue(nil, "").ID
+		var previous string
+		if _, err := r.settings.UpdateGuild(guildID, func(g *settings.Guild) { previous, g.LiveRoleID = g.LiveRoleID, roleID }); err != nil {
+			return "", err
+		}
+		go func() {
+			if previous != roleID {
+				r.releaseRole(guildID, previous)
+			}
+			if err := r.reconcileGuild(guildID, roleID); err != nil {
+				r.logger.Errorf("failed to reconcile live role of guild %s: %v", guildID, err)
+			}
+		}()
+		return i18n.T(lang, "liverole.role_set", roleID), nil
+
+	case "disable":
+		var previous string
+		if _, err := r.settings.UpdateGuild(guildID, func(g *settings.Guild) { previous, g.LiveRoleID = g.LiveRoleID, "" }); err != nil {
+			return "", err
+		}
+		go r.releaseRole(guildID, previous)
+		return i18n.T(lang, "liverole.disabled"), nil
+
+	case "link":
+		userID := opts["member"].UserValue(nil).ID
+		user, err := r.resolveUser(opts["twitch"].StringValue())
+		if err != nil {
+			return "", err
+		}
+		if user == nil {
+			return i18n.T(lang, "liverole.unknown_user", opts["twitch"].StringValue()), nil
+		}
+		var previous string
+		if err := r.updateMapping(guildID, func(m map[string]string) { previous, m[userID] = m[userID], user.ID }); err != nil {
+			return "", err
+		}
+		r.track(user.ID)
+		if previous != "" && previous != user.ID {
+			r.untrack(previous)
+		}
+		if g, err := r.settings.Guild(guildID); err == nil && g.LiveRoleID != "" {
+			go r.reconcileGuild(guildID, g.LiveRoleID)
+		}
+		return i18n.T(lang, "liverole.linked", userID, user.Login), nil
+
+	case "unlink":
+		userID := opts["member"].UserValue(nil).ID
+		var previous string
+		if err := r.updateMapping(guildID, func(m map[string]string) { previous = m[userID]; delete(m, userID) }); err != nil {
+			return "", err
+		}
+		if previous != "" {
+			r.untrack(previous)
+		}
+		if g, err := r.settings.Guild(guildID); err == nil && g.LiveRoleID != "" {
+			go r.reconcileGuild(guildID, g.LiveRoleID)
+		}
+		return i18n.T(lang, "liverole.unlinked", userID), nil
+
+	case "list":
+		streamers, err := r.streamers(guildID)
+		if err != nil {
+			return "", err
+		}
+		if len(streamers) == 0 {
+			return i18n.T(lang, "liverole.empty"), nil
+		}
+		lines := make([]string, 0, len(streamers))
+		for userID, twitchID := range streamers {
+			lines = append(lines, fmt.Sprintf("<@%s> → `%s`", userID, twitchID))
+		}
+		sort.Strings(lines)
+		return strings.Join(lines, "\n"), nil
+	}
+	return "", fmt.Errorf("unknown subcommand %q", path)
+}
+
+// resolveUser looks up a Twitch user by login, or by ID when the value is numeric
+func (r *LiveRoles) resolveUser(value string) (*User, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	var ids, logins []string
+	if strings.Trim(value, "0123456789") == "" {
+		ids = []string{value}
+	} else {
+		logins = []string{value}
+	}
+	users, err := r.helix.GetUsers("", ids, logins)
+	if err != nil || len(users) == 0 {
+		return nil, err
+	}
+	return &users[0], nil
+}
+
+// updateMapping applies fn to the members mapping of a guild and saves it
+func (r *LiveRoles) updateMapping(guildID string, fn func(map[string]string)) error {
+	mapping := map[string]string{}
+	return r.store.Update(liveMembersBucket, guildID, &mapping, func(bool) error {
+		fn(mapping)
+		return nil
+	})
+}
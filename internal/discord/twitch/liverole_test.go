@@ -0,0 +1,190 @@
+package twitch
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/discordtest"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/i18n"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/twitchtest"
+)
+
+// roleCalls summarizes the role updates among calls, e.g. "add_role 900000000000000020 900000000000000021"
+func roleCalls(calls []discordtest.Call) []string {
+	var out []string
+	for _, c := range calls {
+		if c.Op == discordtest.OpAddRole || c.Op == discordtest.OpRemoveRole {
+			out = append(out, fmt.Sprintf("%s %s %s", c.Op, c.UserID, c.RoleID))
+		}
+	}
+	return out
+}
+
+func TestLiveRoleOnlyRemovesGivenRoles(t *testing.T) {
+	const (
+		liveRole = "900000000000000021"
+		newRole  = "900000000000000024"
+		streamer = "900000000000000020" // carol, gets the role from the bot
+		fan      = "900000000000000022" // already has the role, doesn't stream
+		veteran  = "900000000000000023" // bob, already has the role and streams
+	)
+	e := newE2E(t, alice)
+	if err := e.store.Put(liveMembersBucket, e2eGuild, map[string]string{streamer: carol.ID, veteran: bob.ID}); err != nil {
+		t.Fatal(err)
+	}
+	e.discord.AddMember(e2eGuild, &discordgo.Member{User: &discordgo.User{ID: streamer}})
+	e.discord.AddMember(e2eGuild, &discordgo.Member{User: &discordgo.User{ID: fan}, Roles: []string{liveRole}})
+	e.discord.AddMember(e2eGuild, &discordgo.Member{User: &discordgo.User{ID: veteran}, Roles: []string{liveRole}})
+	e.twitch.SetLive(twitchtest.Stream{UserID: carol.ID, UserLogin: carol.Login, UserName: carol.DisplayName})
+	e.twitch.SetLive(twitchtest.Stream{UserID: bob.ID, UserLogin: bob.Login, UserName: bob.DisplayName})
+	e.start(t)
+
+	setRole := func(roleID string) {
+		t.Helper()
+		opts := map[string]*discordgo.ApplicationCommandInteractionDataOption{
+			"role": {Name: "role", Type: discordgo.ApplicationCommandOptionRole, Value: roleID},
+		}
+		if _, err := e.server.liveRoles.handleCommand(i18n.English, e2eGuild, "role", opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(step string, n int, want ...string) {
+		t.Helper()
+		got := roleCalls(e.discord.Wait(t, n))
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("%s: role updates\n%q\nwant\n%q", step, got, want)
+		}
+	}
+
+	// Members who had the role before keep it
+	setRole(liveRole)
+	check("set role", 1,
+		"add_role "+streamer+" "+liveRole)
+
+	e.postEvent(t, SimulateStreamOffline(simulatedUser(bob)))
+	e.postEvent(t, SimulateStreamOffline(simulatedUser(carol)))
+	check("stream offline", 2,
+		"add_role "+streamer+" "+liveRole,
+		"remove_role "+streamer+" "+liveRole)
+
+	e.postEvent(t, SimulateStreamOnline(simulatedUser(carol)))
+	check("stream online", 3,
+		"add_role "+streamer+" "+liveRole,
+		"remove_role "+streamer+" "+liveRole,
+		"add_role "+streamer+" "+liveRole)
+
+	// Changing the role only takes the previous one back from the member who got it from the bot
+	e.discord.Reset()
+	setRole(newRole)
+	check("change role", 3,
+		"remove_role "+streamer+" "+liveRole,
+		"add_role "+streamer+" "+newRole,
+		"add_role "+veteran+" "+newRole)
+
+	e.discord.Reset()
+	if _, err := e.server.liveRoles.handleCommand(i18n.English, e2eGuild, "disable", nil); err != nil {
+		t.Fatal(err)
+	}
+	check("disable", 2,
+		"remove_role "+streamer+" "+newRole,
+		"remove_role "+veteran+" "+newRole)
+
+	eventually(t, "live role holders forgotten", func() bool {
+		holders, err := e.server.liveRoles.holders(e2eGuild)
+		return err == nil && len(holders) == 0
+	})
+}
+
+func TestUnlinkTakesLiveRoleBack(t *testing.T) {
+	const (
+		liveRole = "900000000000000021"
+		streamer = "900000000000000020" // linked to carol
+	)
+	e := newE2E(t, alice)
+	if err := e.store.Put(linkBucket, streamer, Link{DiscordUserID: streamer, TwitchUserID: carol.ID, TwitchLogin: carol.Login, AccessToken: "user-token"}); err != nil {
+		t.Fatal(err)
+	}
+	e.server.liveRoles.TrackAll()
+	e.discord.AddMember(e2eGuild, &discordgo.Member{User: &discordgo.User{ID: streamer}})
+	e.twitch.SetLive(twitchtest.Stream{UserID: carol.ID, UserLogin: carol.Login, UserName: carol.DisplayName})
+	e.start(t)
+
+	opts := map[string]*discordgo.ApplicationCommandInteractionDataOption{
+		"role": {Name: "role", Type: discordgo.ApplicationCommandOptionRole, Value: liveRole},
+	}
+	if _, err := e.server.liveRoles.handleCommand(i18n.English, e2eGuild, "role", opts); err != nil {
+		t.Fatal(err)
+	}
+	e.discord.Wait(t, 1)
+	if !hasSubscription(e, carol.ID) {
+		t.Fatal("linked streamer not tracked")
+	}
+
+	if _, _, err := e.server.linker.Unlink(streamer); err != nil {
+		t.Fatal(err)
+	}
+	got := roleCalls(e.discord.Wait(t, 2))
+	if want := []string{"add_role " + streamer + " " + liveRole, "remove_role " + streamer + " " + liveRole}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("role updates %q, want %q", got, want)
+	}
+	eventually(t, "subscriptions of the unlinked streamer deleted", func() bool { return !hasSubscription(e, carol.ID) })
+	if !hasSubscription(e, alice.ID) {
+		t.Error("subscriptions of an announced broadcaster deleted")
+	}
+}
+
+func TestLiveRoleMappingUntracks(t *testing.T) {
+	const (
+		streamer = "900000000000000020"
+		other    = "900000000000000022" // mapped to the same account in another guild
+	)
+	e := newE2E(t, alice)
+	e.twitch.AddUser(bob)
+	e.twitch.AddUser(carol)
+	e.start(t)
+	command := func(guildID, path, userID, twitch string) {
+		t.Helper()
+		opts := map[string]*discordgo.ApplicationCommandInteractionDataOption{
+			"member": {Name: "member", Type: discordgo.ApplicationCommandOptionUser, Value: userID},
+		}
+		if twitch != "" {
+			opts["twitch"] = &discordgo.ApplicationCommandInteractionDataOption{Name: "twitch", Type: discordgo.ApplicationCommandOptionString, Value: twitch}
+		}
+		if _, err := e.server.liveRoles.handleCommand(i18n.English, guildID, path, opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	command(e2eGuild, "link", streamer, carol.Login)
+	eventually(t, "mapped streamer tracked", func() bool { return hasSubscription(e, carol.ID) })
+
+	// Mapping the member to another account stops tracking the previous one
+	command(e2eGuild, "link", streamer, bob.Login)
+	command(otherGuild, "link", other, bob.Login)
+	eventually(t, "new account tracked", func() bool { return hasSubscription(e, bob.ID) })
+	eventually(t, "subscriptions of the previous account deleted", func() bool { return !hasSubscription(e, carol.ID) })
+
+	// An account mapped in another guild stays tracked
+	command(e2eGuild, "unlink", streamer, "")
+	time.Sleep(50 * time.Millisecond) // subscriptions are deleted in the background
+	if !hasSubscription(e, bob.ID) {
+		t.Fatal("subscriptions of an account still mapped deleted")
+	}
+	command(otherGuild, "unlink", other, "")
+	eventually(t, "subscriptions of the unmapped account deleted", func() bool { return !hasSubscription(e, bob.ID) })
+	if !hasSubscription(e, alice.ID) {
+		t.Error("subscriptions of an announced broadcaster deleted")
+	}
+}
+
+// hasSubscription reports whether the fake Twitch server has a subscription of the broadcaster
+func hasSubscription(e *e2e, broadcasterID string) bool {
+	for _, sub := range e.twitch.Subscriptions() {
+		if sub.Condition["broadcaster_user_id"] == broadcasterID {
+			return true
+		}
+	}
+	return false
+}
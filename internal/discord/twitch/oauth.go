@@ -0,0 +1,306 @@
+package twitch
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flthibaud/TwitchLiveNotifier/internal/i18n"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/storage"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	linkBucket = "twitch_links"
+
+	// linkStateTTL is how long a /twitch link URL stays valid
+	linkStateTTL = 10 * time.Minute
+
+	// encryptedPrefix marks the tokens stored encrypted, followed by the base64 of the nonce and ciphertext
+	encryptedPrefix = "enc:"
+)
+
+// Link associates a Discord user with the Twitch account they authorized
+type Link struct {
+	DiscordUserID string    `json:"discord_user_id"`
+	TwitchUserID  string    `json:"twitch_user_id"`
+	TwitchLogin   string    `json:"twitch_login"`
+	AccessToken   string    `json:"access_token"`
+	RefreshToken  string    `json:"refresh_token"`
+	LinkedAt      time.Time `json:"linked_at"`
+}
+
+// pendingLink is an authorization started by a Discord user, waiting for the OAuth callback
+type pendingLink struct {
+	discordUserID string
+	lang          string
+	expires       time.Time
+}
+
+// Linker runs the OAuth authorization code flow linking Discord users to their Twitch account
+type Linker struct {
+	helix       *Client
+	store       *storage.Store
+	redirectURL string
+	tokens      cipher.AEAD // encrypts the tokens in the store, nil stores them in plaintext
+	logger      *logrus.Logger
+
+	// OnLinked, when set, is called after a Discord user linked a Twitch account
+	OnLinked func(link *Link)
+	// OnUnlinked, when set, is called after a Discord user unlinked their Twitch account
+	OnUnlinked func(link *Link)
+
+	mu      sync.Mutex
+	pending map[string]pendingLink // by OAuth state
+}
+
+// NewLinker creates a linker using redirectURL as the OAuth redirect URI (served by HandleCallback).
+// When tokenKey is set (an AES-256 key), the tokens of the links are encrypted in the store.
+func NewLinker(helix *Client, store *storage.Store, redirectURL string, tokenKey []byte, logger *logrus.Logger) *Linker {
+	l := &Linker{
+		helix:       helix,
+		store:       store,
+		redirectURL: redirectURL,
+		logger:      logger,
+		pending:     make(map[string]pendingLink),
+	}
+	if tokenKey != nil {
+		block, err := aes.NewCipher(tokenKey)
+		if err != nil {
+			panic(err) // the configuration only accepts 32 bytes keys
+		}
+		if l.tokens, err = cipher.NewGCM(block); err != nil {
+			panic(err)
+		}
+	}
+	return l
+}
+
+// EncryptTokens encrypts the tokens of the links stored in plaintext, e.g. before a key was configured.
+// It does nothing without a key.
+func (l *Linker) EncryptTokens() error {
+	if l.tokens == nil {
+		return nil
+	}
+	links, err := l.Links()
+	if err != nil {
+		return err
+	}
+	for n := range links {
+		if err := l.save(&links[n]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AuthorizeURL starts a link for a Discord user and returns the Twitch consent page URL.
+// lang is the language of the page shown once the callback completes.
+func (l *Linker) AuthorizeURL(discordUserID, lang string) (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	state := hex.EncodeToString(buf)
+
+	l.mu.Lock()
+	now := time.Now()
+	for k, p := range l.pending {
+		if now.After(p.expires) {
+			delete(l.pending, k)
+		}
+	}
+	l.pending[state] = pendingLink{discordUserID: discordUserID, lang: lang, expires: now.Add(linkStateTTL)}
+	l.mu.Unlock()
+
+	return l.helix.AuthorizeURL(l.redirectURL, state, nil), nil
+}
+
+// HandleCallback serves the OAuth redirect: it exchanges the code and stores the link
+func (l *Linker) HandleCallback(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	state := q.Get("state")
+
+	l.mu.Lock()
+	p, ok := l.pending[state]
+	delete(l.pending, state)
+	l.mu.Unlock()
+
+	if !ok || time.Now().After(p.expires) {
+		l.writePage(w, http.StatusBadRequest, i18n.Default, "oauth.page.expired")
+		return
+	}
+	if errParam := q.Get("error"); errParam != "" {
+		l.logger.Infof("Twitch link refused by %s: %s", p.discordUserID, errParam)
+		l.writePage(w, http.StatusOK, p.lang, "oauth.page.denied")
+		return
+	}
+
+	link, err := l.complete(p.discordUserID, q.Get("code"))
+	if err != nil {
+		l.logger.Errorf("Twitch link failed for %s: %v", p.discordUserID, err)
+		l.writePage(w, http.StatusBadGateway, p.lang, "oauth.page.failed")
+		return
+	}
+	l.logger.Infof("Discord user %s linked to Twitch %s (%s)", link.DiscordUserID, link.TwitchLogin, link.TwitchUserID)
+	if l.OnLinked != nil {
+		l.OnLinked(link)
+	}
+	l.writePage(w, http.StatusOK, p.lang, "oauth.page.linked", link.TwitchLogin)
+}
+
+// complete exchanges an authorization code and stores the resulting link
+func (l *Linker) complete(discordUserID, code string) (*Link, error) {
+	tok, err := l.helix.ExchangeCode(code, l.redirectURL)
+	if err != nil {
+		return nil, err
+	}
+	users, err := l.helix.GetUsers(tok.AccessToken, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, fmt.Errorf("no user returned for the token")
+	}
+
+	// Replace a previous link of the same Discord user
+	old, replaced, _ := l.Get(discordUserID)
+	if replaced {
+		if err := l.helix.RevokeToken(old.AccessToken); err != nil {
+			l.logger.Warnf("failed to revoke previous token of %s: %v", discordUserID, err)
+		}
+	}
+
+	link := &Link{
+		DiscordUserID: discordUserID,
+		TwitchUserID:  users[0].ID,
+		TwitchLogin:   users[0].Login,
+		AccessToken:   tok.AccessToken,
+		RefreshToken:  tok.RefreshToken,
+		LinkedAt:      time.Now(),
+	}
+	if err := l.save(link); err != nil {
+		return nil, err
+	}
+	// The previous account is unlinked once the new link replaced it, so it is no longer among the links
+	if replaced && old.TwitchUserID != link.TwitchUserID && l.OnUnlinked != nil {
+		l.OnUnlinked(old)
+	}
+	return link, nil
+}
+
+// save stores a link, with its tokens encrypted when the linker has a key
+func (l *Linker) save(link *Link) error {
+	stored := *link
+	if l.tokens != nil {
+		stored.AccessToken = l.seal(link.AccessToken)
+		stored.RefreshToken = l.seal(link.RefreshToken)
+	}
+	return l.store.Put(linkBucket, link.DiscordUserID, stored)
+}
+
+// Get returns the link of a Discord user, with its tokens decrypted
+func (l *Linker) Get(discordUserID string) (*Link, bool, error) {
+	var link Link
+	ok, err := l.store.Get(linkBucket, discordUserID, &link)
+	if !ok || err != nil {
+		return nil, ok, err
+	}
+	if link.AccessToken, err = l.open(link.AccessToken); err != nil {
+		return nil, true, fmt.Errorf("cannot decrypt the tokens of %s: %w", discordUserID, err)
+	}
+	if link.RefreshToken, err = l.open(link.RefreshToken); err != nil {
+		return nil, true, fmt.Errorf("cannot decrypt the tokens of %s: %w", discordUserID, err)
+	}
+	return &link, true, nil
+}
+
+// seal encrypts a token
+func (l *Linker) seal(token string) string {
+	if token == "" {
+		return ""
+	}
+	nonce := make([]byte, l.tokens.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err) // the system random generator doesn't fail
+	}
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(l.tokens.Seal(nonce, nonce, []byte(token), nil))
+}
+
+// open decrypts a token stored by seal. Tokens stored in plaintext are returned as is.
+func (l *Linker) open(stored string) (string, error) {
+	data, ok := strings.CutPrefix(stored, encryptedPrefix)
+	if !ok {
+		return stored, nil
+	}
+	if l.tokens == nil {
+		return "", errors.New("the token is encrypted and no key is configured")
+	}
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return "", err
+	}
+	size := l.tokens.NonceSize()
+	if len(raw) < size {
+		return "", errors.New("encrypted token too short")
+	}
+	token, err := l.tokens.Open(nil, raw[:size], raw[size:], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(token), nil
+}
+
+// Links returns every stored link
+func (l *Linker) Links() ([]Link, error) {
+	var links []Link
+	for _, key := range l.store.Keys(linkBucket) {
+		link, ok, err := l.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			links = append(links, *link)
+		}
+	}
+	return links, nil
+}
+
+// Unlink revokes the Twitch token of a Discord user and deletes the link.
+// It returns the removed link, or nil when the user wasn't linked, and whether the token was revoked:
+// the link is deleted even when the revocation fails, so users can always unlink.
+func (l *Linker) Unlink(discordUserID string) (*Link, bool, error) {
+	link, ok, err := l.Get(discordUserID)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	revoked := true
+	if err := l.helix.RevokeToken(link.AccessToken); err != nil {
+		l.logger.Warnf("failed to revoke the token of %s, unlinking anyway: %v", discordUserID, err)
+		revoked = false
+	}
+	if err := l.store.Delete(linkBucket, discordUserID); err != nil {
+		return nil, revoked, err
+	}
+	if l.OnUnlinked != nil {
+		l.OnUnlinked(link)
+	}
+	return link, revoked, nil
+}
+
+// writePage answers the browser with a minimal HTML page
+func (l *Linker) writePage(w http.ResponseWriter, status int, lang, key string, args ...interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<!DOCTYPE html><html><head><meta charset=\"utf-8\"><title>TwitchLiveNotifier</title></head><body><p>%s</p></body></html>",
+		html.EscapeString(i18n.T(lang, key, args...)))
+}
@@ -0,0 +1,273 @@
+package twitch
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/flthibaud/TwitchLiveNotifier/internal/storage"
+	"github.com/sirupsen/logrus"
+)
+
+// fakeOAuthProvider emulates the Twitch OAuth endpoints and Helix /users
+type fakeOAuthProvider struct {
+	mu         sync.Mutex
+	revoked    []string
+	failRevoke bool // answer revocations with a server error
+}
+
+func (p *fakeOAuthProvider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/oauth2/token":
+		r.ParseForm()
+		if r.Form.Get("client_secret") != "secret" {
+			http.Error(w, `{"message":"invalid client secret"}`, http.StatusForbidden)
+			return
+		}
+		switch r.Form.Get("grant_type") {
+		case "authorization_code":
+			if r.Form.Get("code") != "good-code" {
+				http.Error(w, `{"message":"Invalid authorization code"}`, http.StatusBadRequest)
+				return
+			}
+			json.NewEncoder(w).Encode(Token{AccessToken: "user-token", RefreshToken: "refresh", ExpiresIn: 3600})
+		case "client_credentials":
+			json.NewEncoder(w).Encode(Token{AccessToken: "app-token", ExpiresIn: 3600})
+		}
+	case "/oauth2/revoke":
+		r.ParseForm()
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.failRevoke {
+			http.Error(w, `{"status":503,"message":"Service Unavailable"}`, http.StatusServiceUnavailable)
+			return
+		}
+		p.revoked = append(p.revoked, r.Form.Get("token"))
+	case "/helix/users":
+		if r.Header.Get("Authorization") != "Bearer user-token" {
+			http.Error(w, `{"message":"invalid token"}`, http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string][]User{"data": {{ID: "141981764", Login: "twitchdev"}}})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newTestLinker(t *testing.T) (*Linker, *fakeOAuthProvider) {
+	t.Helper()
+	return newTestLinkerWithKey(t, nil, "")
+}
+
+// newTestLinkerWithKey creates a linker encrypting the tokens with key, storing the links at path
+// (in memory when empty)
+func newTestLinkerWithKey(t *testing.T, key []byte, path string) (*Linker, *fakeOAuthProvider) {
+	t.Helper()
+	provider := &fakeOAuthProvider{}
+	srv := httptest.NewServer(provider)
+	t.Cleanup(srv.Close)
+
+	helix := NewClient("client", "secret")
+	helix.AuthURL = srv.URL + "/oauth2"
+	helix.HelixURL = srv.URL + "/helix"
+
+	store, err := storage.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := logrus.New()
+	logger.Out = io.Discard
+	return NewLinker(helix, store, "https://bot.example/oauth/callback", key, logger), provider
+}
+
+// callback runs the OAuth redirect against the linker and returns the response
+func callback(l *Linker, query url.Values) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	l.HandleCallback(rec, httptest.NewRequest("GET", "/oauth/callback?"+query.Encode(), nil))
+	return rec
+}
+
+func TestLinkFlow(t *testing.T) {
+	l, provider := newTestLinker(t)
+
+	authURL, err := l.AuthorizeURL("discord-1", "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(authURL)
+	if got := u.Query().Get("redirect_uri"); got != "https://bot.example/oauth/callback" {
+		t.Fatalf("redirect_uri = %q", got)
+	}
+	state := u.Query().Get("state")
+
+	rec := callback(l, url.Values{"code": {"good-code"}, "state": {state}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("callback status = %d: %s", rec.Code, rec.Body)
+	}
+	if !strings.Contains(rec.Body.String(), "twitchdev") {
+		t.Errorf("callback page doesn't mention the account: %s", rec.Body)
+	}
+
+	link, ok, err := l.Get("discord-1")
+	if err != nil || !ok {
+		t.Fatalf("link not stored: ok=%v err=%v", ok, err)
+	}
+	if link.TwitchUserID != "141981764" || link.TwitchLogin != "twitchdev" || link.AccessToken != "user-token" {
+		t.Errorf("unexpected link: %+v", link)
+	}
+
+	// The state can only be used once
+	if rec := callback(l, url.Values{"code": {"good-code"}, "state": {state}}); rec.Code != http.StatusBadRequest {
+		t.Errorf("replayed state status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	removed, revoked, err := l.Unlink("discord-1")
+	if err != nil || removed == nil || !revoked {
+		t.Fatalf("unlink: removed=%v revoked=%v err=%v", removed, revoked, err)
+	}
+	if len(provider.revoked) != 1 || provider.revoked[0] != "user-token" {
+		t.Errorf("revoked tokens = %v, want [user-token]", provider.revoked)
+	}
+	if _, ok, _ := l.Get("discord-1"); ok {
+		t.Error("link still stored after unlink")
+	}
+}
+
+func TestLinkCallbackErrors(t *testing.T) {
+	l, _ := newTestLinker(t)
+
+	if rec := callback(l, url.Values{"code": {"good-code"}, "state": {"unknown"}}); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown state status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	authURL, _ := l.AuthorizeURL("discord-2", "fr")
+	u, _ := url.Parse(authURL)
+	rec := callback(l, url.Values{"code": {"bad-code"}, "state": {u.Query().Get("state")}})
+	if rec.Code != http.StatusBadGateway {
+		t.Errorf("bad code status = %d, want %d", rec.Code, http.StatusBadGateway)
+	}
+	if _, ok, _ := l.Get("discord-2"); ok {
+		t.Error("link stored despite failed exchange")
+	}
+
+	authURL, _ = l.AuthorizeURL("discord-3", "en")
+	u, _ = url.Parse(authURL)
+	rec = callback(l, url.Values{"error": {"access_denied"}, "state": {u.Query().Get("state")}})
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "refused") {
+		t.Errorf("denied consent: status=%d body=%s", rec.Code, rec.Body)
+	}
+
+	if removed, _, err := l.Unlink("nobody"); removed != nil || err != nil {
+		t.Errorf("unlink of unknown user: removed=%v err=%v", removed, err)
+	}
+}
+
+func TestRelink(t *testing.T) {
+	l, provider := newTestLinker(t)
+	if err := l.store.Put(linkBucket, "discord-1", Link{DiscordUserID: "discord-1", TwitchUserID: "1001", TwitchLogin: "alice", AccessToken: "old-token"}); err != nil {
+		t.Fatal(err)
+	}
+	var unlinked []string
+	l.OnUnlinked = func(link *Link) {
+		// The previous account must no longer be linked when it is untracked
+		if current, _, _ := l.Get("discord-1"); current == nil || current.TwitchUserID == link.TwitchUserID {
+			t.Errorf("link of %s still stored when unlinked", link.TwitchUserID)
+		}
+		unlinked = append(unlinked, link.TwitchUserID)
+	}
+	relink := func() {
+		t.Helper()
+		authURL, _ := l.AuthorizeURL("discord-1", "en")
+		u, _ := url.Parse(authURL)
+		if rec := callback(l, url.Values{"code": {"good-code"}, "state": {u.Query().Get("state")}}); rec.Code != http.StatusOK {
+			t.Fatalf("callback status = %d: %s", rec.Code, rec.Body)
+		}
+	}
+
+	// Linking another account unlinks the previous one
+	relink()
+	if len(unlinked) != 1 || unlinked[0] != "1001" {
+		t.Errorf("unlinked accounts %v, want [1001]", unlinked)
+	}
+	if len(provider.revoked) != 1 || provider.revoked[0] != "old-token" {
+		t.Errorf("revoked tokens = %v, want [old-token]", provider.revoked)
+	}
+	if link, _, _ := l.Get("discord-1"); link == nil || link.TwitchUserID != "141981764" {
+		t.Errorf("link = %+v, want the new account", link)
+	}
+
+	// Linking the same account again only renews the tokens
+	relink()
+	if len(unlinked) != 1 {
+		t.Errorf("unlinked accounts %v, want the first account only", unlinked)
+	}
+}
+
+func TestUnlinkRevokeFailure(t *testing.T) {
+	l, provider := newTestLinker(t)
+	if err := l.store.Put(linkBucket, "discord-1", Link{DiscordUserID: "discord-1", TwitchLogin: "twitchdev", AccessToken: "user-token"}); err != nil {
+		t.Fatal(err)
+	}
+	provider.failRevoke = true
+
+	// A failed revocation doesn't prevent unlinking
+	removed, revoked, err := l.Unlink("discord-1")
+	if err != nil || removed == nil || revoked {
+		t.Fatalf("unlink: removed=%v revoked=%v err=%v, want the link removed without revocation", removed, revoked, err)
+	}
+	if _, ok, _ := l.Get("discord-1"); ok {
+		t.Error("link still stored after unlink")
+	}
+}
+
+func TestLinkTokensEncrypted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bot.json")
+	key := bytes.Repeat([]byte{7}, 32)
+
+	// A link stored before the key was configured
+	l, _ := newTestLinkerWithKey(t, nil, path)
+	if err := l.store.Put(linkBucket, "discord-1", Link{DiscordUserID: "discord-1", AccessToken: "old-token", RefreshToken: "old-refresh"}); err != nil {
+		t.Fatal(err)
+	}
+
+	l, _ = newTestLinkerWithKey(t, key, path)
+	if err := l.EncryptTokens(); err != nil {
+		t.Fatal(err)
+	}
+	authURL, _ := l.AuthorizeURL("discord-2", "en")
+	u, _ := url.Parse(authURL)
+	if rec := callback(l, url.Values{"code": {"good-code"}, "state": {u.Query().Get("state")}}); rec.Code != http.StatusOK {
+		t.Fatalf("callback status = %d: %s", rec.Code, rec.Body)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range []string{"old-token", "old-refresh", "user-token", "refresh\""} {
+		if bytes.Contains(data, []byte(token)) {
+			t.Errorf("token %s stored in plaintext: %s", token, data)
+		}
+	}
+	for id, want := range map[string]string{"discord-1": "old-token", "discord-2": "user-token"} {
+		if link, ok, err := l.Get(id); err != nil || !ok || link.AccessToken != want {
+			t.Errorf("Get(%s) = %+v, %v, %v, want the decrypted token %s", id, link, ok, err, want)
+		}
+	}
+
+	// The tokens can't be read without the key, or with another one
+	for _, other := range [][]byte{nil, bytes.Repeat([]byte{8}, 32)} {
+		l, _ := newTestLinkerWithKey(t, other, path)
+		if _, _, err := l.Get("discord-1"); err == nil {
+			t.Errorf("Get() with key %v decrypted the token", other)
+		}
+	}
+}
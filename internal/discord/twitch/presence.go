@@ -0,0 +1,214 @@
+package twitch
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/discord/commands"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/discordapi"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/i18n"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/storage"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// presenceBucket holds the members who opted in to presence detection (Discord user ID -> opt-in time)
+const presenceBucket = "presence_optin"
+
+// PresenceDetector announces opted-in members when Discord shows them streaming on Twitch.
+// It is a second source of stream events, next to EventSub, for broadcasters we don't subscribe to.
+type PresenceDetector struct {
+	server *WebhookServer
+	helix  *Client
+	store  *storage.Store
+	logger *logrus.Logger
+
+	mu        sync.Mutex
+	streaming map[string]*StreamEvent // by Discord user ID
+	users     map[string]User         // resolved Twitch users by login
+}
+
+// NewPresenceDetector creates a presence detector feeding the stream events of the server
+func NewPresenceDetector(server *WebhookServer, helix *Client, store *storage.Store, logger *logrus.Logger) *PresenceDetector {
+	return &PresenceDetector{
+		server:    server,
+		helix:     helix,
+		store:     store,
+		logger:    logger,
+		streaming: make(map[string]*StreamEvent),
+		users:     make(map[string]User),
+	}
+}
+
+// OptedIn reports whether a member opted in to presence detection
+func (d *PresenceDetector) OptedIn(userID string) bool {
+	var since time.Time
+	ok, _ := d.store.Get(presenceBucket, userID, &since)
+	return ok
+}
+
+// OptOut stops the presence detection of a member. A stream detected from their presence is ended:
+// their presence updates are ignored from now on, so its offline event would never come.
+func (d *PresenceDetector) OptOut(userID string) error {
+	if err := d.store.Delete(presenceBucket, userID); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	current := d.streaming[userID]
+	delete(d.streaming, userID)
+	d.mu.Unlock()
+	if current != nil {
+		ctx := utils.WithLogger(d.server.eventsCtx, d.logger.WithField("user_id", userID))
+		d.server.streamOffline(withBroadcaster(ctx, d.logger, current.BroadcasterID), current, SourcePresence)
+	}
+	return nil
+}
+
+// OnPresenceUpdate is the discordgo PresenceUpdate handler
+func (d *PresenceDetector) OnPresenceUpdate(s *discordgo.Session, p *discordgo.PresenceUpdate) {
+	if p.User == nil || !d.OptedIn(p.User.ID) {
+		return
+	}
+	userID := p.User.ID
+	ctx := utils.WithLogger(d.server.eventsCtx, d.logger.WithFields(logrus.Fields{"guild_id": p.GuildID, "user_id": userID}))
+	log := utils.Log(ctx, d.logger)
+
+	var activity *discordgo.Activity
+	for _, a := range p.Activities {
+		if a.Type == discordgo.ActivityTypeStreaming && TwitchLoginFromURL(a.URL) != "" {
+			activity = a
+			break
+		}
+	}
+
+	d.mu.Lock()
+	current := d.streaming[userID]
+	d.mu.Unlock()
+
+	switch {
+	case activity == nil && current != nil:
+		d.mu.Lock()
+		delete(d.streaming, userID)
+		d.mu.Unlock()
+		d.server.streamOffline(withBroadcaster(ctx, d.logger, current.BroadcasterID), current, SourcePresence)
+
+	case activity != nil && current == nil:
+		user, err := d.resolve(TwitchLoginFromURL(activity.URL))
+		if err != nil {
+			log.Errorf("failed to resolve Twitch user of %s: %v", activity.URL, err)
+			return
+		}
+		if user == nil {
+			return
+		}
+		ev := &StreamEvent{
+			BroadcasterID:    user.ID,
+			BroadcasterLogin: user.Login,
+			BroadcasterName:  user.DisplayName,
+			StartedAt:        time.Now(),
+		}
+		d.mu.Lock()
+		if d.streaming[userID] != nil {
+			// Another guild reported the same presence meanwhile
+			d.mu.Unlock()
+			return
+		}
+		d.streaming[userID] = ev
+		d.mu.Unlock()
+
+		ctx = withBroadcaster(ctx, d.logger, user.ID)
+		utils.Log(ctx, d.logger).Infof("Presence: %s is streaming on Twitch as %s", p.User.ID, user.Login)
+		fallback := &Stream{
+			UserID:    user.ID,
+			UserLogin: user.Login,
+			UserName:  user.DisplayName,
+			Title:     orDash(activity.Details),
+			GameName:  orDash(activity.State),
+			StartedAt: ev.StartedAt,
+		}
+		d.server.streamOnline(ctx, ev, SourcePresence, fallback)
+	}
+}
+
+// resolve looks up a Twitch user by login, with a cache
+func (d *PresenceDetector) resolve(login string) (*User, error) {
+	d.mu.Lock()
+	user, ok := d.users[login]
+	d.mu.Unlock()
+	if ok {
+		return &user, nil
+	}
+
+	users, err := d.helix.GetUsers("", nil, []string{login})
+	if err != nil || len(users) == 0 {
+		return nil, err
+	}
+	d.mu.Lock()
+	d.users[login] = users[0]
+	d.mu.Unlock()
+	return &users[0], nil
+}
+
+// TwitchLoginFromURL extracts the channel login of a Twitch URL (https://www.twitch.tv/login)
+func TwitchLoginFromURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	host := strings.TrimPrefix(strings.ToLower(u.Host), "www.")
+	if host != "twitch.tv" && host != "m.twitch.tv" {
+		return ""
+	}
+	login := strings.Trim(u.Path, "/")
+	if login == "" || strings.Contains(login, "/") {
+		return ""
+	}
+	return strings.ToLower(login)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// PresenceCommand defines the /presence command
+var PresenceCommand = i18n.Localize(&discordgo.ApplicationCommand{
+	Name: "presence",
+	Options: []*discordgo.ApplicationCommandOption{
+		{Type: discordgo.ApplicationCommandOptionSubCommand, Name: "optin"},
+		{Type: discordgo.ApplicationCommandOptionSubCommand, Name: "optout"},
+	},
+})
+
+// Command builds the /presence command letting members opt in to presence detection
+func (d *PresenceDetector) Command() *commands.Command {
+	return &commands.Command{
+		Definition: PresenceCommand,
+		Handler: func(s discordapi.Sender, i *discordgo.InteractionCreate) {
+			lang := commands.Lang(i)
+			userID := commands.InvokerID(i)
+			path, _ := commands.SubCommand(i.ApplicationCommandData().Options)
+
+			var err error
+			reply := i18n.T(lang, "presence.optin")
+			if path == "optout" {
+				reply = i18n.T(lang, "presence.optout")
+				err = d.OptOut(userID)
+			} else {
+				err = d.store.Put(presenceBucket, userID, time.Now())
+			}
+			if err != nil {
+				d.logger.Errorf("Cannot handle /presence %s: %v", path, err)
+				reply = i18n.T(lang, "twitch.error")
+			}
+			if err := commands.RespondEphemeral(s, i, reply); err != nil {
+				d.logger.Errorf("Cannot respond to /presence: %v", err)
+			}
+		},
+	}
+}
@@ -0,0 +1,187 @@
+package twitch
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/discordtest"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/twitchtest"
+	"github.com/sirupsen/logrus"
+)
+
+// presenceMember is the Discord user streaming in the presence tests
+const presenceMember = "800000000000000001"
+
+// newPresenceDetector returns a presence detector of an e2e server, with presenceMember opted in
+func newPresenceDetector(t *testing.T, e *e2e) *PresenceDetector {
+	t.Helper()
+	logger := logrus.New()
+	logger.Out = io.Discard
+	d := NewPresenceDetector(e.server, e.server.helix, e.store, logger)
+	if err := e.store.Put(presenceBucket, presenceMember, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// presence returns the presence update of a member, streaming at streamURL unless it is empty
+func presence(userID, streamURL string) *discordgo.PresenceUpdate {
+	p := &discordgo.PresenceUpdate{GuildID: e2eGuild}
+	p.User = &discordgo.User{ID: userID}
+	p.Activities = []*discordgo.Activity{{Type: discordgo.ActivityTypeGame, Name: "Celeste"}}
+	if streamURL != "" {
+		p.Activities = append(p.Activities, &discordgo.Activity{Type: discordgo.ActivityTypeStreaming, URL: streamURL, Details: "Any% practice", State: "Celeste"})
+	}
+	return p
+}
+
+// announcements returns the number of announcements sent
+func announcements(e *e2e) int {
+	sent := 0
+	for _, c := range e.discord.Calls() {
+		if c.Op == discordtest.OpSend {
+			sent++
+		}
+	}
+	return sent
+}
+
+func TestTwitchLoginFromURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://www.twitch.tv/Alice", "alice"},
+		{"https://twitch.tv/alice/", "alice"},
+		{"https://m.twitch.tv/alice", "alice"},
+		{"https://www.twitch.tv/", ""},
+		{"https://www.twitch.tv/alice/videos", ""},
+		{"https://www.youtube.com/alice", ""},
+		{"https://twitch.tv.example/alice", ""},
+		{"not a url %", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := TwitchLoginFromURL(tt.url); got != tt.want {
+			t.Errorf("TwitchLoginFromURL(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestPresenceStream(t *testing.T) {
+	e := newE2E(t, alice)
+	e.twitch.AddUser(carol)
+	e.start(t)
+	d := newPresenceDetector(t, e)
+
+	// Members who didn't opt in are ignored
+	d.OnPresenceUpdate(nil, presence("800000000000000002", "https://www.twitch.tv/bob"))
+	if _, ok := e.server.announcer.Session(bob.ID); ok {
+		t.Fatal("stream of a member who didn't opt in detected")
+	}
+
+	// A streaming activity starts a session, announced once even when other guilds report it
+	d.OnPresenceUpdate(nil, presence(presenceMember, "https://www.twitch.tv/carol"))
+	d.OnPresenceUpdate(nil, presence(presenceMember, "https://www.twitch.tv/carol"))
+	msg := e.discord.Wait(t, 1)[0]
+	if msg.Op != discordtest.OpSend || msg.ChannelID != e2eChannel || msg.Message.Embeds[0].Title != "🔴 Carol is live!" {
+		t.Fatalf("%s in %s %+v, want the announcement of carol", msg.Op, msg.ChannelID, msg.Message)
+	}
+	if sess, ok := e.server.announcer.Session(carol.ID); !ok || sess.Source != SourcePresence {
+		t.Fatalf("session of carol = %+v, want one opened by presence", sess)
+	}
+
+	// The activity ending closes it
+	d.OnPresenceUpdate(nil, presence(presenceMember, ""))
+	if _, ok := e.server.announcer.Session(carol.ID); ok {
+		t.Error("session still open after the streaming activity ended")
+	}
+	eventually(t, "queue drained", func() bool { return e.client.Queue().Depth() == 0 })
+	if n := announcements(e); n != 1 {
+		t.Errorf("%d announcements, want 1", n)
+	}
+}
+
+func TestPresenceOptOutWhileLive(t *testing.T) {
+	e := newE2E(t, alice)
+	e.twitch.AddUser(carol)
+	listener := &offlineListener{}
+	e.server.AddListener(listener)
+	e.start(t)
+	d := newPresenceDetector(t, e)
+
+	d.OnPresenceUpdate(nil, presence(presenceMember, "https://www.twitch.tv/carol"))
+	e.discord.Wait(t, 1)
+
+	// The presence updates stop with the opt-out: the stream is ended right away
+	if err := d.OptOut(presenceMember); err != nil {
+		t.Fatal(err)
+	}
+	if d.OptedIn(presenceMember) {
+		t.Error("member still opted in")
+	}
+	if _, ok := e.server.announcer.Session(carol.ID); ok {
+		t.Error("session still open after the opt-out")
+	}
+	eventually(t, "listeners notified", func() bool { return len(listener.closed()) == 1 })
+	if closed := listener.closed(); closed[0] != carol.ID {
+		t.Errorf("closed sessions %v, want carol's", closed)
+	}
+
+	// Nothing is left to end when the activity stops later
+	d.OnPresenceUpdate(nil, presence(presenceMember, ""))
+	if err := d.OptOut(presenceMember); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond) // listeners are notified in the background
+	if closed := listener.closed(); len(closed) != 1 {
+		t.Errorf("closed sessions %v, want carol's only", closed)
+	}
+}
+
+func TestPresenceWithEventSub(t *testing.T) {
+	e := newE2E(t, alice)
+	e.start(t)
+	e.twitch.SetLive(twitchtest.Stream{UserID: alice.ID, UserLogin: alice.Login, UserName: alice.DisplayName, GameName: "Celeste"})
+	d := newPresenceDetector(t, e)
+
+	// Presence first: EventSub completes the session instead of announcing again
+	d.OnPresenceUpdate(nil, presence(presenceMember, "https://www.twitch.tv/alice"))
+	e.discord.Wait(t, 1)
+	online := SimulateStreamOnline(simulatedUser(alice))
+	e.postEvent(t, online)
+	eventually(t, "session completed by EventSub", func() bool {
+		sess, ok := e.server.announcer.Session(alice.ID)
+		return ok && sess.StreamID == online.Event["id"] && sess.Source == SourceEventSub
+	})
+
+	// Presence can't close a session EventSub took over, EventSub closes it
+	d.OnPresenceUpdate(nil, presence(presenceMember, ""))
+	if _, ok := e.server.announcer.Session(alice.ID); !ok {
+		t.Fatal("presence closed the session of EventSub")
+	}
+	e.postEvent(t, SimulateStreamOffline(simulatedUser(alice)))
+	eventually(t, "session closed", func() bool {
+		_, ok := e.server.announcer.Session(alice.ID)
+		return !ok
+	})
+
+	// EventSub first: presence doesn't announce again
+	second := SimulateStreamOnline(simulatedUser(alice))
+	e.postEvent(t, second)
+	eventually(t, "announcement of the second stream", func() bool { return announcements(e) == 2 })
+	eventually(t, "session of the second stream", func() bool {
+		sess, ok := e.server.announcer.Session(alice.ID)
+		return ok && sess.StreamID == second.Event["id"]
+	})
+	d.OnPresenceUpdate(nil, presence(presenceMember, "https://www.twitch.tv/alice"))
+	if sess, _ := e.server.announcer.Session(alice.ID); sess.StreamID != second.Event["id"] || sess.Source != SourceEventSub {
+		t.Errorf("session = %+v, want the one of EventSub kept", sess)
+	}
+	eventually(t, "queue drained", func() bool { return e.client.Queue().Depth() == 0 })
+	if n := announcements(e); n != 2 {
+		t.Errorf("%d announcements, want one per stream", n)
+	}
+}
@@ -0,0 +1,358 @@
+package twitch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/config"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/discord"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/i18n"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/storage"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// scheduledEventBucket maps a Twitch schedule segment ID to the Discord event mirroring it
+	scheduledEventBucket = "scheduled_events"
+
+	// scheduleHorizon is how far ahead segments are mirrored
+	scheduleHorizon = 7 * 24 * time.Hour
+
+	// defaultSegmentDuration is used for segments without an end time (Discord requires one)
+	defaultSegmentDuration = 2 * time.Hour
+
+	// activationWindow is how early a stream may start and still activate its scheduled event
+	activationWindow = time.Hour
+)
+
+// ScheduleSegment is a stream planned in a broadcaster's Twitch schedule
+type ScheduleSegment struct {
+	ID            string     `json:"id"`
+	StartTime     time.Time  `json:"start_time"`
+	EndTime       *time.Time `json:"end_time"`
+	Title         string     `json:"title"`
+	CanceledUntil *time.Time `json:"canceled_until"`
+	Category      *struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"category"`
+	IsRecurring bool `json:"is_recurring"`
+}
+
+// Schedule is the Twitch schedule of a broadcaster
+type Schedule struct {
+	Segments         []ScheduleSegment `json:"segments"`
+	BroadcasterID    string            `json:"broadcaster_id"`
+	BroadcasterName  string            `json:"broadcaster_name"`
+	BroadcasterLogin string            `json:"broadcaster_login"`
+}
+
+// GetSchedule fetches the schedule segments of a broadcaster starting after start, following pagination.
+// A broadcaster without a schedule returns an empty schedule.
+func (c *Client) GetSchedule(broadcasterID string, start, end time.Time) (*Schedule, error) {
+	schedule := &Schedule{BroadcasterID: broadcasterID}
+	cursor := ""
+	for {
+		q := url.Values{
+			"broadcaster_id": {broadcasterID},
+			"start_time":     {start.UTC().Format(time.RFC3339)},
+			"first":          {"25"},
+		}
+		if cursor != "" {
+			q.Set("after", cursor)
+		}
+		var data struct {
+			Data       *Schedule `json:"data"`
+			Pagination struct {
+				Cursor string `json:"cursor"`
+			} `json:"pagination"`
+		}
+		err := c.get("/schedule", q, "", &data)
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound {
+			return schedule, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if data.Data == nil {
+			return schedule, nil
+		}
+		schedule.BroadcasterName = data.Data.BroadcasterName
+		schedule.BroadcasterLogin = data.Data.BroadcasterLogin
+		for _, seg := range data.Data.Segments {
+			if seg.StartTime.After(end) {
+				return schedule, nil
+			}
+			schedule.Segments = append(schedule.Segments, seg)
+		}
+		if data.Pagination.Cursor == "" || len(data.Data.Segments) == 0 {
+			return schedule, nil
+		}
+		cursor = data.Pagination.Cursor
+	}
+}
+
+// MirroredEvent is a Discord Guild Scheduled Event mirroring a Twitch schedule segment
+type MirroredEvent struct {
+	SegmentID        string                              `json:"segment_id"`
+	GuildID          string                              `json:"guild_id"`
+	EventID          string                              `json:"event_id"`
+	BroadcasterID    string                              `json:"broadcaster_id"`
+	BroadcasterName  string                              `json:"broadcaster_name"`
+	BroadcasterLogin string                              `json:"broadcaster_login"`
+	Title            string                              `json:"title"`
+	Category         string                              `json:"category,omitempty"`
+	Start            time.Time                           `json:"start"`
+	End              time.Time                           `json:"end"`
+	Status           discordgo.GuildScheduledEventStatus `json:"status"`
+}
+
+// ScheduleSync mirrors the Twitch schedule of followed broadcasters as external
+// Guild Scheduled Events in the guild of the notification channel, and follows
+// their lifecycle with stream events (active when live, completed when offline).
+type ScheduleSync struct {
+	cfg           *config.Config
+	discordClient *discord.Client
+	helix         *Client
+	store         *storage.Store
+	logger        *logrus.Logger
+
+	mu sync.Mutex
+}
+
+// NewScheduleSync creates the schedule synchronizer
+func NewScheduleSync(cfg *config.Config, discordClient *discord.Client, helix *Client, store *storage.Store, logger *logrus.Logger) *ScheduleSync {
+	return &ScheduleSync{
+		cfg:           cfg,
+		discordClient: discordClient,
+		helix:         helix,
+		store:         store,
+		logger:        logger,
+	}
+}
+
+// Run synchronizes schedules every interval until ctx is done
+func (s *ScheduleSync) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		s.SyncAll()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SyncAll synchronizes the schedule of every followed broadcaster
+func (s *ScheduleSync) SyncAll() {
+	guildID := s.discordClient.ChannelGuildID(s.cfg.NotifyChannel())
+	if guildID == "" {
+		s.logger.Warn("Schedule sync: guild of the notification channel is unknown, skipping")
+		return
+	}
+	for _, broadcasterID := range s.cfg.BroadcasterIDs() {
+		if err := s.sync(guildID, broadcasterID); err != nil {
+			s.logger.Errorf("Schedule sync failed for %s: %v", broadcasterID, err)
+		}
+	}
+}
+
+// sync mirrors the upcoming segments of a broadcaster: creates new ones, updates
+// rescheduled ones, and cancels those removed from the Twitch schedule
+func (s *ScheduleSync) sync(guildID, broadcasterID string) error {
+	now := time.Now()
+	schedule, err := s.helix.GetSchedule(broadcasterID, now, now.Add(scheduleHorizon))
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lang := s.discordClient.ChannelLanguage(s.cfg.NotifyChannel())
+	seen := map[string]bool{}
+	for _, seg := range schedule.Segments {
+		if seg.CanceledUntil != nil {
+			continue // vacation or canceled occurrence: handled as a removal
+		}
+		seen[seg.ID] = true
+
+		want := MirroredEvent{
+			SegmentID:        seg.ID,
+			GuildID:          guildID,
+			BroadcasterID:    broadcasterID,
+			BroadcasterName:  schedule.BroadcasterName,
+			BroadcasterLogin: schedule.BroadcasterLogin,
+			Title:            seg.Title,
+			Start:            seg.StartTime,
+			End:              seg.StartTime.Add(defaultSegmentDuration),
+			Status:           discordgo.GuildScheduledEventStatusScheduled,
+		}
+		if seg.EndTime != nil {
+			want.End = *seg.EndTime
+		}
+		if seg.Category != nil {
+			want.Category = seg.Category.Name
+		}
+
+		var cur MirroredEvent
+		ok, err := s.store.Get(scheduledEventBucket, seg.ID, &cur)
+		if err != nil {
+			return err
+		}
+		switch {
+		case !ok:
+			if !want.Start.After(now) {
+				continue // Discord only accepts events starting in the future
+			}
+			ev, err := s.discordClient.CreateScheduledEvent(guildID, s.params(lang, &want))
+			if err != nil {
+				s.logger.Errorf("failed to create scheduled event for segment %s: %v", seg.ID, err)
+				continue
+			}
+			want.EventID = ev.ID
+			s.logger.Infof("Scheduled event created for %s on %s", want.BroadcasterName, want.Start.Format(time.RFC3339))
+		case cur.Status != discordgo.GuildScheduledEventStatusScheduled:
+			continue // live or over, leave it to stream events
+		case cur.Start.Equal(want.Start) && cur.End.Equal(want.End) && cur.Title == want.Title && cur.Category == want.Category:
+			continue
+		default:
+			want.EventID = cur.EventID
+			if _, err := s.discordClient.EditScheduledEvent(guildID, cur.EventID, s.params(lang, &want)); err != nil {
+				s.logger.Errorf("failed to update scheduled event %s: %v", cur.EventID, err)
+				continue
+			}
+			s.logger.Infof("Scheduled event of %s rescheduled to %s", want.BroadcasterName, want.Start.Format(time.RFC3339))
+		}
+		if err := s.store.Put(scheduledEventBucket, seg.ID, want); err != nil {
+			return err
+		}
+	}
+
+	// Segments no longer in the schedule
+	for _, ev := range s.events(broadcasterID) {
+		if seen[ev.SegmentID] {
+			continue
+		}
+		if ev.Status == discordgo.GuildScheduledEventStatusScheduled && ev.Start.After(now) {
+			s.setStatus(&ev, discordgo.GuildScheduledEventStatusCanceled)
+			continue
+		}
+		if ev.Status != discordgo.GuildScheduledEventStatusActive && ev.End.Before(now) {
+			// Past segment, forget it
+			s.store.Delete(scheduledEventBucket, ev.SegmentID)
+		}
+	}
+	return nil
+}
+
+// params builds the Discord event parameters of a mirrored segment
+func (s *ScheduleSync) params(lang string, ev *MirroredEvent) *discordgo.GuildScheduledEventParams {
+	name := ev.Title
+	if name == "" {
+		name = i18n.T(lang, "schedule.default_name", ev.BroadcasterName)
+	}
+	if runes := []rune(name); len(runes) > 100 {
+		name = string(runes[:100])
+	}
+	description := i18n.T(lang, "schedule.description", ev.BroadcasterName)
+	if ev.Category != "" {
+		description = fmt.Sprintf("%s\n%s", description, i18n.T(lang, "schedule.category", ev.Category))
+	}
+	start, end := ev.Start, ev.End
+	return &discordgo.GuildScheduledEventParams{
+		Name:               name,
+		Description:        description,
+		ScheduledStartTime: &start,
+		ScheduledEndTime:   &end,
+		PrivacyLevel:       discordgo.GuildScheduledEventPrivacyLevelGuildOnly,
+		EntityType:         discordgo.GuildScheduledEventEntityTypeExternal,
+		EntityMetadata: &discordgo.GuildScheduledEventEntityMetadata{
+			Location: "https://twitch.tv/" + ev.BroadcasterLogin,
+		},
+	}
+}
+
+// events returns the mirrored events of a broadcaster, sorted by start time
+func (s *ScheduleSync) events(broadcasterID string) []MirroredEvent {
+	var events []MirroredEvent
+	for _, key := range s.store.Keys(scheduledEventBucket) {
+		var ev MirroredEvent
+		if ok, err := s.store.Get(scheduledEventBucket, key, &ev); ok && err == nil && (broadcasterID == "" || ev.BroadcasterID == broadcasterID) {
+			events = append(events, ev)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Start.Before(events[j].Start) })
+	return events
+}
+
+// Upcoming returns the scheduled streams mirrored between now and until
+func (s *ScheduleSync) Upcoming(until time.Time) []MirroredEvent {
+	var upcoming []MirroredEvent
+	now := time.Now()
+	for _, ev := range s.events("") {
+		if ev.Status == discordgo.GuildScheduledEventStatusScheduled && ev.Start.After(now) && ev.Start.Before(until) {
+			upcoming = append(upcoming, ev)
+		}
+	}
+	return upcoming
+}
+
+// setStatus changes the status of a mirrored event on Discord and in the store
+func (s *ScheduleSync) setStatus(ev *MirroredEvent, status discordgo.GuildScheduledEventStatus) {
+	_, err := s.discordClient.EditScheduledEvent(ev.GuildID, ev.EventID, &discordgo.GuildScheduledEventParams{Status: status})
+	if err != nil {
+		s.logger.Errorf("failed to set status %d on scheduled event %s: %v", status, ev.EventID, err)
+		return
+	}
+	ev.Status = status
+	if status == discordgo.GuildScheduledEventStatusCanceled || status == discordgo.GuildScheduledEventStatusCompleted {
+		s.store.Delete(scheduledEventBucket, ev.SegmentID)
+		return
+	}
+	if err := s.store.Put(scheduledEventBucket, ev.SegmentID, ev); err != nil {
+		s.logger.Errorf("failed to save scheduled event %s: %v", ev.EventID, err)
+	}
+}
+
+// StreamOnline marks the scheduled event of the stream as active: the closest
+// scheduled segment that started, or starts within the activation window
+func (s *ScheduleSync) StreamOnline(ctx context.Context, ev *StreamEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for _, mirrored := range s.events(ev.BroadcasterID) {
+		if mirrored.Status != discordgo.GuildScheduledEventStatusScheduled {
+			continue
+		}
+		if mirrored.Start.Add(-activationWindow).Before(now) && mirrored.End.After(now) {
+			s.setStatus(&mirrored, discordgo.GuildScheduledEventStatusActive)
+			utils.Log(ctx, s.logger).WithField("guild_id", mirrored.GuildID).Infof("Scheduled event of %s is now active", ev.BroadcasterName)
+			return
+		}
+	}
+}
+
+// StreamOffline completes the active scheduled events of the broadcaster
+func (s *ScheduleSync) StreamOffline(ctx context.Context, ev *StreamEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, mirrored := range s.events(ev.BroadcasterID) {
+		if mirrored.Status == discordgo.GuildScheduledEventStatusActive {
+			s.setStatus(&mirrored, discordgo.GuildScheduledEventStatusCompleted)
+		}
+	}
+}
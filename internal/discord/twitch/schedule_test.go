@@ -0,0 +1,185 @@
+package twitch
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/discordtest"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/twitchtest"
+	"github.com/sirupsen/logrus"
+)
+
+// newScheduleSync returns the schedule synchronizer of an e2e server, for the guild of e2eChannel
+func newScheduleSync(e *e2e) *ScheduleSync {
+	logger := logrus.New()
+	logger.Out = io.Discard
+	return NewScheduleSync(e.cfg, e.client, e.server.helix, e.store, logger)
+}
+
+// mirrored returns the mirrored event of a segment
+func mirrored(t *testing.T, e *e2e, segmentID string) (MirroredEvent, bool) {
+	t.Helper()
+	var ev MirroredEvent
+	ok, err := e.store.Get(scheduledEventBucket, segmentID, &ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ev, ok
+}
+
+// eventCall checks that a recorded call is an operation on a scheduled event of e2eGuild
+func eventCall(t *testing.T, c discordtest.Call, op string) *discordgo.GuildScheduledEventParams {
+	t.Helper()
+	if c.Op != op || c.GuildID != e2eGuild || c.Event == nil {
+		t.Fatalf("Discord call %s on guild %s, want %s on %s", c.Op, c.GuildID, op, e2eGuild)
+	}
+	return c.Event
+}
+
+func TestScheduleSync(t *testing.T) {
+	e := newE2E(t, alice)
+	s := newScheduleSync(e)
+	now := time.Now().UTC().Truncate(time.Second)
+	end := now.Add(4 * time.Hour)
+	canceled := now.Add(6 * time.Hour)
+	e.twitch.SetSchedule(alice.ID, []twitchtest.Segment{
+		{ID: "speedrun", StartTime: now.Add(2 * time.Hour), EndTime: &end, Title: "Speedrun", Category: &twitchtest.Category{ID: celesteID, Name: "Celeste"}},
+		{ID: "chill", StartTime: now.Add(26 * time.Hour)},
+		{ID: "vacation", StartTime: now.Add(5 * time.Hour), CanceledUntil: &canceled},
+	})
+
+	// New segments are created, canceled ones are skipped
+	s.SyncAll()
+	calls := e.discord.Calls()
+	if len(calls) != 2 {
+		t.Fatalf("%d Discord calls, want the creation of 2 events: %+v", len(calls), calls)
+	}
+	speedrun := eventCall(t, calls[0], discordtest.OpCreateEvent)
+	if speedrun.Name != "Speedrun" || !speedrun.ScheduledStartTime.Equal(now.Add(2*time.Hour)) || !speedrun.ScheduledEndTime.Equal(end) {
+		t.Errorf("created %q from %s to %s, want the speedrun segment", speedrun.Name, speedrun.ScheduledStartTime, speedrun.ScheduledEndTime)
+	}
+	if speedrun.EntityMetadata.Location != "https://twitch.tv/alice" {
+		t.Errorf("location = %s, want the channel of alice", speedrun.EntityMetadata.Location)
+	}
+	chill := eventCall(t, calls[1], discordtest.OpCreateEvent)
+	if !chill.ScheduledEndTime.Equal(now.Add(26*time.Hour + defaultSegmentDuration)) {
+		t.Errorf("end of a segment without end time = %s, want the default duration", chill.ScheduledEndTime)
+	}
+	if _, ok := mirrored(t, e, "vacation"); ok {
+		t.Error("canceled segment mirrored")
+	}
+
+	// An unchanged schedule changes nothing
+	e.discord.Reset()
+	s.SyncAll()
+	if calls := e.discord.Calls(); len(calls) != 0 {
+		t.Fatalf("unchanged schedule made %d Discord calls: %+v", len(calls), calls)
+	}
+
+	// A rescheduled segment is updated, a removed one is canceled
+	mirroredSpeedrun, _ := mirrored(t, e, "speedrun")
+	mirroredChill, _ := mirrored(t, e, "chill")
+	end = now.Add(5 * time.Hour)
+	e.twitch.SetSchedule(alice.ID, []twitchtest.Segment{
+		{ID: "speedrun", StartTime: now.Add(3 * time.Hour), EndTime: &end, Title: "Speedrun", Category: &twitchtest.Category{ID: celesteID, Name: "Celeste"}},
+	})
+	s.SyncAll()
+	calls = e.discord.Calls()
+	if len(calls) != 2 {
+		t.Fatalf("%d Discord calls, want an update and a cancellation: %+v", len(calls), calls)
+	}
+	update := eventCall(t, calls[0], discordtest.OpEditEvent)
+	if calls[0].EventID != mirroredSpeedrun.EventID || !update.ScheduledStartTime.Equal(now.Add(3*time.Hour)) {
+		t.Errorf("updated event %s to %s, want %s at %s", calls[0].EventID, update.ScheduledStartTime, mirroredSpeedrun.EventID, now.Add(3*time.Hour))
+	}
+	cancel := eventCall(t, calls[1], discordtest.OpEditEvent)
+	if calls[1].EventID != mirroredChill.EventID || cancel.Status != discordgo.GuildScheduledEventStatusCanceled {
+		t.Errorf("edited event %s with status %d, want %s canceled", calls[1].EventID, cancel.Status, mirroredChill.EventID)
+	}
+	if _, ok := mirrored(t, e, "chill"); ok {
+		t.Error("canceled event still stored")
+	}
+	if ev, _ := mirrored(t, e, "speedrun"); !ev.Start.Equal(now.Add(3 * time.Hour)) {
+		t.Errorf("stored start = %s, want the new one", ev.Start)
+	}
+}
+
+func TestScheduleSyncCreateFailure(t *testing.T) {
+	e := newE2E(t, alice)
+	s := newScheduleSync(e)
+	e.twitch.SetSchedule(alice.ID, []twitchtest.Segment{{ID: "speedrun", StartTime: time.Now().Add(2 * time.Hour)}})
+
+	// A segment that couldn't be created is created at the next sync
+	e.discord.FailNext(discordtest.OpCreateEvent, errors.New("HTTP 403 Forbidden, Missing Permissions"))
+	s.SyncAll()
+	if _, ok := mirrored(t, e, "speedrun"); ok {
+		t.Fatal("event stored although its creation failed")
+	}
+	s.SyncAll()
+	if ev, ok := mirrored(t, e, "speedrun"); !ok || ev.EventID == "" {
+		t.Fatalf("event not created by the next sync: %+v", ev)
+	}
+	if n := len(e.discord.Calls()); n != 2 {
+		t.Errorf("%d Discord calls, want 2 creations", n)
+	}
+}
+
+func TestScheduleStreamLifecycle(t *testing.T) {
+	e := newE2E(t, alice, bob)
+	s := newScheduleSync(e)
+	now := time.Now().UTC().Truncate(time.Second)
+	e.twitch.SetSchedule(alice.ID, []twitchtest.Segment{
+		{ID: "soon", StartTime: now.Add(30 * time.Minute)},
+		{ID: "tomorrow", StartTime: now.Add(24 * time.Hour)},
+	})
+	s.SyncAll()
+	soon, _ := mirrored(t, e, "soon")
+	e.discord.Reset()
+
+	// A stream without a segment starting soon activates nothing
+	ctx := context.Background()
+	s.StreamOnline(ctx, &StreamEvent{BroadcasterID: bob.ID, BroadcasterName: bob.DisplayName})
+	if calls := e.discord.Calls(); len(calls) != 0 {
+		t.Fatalf("stream of bob made %d Discord calls: %+v", len(calls), calls)
+	}
+
+	// Going live within the activation window starts the closest event, only
+	s.StreamOnline(ctx, &StreamEvent{BroadcasterID: alice.ID, BroadcasterName: alice.DisplayName})
+	calls := e.discord.Calls()
+	if len(calls) != 1 {
+		t.Fatalf("%d Discord calls, want the activation of the event: %+v", len(calls), calls)
+	}
+	if p := eventCall(t, calls[0], discordtest.OpEditEvent); calls[0].EventID != soon.EventID || p.Status != discordgo.GuildScheduledEventStatusActive {
+		t.Errorf("edited event %s with status %d, want %s active", calls[0].EventID, p.Status, soon.EventID)
+	}
+	if ev, _ := mirrored(t, e, "soon"); ev.Status != discordgo.GuildScheduledEventStatusActive {
+		t.Errorf("stored status = %d, want active", ev.Status)
+	}
+
+	// A sync leaves the live event to the stream events
+	e.discord.Reset()
+	s.SyncAll()
+	if calls := e.discord.Calls(); len(calls) != 0 {
+		t.Fatalf("sync of a live event made %d Discord calls: %+v", len(calls), calls)
+	}
+
+	// Going offline completes it
+	s.StreamOffline(ctx, &StreamEvent{BroadcasterID: alice.ID, BroadcasterName: alice.DisplayName})
+	calls = e.discord.Calls()
+	if len(calls) != 1 {
+		t.Fatalf("%d Discord calls, want the completion of the event: %+v", len(calls), calls)
+	}
+	if p := eventCall(t, calls[0], discordtest.OpEditEvent); calls[0].EventID != soon.EventID || p.Status != discordgo.GuildScheduledEventStatusCompleted {
+		t.Errorf("edited event %s with status %d, want %s completed", calls[0].EventID, p.Status, soon.EventID)
+	}
+	if _, ok := mirrored(t, e, "soon"); ok {
+		t.Error("completed event still stored")
+	}
+	if ev, ok := mirrored(t, e, "tomorrow"); !ok || ev.Status != discordgo.GuildScheduledEventStatusScheduled {
+		t.Errorf("event of tomorrow = %+v, want it still scheduled", ev)
+	}
+}
@@ -0,0 +1,165 @@
+package twitch
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// EventSub message types, sent in the Twitch-Eventsub-Message-Type header
+const (
+	MessageVerification = "webhook_callback_verification"
+	MessageNotification = "notification"
+	MessageRevocation   = "revocation"
+)
+
+// SimulatedMessage is an EventSub message built locally, to test the webhook without a real stream
+type SimulatedMessage struct {
+	Type             string // MessageVerification, MessageNotification or MessageRevocation
+	SubscriptionType string
+	Condition        map[string]string
+	Event            map[string]interface{} // notifications only
+	Challenge        string                 // verifications only
+	Status           string                 // status of the subscription, e.g. authorization_revoked for revocations
+	Callback         string
+}
+
+// Request builds the POST request of the message to the webhook at target, with the
+// Twitch-Eventsub-* headers and the signature Twitch computes with secret
+func (m *SimulatedMessage) Request(target, secret string) (*http.Request, error) {
+	status := m.Status
+	if status == "" {
+		status = "enabled"
+		if m.Type == MessageVerification {
+			status = "webhook_callback_verification_pending"
+		}
+	}
+	version := "1"
+	if v, ok := subscriptionVersions[m.SubscriptionType]; ok {
+		version = v
+	}
+	now := time.Now().UTC()
+	sub := map[string]interface{}{
+		"id":         randomID(),
+		"status":     status,
+		"type":       m.SubscriptionType,
+		"version":    version,
+		"cost":       0,
+		"condition":  m.Condition,
+		"transport":  map[string]string{"method": "webhook", "callback": m.Callback},
+		"created_at": now.Format(time.RFC3339Nano),
+	}
+	payload := map[string]interface{}{"subscription": sub}
+	switch m.Type {
+	case MessageVerification:
+		payload["challenge"] = m.Challenge
+	case MessageNotification:
+		payload["event"] = m.Event
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	msgID := randomID()
+	timestamp := now.Format(time.RFC3339Nano)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(msgID + timestamp + string(body)))
+
+	req, err := http.NewRequest("POST", target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Twitch-Eventsub-Message-Id", msgID)
+	req.Header.Set("Twitch-Eventsub-Message-Retry", "0")
+	req.Header.Set("Twitch-Eventsub-Message-Type", m.Type)
+	req.Header.Set("Twitch-Eventsub-Message-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	req.Header.Set("Twitch-Eventsub-Message-Timestamp", timestamp)
+	req.Header.Set("Twitch-Eventsub-Subscription-Type", m.SubscriptionType)
+	req.Header.Set("Twitch-Eventsub-Subscription-Version", version)
+	return req, nil
+}
+
+// SimulatedUser is a broadcaster of a simulated event
+type SimulatedUser struct {
+	ID, Login, Name string
+}
+
+func (u SimulatedUser) fields(prefix string) map[string]interface{} {
+	return map[string]interface{}{
+		prefix + "_user_id":    u.ID,
+		prefix + "_user_login": u.Login,
+		prefix + "_user_name":  u.Name,
+	}
+}
+
+// SimulateStreamOnline builds the stream.online notification of a broadcaster going live now
+func SimulateStreamOnline(b SimulatedUser) *SimulatedMessage {
+	event := b.fields("broadcaster")
+	event["id"] = randomDigits()
+	event["type"] = "live"
+	event["started_at"] = time.Now().UTC().Format(time.RFC3339)
+	return &SimulatedMessage{Type: MessageNotification, SubscriptionType: "stream.online", Condition: SubscriptionCondition("stream.online", b.ID), Event: event}
+}
+
+// SimulateStreamOffline builds the stream.offline notification of a broadcaster
+func SimulateStreamOffline(b SimulatedUser) *SimulatedMessage {
+	return &SimulatedMessage{Type: MessageNotification, SubscriptionType: "stream.offline", Condition: SubscriptionCondition("stream.offline", b.ID), Event: b.fields("broadcaster")}
+}
+
+// SimulateChannelUpdate builds the channel.update notification of a broadcaster changing its title or category
+func SimulateChannelUpdate(b SimulatedUser, title, categoryID, categoryName string) *SimulatedMessage {
+	event := b.fields("broadcaster")
+	event["title"] = title
+	event["language"] = "fr"
+	event["category_id"] = categoryID
+	event["category_name"] = categoryName
+	event["content_classification_labels"] = []string{}
+	return &SimulatedMessage{Type: MessageNotification, SubscriptionType: "channel.update", Condition: SubscriptionCondition("channel.update", b.ID), Event: event}
+}
+
+// SimulateRaid builds the channel.raid notification of from raiding b with viewers
+func SimulateRaid(from, b SimulatedUser, viewers int) *SimulatedMessage {
+	event := from.fields("from_broadcaster")
+	for k, v := range b.fields("to_broadcaster") {
+		event[k] = v
+	}
+	event["viewers"] = viewers
+	return &SimulatedMessage{Type: MessageNotification, SubscriptionType: "channel.raid", Condition: SubscriptionCondition("channel.raid", b.ID), Event: event}
+}
+
+// SimulateVerification builds the challenge Twitch sends to verify the callback of a new subscription
+func SimulateVerification(subType string, b SimulatedUser) *SimulatedMessage {
+	return &SimulatedMessage{Type: MessageVerification, SubscriptionType: subType, Condition: SubscriptionCondition(subType, b.ID), Challenge: randomID()}
+}
+
+// SimulateRevocation builds the revocation of a subscription, with the reason as status
+// (e.g. authorization_revoked, user_removed, notification_failures_exceeded)
+func SimulateRevocation(subType string, b SimulatedUser, status string) *SimulatedMessage {
+	return &SimulatedMessage{Type: MessageRevocation, SubscriptionType: subType, Condition: SubscriptionCondition(subType, b.ID), Status: status}
+}
+
+// randomID returns a random UUID-like identifier, like the IDs of Twitch messages and subscriptions
+func randomID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// randomDigits returns a random numeric identifier, like the IDs of streams
+func randomDigits() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	n := uint64(0)
+	for _, v := range b {
+		n = n<<8 | uint64(v)
+	}
+	return fmt.Sprintf("%d", n%90000000000+10000000000)
+}
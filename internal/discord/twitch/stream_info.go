@@ -1,9 +1,7 @@
 package twitch
 
 import (
-	"encoding/json"
-	"fmt"
-	"net/http"
+	"net/url"
 	"time"
 )
 
@@ -11,6 +9,7 @@ import (
 type Stream struct {
 	ID           string    `json:"id"`
 	UserID       string    `json:"user_id"`
+	UserLogin    string    `json:"user_login"`
 	UserName     string    `json:"user_name"`
 	GameID       string    `json:"game_id"`
 	GameName     string    `json:"game_name"`
@@ -29,27 +28,9 @@ type streamsResponse struct {
 
 // GetStreamInfo fetches stream information for the given broadcaster ID.
 // Returns a pointer to Stream if live, or nil if offline.
-func GetStreamInfo(broadcasterID, clientID, oauthToken string) (*Stream, error) {
-	url := fmt.Sprintf("https://api.twitch.tv/helix/streams?user_id=%s", broadcasterID)
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Client-ID", clientID)
-	req.Header.Set("Authorization", "Bearer "+oauthToken)
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode/100 != 2 {
-		return nil, fmt.Errorf("twitch API error: %s", resp.Status)
-	}
-
+func (c *Client) GetStreamInfo(broadcasterID string) (*Stream, error) {
 	var sr streamsResponse
-	if err := json.NewDecoder(resp.Body).Decode(&sr); err != nil {
+	if err := c.get("/streams", url.Values{"user_id": {broadcasterID}}, "", &sr); err != nil {
 		return nil, err
 	}
 
@@ -58,3 +39,22 @@ func GetStreamInfo(broadcasterID, clientID, oauthToken string) (*Stream, error)
 	}
 	return &sr.Data[0], nil
 }
+
+// GetStreams fetches the live streams of the given broadcaster IDs, 100 IDs per request.
+// Offline broadcasters are absent from the result.
+func (c *Client) GetStreams(broadcasterIDs []string) ([]Stream, error) {
+	var streams []Stream
+	for start := 0; start < len(broadcasterIDs); start += 100 {
+		end := start + 100
+		if end > len(broadcasterIDs) {
+			end = len(broadcasterIDs)
+		}
+		q := url.Values{"user_id": broadcasterIDs[start:end], "first": {"100"}}
+		var sr streamsResponse
+		if err := c.get("/streams", q, "", &sr); err != nil {
+			return nil, err
+		}
+		streams = append(streams, sr.Data...)
+	}
+	return streams, nil
+}
@@ -0,0 +1,151 @@
+package twitch
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/discord"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/i18n"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// vodMatchTolerance is the maximum gap between the start of a session and the creation of its archive
+const vodMatchTolerance = 10 * time.Minute
+
+// vodRetryDelays are the waits between two lookups of the archive of an ended stream,
+// Twitch can take a while to publish it
+var vodRetryDelays = []time.Duration{
+	30 * time.Second,
+	time.Minute,
+	2 * time.Minute,
+	5 * time.Minute,
+	10 * time.Minute,
+	15 * time.Minute,
+}
+
+// Video represents a Twitch video returned by Helix /videos
+type Video struct {
+	ID        string    `json:"id"`
+	StreamID  string    `json:"stream_id"`
+	UserID    string    `json:"user_id"`
+	UserLogin string    `json:"user_login"`
+	UserName  string    `json:"user_name"`
+	Title     string    `json:"title"`
+	URL       string    `json:"url"`
+	CreatedAt time.Time `json:"created_at"`
+	Duration  string    `json:"duration"` // e.g. 3h8m33s
+	Type      string    `json:"type"`
+}
+
+// GetArchives fetches the latest archives (past broadcasts) of a broadcaster
+func (c *Client) GetArchives(broadcasterID string, first int) ([]Video, error) {
+	var data struct {
+		Data []Video `json:"data"`
+	}
+	q := url.Values{"user_id": {broadcasterID}, "type": {"archive"}, "first": {strconv.Itoa(first)}}
+	if err := c.get("/videos", q, "", &data); err != nil {
+		return nil, err
+	}
+	return data.Data, nil
+}
+
+// MatchArchive returns the archive of a session: the video of its stream ID, or, when the
+// stream ID is unknown, the video created closest to the start of the session
+func MatchArchive(sess *Session, videos []Video) *Video {
+	var best *Video
+	var bestGap time.Duration
+	for i := range videos {
+		v := &videos[i]
+		if sess.StreamID != "" && v.StreamID != "" {
+			if v.StreamID == sess.StreamID {
+				return v
+			}
+			continue
+		}
+		gap := v.CreatedAt.Sub(sess.StartedAt)
+		if gap < 0 {
+			gap = -gap
+		}
+		if gap <= vodMatchTolerance && (best == nil || gap < bestGap) {
+			best, bestGap = v, gap
+		}
+	}
+	return best
+}
+
+// VODPoster replies to the announcements of an ended stream with the link of its archive
+type VODPoster struct {
+	discordClient *discord.Client
+	helix         *Client
+	logger        *logrus.Logger
+}
+
+// NewVODPoster creates the VOD poster
+func NewVODPoster(discordClient *discord.Client, helix *Client, logger *logrus.Logger) *VODPoster {
+	return &VODPoster{
+		discordClient: discordClient,
+		helix:         helix,
+		logger:        logger,
+	}
+}
+
+// StreamOnline does nothing, archives are looked up when the stream ends
+func (p *VODPoster) StreamOnline(ctx context.Context, ev *StreamEvent) {}
+
+// StreamOffline looks up the archive of the closed session, retrying until Twitch publishes it
+func (p *VODPoster) StreamOffline(ctx context.Context, ev *StreamEvent) {
+	sess := ev.Session
+	if sess == nil || len(sess.Messages) == 0 {
+		return
+	}
+	log := utils.Log(ctx, p.logger)
+	for _, delay := range vodRetryDelays {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		videos, err := p.helix.GetArchives(sess.BroadcasterID, 5)
+		if err != nil {
+			log.Warnf("failed to fetch archives of %s: %v", sess.BroadcasterName, err)
+			continue
+		}
+		if video := MatchArchive(sess, videos); video != nil {
+			p.post(log, sess, video)
+			return
+		}
+	}
+	log.Infof("No archive found for the stream of %s (VODs may be disabled)", sess.BroadcasterName)
+}
+
+// post replies to every announcement of the session with the archive
+func (p *VODPoster) post(log *logrus.Entry, sess *Session, video *Video) {
+	for _, ref := range sess.Messages {
+		lang := p.discordClient.ChannelLanguage(ref.ChannelID)
+		if err := p.discordClient.SendReply(ref.ChannelID, ref.MessageID, VODEmbed(lang, video)); err != nil {
+			log.WithField("channel_id", ref.ChannelID).Errorf("failed to post the VOD of %s: %v", sess.BroadcasterName, err)
+		}
+	}
+	log.Infof("📼 VOD of %s posted: %s", sess.BroadcasterName, video.URL)
+}
+
+// VODEmbed builds the embed of the archive of an ended stream in the given language
+func VODEmbed(lang string, video *Video) *discordgo.MessageEmbed {
+	return &discordgo.MessageEmbed{
+		Title:       i18n.T(lang, "vod.title", video.UserName),
+		URL:         video.URL,
+		Description: video.Title,
+		Color:       0x9146FF, // Twitch purple
+		Fields: []*discordgo.MessageEmbedField{
+			{
+				Name:   i18n.T(lang, "vod.field.duration"),
+				Value:  video.Duration,
+				Inline: true,
+			},
+		},
+	}
+}
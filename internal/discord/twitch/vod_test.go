@@ -0,0 +1,31 @@
+package twitch
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestVODPosterStopsWithContext(t *testing.T) {
+	logger := logrus.New()
+	logger.Out = io.Discard
+	p := NewVODPoster(nil, nil, logger)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.StreamOffline(ctx, &StreamEvent{BroadcasterID: alice.ID, Session: &Session{BroadcasterID: alice.ID,
+			Messages: []MessageRef{{ChannelID: e2eChannel, MessageID: "1"}}}})
+	}()
+
+	// Waiting for the archive doesn't hold up the shutdown
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("StreamOffline still waiting for the archive after the context was canceled")
+	}
+}
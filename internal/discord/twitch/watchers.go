@@ -0,0 +1,523 @@
+package twitch
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/delivery"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/discord"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/discord/commands"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/discordapi"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/i18n"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/storage"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// watcherBucket holds the watchers by ID
+	watcherBucket = "watchers"
+
+	// watcherStateBucket holds, by watcher ID, the streams each watcher already notified
+	watcherStateBucket = "watcher_state"
+
+	// Kinds of watchers
+	WatchGame = "game"
+	WatchTeam = "team"
+
+	// defaultWatchCooldown is the default minimum time between two notifications of the same broadcaster by a watcher
+	defaultWatchCooldown = time.Hour
+
+	// maxGameStreamPages bounds the streams fetched for a game watcher (100 streams per page)
+	maxGameStreamPages = 10
+)
+
+// Watcher notifies a channel when any broadcaster streaming a game, or any member of a team, goes live
+type Watcher struct {
+	ID         string        `json:"id"`
+	GuildID    string        `json:"guild_id"`
+	ChannelID  string        `json:"channel_id"`
+	Kind       string        `json:"kind"`        // WatchGame or WatchTeam
+	Target     string        `json:"target"`      // game ID or team name
+	TargetName string        `json:"target_name"` // game or team display name
+	Language   string        `json:"language,omitempty"`
+	MinViewers int           `json:"min_viewers,omitempty"`
+	Cooldown   time.Duration `json:"cooldown"`
+	CreatedBy  string        `json:"created_by"`
+}
+
+// watchedStream is a stream already notified by a watcher
+type watchedStream struct {
+	StreamID   string    `json:"stream_id"`
+	NotifiedAt time.Time `json:"notified_at"`
+}
+
+// Game represents a Twitch category returned by Helix /games
+type Game struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// Team represents a Twitch team returned by Helix /teams
+type Team struct {
+	ID              string `json:"id"`
+	TeamName        string `json:"team_name"`
+	TeamDisplayName string `json:"team_display_name"`
+	Users           []struct {
+		UserID    string `json:"user_id"`
+		UserLogin string `json:"user_login"`
+		UserName  string `json:"user_name"`
+	} `json:"users"`
+}
+
+// GetGame looks up a category by name, it returns nil when the category doesn't exist
+func (c *Client) GetGame(name string) (*Game, error) {
+	var data struct {
+		Data []Game `json:"data"`
+	}
+	if err := c.get("/games", url.Values{"name": {name}}, "", &data); err != nil {
+		return nil, err
+	}
+	if len(data.Data) == 0 {
+		return nil, nil
+	}
+	return &data.Data[0], nil
+}
+
+// GetTeam looks up a team and its members by name, it returns nil when the team doesn't exist
+func (c *Client) GetTeam(name string) (*Team, error) {
+	var data struct {
+		Data []Team `json:"data"`
+	}
+	err := c.get("/teams", url.Values{"name": {name}}, "", &data)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && (apiErr.Status == http.StatusBadRequest || apiErr.Status == http.StatusNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data.Data) == 0 {
+		return nil, nil
+	}
+	return &data.Data[0], nil
+}
+
+// GetGameStreams fetches the live streams of a category in a language (any language when empty)
+// with at least minViewers viewers. Streams are returned by Helix in decreasing viewer count,
+// so pagination stops at the first stream under the threshold.
+func (c *Client) GetGameStreams(gameID, language string, minViewers int) ([]Stream, error) {
+	var streams []Stream
+	cursor := ""
+	for page := 0; page < maxGameStreamPages; page++ {
+		q := url.Values{"game_id": {gameID}, "first": {"100"}}
+		if language != "" {
+			q.Set("language", language)
+		}
+		if cursor != "" {
+			q.Set("after", cursor)
+		}
+		var data struct {
+			Data       []Stream `json:"data"`
+			Pagination struct {
+				Cursor string `json:"cursor"`
+			} `json:"pagination"`
+		}
+		if err := c.get("/streams", q, "", &data); err != nil {
+			return nil, err
+		}
+		for _, stream := range data.Data {
+			if stream.ViewerCount < minViewers {
+				return streams, nil
+			}
+			streams = append(streams, stream)
+		}
+		if data.Pagination.Cursor == "" || len(data.Data) == 0 {
+			break
+		}
+		cursor = data.Pagination.Cursor
+	}
+	return streams, nil
+}
+
+// Watchers polls the category and team watchers configured with /watch
+type Watchers struct {
+	discordClient *discord.Client
+	helix         *Client
+	filters       *Filters
+	store         *storage.Store
+	logger        *logrus.Logger
+
+	mu sync.Mutex // keeps a poll from saving the state of a watcher being removed
+}
+
+// errNoWatcher aborts the removal of a watcher that doesn't exist in the guild
+var errNoWatcher = errors.New("no such watcher")
+
+// NewWatchers creates the watchers manager, streams are announced when filters allow them
+func NewWatchers(discordClient *discord.Client, helix *Client, filters *Filters, store *storage.Store, logger *logrus.Logger) *Watchers {
+	return &Watchers{
+		discordClient: discordClient,
+		helix:         helix,
+		filters:       filters,
+		store:         store,
+		logger:        logger,
+	}
+}
+
+// Run polls every watcher every interval until ctx is done
+func (w *Watchers) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		w.PollAll()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// List returns the watchers of a guild (every watcher when guildID is empty), sorted by ID
+func (w *Watchers) List(guildID string) []Watcher {
+	var watchers []Watcher
+	for _, id := range w.store.Keys(watcherBucket) {
+		var watcher Watcher
+		ok, err := w.store.Get(watcherBucket, id, &watcher)
+		if err != nil {
+			w.logger.Errorf("failed to load watcher %s: %v", id, err)
+			continue
+		}
+		if ok && (guildID == "" || watcher.GuildID == guildID) {
+			watchers = append(watchers, watcher)
+		}
+	}
+	return watchers
+}
+
+// Add stores a new watcher and returns it with its ID. A cooldown of 0 notifies every new stream.
+func (w *Watchers) Add(watcher Watcher) (*Watcher, error) {
+	if watcher.Cooldown < 0 {
+		return nil, fmt.Errorf("invalid cooldown %s", watcher.Cooldown)
+	}
+	buf := make([]byte, 3)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	watcher.ID = hex.EncodeToString(buf)
+	stored := watcher
+	err := w.store.Update(watcherBucket, watcher.ID, &stored, func(exists bool) error {
+		if exists {
+			return fmt.Errorf("watcher %s already exists", watcher.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &watcher, nil
+}
+
+// Remove deletes a watcher of a guild, it reports whether the watcher existed
+func (w *Watchers) Remove(guildID, id string) (bool, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var watcher Watcher
+	err := w.store.Update(watcherBucket, id, &watcher, func(exists bool) error {
+		if !exists || watcher.GuildID != guildID {
+			return errNoWatcher
+		}
+		return storage.ErrDelete
+	})
+	if errors.Is(err, errNoWatcher) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, w.store.Delete(watcherStateBucket, id)
+}
+
+// PollAll polls every watcher
+func (w *Watchers) PollAll() {
+	for _, watcher := range w.List("") {
+		if err := w.poll(&watcher); err != nil {
+			w.logger.Errorf("Watcher %s (%s %s) failed: %v", watcher.ID, watcher.Kind, watcher.TargetName, err)
+		}
+	}
+}
+
+// poll notifies the live streams matching a watcher that weren't notified yet, and whose
+// broadcaster wasn't notified by this watcher during the cooldown.
+// The streams already live at the first poll are only recorded, so adding a watcher on a
+// big category doesn't flood the channel.
+func (w *Watchers) poll(watcher *Watcher) error {
+	streams, err := w.streams(watcher)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// Removed while fetching the streams
+	if ok, err := w.store.Get(watcherBucket, watcher.ID, &Watcher{}); err != nil || !ok {
+		return err
+	}
+
+	state := map[string]watchedStream{}
+	polled, err := w.store.Get(watcherStateBucket, watcher.ID, &state)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	live := map[string]bool{}
+	lang := w.discordClient.ChannelLanguage(watcher.ChannelID)
+	for i := range streams {
+		stream := &streams[i]
+		live[stream.UserID] = true
+		if !watcher.Matches(stream) {
+			continue
+		}
+		if !polled {
+			state[stream.UserID] = watchedStream{StreamID: stream.ID, NotifiedAt: now}
+			continue
+		}
+		prev, seen := state[stream.UserID]
+		if seen && (prev.StreamID == stream.ID || now.Sub(prev.NotifiedAt) < watcher.Cooldown) {
+			continue
+		}
+		if !w.filters.Allow(watcher.ChannelID, stream) {
+			continue
+		}
+		ctx := withBroadcaster(context.Background(), w.logger, stream.UserID)
+		log := utils.Log(ctx, w.logger)
+		if _, err := w.discordClient.Announce(ctx, watcher.ChannelID, WatcherEmbed(lang, watcher, stream), Summary(stream), delivery.Tag{}); err != nil {
+			log.Errorf("failed to announce %s for watcher %s: %v", stream.UserName, watcher.ID, err)
+			continue
+		}
+		log.Infof("📣 %s est en live ! (watcher %s %s)", stream.UserName, watcher.Kind, watcher.TargetName)
+		state[stream.UserID] = watchedStream{StreamID: stream.ID, NotifiedAt: now}
+	}
+
+	if !polled {
+		w.logger.Infof("Watcher %s (%s %s): %d streams already live, not notified", watcher.ID, watcher.Kind, watcher.TargetName, len(state))
+	}
+
+	// Forget offline broadcasters once their cooldown is over
+	for userID, prev := range state {
+		if !live[userID] && now.Sub(prev.NotifiedAt) >= watcher.Cooldown {
+			delete(state, userID)
+		}
+	}
+	return w.store.Put(watcherStateBucket, watcher.ID, state)
+}
+
+// streams fetches the live streams of the broadcasters a watcher expands to
+func (w *Watchers) streams(watcher *Watcher) ([]Stream, error) {
+	switch watcher.Kind {
+	case WatchGame:
+		return w.helix.GetGameStreams(watcher.Target, watcher.Language, watcher.MinViewers)
+	case WatchTeam:
+		team, err := w.helix.GetTeam(watcher.Target)
+		if err != nil {
+			return nil, err
+		}
+		if team == nil {
+			return nil, fmt.Errorf("team %q not found", watcher.Target)
+		}
+		ids := make([]string, 0, len(team.Users))
+		for _, u := range team.Users {
+			ids = append(ids, u.UserID)
+		}
+		return w.helix.GetStreams(ids)
+	}
+	return nil, fmt.Errorf("unknown watcher kind %q", watcher.Kind)
+}
+
+// Matches reports whether a live stream passes the language and viewer thresholds of the watcher
+func (watcher *Watcher) Matches(stream *Stream) bool {
+	if watcher.Language != "" && !strings.EqualFold(stream.Language, watcher.Language) {
+		return false
+	}
+	return stream.ViewerCount >= watcher.MinViewers
+}
+
+// WatcherEmbed builds the announcement of a stream found by a watcher
+func WatcherEmbed(lang string, watcher *Watcher, stream *Stream) *discordgo.MessageEmbed {
+	embed := LiveEmbed(lang, stream)
+	embed.Description = i18n.T(lang, "watch.via."+watcher.Kind, watcher.TargetName)
+	return embed
+}
+
+// describe formats a watcher for /watch list
+func (watcher *Watcher) describe(lang string) string {
+	parts := []string{fmt.Sprintf("`%s` %s", watcher.ID, i18n.T(lang, "watch.kind."+watcher.Kind, watcher.TargetName))}
+	if watcher.Language != "" {
+		parts = append(parts, i18n.T(lang, "watch.language", watcher.Language))
+	}
+	if watcher.MinViewers > 0 {
+		parts = append(parts, i18n.T(lang, "watch.min_viewers", watcher.MinViewers))
+	}
+	parts = append(parts, i18n.T(lang, "watch.cooldown", watcher.Cooldown.String()), fmt.Sprintf("→ <#%s>", watcher.ChannelID))
+	return strings.Join(parts, " · ")
+}
+
+// watchFilterOptions are the options shared by /watch game and /watch team
+func watchFilterOptions() []*discordgo.ApplicationCommandOption {
+	minValue := 0.0
+	return []*discordgo.ApplicationCommandOption{
+		{Type: discordgo.ApplicationCommandOptionString, Name: "language", MinLength: intPtr(2), MaxLength: 2},
+		{Type: discordgo.ApplicationCommandOptionInteger, Name: "min_viewers", MinValue: &minValue},
+		{Type: discordgo.ApplicationCommandOptionInteger, Name: "cooldown", MinValue: &minValue},
+		{
+			Type:         discordgo.ApplicationCommandOptionChannel,
+			Name:         "channel",
+			ChannelTypes: []discordgo.ChannelType{discordgo.ChannelTypeGuildText, discordgo.ChannelTypeGuildNews},
+		},
+	}
+}
+
+func intPtr(v int) *int { return &v }
+
+// WatchCommand defines the /watch command
+var WatchCommand = i18n.Localize(&discordgo.ApplicationCommand{
+	Name:                     "watch",
+	DefaultMemberPermissions: &manageGuild,
+	DMPermission:             &guildOnly,
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type: discordgo.ApplicationCommandOptionSubCommand,
+			Name: "game",
+			Options: append([]*discordgo.ApplicationCommandOption{
+				{Type: discordgo.ApplicationCommandOptionString, Name: "name", Required: true},
+			}, watchFilterOptions()...),
+		},
+		{
+			Type: discordgo.ApplicationCommandOptionSubCommand,
+			Name: "team",
+			Options: append([]*discordgo.ApplicationCommandOption{
+				{Type: discordgo.ApplicationCommandOptionString, Name: "name", Required: true},
+			}, watchFilterOptions()...),
+		},
+		{Type: discordgo.ApplicationCommandOptionSubCommand, Name: "list"},
+		{
+			Type: discordgo.ApplicationCommandOptionSubCommand,
+			Name: "remove",
+			Options: []*discordgo.ApplicationCommandOption{
+				{Type: discordgo.ApplicationCommandOptionString, Name: "id", Required: true},
+			},
+		},
+	},
+})
+
+var manageGuild int64 = discordgo.PermissionManageServer
+
+// Command builds the /watch command
+func (w *Watchers) Command() *commands.Command {
+	return &commands.Command{
+		Definition: WatchCommand,
+		Handler: func(s discordapi.Sender, i *discordgo.InteractionCreate) {
+			lang := commands.Lang(i)
+			path, opts := commands.SubCommand(i.ApplicationCommandData().Options)
+			reply, err := w.handleCommand(lang, i, path, opts)
+			if err != nil {
+				w.logger.Errorf("Cannot handle /watch %s: %v", path, err)
+				reply = i18n.T(lang, "watch.error", err)
+			}
+			if err := commands.RespondEphemeral(s, i, reply); err != nil {
+				w.logger.Errorf("Cannot respond to /watch: %v", err)
+			}
+		},
+	}
+}
+
+func (w *Watchers) handleCommand(lang string, i *discordgo.InteractionCreate, path string, opts map[string]*discordgo.ApplicationCommandInteractionDataOption) (string, error) {
+	switch path {
+	case WatchGame, WatchTeam:
+		watcher := Watcher{
+			GuildID:   i.GuildID,
+			ChannelID: i.ChannelID,
+			Kind:      path,
+			Cooldown:  defaultWatchCooldown,
+			CreatedBy: commands.InvokerID(i),
+		}
+		name := strings.TrimSpace(opts["name"].StringValue())
+		if path == WatchGame {
+			game, err := w.helix.GetGame(name)
+			if err != nil {
+				return "", err
+			}
+			if game == nil {
+				return i18n.T(lang, "watch.unknown_game", name), nil
+			}
+			watcher.Target, watcher.TargetName = game.ID, game.Name
+		} else {
+			team, err := w.helix.GetTeam(strings.ToLower(name))
+			if err != nil {
+				return "", err
+			}
+			if team == nil {
+				return i18n.T(lang, "watch.unknown_team", name), nil
+			}
+			watcher.Target, watcher.TargetName = team.TeamName, team.TeamDisplayName
+		}
+		if opt, ok := opts["language"]; ok {
+			watcher.Language = strings.ToLower(opt.StringValue())
+		}
+		if opt, ok := opts["min_viewers"]; ok {
+			watcher.MinViewers = int(opt.IntValue())
+		}
+		if opt, ok := opts["cooldown"]; ok {
+			watcher.Cooldown = time.Duration(opt.IntValue()) * time.Minute
+		}
+		if opt, ok := opts["channel"]; ok {
+			watcher.ChannelID = opt.ChannelValue(nil).ID
+		}
+		added, err := w.Add(watcher)
+		if err != nil {
+			return "", err
+		}
+		go func() {
+			if err := w.poll(added); err != nil {
+				w.logger.Errorf("Watcher %s failed: %v", added.ID, err)
+			}
+		}()
+		return i18n.T(lang, "watch.added", added.describe(lang)), nil
+
+	case "list":
+		watchers := w.List(i.GuildID)
+		if len(watchers) == 0 {
+			return i18n.T(lang, "watch.empty"), nil
+		}
+		sort.Slice(watchers, func(a, b int) bool { return watchers[a].TargetName < watchers[b].TargetName })
+		lines := make([]string, 0, len(watchers))
+		for _, watcher := range watchers {
+			lines = append(lines, watcher.describe(lang))
+		}
+		return strings.Join(lines, "\n"), nil
+
+	case "remove":
+		id := strings.TrimSpace(opts["id"].StringValue())
+		ok, err := w.Remove(i.GuildID, id)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			return i18n.T(lang, "watch.unknown", id), nil
+		}
+		return i18n.T(lang, "watch.removed", id), nil
+	}
+	return "", fmt.Errorf("unknown subcommand %q", path)
+}
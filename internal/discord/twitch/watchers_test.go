@@ -0,0 +1,100 @@
+package twitch
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/flthibaud/TwitchLiveNotifier/internal/discordtest"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/twitchtest"
+)
+
+const celesteID = "504461"
+
+// streamCeleste makes a user live in Celeste
+func (e *e2e) streamCeleste(u twitchtest.User, streamID string) {
+	e.twitch.SetLive(twitchtest.Stream{ID: streamID, UserID: u.ID, UserLogin: u.Login, UserName: u.DisplayName,
+		GameID: celesteID, GameName: "Celeste", StartedAt: time.Now()})
+}
+
+func TestWatcherFirstPoll(t *testing.T) {
+	e := newE2E(t)
+	e.start(t)
+	e.streamCeleste(alice, "1")
+	e.streamCeleste(bob, "2")
+
+	watcher, err := e.server.watchers.Add(Watcher{GuildID: e2eGuild, ChannelID: e2eChannel, Kind: WatchGame, Target: celesteID, TargetName: "Celeste"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The streams already live when the watcher is added aren't notified
+	if err := e.server.watchers.poll(watcher); err != nil {
+		t.Fatal(err)
+	}
+	if d := e.client.Queue().Depth(); d != 0 {
+		t.Fatalf("first poll queued %d announcements, want none", d)
+	}
+
+	e.streamCeleste(carol, "3")
+	if err := e.server.watchers.poll(watcher); err != nil {
+		t.Fatal(err)
+	}
+	msg := e.discord.Wait(t, 1)[0]
+	if msg.Op != discordtest.OpSend || !strings.Contains(msg.Message.Embeds[0].Title, carol.DisplayName) {
+		t.Fatalf("%s %+v, want the announcement of carol", msg.Op, msg.Message)
+	}
+
+	// Without cooldown, a restarted stream is notified again
+	e.streamCeleste(alice, "4")
+	if err := e.server.watchers.poll(watcher); err != nil {
+		t.Fatal(err)
+	}
+	msg = e.discord.Wait(t, 2)[1]
+	if !strings.Contains(msg.Message.Embeds[0].Title, alice.DisplayName) {
+		t.Fatalf("second announcement %q, want alice", msg.Message.Embeds[0].Title)
+	}
+	if err := e.server.watchers.poll(watcher); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "queue drained", func() bool { return e.client.Queue().Depth() == 0 })
+	if n := len(e.discord.Calls()); n != 2 {
+		t.Errorf("%d Discord calls, want 2", n)
+	}
+}
+
+func TestWatcherCooldown(t *testing.T) {
+	e := newE2E(t)
+	if _, err := e.server.watchers.Add(Watcher{GuildID: e2eGuild, Kind: WatchGame, Cooldown: -time.Minute}); err == nil {
+		t.Error("watcher with a negative cooldown added")
+	}
+	w, err := e.server.watchers.Add(Watcher{GuildID: e2eGuild, Kind: WatchGame})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Cooldown != 0 {
+		t.Errorf("cooldown = %s, want 0 kept", w.Cooldown)
+	}
+}
+
+func TestWatcherRemove(t *testing.T) {
+	e := newE2E(t)
+	watcher, err := e.server.watchers.Add(Watcher{GuildID: e2eGuild, ChannelID: e2eChannel, Kind: WatchGame, Target: celesteID, TargetName: "Celeste"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A guild can't remove the watchers of another guild
+	if ok, err := e.server.watchers.Remove("700000000000000099", watcher.ID); ok || err != nil {
+		t.Errorf("Remove() from another guild = %v, %v, want false", ok, err)
+	}
+	if ok, err := e.server.watchers.Remove(e2eGuild, watcher.ID); !ok || err != nil {
+		t.Errorf("Remove() = %v, %v, want true", ok, err)
+	}
+	if ok, err := e.server.watchers.Remove(e2eGuild, watcher.ID); ok || err != nil {
+		t.Errorf("second Remove() = %v, %v, want false", ok, err)
+	}
+	if list := e.server.watchers.List(""); len(list) != 0 {
+		t.Errorf("watchers = %v, want none", list)
+	}
+}
@@ -1,20 +1,26 @@
 package twitch
 
 import (
-	"bytes"
 	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/bwmarrin/discordgo"
 	"github.com/flthibaud/TwitchLiveNotifier/internal/config"
 	"github.com/flthibaud/TwitchLiveNotifier/internal/discord"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/metrics"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/storage"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/utils"
 	"github.com/sirupsen/logrus"
 )
 
@@ -24,12 +30,34 @@ type WebhookServer struct {
 	logger        *logrus.Logger
 	discordClient *discord.Client
 	httpServer    *http.Server
-	oauthToken    string
+	helix         *Client
+	linker        *Linker
+	liveRoles     *LiveRoles
+	announcer     *Announcer
+	filters       *Filters
+	schedule      *ScheduleSync
+	clips         *ClipWatcher
+	watchers      *Watchers
+	digests       *Digests
+	reloader      *config.Reloader // edits the configuration file for the admin API
+	recentErrors  *utils.RecentErrors
+	startedAt     time.Time
+	eventsCtx     context.Context // context of the stream events, canceled when the server stops
+	stopEvents    context.CancelFunc
+
+	mu         sync.Mutex
+	started    bool
+	subscribed bool              // subscriptions of the tracked broadcasters were created at startup
+	tracked    map[string]bool   // broadcasters with subscriptions, announced or not
+	subStates  map[string]string // state of the subscriptions by key: empty when active, the error when it failed or was revoked
+	listeners  []StreamListener
+	events     []RecentEvent // last stream events and revocations, oldest first
 }
 
 // NewServer instantiates the Twitch webhook server
-func NewServer(cfg *config.Config, logger *logrus.Logger, discordClient *discord.Client) *WebhookServer {
+func NewServer(cfg *config.Config, logger *logrus.Logger, discordClient *discord.Client, store *storage.Store) *WebhookServer {
 	mux := http.NewServeMux()
+	helix := NewClient(cfg.TwitchClientID, cfg.TwitchClientSecret)
 	srv := &WebhookServer{
 		cfg:           cfg,
 		logger:        logger,
@@ -38,27 +66,124 @@ func NewServer(cfg *config.Config, logger *logrus.Logger, discordClient *discord
 			Addr:    fmt.Sprintf(":%s", cfg.Port),
 			Handler: mux,
 		},
+		helix:     helix,
+		linker:    NewLinker(helix, store, cfg.CallbackURL+"/oauth/callback", cfg.TokenKey, logger),
+		tracked:   make(map[string]bool),
+		subStates: make(map[string]string),
+		startedAt: time.Now(),
+	}
+	srv.eventsCtx, srv.stopEvents = context.WithCancel(context.Background())
+	srv.filters = NewFilters(discordClient, helix, store, logger)
+	srv.announcer = NewAnnouncer(cfg, discordClient, helix, srv.filters, store, logger)
+	for _, broadcasterID := range cfg.BroadcasterIDs() {
+		srv.tracked[broadcasterID] = true
 	}
 	mux.HandleFunc("/webhook", srv.handleWebhook)
+	mux.HandleFunc("/oauth/callback", srv.linker.HandleCallback)
+	mux.HandleFunc("/healthz", srv.handleHealthz)
+	mux.HandleFunc("/readyz", srv.handleReadyz)
+	mux.Handle("/metrics", metrics.Handler())
+	if cfg.APIToken != "" {
+		mux.HandleFunc(apiPrefix, srv.handleAPI)
+	}
+	if cfg.DashboardToken != "" {
+		srv.registerDashboard(mux)
+	}
+	discordClient.AddCommand(NewTwitchCommand(srv.linker, logger))
+	discordClient.AddCommand(srv.filters.Command())
+
+	// Live role of linked streamers
+	if cfg.LiveRoles {
+		srv.liveRoles = NewLiveRoles(discordClient, helix, srv.linker, store, srv.Track, srv.untrack, logger)
+		srv.linker.OnLinked = func(link *Link) { srv.Track(link.TwitchUserID) }
+		srv.linker.OnUnlinked = func(link *Link) {
+			// The member is no longer a streamer: the live role is taken back, and the events stop
+			// unless the Twitch account is still announced, mapped or linked by someone else
+			srv.untrack(link.TwitchUserID)
+			go srv.liveRoles.Reconcile()
+		}
+		srv.liveRoles.TrackAll()
+		srv.AddListener(srv.liveRoles)
+		discordClient.AddCommand(srv.liveRoles.Command())
+		discordClient.AddHandler(srv.liveRoles.OnReady)
+	}
+
+	// Optional second source of stream events
+	if cfg.PresenceDetection {
+		presence := NewPresenceDetector(srv, helix, store, logger)
+		discordClient.AddCommand(presence.Command())
+		discordClient.AddHandler(presence.OnPresenceUpdate)
+	}
+
+	// Twitch schedule mirrored as Discord scheduled events
+	if cfg.ScheduleSyncInterval > 0 {
+		srv.schedule = NewScheduleSync(cfg, discordClient, helix, store, logger)
+		srv.AddListener(srv.schedule)
+	}
+
+	// New clips of followed broadcasters
+	if cfg.ClipsChannelID != "" {
+		srv.clips = NewClipWatcher(cfg, discordClient, helix, store, logger)
+	}
+
+	// VOD of ended streams
+	if cfg.VODLinks {
+		srv.AddListener(NewVODPoster(discordClient, helix, logger))
+	}
+
+	// Category and team watchers
+	srv.watchers = NewWatchers(discordClient, helix, srv.filters, store, logger)
+	discordClient.AddCommand(srv.watchers.Command())
+
+	// Daily and weekly digests
+	srv.digests = NewDigests(cfg, discordClient, helix, srv.announcer, store, logger)
+	srv.AddListener(srv.digests)
+	discordClient.AddCommand(srv.digests.Command())
 	return srv
 }
 
-// Start obtains an OAuth token, subscribes to stream.online, and starts the HTTP server
+// Start obtains an OAuth token, subscribes to stream events, and starts the HTTP server
 func (s *WebhookServer) Start(ctx context.Context) error {
 	// 1. Get OAuth token for Twitch API
-	token, err := s.getOAuthToken()
-	if err != nil {
+	if _, err := s.helix.AppToken(); err != nil {
 		return fmt.Errorf("error getting OAuth token: %w", err)
 	}
-	s.oauthToken = token
 
-	// 2. Subscribe to stream.online for each BROADCASTER_ID env var
-	for _, broadcasterID := range s.cfg.TwitchBroadcasterIDs {
-		if err := s.subscribeStreamOnline(broadcasterID); err != nil {
-			s.logger.Errorf("Error subscribing to stream.online for %s: %v", broadcasterID, err)
-		}
+	// Close sessions of broadcasters that went offline while we were down
+	s.reconcileSessions()
+
+	// Encrypt the tokens of the accounts linked before the encryption key was configured
+	if err := s.linker.EncryptTokens(); err != nil {
+		return fmt.Errorf("error encrypting the linked accounts tokens: %w", err)
+	}
+
+	// 2. Subscribe to stream events for each BROADCASTER_ID env var and tracked broadcaster
+	s.mu.Lock()
+	s.started = true
+	broadcasters := make([]string, 0, len(s.tracked))
+	for broadcasterID := range s.tracked {
+		broadcasters = append(broadcasters, broadcasterID)
+	}
+	s.mu.Unlock()
+	sort.Strings(broadcasters)
+	for _, broadcasterID := range broadcasters {
+		s.subscribeAll(broadcasterID)
+	}
+	s.mu.Lock()
+	s.subscribed = true
+	s.mu.Unlock()
+	s.logger.Infof("Subscriptions created for broadcaster IDs: %s", broadcasters)
+
+	if s.schedule != nil {
+		go s.schedule.Run(ctx, s.cfg.ScheduleSyncInterval)
+	}
+	if s.clips != nil {
+		go s.clips.Run(ctx, s.cfg.ClipsPollInterval)
 	}
-	s.logger.Infof("Subscriptions created for broadcaster IDs: %s", s.cfg.TwitchBroadcasterIDs)
+	go s.watchers.Run(ctx, s.cfg.WatchPollInterval)
+	go s.retrySubscriptions(ctx, subscriptionRetryInterval)
+	go s.announcer.Run(ctx, sessionSampleInterval)
+	go s.digests.Run(ctx)
 
 	// 3. Start HTTP server
 	go func() {
@@ -72,146 +197,213 @@ func (s *WebhookServer) Start(ctx context.Context) error {
 		s.cfg.CallbackURL,
 	)
 
-	// Wait for shutdown
+	// Wait for shutdown, listeners still handling events (e.g. waiting for a VOD) give up
 	<-ctx.Done()
+	s.stopEvents()
 	return s.httpServer.Shutdown(context.Background())
 }
 
-// getOAuthToken fetches an app access token from Twitch
-func (s *WebhookServer) getOAuthToken() (string, error) {
-	url := fmt.Sprintf("https://id.twitch.tv/oauth2/token?client_id=%s&client_secret=%s&grant_type=client_credentials",
-		s.cfg.TwitchClientID, s.cfg.TwitchClientSecret)
-	resp, err := http.Post(url, "application/json", nil)
-	if err != nil {
-		return "", err
+// subscriptionTypes are the EventSub subscription types created for every tracked broadcaster
+var subscriptionTypes = []string{"stream.online", "stream.offline"}
+
+// subscriptionRetryInterval is the interval between two attempts to create the failing subscriptions
+const subscriptionRetryInterval = time.Minute
+
+// Track makes sure the server receives stream events of a broadcaster, which is only
+// dispatched to listeners and not announced unless it is in TWITCH_BROADCASTER_IDS.
+// Subscriptions are created right away when the server is already started.
+func (s *WebhookServer) Track(broadcasterID string) {
+	s.mu.Lock()
+	known := s.tracked[broadcasterID]
+	s.tracked[broadcasterID] = true
+	started := s.started
+	s.mu.Unlock()
+
+	if !known && started {
+		go s.subscribeAll(broadcasterID)
+	}
+}
+
+// ApplyConfig applies a reloaded configuration: subscriptions are created for the added broadcasters,
+// and deleted for the removed ones, unless their events are still needed (e.g. for the live role).
+// Routing is read from the configuration for every announcement, it needs nothing more.
+func (s *WebhookServer) ApplyConfig(prev, next config.Reloadable) {
+	added, removed := config.Diff(prev.TwitchBroadcasterIDs, next.TwitchBroadcasterIDs)
+	for _, broadcasterID := range added {
+		s.Track(broadcasterID)
 	}
-	defer resp.Body.Close()
-	var data struct {
-		AccessToken string `json:"access_token"`
+	s.untrack(removed...)
+}
+
+// untrack stops tracking broadcasters and deletes their subscriptions, unless their events are still
+// needed: announced broadcasters, and linked or mapped streamers of the live role, are kept
+func (s *WebhookServer) untrack(broadcasterIDs ...string) {
+	if len(broadcasterIDs) == 0 {
+		return
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
-		return "", err
+
+	s.mu.Lock()
+	for _, broadcasterID := range broadcasterIDs {
+		if !s.isFollowed(broadcasterID) {
+			delete(s.tracked, broadcasterID)
+		}
+	}
+	s.mu.Unlock()
+	// Linked and mapped streamers are tracked again
+	if s.liveRoles != nil {
+		s.liveRoles.TrackAll()
+	}
+	for _, broadcasterID := range broadcasterIDs {
+		s.mu.Lock()
+		tracked, started := s.tracked[broadcasterID], s.started
+		s.mu.Unlock()
+		if !tracked && started {
+			go s.unsubscribeAll(broadcasterID)
+		}
 	}
-	return data.AccessToken, nil
 }
 
-// subscribeStreamOnline creates a Twitch EventSub subscription of type stream.online
-func (s *WebhookServer) subscribeStreamOnline(broadcasterID string) error {
-	client := http.DefaultClient
-	baseURL := "https://api.twitch.tv/helix/eventsub/subscriptions"
+// isFollowed reports whether a broadcaster is announced
+func (s *WebhookServer) isFollowed(broadcasterID string) bool {
+	for _, id := range s.cfg.BroadcasterIDs() {
+		if id == broadcasterID {
+			return true
+		}
+	}
+	return false
+}
 
-	// Determine current desired callback URL
-	callbackURL := fmt.Sprintf("%s/webhook", s.cfg.CallbackURL)
+// subscribeAll makes sure every subscription type exists for a broadcaster
+func (s *WebhookServer) subscribeAll(broadcasterID string) {
+	for _, subType := range subscriptionTypes {
+		err := s.subscribe(subType, broadcasterID)
+		if err != nil {
+			s.logger.Errorf("Error subscribing to %s for %s: %v", subType, broadcasterID, err)
+		}
+		s.setSubscriptionError(subType, broadcasterID, err)
+	}
+}
 
-	// 1. List existing subscriptions for this broadcaster and type
-	listURL := fmt.Sprintf(
-		"%s?type=stream.online&condition[broadcaster_user_id]=%s", baseURL, broadcasterID,
-	)
-	reqList, err := http.NewRequest("GET", listURL, nil)
-	if err != nil {
-		return err
+// unsubscribeAll deletes the subscriptions of a broadcaster that is no longer tracked
+func (s *WebhookServer) unsubscribeAll(broadcasterID string) {
+	callbackURL := fmt.Sprintf("%s/webhook", s.cfg.CallbackURL)
+	for _, subType := range subscriptionTypes {
+		subs, err := s.subscriptions(subType, broadcasterID)
+		if err != nil {
+			s.logger.Errorf("Error listing %s subscriptions of %s: %v", subType, broadcasterID, err)
+			continue
+		}
+		for _, sub := range subs {
+			if sub.Transport.Callback != callbackURL {
+				continue
+			}
+			if err := s.helix.DeleteSubscription(sub.ID); err != nil {
+				s.logger.Errorf("failed to delete subscription %s: %v", sub.ID, err)
+				continue
+			}
+			s.logger.Infof("Deleted %s subscription of %s (ID=%s)", subType, broadcasterID, sub.ID)
+		}
+		s.forgetSubscription(subType, broadcasterID)
 	}
-	reqList.Header.Set("Client-ID", s.cfg.TwitchClientID)
-	reqList.Header.Set("Authorization", "Bearer "+s.oauthToken)
+}
 
-	respList, err := client.Do(reqList)
-	if err != nil {
-		return err
+// retrySubscriptions creates again the failing subscriptions of the tracked broadcasters (e.g. rate
+// limited at startup, or revoked) every interval until ctx is done
+func (s *WebhookServer) retrySubscriptions(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.retryFailingSubscriptions()
+		}
 	}
-	defer respList.Body.Close()
+}
 
-	if respList.StatusCode == http.StatusTooManyRequests {
-		s.logger.Warn("Twitch subscription rate limit reached, skipping subscription check")
-		return nil
+// retryFailingSubscriptions tries once to create every failing subscription of the tracked broadcasters
+func (s *WebhookServer) retryFailingSubscriptions() {
+	for _, key := range s.failingSubscriptionKeys() {
+		subType, broadcasterID, _ := strings.Cut(key, "/")
+		err := s.subscribe(subType, broadcasterID)
+		if err != nil {
+			s.logger.Warnf("Retrying %s subscription of %s failed: %v", subType, broadcasterID, err)
+		} else {
+			s.logger.Infof("%s subscription of %s created after a failure", subType, broadcasterID)
+		}
+		s.setSubscriptionError(subType, broadcasterID, err)
 	}
-	if respList.StatusCode/100 != 2 {
-		data, _ := io.ReadAll(respList.Body)
-		return fmt.Errorf("error listing subscriptions: %s", data)
+}
+
+// subscriptions lists the subscriptions of the given type for a broadcaster
+func (s *WebhookServer) subscriptions(subType, broadcasterID string) ([]Subscription, error) {
+	list, err := s.helix.Subscriptions(url.Values{
+		"type":                           {subType},
+		"condition[broadcaster_user_id]": {broadcasterID},
+	})
+	if err != nil {
+		return nil, err
 	}
+	return list.Data, nil
+}
+
+// subscribe creates a Twitch EventSub subscription of the given type for a broadcaster
+func (s *WebhookServer) subscribe(subType, broadcasterID string) error {
+	// Determine current desired callback URL
+	callbackURL := fmt.Sprintf("%s/webhook", s.cfg.CallbackURL)
 
-	// Decode existing subscriptions
-	var listData struct {
-		Data []struct {
-			ID        string `json:"id"`
-			Transport struct {
-				Callback string `json:"callback"`
-				Method   string `json:"method"`
-			} `json:"transport"`
-		} `json:"data"`
+	// 1. List existing subscriptions for this broadcaster and type
+	subs, err := s.subscriptions(subType, broadcasterID)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.Status == http.StatusTooManyRequests {
+		// Reported as failing, so readiness fails until a retry creates the subscription
+		return fmt.Errorf("rate limited while listing subscriptions: %w", err)
 	}
-	if err := json.NewDecoder(respList.Body).Decode(&listData); err != nil {
-		return err
+	if err != nil {
+		return fmt.Errorf("error listing subscriptions: %w", err)
 	}
 
 	// Check for existing subscription
-	for _, sub := range listData.Data {
+	for _, sub := range subs {
 		if sub.Transport.Callback == callbackURL {
 			s.logger.Infof("Valid subscription exists (ID=%s), no action needed", sub.ID)
 			return nil
 		}
 		// Outdated callback, delete it
-		delURL := fmt.Sprintf("%s?id=%s", baseURL, sub.ID)
-		reqDel, _ := http.NewRequest("DELETE", delURL, nil)
-		reqDel.Header.Set("Client-ID", s.cfg.TwitchClientID)
-		reqDel.Header.Set("Authorization", "Bearer "+s.oauthToken)
-		respDel, err := client.Do(reqDel)
-		if err != nil {
+		if err := s.helix.DeleteSubscription(sub.ID); err != nil {
 			s.logger.Warnf("failed to delete old subscription %s: %v", sub.ID, err)
 		} else {
-			respDel.Body.Close()
 			s.logger.Infof("Deleted outdated subscription (ID=%s)", sub.ID)
 		}
 		// continue to ensure no matching subscription remains
 	}
 
 	// 2. Create new subscription with correct callback
-	s.logger.Infof("Creating new subscription for stream.online with callback %s", callbackURL)
-	body := map[string]interface{}{
-		"type":    "stream.online",
-		"version": "1",
-		"condition": map[string]string{
-			"broadcaster_user_id": broadcasterID,
-		},
-		"transport": map[string]string{
-			"method":   "webhook",
-			"callback": callbackURL,
-			"secret":   s.cfg.TwitchWebhookSecret,
-		},
-	}
-	jsonData, _ := json.Marshal(body)
-	req, err := http.NewRequest("POST", baseURL, bytes.NewBuffer(jsonData))
-	if err != nil {
+	s.logger.Infof("Creating new subscription for %s with callback %s", subType, callbackURL)
+	if _, err := s.helix.CreateSubscription(subType, SubscriptionCondition(subType, broadcasterID), callbackURL, s.cfg.TwitchWebhookSecret); err != nil {
 		return err
 	}
-	req.Header.Set("Client-ID", s.cfg.TwitchClientID)
-	req.Header.Set("Authorization", "Bearer "+s.oauthToken)
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode/100 != 2 {
-		data, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("error creating subscription: %s", data)
-	}
 	s.logger.Info("Subscription created successfully")
 	return nil
 }
 
 // handleWebhook processes Twitch EventSub callbacks
 func (s *WebhookServer) handleWebhook(w http.ResponseWriter, r *http.Request) {
-	// 1) Logs & body brut
-	s.logger.Infof("📥 Webhook reçu : %s %s", r.Method, r.URL.Path)
+	// 1) Logs & body brut, every line of the request carries its message ID and subscription type
+	log := s.logger.WithFields(logrus.Fields{
+		"message_id":        r.Header.Get("Twitch-Eventsub-Message-Id"),
+		"subscription_type": r.Header.Get("Twitch-Eventsub-Subscription-Type"),
+	})
+	log.Infof("📥 Webhook reçu : %s %s", r.Method, r.URL.Path)
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		s.logger.Errorf("Lecture du body échouée : %v", err)
+		log.Errorf("Lecture du body échouée : %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 	defer r.Body.Close()
-	s.logger.Infof("📝 Payload brut : %s", string(body))
+	log.Debugf("📝 Payload brut : %s", string(body))
 
 	// 2) Récupère les headers Twitch
 	msgType := r.Header.Get("Twitch-Eventsub-Message-Type") // webhook_callback_verification | notification | revocation
@@ -221,11 +413,13 @@ func (s *WebhookServer) handleWebhook(w http.ResponseWriter, r *http.Request) {
 
 	// 3) Vérifie signature HMAC
 	if !s.verifySignature(msgID+timestamp+string(body), signature) {
-		s.logger.Warn("Signature invalide, on rejette")
+		signatureFailures.Inc()
+		log.Warn("Signature invalide, on rejette")
 		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
-	s.logger.Infof("Signature OK – type=%s", msgType)
+	log.Infof("Signature OK – type=%s", msgType)
+	webhooksReceived.Inc(metricLabel(msgType, messageTypes), metricLabel(r.Header.Get("Twitch-Eventsub-Subscription-Type"), subscriptionTypes))
 
 	// 4) Route selon le header
 	switch msgType {
@@ -235,104 +429,79 @@ func (s *WebhookServer) handleWebhook(w http.ResponseWriter, r *http.Request) {
 			Challenge string `json:"challenge"`
 		}
 		if err := json.Unmarshal(body, &challenge); err != nil {
-			s.logger.Errorf("Parsing challenge échoué : %v", err)
+			log.Errorf("Parsing challenge échoué : %v", err)
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
-		s.logger.Info("Répond au challenge")
+		log.Info("Répond au challenge")
 		w.Header().Set("Content-Type", "text/plain")
 		w.Write([]byte(challenge.Challenge))
 		return
 
 	case "notification":
-		s.logger.Info("Notification reçue, on parse l’événement")
+		log.Info("Notification reçue, on parse l’événement")
 		// On isole subscription.type et event
 		var payload struct {
 			Subscription struct {
 				Type string `json:"type"`
 			} `json:"subscription"`
 			Event struct {
-				BroadcasterUserID   string `json:"broadcaster_user_id"`
-				BroadcasterUserName string `json:"broadcaster_user_name"`
-				StartedAt           string `json:"started_at"`
+				ID                   string `json:"id"`
+				BroadcasterUserID    string `json:"broadcaster_user_id"`
+				BroadcasterUserLogin string `json:"broadcaster_user_login"`
+				BroadcasterUserName  string `json:"broadcaster_user_name"`
+				StartedAt            string `json:"started_at"`
 			} `json:"event"`
 		}
 		if err := json.Unmarshal(body, &payload); err != nil {
-			s.logger.Errorf("Parsing notification échoué : %v", err)
+			log.Errorf("Parsing notification échoué : %v", err)
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
 
-		// Si c’est un stream.online
-		if payload.Subscription.Type == "stream.online" {
-			s.logger.Infof("📣 %s est en live !", payload.Event.BroadcasterUserName)
-			stream, err := GetStreamInfo(payload.Event.BroadcasterUserID, s.cfg.TwitchClientID, s.oauthToken)
-			if err != nil {
-				s.logger.Errorf("Error fetching stream info: %v", err)
-			} else if stream != nil {
-				// build embed with stream.UserName, stream.Title, stream.GameName, stream.ViewerCount, stream.StartedAt
-				embed := &discordgo.MessageEmbed{
-					Title: fmt.Sprintf("🔴 %s est en live !", stream.UserName),
-					URL:   fmt.Sprintf("https://twitch.tv/%s", stream.UserName),
-
-					Color: 0x9146FF, // Twitch purple
-
-					Author: &discordgo.MessageEmbedAuthor{
-						Name:    stream.UserName,
-						URL:     fmt.Sprintf("https://twitch.tv/%s", stream.UserName),
-						IconURL: fmt.Sprintf("https://static-cdn.jtvnw.net/jtv_user_pictures/%s-profile_image-70x70.png", stream.UserID),
-						// IconURL: "https://static-cdn.jtvnw.net/user-default-pictures-uv/ead5c8b2-a4c9-4724-b1dd-9f00b46cbd3d-profile_image-70x70.png",
-					},
-
-					Image: &discordgo.MessageEmbedImage{
-						URL:    fmt.Sprintf("https://static-cdn.jtvnw.net/previews-ttv/live_user_%s-440x248.jpg", stream.UserName),
-						Width:  440,
-						Height: 248,
-					},
-
-					Fields: []*discordgo.MessageEmbedField{
-						{
-							Name:   "📝 Titre",
-							Value:  stream.Title,
-							Inline: false,
-						},
-						{
-							Name:   "🎮 Jeu",
-							Value:  stream.GameName,
-							Inline: true,
-						},
-						{
-							Name:   "👀 Spectateurs",
-							Value:  fmt.Sprintf("%d", stream.ViewerCount),
-							Inline: true,
-						},
-					},
-
-					Timestamp: stream.StartedAt.Format(time.RFC3339), // RFC3339 string
-
-					Footer: &discordgo.MessageEmbedFooter{
-						Text:    "Suivez sur Twitch !",
-						IconURL: "https://static.twitchcdn.net/assets/favicon-32-e29e246c157142c94346.png",
-					},
-				}
-				if err := s.discordClient.SendEmbed(s.cfg.NotifyChannelID, embed); err != nil {
-					s.logger.Errorf("Envoi Discord raté : %v", err)
-				} else {
-					s.logger.Info("Embed Discord envoyé ✅")
-				}
-			}
+		ev := &StreamEvent{
+			BroadcasterID:    payload.Event.BroadcasterUserID,
+			BroadcasterLogin: payload.Event.BroadcasterUserLogin,
+			BroadcasterName:  payload.Event.BroadcasterUserName,
+			StreamID:         payload.Event.ID,
+		}
+		ev.StartedAt, _ = time.Parse(time.RFC3339, payload.Event.StartedAt)
+		// Not the request context: the event is still handled in the background once Twitch is acknowledged
+		ctx := withBroadcaster(utils.WithLogger(s.eventsCtx, log), s.logger, ev.BroadcasterID)
+
+		// Only the sessions are updated here: announcements are prepared and queued in the
+		// background, so Twitch is acknowledged right away
+		switch payload.Subscription.Type {
+		case "stream.online":
+			s.streamOnline(ctx, ev, SourceEventSub, nil)
+		case "stream.offline":
+			s.streamOffline(ctx, ev, SourceEventSub)
 		}
 
 		w.WriteHeader(http.StatusNoContent)
 		return
 
 	case "revocation":
-		s.logger.Warn("Subscription révoquée par Twitch")
+		var payload struct {
+			Subscription struct {
+				Type      string `json:"type"`
+				Status    string `json:"status"`
+				Condition struct {
+					BroadcasterUserID string `json:"broadcaster_user_id"`
+				} `json:"condition"`
+			} `json:"subscription"`
+		}
+		json.Unmarshal(body, &payload)
+		sub := payload.Subscription
+		log.WithField("broadcaster_id", sub.Condition.BroadcasterUserID).Warnf("Subscription révoquée par Twitch : %s pour %s (%s)", sub.Type, sub.Condition.BroadcasterUserID, sub.Status)
+		s.setSubscriptionError(sub.Type, sub.Condition.BroadcasterUserID, fmt.Errorf("revoked (%s)", sub.Status))
+		s.recordEvent(RecentEvent{Type: EventRevocation, Source: SourceEventSub, BroadcasterID: sub.Condition.BroadcasterUserID,
+			Detail: fmt.Sprintf("%s (%s)", sub.Type, sub.Status)})
 		w.WriteHeader(http.StatusNoContent)
 		return
 
 	default:
-		s.logger.Infof("Type inattendu : %s", msgType)
+		log.Infof("Type inattendu : %s", msgType)
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
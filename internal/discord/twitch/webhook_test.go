@@ -0,0 +1,87 @@
+package twitch
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/config"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/discordtest"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/settings"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/twitchtest"
+)
+
+// goldenStartedAt is the start of the streams of the golden tests, shown in the announcements
+var goldenStartedAt = time.Date(2024, 5, 4, 18, 30, 0, 0, time.UTC)
+
+// postEvent posts a notification to the webhook and checks it is acknowledged
+func (e *e2e) postEvent(t *testing.T, msg *SimulatedMessage) {
+	t.Helper()
+	if code, body := e.post(t, msg, e2eWebhookSecret); code != http.StatusNoContent {
+		t.Fatalf("%s answered %d %s, want 204", msg.SubscriptionType, code, body)
+	}
+}
+
+func simulatedUser(u twitchtest.User) SimulatedUser {
+	return SimulatedUser{ID: u.ID, Login: u.Login, Name: u.DisplayName}
+}
+
+func TestWebhookGolden(t *testing.T) {
+	t.Run("stream_online", func(t *testing.T) {
+		e := newE2E(t, alice)
+		e.start(t)
+		e.twitch.SetLive(twitchtest.Stream{UserID: alice.ID, UserLogin: alice.Login, UserName: alice.DisplayName,
+			GameName: "Celeste", Title: "Any% practice", ViewerCount: 42, StartedAt: goldenStartedAt})
+
+		e.postEvent(t, SimulateStreamOnline(simulatedUser(alice)))
+		discordtest.Golden(t, "webhook_stream_online", e.discord.Wait(t, 1))
+	})
+
+	t.Run("stream_online_french", func(t *testing.T) {
+		e := newE2E(t, alice)
+		if _, err := e.client.Settings().UpdateGuild(e2eGuild, func(g *settings.Guild) { g.Language = "fr" }); err != nil {
+			t.Fatal(err)
+		}
+		e.start(t)
+		e.twitch.SetLive(twitchtest.Stream{UserID: alice.ID, UserLogin: alice.Login, UserName: alice.DisplayName,
+			GameName: "Celeste", Title: "Entraînement any%", ViewerCount: 7, StartedAt: goldenStartedAt})
+
+		e.postEvent(t, SimulateStreamOnline(simulatedUser(alice)))
+		discordtest.Golden(t, "webhook_stream_online_french", e.discord.Wait(t, 1))
+	})
+
+	t.Run("stream_online_routed", func(t *testing.T) {
+		// bob is announced by another notifier, in its channel with its role
+		const esportsChannel, esportsRole = "900000000000000010", "900000000000000011"
+		e := newE2E(t, alice, bob)
+		e.discord.AddChannel(&discordgo.Channel{ID: esportsChannel, GuildID: e2eGuild, Type: discordgo.ChannelTypeGuildText})
+		e.cfg.Notifiers = append(e.cfg.Notifiers, config.Notifier{Name: "esports", ChannelID: esportsChannel, RoleID: esportsRole})
+		e.cfg.Broadcasters = []config.Broadcaster{{ID: alice.ID}, {ID: bob.ID, Notifier: "esports"}}
+		e.start(t)
+		e.twitch.SetLive(twitchtest.Stream{UserID: bob.ID, UserLogin: bob.Login, UserName: bob.DisplayName,
+			GameName: "Rocket League", Title: "Finals", ViewerCount: 1200, StartedAt: goldenStartedAt})
+
+		e.postEvent(t, SimulateStreamOnline(simulatedUser(bob)))
+		discordtest.Golden(t, "webhook_stream_online_routed", e.discord.Wait(t, 1))
+	})
+
+	t.Run("live_role", func(t *testing.T) {
+		// carol isn't announced, but her member gets the live role while she streams
+		const member, liveRole = "900000000000000020", "900000000000000021"
+		e := newE2E(t, alice)
+		if _, err := e.client.Settings().UpdateGuild(e2eGuild, func(g *settings.Guild) { g.LiveRoleID = liveRole }); err != nil {
+			t.Fatal(err)
+		}
+		if err := e.store.Put(liveMembersBucket, e2eGuild, map[string]string{member: carol.ID}); err != nil {
+			t.Fatal(err)
+		}
+		e.discord.AddMember(e2eGuild, &discordgo.Member{User: &discordgo.User{ID: member}})
+		e.start(t)
+
+		e.postEvent(t, SimulateStreamOnline(simulatedUser(carol)))
+		e.discord.Wait(t, 1)
+		e.postEvent(t, SimulateStreamOffline(simulatedUser(carol)))
+		discordtest.Golden(t, "webhook_live_role", e.discord.Wait(t, 2))
+	})
+}
@@ -0,0 +1,108 @@
+// Package discordapi defines the Discord operations the bot performs, so the code posting
+// messages and answering commands can run against a fake (see discordtest) instead of Discord
+package discordapi
+
+import "github.com/bwmarrin/discordgo"
+
+// Sender is the subset of the Discord REST API used by the bot
+type Sender interface {
+	// SendMessage posts a message. Rate limits are returned as errors instead of waited for:
+	// the delivery queue schedules the retry.
+	SendMessage(channelID string, msg *discordgo.MessageSend) (*discordgo.Message, error)
+	// EditMessage edits the message identified by edit.Channel and edit.ID
+	EditMessage(edit *discordgo.MessageEdit) (*discordgo.Message, error)
+	DeleteMessage(channelID, messageID string) error
+	// React adds a reaction of the bot, emoji is a unicode emoji or name:id for custom emojis
+	React(channelID, messageID, emoji string) error
+	// CreateThread starts a thread from a message
+	CreateThread(channelID, messageID, name string) (*discordgo.Channel, error)
+
+	AddRole(guildID, userID, roleID string) error
+	RemoveRole(guildID, userID, roleID string) error
+
+	// CreateScheduledEvent creates a Guild Scheduled Event
+	CreateScheduledEvent(guildID string, params *discordgo.GuildScheduledEventParams) (*discordgo.GuildScheduledEvent, error)
+	// EditScheduledEvent updates a Guild Scheduled Event, e.g. its status
+	EditScheduledEvent(guildID, eventID string, params *discordgo.GuildScheduledEventParams) (*discordgo.GuildScheduledEvent, error)
+
+	// Respond sends the response of an interaction
+	Respond(i *discordgo.Interaction, resp *discordgo.InteractionResponse) error
+	// EditResponse edits the response of an interaction, e.g. after a deferred response
+	EditResponse(i *discordgo.Interaction, edit *discordgo.WebhookEdit) (*discordgo.Message, error)
+
+	// Channel fetches a channel
+	Channel(channelID string) (*discordgo.Channel, error)
+	// Member fetches a guild member
+	Member(guildID, userID string) (*discordgo.Member, error)
+	// Members lists up to limit members of a guild with a user ID after after, by user ID
+	Members(guildID, after string, limit int) ([]*discordgo.Member, error)
+}
+
+// threadArchiveDuration is the inactivity in minutes after which the threads created by the bot are archived
+const threadArchiveDuration = 1440
+
+// Session is the Sender calling Discord with a discordgo session
+type Session struct {
+	session *discordgo.Session
+}
+
+// NewSession returns the Sender of a discordgo session
+func NewSession(s *discordgo.Session) *Session {
+	return &Session{session: s}
+}
+
+func (s *Session) SendMessage(channelID string, msg *discordgo.MessageSend) (*discordgo.Message, error) {
+	return s.session.ChannelMessageSendComplex(channelID, msg, discordgo.WithRetryOnRatelimit(false))
+}
+
+func (s *Session) EditMessage(edit *discordgo.MessageEdit) (*discordgo.Message, error) {
+	return s.session.ChannelMessageEditComplex(edit)
+}
+
+func (s *Session) DeleteMessage(channelID, messageID string) error {
+	return s.session.ChannelMessageDelete(channelID, messageID)
+}
+
+func (s *Session) React(channelID, messageID, emoji string) error {
+	return s.session.MessageReactionAdd(channelID, messageID, emoji)
+}
+
+func (s *Session) CreateThread(channelID, messageID, name string) (*discordgo.Channel, error) {
+	return s.session.MessageThreadStart(channelID, messageID, name, threadArchiveDuration)
+}
+
+func (s *Session) AddRole(guildID, userID, roleID string) error {
+	return s.session.GuildMemberRoleAdd(guildID, userID, roleID)
+}
+
+func (s *Session) RemoveRole(guildID, userID, roleID string) error {
+	return s.session.GuildMemberRoleRemove(guildID, userID, roleID)
+}
+
+func (s *Session) CreateScheduledEvent(guildID string, params *discordgo.GuildScheduledEventParams) (*discordgo.GuildScheduledEvent, error) {
+	return s.session.GuildScheduledEventCreate(guildID, params)
+}
+
+func (s *Session) EditScheduledEvent(guildID, eventID string, params *discordgo.GuildScheduledEventParams) (*discordgo.GuildScheduledEvent, error) {
+	return s.session.GuildScheduledEventEdit(guildID, eventID, params)
+}
+
+func (s *Session) Respond(i *discordgo.Interaction, resp *discordgo.InteractionResponse) error {
+	return s.session.InteractionRespond(i, resp)
+}
+
+func (s *Session) EditResponse(i *discordgo.Interaction, edit *discordgo.WebhookEdit) (*discordgo.Message, error) {
+	return s.session.InteractionResponseEdit(i, edit)
+}
+
+func (s *Session) Channel(channelID string) (*discordgo.Channel, error) {
+	return s.session.Channel(channelID)
+}
+
+func (s *Session) Member(guildID, userID string) (*discordgo.Member, error) {
+	return s.session.GuildMember(guildID, userID)
+}
+
+func (s *Session) Members(guildID, after string, limit int) ([]*discordgo.Member, error) {
+	return s.session.GuildMembers(guildID, after, limit)
+}
@@ -0,0 +1,45 @@
+package discordtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files with the current output")
+
+// Golden compares the indented JSON of v (e.g. the calls of a Recorder) with testdata/<name>.golden,
+// which is rewritten instead when the tests run with -update
+func Golden(tb testing.TB, name string, v interface{}) {
+	tb.Helper()
+	// Mentions stay readable: <@&role> instead of \u003c@\u0026role\u003e
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		tb.Fatal(err)
+	}
+	got := buf.Bytes()
+
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			tb.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			tb.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		tb.Fatalf("%v (run the tests with -update to create it)", err)
+	}
+	if !bytes.Equal(got, want) {
+		tb.Errorf("%s differs from the golden file (run the tests with -update to accept it)\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
@@ -0,0 +1,309 @@
+// Package discordtest provides a fake Discord API recording the operations of the bot, and golden
+// files to compare them with.
+package discordtest
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/discordapi"
+)
+
+// Operations recorded by a Recorder
+const (
+	OpSend          = "send"
+	OpEdit          = "edit"
+	OpDelete        = "delete"
+	OpReact         = "react"
+	OpCreateThread  = "create_thread"
+	OpAddRole       = "add_role"
+	OpRemoveRole    = "remove_role"
+	OpCreateEvent   = "create_event"
+	OpEditEvent     = "edit_event"
+	OpRespond       = "respond"
+	OpEditResponse  = "edit_response"
+	OpChannelLookup = "channel"
+	OpMemberLookup  = "member"
+	OpMembersLookup = "members"
+)
+
+// Call is an operation performed on a Recorder. Only the fields of the operation are set.
+type Call struct {
+	Op            string                               `json:"op"`
+	GuildID       string                               `json:"guild_id,omitempty"`
+	ChannelID     string                               `json:"channel_id,omitempty"`
+	MessageID     string                               `json:"message_id,omitempty"`
+	UserID        string                               `json:"user_id,omitempty"`
+	RoleID        string                               `json:"role_id,omitempty"`
+	InteractionID string                               `json:"interaction_id,omitempty"`
+	Emoji         string                               `json:"emoji,omitempty"`
+	EventID       string                               `json:"event_id,omitempty"`
+	Name          string                               `json:"name,omitempty"`
+	Message       *discordgo.MessageSend               `json:"message,omitempty"`
+	Edit          *discordgo.MessageEdit               `json:"edit,omitempty"`
+	Event         *discordgo.GuildScheduledEventParams `json:"event,omitempty"`
+	Response      *discordgo.InteractionResponse       `json:"response,omitempty"`
+	ResponseEdit  *discordgo.WebhookEdit               `json:"response_edit,omitempty"`
+}
+
+// Recorder is a discordapi.Sender recording its calls instead of calling Discord. Posted messages
+// get sequential IDs, and channels and members are looked up among the ones added with AddChannel
+// and AddMember. Lookups aren't recorded, they don't change anything on Discord.
+type Recorder struct {
+	mu       sync.Mutex
+	calls    []Call
+	channels map[string]*discordgo.Channel
+	members  map[string]*discordgo.Member // by guild ID/user ID
+	failures map[string][]error
+	lastID   int
+}
+
+var _ discordapi.Sender = (*Recorder)(nil)
+
+// NewRecorder returns an empty Recorder
+func NewRecorder() *Recorder {
+	return &Recorder{
+		channels: map[string]*discordgo.Channel{},
+		members:  map[string]*discordgo.Member{},
+		failures: map[string][]error{},
+	}
+}
+
+// AddChannel makes a channel known to the Recorder
+func (r *Recorder) AddChannel(ch *discordgo.Channel) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.channels[ch.ID] = ch
+}
+
+// AddMember makes a member of a guild known to the Recorder
+func (r *Recorder) AddMember(guildID string, m *discordgo.Member) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m.GuildID = guildID
+	r.members[guildID+"/"+m.User.ID] = m
+}
+
+// FailNext makes the next call of an operation (e.g. OpSend) fail with err. The failed call is recorded.
+func (r *Recorder) FailNext(op string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failures[op] = append(r.failures[op], err)
+}
+
+// Calls returns the recorded calls, in order
+func (r *Recorder) Calls() []Call {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Call(nil), r.calls...)
+}
+
+// Reset forgets the recorded calls
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = nil
+}
+
+// Wait waits for n calls to be recorded and returns them, failing the test after a few seconds
+func (r *Recorder) Wait(tb testing.TB, n int) []Call {
+	tb.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		calls := r.Calls()
+		if len(calls) >= n {
+			return calls
+		}
+		if time.Now().After(deadline) {
+			tb.Fatalf("timed out waiting for %d Discord call(s), got %d: %+v", n, len(calls), calls)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// record records a call and returns the error it was set to fail with
+func (r *Recorder) record(c Call) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, c)
+	if errs := r.failures[c.Op]; len(errs) > 0 {
+		r.failures[c.Op] = errs[1:]
+		return errs[0]
+	}
+	return nil
+}
+
+// nextID returns a new snowflake-like ID
+func (r *Recorder) nextID() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastID++
+	return fmt.Sprintf("%d", 1000000000000000000+r.lastID)
+}
+
+func (r *Recorder) SendMessage(channelID string, msg *discordgo.MessageSend) (*discordgo.Message, error) {
+	if err := r.record(Call{Op: OpSend, ChannelID: channelID, Message: msg}); err != nil {
+		return nil, err
+	}
+	return &discordgo.Message{ID: r.nextID(), ChannelID: channelID, Content: msg.Content, Embeds: msg.Embeds}, nil
+}
+
+func (r *Recorder) EditMessage(edit *discordgo.MessageEdit) (*discordgo.Message, error) {
+	if err := r.record(Call{Op: OpEdit, ChannelID: edit.Channel, MessageID: edit.ID, Edit: edit}); err != nil {
+		return nil, err
+	}
+	msg := &discordgo.Message{ID: edit.ID, ChannelID: edit.Channel}
+	if edit.Content != nil {
+		msg.Content = *edit.Content
+	}
+	if edit.Embeds != nil {
+		msg.Embeds = *edit.Embeds
+	}
+	return msg, nil
+}
+
+func (r *Recorder) DeleteMessage(channelID, messageID string) error {
+	return r.record(Call{Op: OpDelete, ChannelID: channelID, MessageID: messageID})
+}
+
+func (r *Recorder) React(channelID, messageID, emoji string) error {
+	return r.record(Call{Op: OpReact, ChannelID: channelID, MessageID: messageID, Emoji: emoji})
+}
+
+func (r *Recorder) CreateThread(channelID, messageID, name string) (*discordgo.Channel, error) {
+	if err := r.record(Call{Op: OpCreateThread, ChannelID: channelID, MessageID: messageID, Name: name}); err != nil {
+		return nil, err
+	}
+	thread := &discordgo.Channel{ID: r.nextID(), ParentID: channelID, Name: name, Type: discordgo.ChannelTypeGuildPublicThread}
+	r.mu.Lock()
+	if parent, ok := r.channels[channelID]; ok {
+		thread.GuildID = parent.GuildID
+	}
+	r.channels[thread.ID] = thread
+	r.mu.Unlock()
+	return thread, nil
+}
+
+func (r *Recorder) AddRole(guildID, userID, roleID string) error {
+	if err := r.record(Call{Op: OpAddRole, GuildID: guildID, UserID: userID, RoleID: roleID}); err != nil {
+		return err
+	}
+	r.setRole(guildID, userID, roleID, true)
+	return nil
+}
+
+func (r *Recorder) RemoveRole(guildID, userID, roleID string) error {
+	if err := r.record(Call{Op: OpRemoveRole, GuildID: guildID, UserID: userID, RoleID: roleID}); err != nil {
+		return err
+	}
+	r.setRole(guildID, userID, roleID, false)
+	return nil
+}
+
+// setRole updates the roles of a known member, so later lookups see the change
+func (r *Recorder) setRole(guildID, userID, roleID string, add bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m, ok := r.members[guildID+"/"+userID]
+	if !ok {
+		return
+	}
+	roles := []string{}
+	for _, role := range m.Roles {
+		if role != roleID {
+			roles = append(roles, role)
+		}
+	}
+	if add {
+		roles = append(roles, roleID)
+	}
+	m.Roles = roles
+}
+
+func (r *Recorder) CreateScheduledEvent(guildID string, params *discordgo.GuildScheduledEventParams) (*discordgo.GuildScheduledEvent, error) {
+	if err := r.record(Call{Op: OpCreateEvent, GuildID: guildID, Event: params}); err != nil {
+		return nil, err
+	}
+	return &discordgo.GuildScheduledEvent{ID: r.nextID(), GuildID: guildID, Name: params.Name, Status: discordgo.GuildScheduledEventStatusScheduled}, nil
+}
+
+func (r *Recorder) EditScheduledEvent(guildID, eventID string, params *discordgo.GuildScheduledEventParams) (*discordgo.GuildScheduledEvent, error) {
+	if err := r.record(Call{Op: OpEditEvent, GuildID: guildID, EventID: eventID, Event: params}); err != nil {
+		return nil, err
+	}
+	return &discordgo.GuildScheduledEvent{ID: eventID, GuildID: guildID, Name: params.Name, Status: params.Status}, nil
+}
+
+func (r *Recorder) Respond(i *discordgo.Interaction, resp *discordgo.InteractionResponse) error {
+	return r.record(Call{Op: OpRespond, GuildID: i.GuildID, ChannelID: i.ChannelID, InteractionID: i.ID, Response: resp})
+}
+
+func (r *Recorder) EditResponse(i *discordgo.Interaction, edit *discordgo.WebhookEdit) (*discordgo.Message, error) {
+	if err := r.record(Call{Op: OpEditResponse, GuildID: i.GuildID, ChannelID: i.ChannelID, InteractionID: i.ID, ResponseEdit: edit}); err != nil {
+		return nil, err
+	}
+	return &discordgo.Message{ID: r.nextID(), ChannelID: i.ChannelID}, nil
+}
+
+func (r *Recorder) Channel(channelID string) (*discordgo.Channel, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.lookupFailure(OpChannelLookup); err != nil {
+		return nil, err
+	}
+	if ch, ok := r.channels[channelID]; ok {
+		return ch, nil
+	}
+	return nil, errUnknownChannel
+}
+
+func (r *Recorder) Member(guildID, userID string) (*discordgo.Member, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.lookupFailure(OpMemberLookup); err != nil {
+		return nil, err
+	}
+	if m, ok := r.members[guildID+"/"+userID]; ok {
+		return m, nil
+	}
+	return nil, errUnknownMember
+}
+
+func (r *Recorder) Members(guildID, after string, limit int) ([]*discordgo.Member, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.lookupFailure(OpMembersLookup); err != nil {
+		return nil, err
+	}
+	var members []*discordgo.Member
+	for _, m := range r.members {
+		if m.GuildID == guildID && m.User.ID > after {
+			members = append(members, m)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].User.ID < members[j].User.ID })
+	if len(members) > limit {
+		members = members[:limit]
+	}
+	return members, nil
+}
+
+// lookupFailure returns the error a lookup was set to fail with
+func (r *Recorder) lookupFailure(op string) error {
+	if errs := r.failures[op]; len(errs) > 0 {
+		r.failures[op] = errs[1:]
+		return errs[0]
+	}
+	return nil
+}
+
+// Errors of the lookups of unknown channels and members, as returned by Discord
+var (
+	errUnknownChannel = errors.New("HTTP 404 Not Found, {\"message\": \"Unknown Channel\", \"code\": 10003}")
+	errUnknownMember  = errors.New("HTTP 404 Not Found, {\"message\": \"Unknown Member\", \"code\": 10007}")
+)
@@ -0,0 +1,151 @@
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Supported languages
+const (
+	English = "en"
+	French  = "fr"
+)
+
+// Default is the language of command definitions and the fallback for missing messages
+const Default = English
+
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// bundles maps a language to its message catalog
+var bundles = map[string]map[string]string{}
+
+// discordLocales maps a language to the Discord locales it covers
+var discordLocales = map[string][]discordgo.Locale{
+	English: {discordgo.EnglishUS, discordgo.EnglishGB},
+	French:  {discordgo.French},
+}
+
+func init() {
+	entries, err := localeFiles.ReadDir("locales")
+	if err != nil {
+		panic(err)
+	}
+	for _, entry := range entries {
+		raw, err := localeFiles.ReadFile(path.Join("locales", entry.Name()))
+		if err != nil {
+			panic(err)
+		}
+		var messages map[string]string
+		if err := json.Unmarshal(raw, &messages); err != nil {
+			panic(fmt.Sprintf("invalid locale file %s: %v", entry.Name(), err))
+		}
+		bundles[strings.TrimSuffix(entry.Name(), ".json")] = messages
+	}
+}
+
+// Languages returns the supported languages, sorted
+func Languages() []string {
+	langs := make([]string, 0, len(bundles))
+	for lang := range bundles {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// Supported reports whether a language has a message catalog
+func Supported(lang string) bool {
+	_, ok := bundles[lang]
+	return ok
+}
+
+// T returns the message for key in lang, formatted with args.
+// Missing messages fall back to the default language, then to the key itself.
+func T(lang, key string, args ...interface{}) string {
+	msg, ok := bundles[lang][key]
+	if !ok {
+		msg, ok = bundles[Default][key]
+	}
+	if !ok {
+		return key
+	}
+	if len(args) == 0 {
+		return msg
+	}
+	return fmt.Sprintf(msg, args...)
+}
+
+// FromLocale returns the supported language matching a Discord locale, or fallback
+func FromLocale(locale discordgo.Locale, fallback string) string {
+	for lang, locales := range discordLocales {
+		for _, l := range locales {
+			if l == locale {
+				return lang
+			}
+		}
+	}
+	return fallback
+}
+
+// Localize fills the descriptions of a command, its options and choices from the catalog,
+// along with their Discord localizations. Keys are "cmd.<command>[.<option>...].description";
+// a ".name" key localizes the name as well. It returns cmd for use in definitions.
+func Localize(cmd *discordgo.ApplicationCommand) *discordgo.ApplicationCommand {
+	prefix := "cmd." + cmd.Name
+	if desc, ok := bundles[Default][prefix+".description"]; ok {
+		cmd.Description = desc
+	}
+	cmd.DescriptionLocalizations = localizations(prefix + ".description")
+	cmd.NameLocalizations = localizations(prefix + ".name")
+	localizeOptions(prefix, cmd.Options)
+	return cmd
+}
+
+func localizeOptions(prefix string, opts []*discordgo.ApplicationCommandOption) {
+	for _, opt := range opts {
+		key := prefix + "." + opt.Name
+		if desc, ok := bundles[Default][key+".description"]; ok {
+			opt.Description = desc
+		}
+		if l := localizations(key + ".description"); l != nil {
+			opt.DescriptionLocalizations = *l
+		}
+		if l := localizations(key + ".name"); l != nil {
+			opt.NameLocalizations = *l
+		}
+		for _, choice := range opt.Choices {
+			if l := localizations(key + ".choice." + fmt.Sprint(choice.Value)); l != nil {
+				choice.NameLocalizations = *l
+			}
+		}
+		localizeOptions(key, opt.Options)
+	}
+}
+
+// localizations returns the translations of key for every non-default language
+func localizations(key string) *map[discordgo.Locale]string {
+	out := map[discordgo.Locale]string{}
+	for lang, messages := range bundles {
+		if lang == Default {
+			continue
+		}
+		msg, ok := messages[key]
+		if !ok {
+			continue
+		}
+		for _, locale := range discordLocales[lang] {
+			out[locale] = msg
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return &out
+}
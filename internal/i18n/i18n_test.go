@@ -0,0 +1,160 @@
+package i18n
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// verbs matches the formatting verbs of a message
+var verbs = regexp.MustCompile(`%[-+# 0-9.]*[a-zA-Z%]`)
+
+// useBundles replaces the message catalogs for the test
+func useBundles(t *testing.T, catalogs map[string]map[string]string) {
+	t.Helper()
+	prev := bundles
+	bundles = catalogs
+	t.Cleanup(func() { bundles = prev })
+}
+
+func TestLocaleParity(t *testing.T) {
+	if langs := Languages(); strings.Join(langs, ",") != English+","+French {
+		t.Fatalf("Languages() = %v", langs)
+	}
+	for _, lang := range Languages() {
+		if lang == Default {
+			continue
+		}
+		var missing, extra []string
+		for key, msg := range bundles[Default] {
+			translated, ok := bundles[lang][key]
+			if !ok {
+				missing = append(missing, key)
+				continue
+			}
+			if got, want := verbs.FindAllString(translated, -1), verbs.FindAllString(msg, -1); strings.Join(got, " ") != strings.Join(want, " ") {
+				t.Errorf("%s: %s has the verbs %q, want %q", lang, key, got, want)
+			}
+		}
+		for key := range bundles[lang] {
+			// Choices are named in the command definitions, only their translations are in the catalogs
+			if _, ok := bundles[Default][key]; !ok && !(strings.HasPrefix(key, "cmd.") && strings.Contains(key, ".choice.")) {
+				extra = append(extra, key)
+			}
+		}
+		sort.Strings(missing)
+		sort.Strings(extra)
+		if len(missing) > 0 {
+			t.Errorf("%s: missing keys %q", lang, missing)
+		}
+		if len(extra) > 0 {
+			t.Errorf("%s: keys missing in %s %q", lang, Default, extra)
+		}
+	}
+}
+
+func TestT(t *testing.T) {
+	useBundles(t, map[string]map[string]string{
+		English: {"greeting": "Hello %s", "farewell": "Bye"},
+		French:  {"greeting": "Bonjour %s"},
+	})
+	tests := []struct {
+		lang, key string
+		args      []interface{}
+		want      string
+	}{
+		{French, "greeting", []interface{}{"Alice"}, "Bonjour Alice"},
+		{English, "greeting", []interface{}{"Alice"}, "Hello Alice"},
+		{French, "farewell", nil, "Bye"},    // missing in French
+		{"de", "greeting", nil, "Hello %s"}, // unsupported language, not formatted without args
+		{French, "unknown", nil, "unknown"}, // missing everywhere
+		{French, "unknown", []interface{}{1}, "unknown"},
+	}
+	for _, tt := range tests {
+		if got := T(tt.lang, tt.key, tt.args...); got != tt.want {
+			t.Errorf("T(%q, %q, %v) = %q, want %q", tt.lang, tt.key, tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestFromLocale(t *testing.T) {
+	tests := []struct {
+		locale discordgo.Locale
+		want   string
+	}{
+		{discordgo.EnglishUS, English},
+		{discordgo.EnglishGB, English},
+		{discordgo.French, French},
+		{discordgo.German, "fallback"},
+	}
+	for _, tt := range tests {
+		if got := FromLocale(tt.locale, "fallback"); got != tt.want {
+			t.Errorf("FromLocale(%q) = %q, want %q", tt.locale, got, tt.want)
+		}
+	}
+}
+
+func TestLocalize(t *testing.T) {
+	useBundles(t, map[string]map[string]string{
+		English: {
+			"cmd.quiet.description":           "Manage the quiet hours",
+			"cmd.quiet.mode.description":      "Set the mode",
+			"cmd.quiet.mode.mode.description": "What happens to announcements",
+			"cmd.quiet.show.description":      "Show the quiet hours",
+		},
+		French: {
+			"cmd.quiet.description":             "Gérer les heures calmes",
+			"cmd.quiet.name":                    "calme",
+			"cmd.quiet.mode.description":        "Choisir le mode",
+			"cmd.quiet.mode.mode.description":   "Ce que deviennent les annonces",
+			"cmd.quiet.mode.mode.choice.silent": "Envoyées sans mention",
+		},
+	})
+	silent := &discordgo.ApplicationCommandOptionChoice{Name: "Sent silently", Value: "silent"}
+	suppress := &discordgo.ApplicationCommandOptionChoice{Name: "Dropped", Value: "suppress"}
+	cmd := Localize(&discordgo.ApplicationCommand{
+		Name: "quiet",
+		Options: []*discordgo.ApplicationCommandOption{
+			{Type: discordgo.ApplicationCommandOptionSubCommand, Name: "mode", Options: []*discordgo.ApplicationCommandOption{
+				{Type: discordgo.ApplicationCommandOptionString, Name: "mode", Choices: []*discordgo.ApplicationCommandOptionChoice{silent, suppress}},
+			}},
+			{Type: discordgo.ApplicationCommandOptionSubCommand, Name: "show"},
+			{Type: discordgo.ApplicationCommandOptionSubCommand, Name: "clear", Description: "Kept"},
+		},
+	})
+
+	french := func(l map[discordgo.Locale]string) string { return l[discordgo.French] }
+	if cmd.Description != "Manage the quiet hours" || french(*cmd.DescriptionLocalizations) != "Gérer les heures calmes" {
+		t.Errorf("command description %q, localizations %v", cmd.Description, *cmd.DescriptionLocalizations)
+	}
+	if cmd.NameLocalizations == nil || french(*cmd.NameLocalizations) != "calme" || len(*cmd.NameLocalizations) != 1 {
+		t.Errorf("command name localizations %v, want the French one", cmd.NameLocalizations)
+	}
+
+	mode := cmd.Options[0]
+	if mode.Description != "Set the mode" || french(mode.DescriptionLocalizations) != "Choisir le mode" || mode.NameLocalizations != nil {
+		t.Errorf("subcommand description %q, localizations %v, name localizations %v", mode.Description, mode.DescriptionLocalizations, mode.NameLocalizations)
+	}
+	nested := mode.Options[0]
+	if nested.Description != "What happens to announcements" || french(nested.DescriptionLocalizations) != "Ce que deviennent les annonces" {
+		t.Errorf("nested option description %q, localizations %v", nested.Description, nested.DescriptionLocalizations)
+	}
+	if silent.Name != "Sent silently" || french(silent.NameLocalizations) != "Envoyées sans mention" {
+		t.Errorf("choice name %q, localizations %v", silent.Name, silent.NameLocalizations)
+	}
+	if suppress.NameLocalizations != nil {
+		t.Errorf("untranslated choice localized: %v", suppress.NameLocalizations)
+	}
+
+	// Options without translations keep their definitions
+	show, cleared := cmd.Options[1], cmd.Options[2]
+	if show.Description != "Show the quiet hours" || show.DescriptionLocalizations != nil {
+		t.Errorf("untranslated option description %q, localizations %v", show.Description, show.DescriptionLocalizations)
+	}
+	if cleared.Description != "Kept" || cleared.DescriptionLocalizations != nil {
+		t.Errorf("option without catalog entry description %q, localizations %v", cleared.Description, cleared.DescriptionLocalizations)
+	}
+}
@@ -0,0 +1,249 @@
+// Package metrics exposes counters, gauges and histograms in the Prometheus text format
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Prefix is prepended to the name of every metric
+const Prefix = "twitchnotifier_"
+
+// labelEscaper escapes label values as required by the exposition format
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// metric is a collector written in the exposition format
+type metric interface {
+	name() string
+	write(w io.Writer)
+}
+
+// Registry holds the metrics exposed by a handler
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]metric
+}
+
+// Default is the registry of the metrics created by the package functions
+var Default = NewRegistry()
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{metrics: map[string]metric{}}
+}
+
+// register adds a metric, replacing a previous metric with the same name
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics[m.name()] = m
+}
+
+// Write writes every metric, sorted by name
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	metrics := make([]metric, 0, len(names))
+	for _, name := range names {
+		metrics = append(metrics, r.metrics[name])
+	}
+	r.mu.Unlock()
+
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
+
+// Handler serves the metrics of the default registry
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		Default.Write(w)
+	})
+}
+
+// series holds the values of a metric by label values
+type series struct {
+	labels []string
+	mu     sync.Mutex
+	values map[string][]string // key → label values
+}
+
+func newSeries(labels []string) series {
+	return series{labels: labels, values: map[string][]string{}}
+}
+
+// key returns the key of label values, s.mu must be held
+func (s *series) key(values []string) string {
+	if len(values) != len(s.labels) {
+		panic(fmt.Sprintf("metrics: %d label values for %d labels", len(values), len(s.labels)))
+	}
+	key := strings.Join(values, "\xff")
+	if _, ok := s.values[key]; !ok {
+		s.values[key] = append([]string(nil), values...)
+	}
+	return key
+}
+
+// sortedKeys returns the keys of the series in a stable order, s.mu must be held
+func (s *series) sortedKeys() []string {
+	keys := make([]string, 0, len(s.values))
+	for key := range s.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// format formats label values with extra label pairs, e.g. {status="200",le="1"}
+func (s *series) format(values []string, extra ...string) string {
+	var pairs []string
+	for n, label := range s.labels {
+		pairs = append(pairs, label+`="`+labelEscaper.Replace(values[n])+`"`)
+	}
+	for n := 0; n+1 < len(extra); n += 2 {
+		pairs = append(pairs, extra[n]+`="`+labelEscaper.Replace(extra[n+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func header(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Counter is a monotonically increasing value, by label values
+type Counter struct {
+	fullName, help string
+	series
+	counts map[string]float64
+}
+
+// NewCounter creates a counter in the default registry
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{fullName: Prefix + name, help: help, series: newSeries(labels), counts: map[string]float64{}}
+	Default.register(c)
+	return c
+}
+
+// Inc adds 1 to the counter of the label values
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v to the counter of the label values
+func (c *Counter) Add(v float64, labelValues ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[c.key(labelValues)] += v
+}
+
+func (c *Counter) name() string { return c.fullName }
+
+func (c *Counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	header(w, c.fullName, c.help, "counter")
+	if len(c.labels) == 0 && len(c.counts) == 0 {
+		fmt.Fprintf(w, "%s 0\n", c.fullName)
+	}
+	for _, key := range c.sortedKeys() {
+		fmt.Fprintf(w, "%s%s %s\n", c.fullName, c.format(c.values[key]), formatFloat(c.counts[key]))
+	}
+}
+
+// Gauge is a value read when the metrics are collected
+type Gauge struct {
+	fullName, help string
+	fn             func() float64
+}
+
+// NewGauge creates a gauge in the default registry, reading its value from fn
+func NewGauge(name, help string, fn func() float64) *Gauge {
+	g := &Gauge{fullName: Prefix + name, help: help, fn: fn}
+	Default.register(g)
+	return g
+}
+
+func (g *Gauge) name() string { return g.fullName }
+
+func (g *Gauge) write(w io.Writer) {
+	header(w, g.fullName, g.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.fullName, formatFloat(g.fn()))
+}
+
+// Histogram counts observations in buckets, by label values
+type Histogram struct {
+	fullName, help string
+	buckets        []float64
+	series
+	counts map[string][]uint64 // cumulative counts by bucket, the last one is +Inf
+	sums   map[string]float64
+}
+
+// NewHistogram creates a histogram in the default registry with the given bucket upper bounds
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{
+		fullName: Prefix + name,
+		help:     help,
+		buckets:  append(append([]float64(nil), buckets...), math.Inf(1)),
+		series:   newSeries(labels),
+		counts:   map[string][]uint64{},
+		sums:     map[string]float64{},
+	}
+	Default.register(h)
+	return h
+}
+
+// Observe records a value for the label values
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := h.key(labelValues)
+	counts, ok := h.counts[key]
+	if !ok {
+		counts = make([]uint64, len(h.buckets))
+		h.counts[key] = counts
+	}
+	for n, bound := range h.buckets {
+		if v <= bound {
+			counts[n]++
+		}
+	}
+	h.sums[key] += v
+}
+
+func (h *Histogram) name() string { return h.fullName }
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	header(w, h.fullName, h.help, "histogram")
+	for _, key := range h.sortedKeys() {
+		values, counts := h.values[key], h.counts[key]
+		for n, bound := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.fullName, h.format(values, "le", formatFloat(bound)), counts[n])
+		}
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.fullName, h.format(values), formatFloat(h.sums[key]))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.fullName, h.format(values), counts[len(counts)-1])
+	}
+}
@@ -0,0 +1,138 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// useRegistry makes the package functions register in an empty registry for the test
+func useRegistry(t *testing.T) *Registry {
+	t.Helper()
+	prev := Default
+	Default = NewRegistry()
+	t.Cleanup(func() { Default = prev })
+	return Default
+}
+
+// output returns what a registry writes
+func output(r *Registry) string {
+	var buf bytes.Buffer
+	r.Write(&buf)
+	return buf.String()
+}
+
+func TestWrite(t *testing.T) {
+	r := useRegistry(t)
+	requests := NewCounter("requests_total", "Requests by status.", "status", "path")
+	NewCounter("failures_total", "Failures.")
+	latency := NewHistogram("latency_seconds", "Latency.", []float64{0.5, 1, 5}, "route")
+	depth := 3.0
+	NewGauge("queue_depth", "Queued items.", func() float64 { return depth })
+
+	requests.Inc("200", "/")
+	requests.Add(2, "200", "/")
+	requests.Inc("500", "/say \"hi\"\\\n")
+	latency.Observe(0.2, "a")
+	latency.Observe(1, "a")
+	latency.Observe(7, "a")
+	latency.Observe(0.5, "b")
+
+	want := `# HELP twitchnotifier_failures_total Failures.
+# TYPE twitchnotifier_failures_total counter
+twitchnotifier_failures_total 0
+# HELP twitchnotifier_latency_seconds Latency.
+# TYPE twitchnotifier_latency_seconds histogram
+twitchnotifier_latency_seconds_bucket{route="a",le="0.5"} 1
+twitchnotifier_latency_seconds_bucket{route="a",le="1"} 2
+twitchnotifier_latency_seconds_bucket{route="a",le="5"} 2
+twitchnotifier_latency_seconds_bucket{route="a",le="+Inf"} 3
+twitchnotifier_latency_seconds_sum{route="a"} 8.2
+twitchnotifier_latency_seconds_count{route="a"} 3
+twitchnotifier_latency_seconds_bucket{route="b",le="0.5"} 1
+twitchnotifier_latency_seconds_bucket{route="b",le="1"} 1
+twitchnotifier_latency_seconds_bucket{route="b",le="5"} 1
+twitchnotifier_latency_seconds_bucket{route="b",le="+Inf"} 1
+twitchnotifier_latency_seconds_sum{route="b"} 0.5
+twitchnotifier_latency_seconds_count{route="b"} 1
+# HELP twitchnotifier_queue_depth Queued items.
+# TYPE twitchnotifier_queue_depth gauge
+twitchnotifier_queue_depth 3
+# HELP twitchnotifier_requests_total Requests by status.
+# TYPE twitchnotifier_requests_total counter
+twitchnotifier_requests_total{status="200",path="/"} 3
+twitchnotifier_requests_total{status="500",path="/say \"hi\"\\\n"} 1
+`
+	if got := output(r); got != want {
+		t.Errorf("Write() =\n%s\nwant\n%s", got, want)
+	}
+
+	// Gauges are read at every collection
+	depth = 0
+	if got := output(r); !strings.Contains(got, "\ntwitchnotifier_queue_depth 0\n") {
+		t.Errorf("gauge not read again:\n%s", got)
+	}
+}
+
+func TestWriteEmpty(t *testing.T) {
+	r := useRegistry(t)
+	NewCounter("events_total", "Events by type.", "type")
+	NewHistogram("duration_seconds", "Duration.", []float64{1})
+
+	// Labeled series only appear once observed
+	want := `# HELP twitchnotifier_duration_seconds Duration.
+# TYPE twitchnotifier_duration_seconds histogram
+# HELP twitchnotifier_events_total Events by type.
+# TYPE twitchnotifier_events_total counter
+`
+	if got := output(r); got != want {
+		t.Errorf("Write() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRegisterReplaces(t *testing.T) {
+	r := useRegistry(t)
+	NewCounter("events_total", "Old.").Inc()
+	NewCounter("events_total", "New.")
+	if got, want := output(r), "# HELP twitchnotifier_events_total New.\n# TYPE twitchnotifier_events_total counter\ntwitchnotifier_events_total 0\n"; got != want {
+		t.Errorf("Write() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestWrongLabelCount(t *testing.T) {
+	useRegistry(t)
+	counter := NewCounter("requests_total", "Requests.", "status")
+	histogram := NewHistogram("latency_seconds", "Latency.", []float64{1}, "route", "method")
+	tests := []struct {
+		name string
+		fn   func()
+	}{
+		{"counter without labels", func() { counter.Inc() }},
+		{"counter with too many labels", func() { counter.Inc("200", "GET") }},
+		{"histogram with too few labels", func() { histogram.Observe(1, "/") }},
+	}
+	for _, tt := range tests {
+		func() {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Errorf("%s: no panic", tt.name)
+				}
+			}()
+			tt.fn()
+		}()
+	}
+}
+
+func TestHandler(t *testing.T) {
+	useRegistry(t)
+	NewCounter("events_total", "Events.")
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if !strings.HasSuffix(rec.Body.String(), "twitchnotifier_events_total 0\n") {
+		t.Errorf("body = %q", rec.Body)
+	}
+}
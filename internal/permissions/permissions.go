@@ -0,0 +1,248 @@
+package permissions
+
+import (
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/discordapi"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/i18n"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/storage"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	policyBucket = "permissions"
+	auditBucket  = "audit"
+	auditKey     = "entries"
+
+	// maxAuditEntries bounds the persisted audit trail
+	maxAuditEntries = 200
+)
+
+// Rule lists the roles and users allowed to run commands
+type Rule struct {
+	Roles []string `json:"roles,omitempty"`
+	Users []string `json:"users,omitempty"`
+}
+
+// Empty reports whether the rule doesn't allow anybody explicitly
+func (r Rule) Empty() bool {
+	return len(r.Roles) == 0 && len(r.Users) == 0
+}
+
+// Matches reports whether the user, or one of its roles, is allowed by the rule
+func (r Rule) Matches(userID string, roles []string) bool {
+	if contains(r.Users, userID) {
+		return true
+	}
+	for _, role := range roles {
+		if contains(r.Roles, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// Policy is the bot-level permission policy of a guild.
+// The guild rule applies to every restricted command, unless the command has its own override.
+type Policy struct {
+	Rule
+	Commands map[string]Rule `json:"commands,omitempty"`
+}
+
+// Invoker describes the member running a command
+type Invoker struct {
+	UserID      string
+	Roles       []string
+	Permissions int64 // computed permissions of the member in the channel
+}
+
+// Decision is the outcome of a permission check
+type Decision struct {
+	Allowed bool
+	Reason  string
+}
+
+// AuditEntry records a permission decision or a policy change
+type AuditEntry struct {
+	Time    time.Time `json:"time"`
+	GuildID string    `json:"guild_id"`
+	UserID  string    `json:"user_id"`
+	Command string    `json:"command"`
+	Action  string    `json:"action"`
+	Detail  string    `json:"detail,omitempty"`
+}
+
+// Manager stores guild policies and evaluates them
+type Manager struct {
+	store  *storage.Store
+	logger *logrus.Logger
+}
+
+// NewManager creates a permission manager backed by the given store
+func NewManager(store *storage.Store, logger *logrus.Logger) *Manager {
+	return &Manager{store: store, logger: logger}
+}
+
+// Policy returns the policy of a guild (an empty policy when none is configured)
+func (m *Manager) Policy(guildID string) (Policy, error) {
+	var p Policy
+	_, err := m.store.Get(policyBucket, guildID, &p)
+	return p, err
+}
+
+// Update loads the policy of a guild, applies fn and saves the result
+func (m *Manager) Update(guildID string, fn func(p *Policy)) (Policy, error) {
+	var p Policy
+	err := m.store.Update(policyBucket, guildID, &p, func(bool) error {
+		fn(&p)
+		for name, rule := range p.Commands {
+			if rule.Empty() {
+				delete(p.Commands, name)
+			}
+		}
+		return nil
+	})
+	return p, err
+}
+
+// Check evaluates whether the invoker may run a command.
+//
+//   - Members with the Administrator permission are always allowed, so a guild can't lock itself out.
+//   - A per-command override, when set, decides alone.
+//   - Otherwise restricted commands (requiredPerms != 0) use the guild rule when set,
+//     and fall back to the Discord permissions of the member.
+//   - Unrestricted commands are open to everybody.
+func (m *Manager) Check(guildID, command string, requiredPerms int64, inv Invoker) (Decision, error) {
+	if inv.Permissions&discordgo.PermissionAdministrator != 0 {
+		return Decision{Allowed: true, Reason: "administrator"}, nil
+	}
+
+	p, err := m.Policy(guildID)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	if rule, ok := p.Commands[command]; ok && !rule.Empty() {
+		if rule.Matches(inv.UserID, inv.Roles) {
+			return Decision{Allowed: true, Reason: "command override"}, nil
+		}
+		return Decision{Reason: "not allowed by command override"}, nil
+	}
+
+	if requiredPerms == 0 {
+		return Decision{Allowed: true, Reason: "unrestricted command"}, nil
+	}
+
+	if !p.Rule.Empty() {
+		if p.Rule.Matches(inv.UserID, inv.Roles) {
+			return Decision{Allowed: true, Reason: "guild policy"}, nil
+		}
+		return Decision{Reason: "not allowed by guild policy"}, nil
+	}
+
+	if inv.Permissions&requiredPerms == requiredPerms {
+		return Decision{Allowed: true, Reason: "discord permissions"}, nil
+	}
+	return Decision{Reason: "missing discord permissions"}, nil
+}
+
+// Audit logs an entry and appends it to the persisted audit trail
+func (m *Manager) Audit(e AuditEntry) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	m.logger.WithFields(logrus.Fields{
+		"audit":    true,
+		"guild_id": e.GuildID,
+		"user_id":  e.UserID,
+		"command":  e.Command,
+		"action":   e.Action,
+	}).Warnf("Audit: %s", e.Detail)
+
+	var entries []AuditEntry
+	err := m.store.Update(auditBucket, auditKey, &entries, func(bool) error {
+		entries = append(entries, e)
+		if len(entries) > maxAuditEntries {
+			entries = entries[len(entries)-maxAuditEntries:]
+		}
+		return nil
+	})
+	if err != nil {
+		m.logger.Errorf("failed to save audit trail: %v", err)
+	}
+}
+
+// AuditTrail returns the persisted audit entries, oldest first
+func (m *Manager) AuditTrail() ([]AuditEntry, error) {
+	var entries []AuditEntry
+	_, err := m.store.Get(auditBucket, auditKey, &entries)
+	return entries, err
+}
+
+func contains(list []string, v string) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Add appends v to list if missing
+func Add(list []string, v string) []string {
+	if contains(list, v) {
+		return list
+	}
+	return append(list, v)
+}
+
+// Remove drops v from list
+func Remove(list []string, v string) []string {
+	out := list[:0]
+	for _, item := range list {
+		if item != v {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// Authorize implements commands.Authorizer: it checks the guild policy for the invoker
+// and records denials in the audit trail.
+func (m *Manager) Authorize(s discordapi.Sender, i *discordgo.InteractionCreate, cmd *discordgo.ApplicationCommand) (bool, string) {
+	lang := i18n.FromLocale(i.Locale, i18n.Default)
+	var required int64
+	if cmd.DefaultMemberPermissions != nil {
+		required = *cmd.DefaultMemberPermissions
+	}
+
+	// Outside of a guild there is no policy, only unrestricted commands can run
+	if i.Member == nil || i.Member.User == nil {
+		if required == 0 {
+			return true, ""
+		}
+		return false, i18n.T(lang, "permissions.guild_only")
+	}
+
+	d, err := m.Check(i.GuildID, cmd.Name, required, Invoker{
+		UserID:      i.Member.User.ID,
+		Roles:       i.Member.Roles,
+		Permissions: i.Member.Permissions,
+	})
+	if err != nil {
+		m.logger.Errorf("permission check failed for /%s: %v", cmd.Name, err)
+		return false, i18n.T(lang, "permissions.check_failed")
+	}
+	if !d.Allowed {
+		m.Audit(AuditEntry{
+			GuildID: i.GuildID,
+			UserID:  i.Member.User.ID,
+			Command: cmd.Name,
+			Action:  "denied",
+			Detail:  d.Reason,
+		})
+		return false, i18n.T(lang, "permissions.denied")
+	}
+	return true, ""
+}
@@ -0,0 +1,155 @@
+package permissions
+
+import (
+	"io"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/storage"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	testGuild  = "700000000000000001"
+	testUser   = "700000000000000003"
+	testRole   = "700000000000000005"
+	otherRole  = "700000000000000006"
+	otherGuild = "700000000000000007"
+)
+
+// newTestManager returns a manager backed by an in-memory store
+func newTestManager(t *testing.T) *Manager {
+	t.Helper()
+	store, err := storage.Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := logrus.New()
+	logger.Out = io.Discard
+	return NewManager(store, logger)
+}
+
+func TestCheckPrecedence(t *testing.T) {
+	manage := int64(discordgo.PermissionManageServer)
+	tests := []struct {
+		name     string
+		policy   Policy
+		command  string
+		required int64
+		inv      Invoker
+		allowed  bool
+		reason   string
+	}{
+		{
+			name:     "administrator beats a command override",
+			policy:   Policy{Commands: map[string]Rule{"filter": {Roles: []string{otherRole}}}},
+			command:  "filter",
+			required: manage,
+			inv:      Invoker{UserID: testUser, Permissions: discordgo.PermissionAdministrator},
+			allowed:  true,
+			reason:   "administrator",
+		},
+		{
+			name:     "command override allows without the discord permissions",
+			policy:   Policy{Rule: Rule{Roles: []string{otherRole}}, Commands: map[string]Rule{"filter": {Roles: []string{testRole}}}},
+			command:  "filter",
+			required: manage,
+			inv:      Invoker{UserID: testUser, Roles: []string{testRole}},
+			allowed:  true,
+			reason:   "command override",
+		},
+		{
+			name:     "command override denies despite the guild rule",
+			policy:   Policy{Rule: Rule{Roles: []string{testRole}}, Commands: map[string]Rule{"filter": {Users: []string{"someone else"}}}},
+			command:  "filter",
+			required: manage,
+			inv:      Invoker{UserID: testUser, Roles: []string{testRole}, Permissions: manage},
+			reason:   "not allowed by command override",
+		},
+		{
+			name:    "command override restricts an unrestricted command",
+			policy:  Policy{Commands: map[string]Rule{"ping": {Roles: []string{otherRole}}}},
+			command: "ping",
+			inv:     Invoker{UserID: testUser, Roles: []string{testRole}},
+			reason:  "not allowed by command override",
+		},
+		{
+			name:    "unrestricted command ignores the guild rule",
+			policy:  Policy{Rule: Rule{Roles: []string{otherRole}}},
+			command: "ping",
+			inv:     Invoker{UserID: testUser},
+			allowed: true,
+			reason:  "unrestricted command",
+		},
+		{
+			name:     "guild rule allows without the discord permissions",
+			policy:   Policy{Rule: Rule{Users: []string{testUser}}},
+			command:  "filter",
+			required: manage,
+			inv:      Invoker{UserID: testUser},
+			allowed:  true,
+			reason:   "guild policy",
+		},
+		{
+			name:     "guild rule denies despite the discord permissions",
+			policy:   Policy{Rule: Rule{Roles: []string{otherRole}}},
+			command:  "filter",
+			required: manage,
+			inv:      Invoker{UserID: testUser, Roles: []string{testRole}, Permissions: manage},
+			reason:   "not allowed by guild policy",
+		},
+		{
+			name:     "discord permissions without a policy",
+			command:  "filter",
+			required: manage,
+			inv:      Invoker{UserID: testUser, Permissions: manage | discordgo.PermissionSendMessages},
+			allowed:  true,
+			reason:   "discord permissions",
+		},
+		{
+			name:     "missing discord permissions",
+			command:  "filter",
+			required: manage | discordgo.PermissionManageRoles,
+			inv:      Invoker{UserID: testUser, Permissions: manage},
+			reason:   "missing discord permissions",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(t)
+			if _, err := m.Update(testGuild, func(p *Policy) { *p = tt.policy }); err != nil {
+				t.Fatal(err)
+			}
+			d, err := m.Check(testGuild, tt.command, tt.required, tt.inv)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.Allowed != tt.allowed || d.Reason != tt.reason {
+				t.Errorf("Check = %+v, want allowed %v (%s)", d, tt.allowed, tt.reason)
+			}
+
+			// The policy of a guild doesn't apply to the others
+			d, err = m.Check(otherGuild, tt.command, tt.required, tt.inv)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := tt.inv.Permissions&discordgo.PermissionAdministrator != 0 || tt.inv.Permissions&tt.required == tt.required
+			if d.Allowed != want {
+				t.Errorf("Check in another guild = %+v, want allowed %v", d, want)
+			}
+		})
+	}
+}
+
+func TestUpdateDropsEmptyOverrides(t *testing.T) {
+	m := newTestManager(t)
+	p, err := m.Update(testGuild, func(p *Policy) {
+		p.Commands = map[string]Rule{"filter": {}, "watch": {Roles: []string{testRole}}}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p.Commands["filter"]; ok || len(p.Commands) != 1 {
+		t.Errorf("commands = %v, want only the watch override", p.Commands)
+	}
+}
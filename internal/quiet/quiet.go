@@ -0,0 +1,187 @@
+// Package quiet implements the quiet hours of a guild: weekly time windows, in the guild
+// timezone, during which announcements are suppressed, sent silently or queued for a digest.
+package quiet
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Modes of quiet hours
+const (
+	ModeSuppress = "suppress" // announcements are dropped
+	ModeSilent   = "silent"   // announcements are sent without pings nor push notifications
+	ModeDigest   = "digest"   // announcements are queued and posted as one digest when the window ends
+)
+
+// Modes lists the supported modes
+var Modes = []string{ModeSuppress, ModeSilent, ModeDigest}
+
+// Rule is a daily time window, on some weekdays. A window ending before it starts
+// (e.g. 22:00-08:00) ends the next day, it belongs to the weekday it starts on.
+type Rule struct {
+	Days  []time.Weekday `json:"days,omitempty"` // every day when empty
+	Start string         `json:"start"`          // HH:MM
+	End   string         `json:"end"`            // HH:MM
+}
+
+// Hours are the quiet hours of a guild
+type Hours struct {
+	Mode  string `json:"mode,omitempty"` // ModeSilent when empty
+	Rules []Rule `json:"rules,omitempty"`
+}
+
+// EffectiveMode returns the mode of the quiet hours, silent by default
+func (h *Hours) EffectiveMode() string {
+	if h.Mode == "" {
+		return ModeSilent
+	}
+	return h.Mode
+}
+
+// Window reports whether now is within the quiet hours, evaluated in loc, and when the window ends.
+// When several windows overlap, the latest end is returned.
+func (h *Hours) Window(now time.Time, loc *time.Location) (bool, time.Time) {
+	now = now.In(loc)
+	active := false
+	var end time.Time
+	for _, rule := range h.Rules {
+		start, stop, err := rule.minutes()
+		if err != nil {
+			continue
+		}
+		// A window started today, or yesterday and running past midnight
+		for _, offset := range []int{0, -1} {
+			day := time.Date(now.Year(), now.Month(), now.Day()+offset, 0, 0, 0, 0, loc)
+			if !rule.On(day.Weekday()) {
+				continue
+			}
+			from := day.Add(time.Duration(start) * time.Minute)
+			to := day.Add(time.Duration(stop) * time.Minute)
+			if stop <= start {
+				to = time.Date(day.Year(), day.Month(), day.Day()+1, 0, 0, 0, 0, loc).Add(time.Duration(stop) * time.Minute)
+			}
+			if !now.Before(from) && now.Before(to) {
+				active = true
+				if to.After(end) {
+					end = to
+				}
+			}
+		}
+	}
+	return active, end
+}
+
+// On reports whether the rule applies to windows starting on a weekday
+func (r *Rule) On(day time.Weekday) bool {
+	if len(r.Days) == 0 {
+		return true
+	}
+	for _, d := range r.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// minutes returns the start and end of the rule in minutes since midnight
+func (r *Rule) minutes() (int, int, error) {
+	start, err := ParseClock(r.Start)
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := ParseClock(r.End)
+	return start, end, err
+}
+
+// String formats the rule, e.g. "mon,tue 22:00-08:00"
+func (r Rule) String() string {
+	days := "every day"
+	if len(r.Days) > 0 {
+		names := make([]string, len(r.Days))
+		for i, d := range r.Days {
+			names[i] = dayNames[d]
+		}
+		days = strings.Join(names, ",")
+	}
+	return fmt.Sprintf("%s %s-%s", days, r.Start, r.End)
+}
+
+// ParseClock parses a HH:MM time of day and returns the minutes since midnight
+func ParseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q (expected HH:MM)", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+var dayNames = map[time.Weekday]string{
+	time.Monday:    "mon",
+	time.Tuesday:   "tue",
+	time.Wednesday: "wed",
+	time.Thursday:  "thu",
+	time.Friday:    "fri",
+	time.Saturday:  "sat",
+	time.Sunday:    "sun",
+}
+
+// dayWords maps the accepted names of weekdays to them: their short and full names, and the usual
+// tues, thur and thurs abbreviations
+var dayWords = map[string]time.Weekday{
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"tues":      time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"thur":      time.Thursday,
+	"thurs":     time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+	"sunday":    time.Sunday,
+}
+
+func init() {
+	for d, name := range dayNames {
+		dayWords[name] = d
+	}
+}
+
+// ParseDays parses a comma-separated list of weekdays (mon,tue,... or monday,tuesday,...),
+// "weekdays" or "weekend". An empty list means every day.
+func ParseDays(s string) ([]time.Weekday, error) {
+	seen := map[time.Weekday]bool{}
+	for _, part := range strings.Split(strings.ToLower(s), ",") {
+		part = strings.TrimSpace(part)
+		switch part {
+		case "":
+			continue
+		case "weekdays":
+			for d := time.Monday; d <= time.Friday; d++ {
+				seen[d] = true
+			}
+			continue
+		case "weekend":
+			seen[time.Saturday], seen[time.Sunday] = true, true
+			continue
+		}
+		d, ok := dayWords[part]
+		if !ok {
+			return nil, fmt.Errorf("invalid day %q (expected mon, tue, wed, thu, fri, sat, sun, weekdays or weekend)", part)
+		}
+		seen[d] = true
+	}
+	days := make([]time.Weekday, 0, len(seen))
+	for d := range seen {
+		days = append(days, d)
+	}
+	// Monday first
+	sort.Slice(days, func(i, j int) bool { return (days[i]+6)%7 < (days[j]+6)%7 })
+	if len(days) == 7 {
+		return nil, nil
+	}
+	return days, nil
+}
@@ -0,0 +1,133 @@
+package quiet
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestParseDays(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string // days, Monday first
+	}{
+		{"", "[]"},
+		{"mon", "[Monday]"},
+		{"Monday, TUE", "[Monday Tuesday]"},
+		{"sun,sat,mon", "[Monday Saturday Sunday]"},
+		{"tues,thur", "[Tuesday Thursday]"},
+		{"thurs, wednesday", "[Wednesday Thursday]"},
+		{"fri,fri,friday", "[Friday]"},
+		{"weekdays", "[Monday Tuesday Wednesday Thursday Friday]"},
+		{"weekend", "[Saturday Sunday]"},
+		{"weekend,fri", "[Friday Saturday Sunday]"},
+		{"weekdays,weekend", "[]"}, // every day
+		{" , mon ,", "[Monday]"},
+	}
+	for _, tt := range tests {
+		days, err := ParseDays(tt.in)
+		if err != nil {
+			t.Errorf("ParseDays(%q): %v", tt.in, err)
+			continue
+		}
+		if got := fmt.Sprint(days); got != tt.want {
+			t.Errorf("ParseDays(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{"monkey", "sunny", "mo", "t", "satur", "fridays", "mon;tue", "lundi"} {
+		if days, err := ParseDays(in); err == nil {
+			t.Errorf("ParseDays(%q) = %v, want an error", in, days)
+		}
+	}
+}
+
+func TestParseClock(t *testing.T) {
+	for in, want := range map[string]int{"00:00": 0, "08:30": 510, " 23:59 ": 1439, "7:05": 425} {
+		if got, err := ParseClock(in); err != nil || got != want {
+			t.Errorf("ParseClock(%q) = %d, %v, want %d", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"24:00", "12:60", "8h30", "", "noon"} {
+		if _, err := ParseClock(in); err == nil {
+			t.Errorf("ParseClock(%q) succeeded, want an error", in)
+		}
+	}
+}
+
+func TestRuleString(t *testing.T) {
+	if got := (Rule{Start: "22:00", End: "08:00"}).String(); got != "every day 22:00-08:00" {
+		t.Errorf("String() = %q", got)
+	}
+	if got := (Rule{Days: []time.Weekday{time.Monday, time.Sunday}, Start: "01:00", End: "02:00"}).String(); got != "mon,sun 01:00-02:00" {
+		t.Errorf("String() = %q", got)
+	}
+}
+
+func TestWindow(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skipf("no timezone database: %v", err)
+	}
+	// 2024-05-06 is a Monday
+	at := func(day, hour, minute int) time.Time { return time.Date(2024, 5, day, hour, minute, 0, 0, paris) }
+	night := Rule{Start: "22:00", End: "08:00"}
+	weekdayNights := Rule{Days: []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}, Start: "23:00", End: "07:00"}
+	lunch := Rule{Start: "12:00", End: "14:00"}
+	allDay := Rule{Days: []time.Weekday{time.Sunday}, Start: "10:00", End: "10:00"}
+
+	tests := []struct {
+		name  string
+		rules []Rule
+		now   time.Time
+		quiet bool
+		end   time.Time
+	}{
+		{"before a window", []Rule{lunch}, at(6, 11, 59), false, time.Time{}},
+		{"at the start", []Rule{lunch}, at(6, 12, 0), true, at(6, 14, 0)},
+		{"at the end", []Rule{lunch}, at(6, 14, 0), false, time.Time{}},
+		{"across midnight, evening", []Rule{night}, at(6, 23, 30), true, at(7, 8, 0)},
+		{"across midnight, morning", []Rule{night}, at(7, 7, 59), true, at(7, 8, 0)},
+		{"across midnight, after", []Rule{night}, at(7, 8, 0), false, time.Time{}},
+
+		// A window across midnight belongs to the day it starts on
+		{"started on a weekday", []Rule{weekdayNights}, at(11, 6, 0), true, at(11, 7, 0)},       // Friday night
+		{"not started on a weekend", []Rule{weekdayNights}, at(11, 23, 30), false, time.Time{}}, // Saturday night
+		{"not started on Sunday", []Rule{weekdayNights}, at(6, 6, 0), false, time.Time{}},       // Monday morning, after Sunday
+		{"started on Sunday night", []Rule{{Days: []time.Weekday{time.Sunday}, Start: "22:00", End: "02:00"}}, at(6, 1, 0), true, at(6, 2, 0)},
+
+		// Overlapping windows end with the latest one
+		{"overlapping", []Rule{night, weekdayNights}, at(7, 6, 30), true, at(7, 8, 0)},
+		{"overlapping, reversed", []Rule{weekdayNights, night}, at(7, 6, 30), true, at(7, 8, 0)},
+		{"start equals end lasts a day", []Rule{allDay}, at(12, 9, 0), false, time.Time{}},
+		{"start equals end, inside", []Rule{allDay}, at(13, 9, 0), true, at(13, 10, 0)},
+
+		{"invalid rules are ignored", []Rule{{Start: "25:00", End: "08:00"}, lunch}, at(6, 13, 0), true, at(6, 14, 0)},
+		{"no rules", nil, at(6, 13, 0), false, time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &Hours{Rules: tt.rules}
+			quiet, end := h.Window(tt.now, paris)
+			if quiet != tt.quiet || !end.Equal(tt.end) {
+				t.Errorf("Window(%s) = %v until %s, want %v until %s", tt.now, quiet, end, tt.quiet, tt.end)
+			}
+		})
+	}
+}
+
+func TestWindowLocation(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skipf("no timezone database: %v", err)
+	}
+	// 14:00 UTC is 23:00 in Tokyo, within its night
+	h := &Hours{Rules: []Rule{{Start: "22:00", End: "06:00"}}}
+	quiet, end := h.Window(time.Date(2024, 5, 6, 14, 0, 0, 0, time.UTC), tokyo)
+	if want := time.Date(2024, 5, 7, 6, 0, 0, 0, tokyo); !quiet || !end.Equal(want) {
+		t.Errorf("Window = %v until %s, want true until %s", quiet, end, want)
+	}
+	if h.EffectiveMode() != ModeSilent {
+		t.Errorf("EffectiveMode() = %q, want %q by default", h.EffectiveMode(), ModeSilent)
+	}
+}
@@ -0,0 +1,461 @@
+// Package rules implements the filter expressions deciding whether a stream is announced.
+//
+// An expression combines conditions over the fields of a stream with and, or, not and parentheses:
+//
+//	game in ["Just Chatting", Minecraft] and title contains "[FR]" and not title contains "!rerun"
+//	not mature or viewers >= 50
+//
+// Fields are game, title, language, broadcaster (login), viewers (number) and mature (boolean,
+// alone or compared with true/false). Operators are =, !=, contains, in, <, <=, > and >=.
+// String comparisons are case-insensitive.
+package rules
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Stream holds the fields of a stream rules are evaluated against
+type Stream struct {
+	Broadcaster string
+	Game        string
+	Title       string
+	Language    string
+	Viewers     int
+	Mature      bool
+}
+
+// Rule is a parsed filter expression
+type Rule struct {
+	source string
+	root   node
+}
+
+// String returns the source of the rule
+func (r *Rule) String() string {
+	return r.source
+}
+
+// Match reports whether a stream passes the rule
+func (r *Rule) Match(s *Stream) bool {
+	ok, _ := r.root.eval(s, 0)
+	return ok
+}
+
+// Explain evaluates the rule and returns, along with the result, one line per condition
+// telling the value it was evaluated against and whether it passed
+func (r *Rule) Explain(s *Stream) (bool, []string) {
+	return r.root.eval(s, 0)
+}
+
+// node is a node of the expression tree
+type node interface {
+	eval(s *Stream, depth int) (bool, []string)
+}
+
+type andNode struct{ children []node }
+type orNode struct{ children []node }
+type notNode struct{ child node }
+
+type condNode struct {
+	field  string
+	op     string
+	values []string
+}
+
+func mark(ok bool) string {
+	if ok {
+		return "✅"
+	}
+	return "❌"
+}
+
+func indent(depth int) string {
+	return strings.Repeat("  ", depth)
+}
+
+// Every child is evaluated, without short-circuit, so the explanation covers every condition
+func (n *andNode) eval(s *Stream, depth int) (bool, []string) {
+	result := true
+	lines := []string{""}
+	for _, child := range n.children {
+		ok, sub := child.eval(s, depth+1)
+		result = result && ok
+		lines = append(lines, sub...)
+	}
+	lines[0] = fmt.Sprintf("%s%s all of:", indent(depth), mark(result))
+	return result, lines
+}
+
+func (n *orNode) eval(s *Stream, depth int) (bool, []string) {
+	result := false
+	lines := []string{""}
+	for _, child := range n.children {
+		ok, sub := child.eval(s, depth+1)
+		result = result || ok
+		lines = append(lines, sub...)
+	}
+	lines[0] = fmt.Sprintf("%s%s any of:", indent(depth), mark(result))
+	return result, lines
+}
+
+func (n *notNode) eval(s *Stream, depth int) (bool, []string) {
+	ok, sub := n.child.eval(s, depth+1)
+	return !ok, append([]string{fmt.Sprintf("%s%s not:", indent(depth), mark(!ok))}, sub...)
+}
+
+func (n *condNode) eval(s *Stream, depth int) (bool, []string) {
+	var actual string
+	var ok bool
+	switch n.field {
+	case "viewers":
+		actual = strconv.Itoa(s.Viewers)
+		want, _ := strconv.Atoi(n.values[0])
+		switch n.op {
+		case "=":
+			ok = s.Viewers == want
+		case "!=":
+			ok = s.Viewers != want
+		case "<":
+			ok = s.Viewers < want
+		case "<=":
+			ok = s.Viewers <= want
+		case ">":
+			ok = s.Viewers > want
+		case ">=":
+			ok = s.Viewers >= want
+		}
+	case "mature":
+		actual = strconv.FormatBool(s.Mature)
+		want := n.values[0] == "true"
+		ok = (s.Mature == want) == (n.op == "=")
+	default:
+		actual = s.field(n.field)
+		switch n.op {
+		case "=":
+			ok = strings.EqualFold(actual, n.values[0])
+		case "!=":
+			ok = !strings.EqualFold(actual, n.values[0])
+		case "contains":
+			ok = strings.Contains(strings.ToLower(actual), strings.ToLower(n.values[0]))
+		case "in":
+			for _, v := range n.values {
+				if strings.EqualFold(actual, v) {
+					ok = true
+					break
+				}
+			}
+		}
+		actual = strconv.Quote(actual)
+	}
+	return ok, []string{fmt.Sprintf("%s%s %s (%s = %s)", indent(depth), mark(ok), n.String(), n.field, actual)}
+}
+
+// String formats the condition back to the expression syntax
+func (n *condNode) String() string {
+	quoted := make([]string, len(n.values))
+	for i, v := range n.values {
+		if n.field == "viewers" || n.field == "mature" {
+			quoted[i] = v
+		} else {
+			quoted[i] = strconv.Quote(v)
+		}
+	}
+	if n.op == "in" {
+		return fmt.Sprintf("%s in [%s]", n.field, strings.Join(quoted, ", "))
+	}
+	return fmt.Sprintf("%s %s %s", n.field, n.op, quoted[0])
+}
+
+// field returns the value of a string field
+func (s *Stream) field(name string) string {
+	switch name {
+	case "broadcaster":
+		return s.Broadcaster
+	case "game":
+		return s.Game
+	case "title":
+		return s.Title
+	case "language":
+		return s.Language
+	}
+	return ""
+}
+
+// fieldOperators lists the operators accepted by each field
+var fieldOperators = map[string][]string{
+	"broadcaster": {"=", "!=", "contains", "in"},
+	"game":        {"=", "!=", "contains", "in"},
+	"title":       {"=", "!=", "contains", "in"},
+	"language":    {"=", "!=", "contains", "in"},
+	"viewers":     {"=", "!=", "<", "<=", ">", ">="},
+	"mature":      {"=", "!="},
+}
+
+// Parse parses a filter expression
+func Parse(expr string) (*Rule, error) {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty expression")
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.tokens[p.pos].text, p.tokens[p.pos].pos+1)
+	}
+	return &Rule{source: strings.TrimSpace(expr), root: root}, nil
+}
+
+type token struct {
+	text   string
+	quoted bool // string literal, never a keyword
+	pos    int
+}
+
+// tokenize splits an expression into words, operators, punctuation and quoted strings
+func tokenize(expr string) ([]token, error) {
+	var tokens []token
+	runes := []rune(expr)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case r == ' ' || r == '\t' || r == '\n':
+			i++
+		case r == '(' || r == ')' || r == '[' || r == ']' || r == ',':
+			tokens = append(tokens, token{text: string(r), pos: i})
+			i++
+		case r == '=' || r == '!' || r == '<' || r == '>':
+			op := string(r)
+			if i+1 < len(runes) && runes[i+1] == '=' {
+				op += "="
+			}
+			if op == "!" {
+				return nil, fmt.Errorf("unexpected \"!\" at position %d (use != or not)", i+1)
+			}
+			tokens = append(tokens, token{text: strings.Replace(op, "==", "=", 1), pos: i})
+			i += len(op)
+		case r == '"' || r == '\'':
+			start := i
+			var sb strings.Builder
+			i++
+			for i < len(runes) && runes[i] != r {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				sb.WriteRune(runes[i])
+				i++
+			}
+			if i >= len(runes) {
+				return nil, fmt.Errorf("unterminated string at position %d", start+1)
+			}
+			i++
+			tokens = append(tokens, token{text: sb.String(), quoted: true, pos: start})
+		default:
+			start := i
+			for i < len(runes) && !strings.ContainsRune(" \t\n()[],=!<>\"'", runes[i]) {
+				i++
+			}
+			tokens = append(tokens, token{text: string(runes[start:i]), pos: start})
+		}
+	}
+	return tokens, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() *token {
+	if p.pos < len(p.tokens) {
+		return &p.tokens[p.pos]
+	}
+	return nil
+}
+
+// keyword reports whether the next token is the given keyword, and consumes it
+func (p *parser) keyword(kw string) bool {
+	t := p.peek()
+	if t != nil && !t.quoted && strings.EqualFold(t.text, kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) error {
+	t := p.peek()
+	if t == nil {
+		return fmt.Errorf("expected %q at the end of the expression", text)
+	}
+	if t.quoted || t.text != text {
+		return fmt.Errorf("expected %q at position %d, got %q", text, t.pos+1, t.text)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	first, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	children := []node{first}
+	for p.keyword("or") {
+		next, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, next)
+	}
+	if len(children) == 1 {
+		return first, nil
+	}
+	return &orNode{children: children}, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	first, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	children := []node{first}
+	for p.keyword("and") {
+		next, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, next)
+	}
+	if len(children) == 1 {
+		return first, nil
+	}
+	return &andNode{children: children}, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.keyword("not") {
+		child, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{child: child}, nil
+	}
+	if t := p.peek(); t != nil && !t.quoted && t.text == "(" {
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return inner, p.expect(")")
+	}
+	return p.parseCondition()
+}
+
+func (p *parser) parseCondition() (node, error) {
+	t := p.peek()
+	if t == nil {
+		return nil, fmt.Errorf("expected a condition at the end of the expression")
+	}
+	field := strings.ToLower(t.text)
+	ops, ok := fieldOperators[field]
+	if t.quoted || !ok {
+		return nil, fmt.Errorf("unknown field %q at position %d (fields: broadcaster, game, title, language, viewers, mature)", t.text, t.pos+1)
+	}
+	p.pos++
+
+	opTok := p.peek()
+	// "mature" alone means "mature = true"
+	if field == "mature" && (opTok == nil || !isOperator(opTok)) {
+		return &condNode{field: field, op: "=", values: []string{"true"}}, nil
+	}
+	if opTok == nil {
+		return nil, fmt.Errorf("expected an operator after %q", field)
+	}
+	op := strings.ToLower(opTok.text)
+	if opTok.quoted || !contains(ops, op) {
+		return nil, fmt.Errorf("operator %q at position %d is not valid for %s (valid: %s)", opTok.text, opTok.pos+1, field, strings.Join(ops, " "))
+	}
+	p.pos++
+
+	var values []string
+	if op == "in" {
+		if err := p.expect("["); err != nil {
+			return nil, err
+		}
+		for {
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+			if t := p.peek(); t != nil && !t.quoted && t.text == "," {
+				p.pos++
+				continue
+			}
+			break
+		}
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+	} else {
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		values = []string{v}
+	}
+
+	switch field {
+	case "viewers":
+		if _, err := strconv.Atoi(values[0]); err != nil {
+			return nil, fmt.Errorf("viewers must be compared with a number, got %q", values[0])
+		}
+	case "mature":
+		values[0] = strings.ToLower(values[0])
+		if values[0] != "true" && values[0] != "false" {
+			return nil, fmt.Errorf("mature must be compared with true or false, got %q", values[0])
+		}
+	}
+	return &condNode{field: field, op: op, values: values}, nil
+}
+
+// value reads a literal: a quoted string or a bare word
+func (p *parser) value() (string, error) {
+	t := p.peek()
+	if t == nil {
+		return "", fmt.Errorf("expected a value at the end of the expression")
+	}
+	if !t.quoted && strings.ContainsAny(t.text, "()[],=<>") {
+		return "", fmt.Errorf("expected a value at position %d, got %q", t.pos+1, t.text)
+	}
+	p.pos++
+	return t.text, nil
+}
+
+func isOperator(t *token) bool {
+	if t.quoted {
+		return false
+	}
+	switch strings.ToLower(t.text) {
+	case "=", "!=", "<", "<=", ">", ">=", "contains", "in":
+		return true
+	}
+	return false
+}
+
+func contains(list []string, v string) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,195 @@
+package rules
+
+import (
+	"strings"
+	"testing"
+)
+
+// dump formats an expression tree with explicit grouping, e.g. and(not(mature = true), viewers >= 50)
+func dump(n node) string {
+	list := func(name string, children []node) string {
+		parts := make([]string, len(children))
+		for i, child := range children {
+			parts[i] = dump(child)
+		}
+		return name + "(" + strings.Join(parts, ", ") + ")"
+	}
+	switch n := n.(type) {
+	case *andNode:
+		return list("and", n.children)
+	case *orNode:
+		return list("or", n.children)
+	case *notNode:
+		return "not(" + dump(n.child) + ")"
+	case *condNode:
+		return n.String()
+	}
+	return "?"
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		expr, want string
+	}{
+		{`game = Minecraft`, `game = "Minecraft"`},
+		{`GAME == "Just Chatting"`, `game = "Just Chatting"`},
+		{`title contains '[FR]'`, `title contains "[FR]"`},
+		{`title contains "say \"hi\""`, `title contains "say \"hi\""`},
+		{`language != en`, `language != "en"`},
+		{`broadcaster in [alice, "bob", 'carol']`, `broadcaster in ["alice", "bob", "carol"]`},
+		{`game in ["Just Chatting"]`, `game in ["Just Chatting"]`},
+		{`viewers >= 50`, `viewers >= 50`},
+		{`viewers<10`, `viewers < 10`},
+		{`viewers > 1 and viewers <= 2 and viewers != 3`, `and(viewers > 1, viewers <= 2, viewers != 3)`},
+		{`mature`, `mature = true`},
+		{`mature = FALSE`, `mature = false`},
+		{`not mature`, `not(mature = true)`},
+		{`not not mature`, `not(not(mature = true))`},
+
+		// and binds tighter than or, not tighter than and
+		{`game = a or game = b and game = c`, `or(game = "a", and(game = "b", game = "c"))`},
+		{`game = a and game = b or game = c`, `or(and(game = "a", game = "b"), game = "c")`},
+		{`not game = a and game = b`, `and(not(game = "a"), game = "b")`},
+		{`not mature or viewers >= 50`, `or(not(mature = true), viewers >= 50)`},
+		{`mature and viewers > 5`, `and(mature = true, viewers > 5)`},
+
+		// Parentheses override the precedence
+		{`(game = a or game = b) and game = c`, `and(or(game = "a", game = "b"), game = "c")`},
+		{`not (game = a or mature)`, `not(or(game = "a", mature = true))`},
+		{`((viewers > 1))`, `viewers > 1`},
+
+		// Keywords are case-insensitive, quoted keywords are values
+		{`game = a OR NOT title CONTAINS "and"`, `or(game = "a", not(title contains "and"))`},
+		{`title = "or"`, `title = "or"`},
+	}
+	for _, tt := range tests {
+		rule, err := Parse(tt.expr)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.expr, err)
+			continue
+		}
+		if got := dump(rule.root); got != tt.want {
+			t.Errorf("Parse(%q) = %s, want %s", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		expr, want string
+	}{
+		{``, `empty expression`},
+		{`   `, `empty expression`},
+		{`views > 5`, `unknown field "views" at position 1`},
+		{`game = a and "title" = b`, `unknown field "title" at position 14`},
+		{`game`, `expected an operator after "game"`},
+		{`game > a`, `operator ">" at position 6 is not valid for game (valid: = != contains in)`},
+		{`viewers contains 5`, `operator "contains" at position 9 is not valid for viewers`},
+		{`viewers > many`, `viewers must be compared with a number, got "many"`},
+		{`viewers > "5x"`, `viewers must be compared with a number, got "5x"`},
+		{`mature = yes`, `mature must be compared with true or false, got "yes"`},
+		{`game =`, `expected a value at the end of the expression`},
+		{`game = )`, `expected a value at position 8, got ")"`},
+		{`game in a`, `expected "[" at position 9, got "a"`},
+		{`game in [a, b`, `expected "]" at the end of the expression`},
+		{`game in [a b]`, `expected "]" at position 12, got "b"`},
+		{`title contains "open`, `unterminated string at position 16`},
+		{`title ! "x"`, `unexpected "!" at position 7 (use != or not)`},
+		{`(game = a`, `expected ")" at the end of the expression`},
+		{`game = a)`, `unexpected ")" at position 9`},
+		{`game = a game = b`, `unexpected "game" at position 10`},
+		{`game = a and`, `expected a condition at the end of the expression`},
+		{`not`, `expected a condition at the end of the expression`},
+		{`game = a or or game = b`, `unknown field "or" at position 13`},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.expr)
+		if err == nil {
+			t.Errorf("Parse(%q) succeeded, want error %q", tt.expr, tt.want)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Parse(%q) error = %q, want %q", tt.expr, err, tt.want)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	stream := &Stream{
+		Broadcaster: "alice",
+		Game:        "Just Chatting",
+		Title:       "[FR] Chill stream !rerun",
+		Language:    "fr",
+		Viewers:     50,
+		Mature:      true,
+	}
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`game = "just chatting"`, true},
+		{`game != "Just Chatting"`, false},
+		{`game in [Minecraft, "JUST CHATTING"]`, true},
+		{`game in [Minecraft]`, false},
+		{`title contains "[fr]"`, true},
+		{`title contains "rerun" and not title contains "!rerun"`, false},
+		{`broadcaster = Alice`, true},
+		{`language in [en, de]`, false},
+		{`viewers = 50`, true},
+		{`viewers != 50`, false},
+		{`viewers < 50`, false},
+		{`viewers <= 50`, true},
+		{`viewers > 49`, true},
+		{`viewers >= 51`, false},
+		{`mature`, true},
+		{`mature = false`, false},
+		{`mature != false`, true},
+		{`not mature or viewers >= 50`, true},
+		{`not mature or viewers >= 100`, false},
+		{`game = Minecraft or game = "Just Chatting" and viewers > 10`, true},
+		{`(game = Minecraft or game = "Just Chatting") and viewers > 100`, false},
+		{`not (game = Minecraft or language = en)`, true},
+	}
+	for _, tt := range tests {
+		rule, err := Parse(tt.expr)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.expr, err)
+			continue
+		}
+		if got := rule.Match(stream); got != tt.want {
+			t.Errorf("%q matched %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestExplain(t *testing.T) {
+	rule, err := Parse(`  game in ["Just Chatting", Minecraft] and not (mature or viewers < 10)  `)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.String() != `game in ["Just Chatting", Minecraft] and not (mature or viewers < 10)` {
+		t.Errorf("String() = %q, want the trimmed source", rule.String())
+	}
+
+	ok, lines := rule.Explain(&Stream{Game: "minecraft", Viewers: 5})
+	want := []string{
+		`❌ all of:`,
+		`  ✅ game in ["Just Chatting", "Minecraft"] (game = "minecraft")`,
+		`  ❌ not:`,
+		`    ✅ any of:`,
+		`      ❌ mature = true (mature = false)`,
+		`      ✅ viewers < 10 (viewers = 5)`,
+	}
+	if ok || strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Explain = %v\n%s\nwant false\n%s", ok, strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Every condition is explained, even after the result is known
+	ok, lines = rule.Explain(&Stream{Game: "Celeste", Viewers: 500})
+	if ok || len(lines) != len(want) {
+		t.Errorf("Explain = %v with %d lines, want false with %d", ok, len(lines), len(want))
+	}
+	if !rule.Match(&Stream{Game: "Just Chatting", Viewers: 500}) {
+		t.Error("rule doesn't match a safe Just Chatting stream with 500 viewers")
+	}
+}
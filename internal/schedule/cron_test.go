@@ -0,0 +1,221 @@
+package schedule
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// values lists the values of a bit set between min and max
+func values(set uint64, min, max int) []int {
+	var out []int
+	for v := min; v <= max; v++ {
+		if set&(1<<uint(v)) != 0 {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+func TestParseField(t *testing.T) {
+	tests := []struct {
+		field    string
+		min, max int
+		want     []int
+	}{
+		{"*", 0, 6, []int{0, 1, 2, 3, 4, 5, 6}},
+		{"5", 0, 59, []int{5}},
+		{"1,15,30", 1, 31, []int{1, 15, 30}},
+		{"8-12", 0, 23, []int{8, 9, 10, 11, 12}},
+		{"*/15", 0, 59, []int{0, 15, 30, 45}},
+		{"8-18/4", 0, 23, []int{8, 12, 16}},
+		{"5/10", 0, 59, []int{5, 15, 25, 35, 45, 55}},
+		{"1/5", 1, 12, []int{1, 6, 11}},
+		{"0,30/10", 0, 59, []int{0, 30, 40, 50}},
+	}
+	for _, tt := range tests {
+		set, err := parseField(tt.field, tt.min, tt.max)
+		if err != nil {
+			t.Errorf("parseField(%q): %v", tt.field, err)
+			continue
+		}
+		if got := values(set, tt.min, tt.max); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("parseField(%q) = %v, want %v", tt.field, got, tt.want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	c, err := Parse("  @Weekly ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.String() != "@Weekly" || fmt.Sprint(values(c.dow, 0, 7)) != "[0]" || fmt.Sprint(values(c.hour, 0, 23)) != "[0]" {
+		t.Errorf("@weekly = %q, days %v, hours %v", c, values(c.dow, 0, 7), values(c.hour, 0, 23))
+	}
+
+	// 7 is Sunday too
+	c, err = Parse("0 9 * * 5-7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := values(c.dow, 0, 6); fmt.Sprint(got) != "[0 5 6]" {
+		t.Errorf("days of week of 5-7 = %v, want [0 5 6]", got)
+	}
+
+	errors := []struct {
+		expr, want string
+	}{
+		{"", "expected 5 fields"},
+		{"0 9 * *", "expected 5 fields"},
+		{"0 9 * * * *", "expected 5 fields"},
+		{"60 * * * *", "invalid minute field"},
+		{"* 24 * * *", "invalid hour field"},
+		{"* * 0 * *", "invalid day-of-month field"},
+		{"* * * 13 *", "invalid month field"},
+		{"* * * * 8", "invalid day-of-week field"},
+		{"*/0 * * * *", `invalid step in "*/0"`},
+		{"*/x * * * *", `invalid step in "*/x"`},
+		{"5-1 * * * *", `"5-1" is out of range 0-59`},
+		{"a-5 * * * *", `invalid range "a-5"`},
+		{"mon * * * *", `invalid value "mon"`},
+		{"@yearly", "expected 5 fields"},
+	}
+	for _, tt := range errors {
+		if _, err := Parse(tt.expr); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Parse(%q) error = %v, want %q", tt.expr, err, tt.want)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	tests := []struct {
+		expr, from string
+		want       []string // next occurrences, in order
+	}{
+		{"*/15 * * * *", "2024-05-04 10:07:30", []string{"2024-05-04 10:15", "2024-05-04 10:30"}},
+		{"0 * * * *", "2024-05-04 10:00:00", []string{"2024-05-04 11:00", "2024-05-04 12:00"}},
+		{"5/20 9 * * *", "2024-05-04 09:30:00", []string{"2024-05-04 09:45", "2024-05-05 09:05"}},
+		{"30 18 * * 1-5", "2024-05-03 19:00:00", []string{"2024-05-06 18:30", "2024-05-07 18:30"}}, // Friday evening
+		{"0 12 * * 7", "2024-05-04 00:00:00", []string{"2024-05-05 12:00", "2024-05-12 12:00"}},    // Sunday as 7
+		{"@monthly", "2024-01-31 12:00:00", []string{"2024-02-01 00:00", "2024-03-01 00:00"}},
+		{"0 0 31 * *", "2024-01-31 00:00:00", []string{"2024-03-31 00:00", "2024-05-31 00:00"}},
+		{"0 0 29 2 *", "2023-01-01 00:00:00", []string{"2024-02-29 00:00", "2028-02-29 00:00"}},
+
+		// With both days restricted, either one matching is enough: the 13th or any Friday
+		{"0 8 13 * 5", "2024-09-10 00:00:00", []string{"2024-09-13 08:00", "2024-09-20 08:00", "2024-09-27 08:00", "2024-10-04 08:00", "2024-10-11 08:00", "2024-10-13 08:00"}},
+		// With only one restricted, it alone decides
+		{"0 8 13 * *", "2024-09-10 00:00:00", []string{"2024-09-13 08:00", "2024-10-13 08:00"}},
+		{"0 8 * * 5", "2024-09-10 00:00:00", []string{"2024-09-13 08:00", "2024-09-20 08:00"}},
+		// A day field starting with * doesn't restrict: odd days that are Fridays
+		{"0 8 */2 * 5", "2024-09-01 00:00:00", []string{"2024-09-13 08:00", "2024-09-27 08:00", "2024-10-11 08:00"}},
+	}
+	for _, tt := range tests {
+		c, err := Parse(tt.expr)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.expr, err)
+			continue
+		}
+		from, err := time.ParseInLocation("2006-01-02 15:04:05", tt.from, time.UTC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for range tt.want {
+			from = c.Next(from)
+			got = append(got, from.Format("2006-01-02 15:04"))
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%q from %s = %v, want %v", tt.expr, tt.from, got, tt.want)
+		}
+	}
+}
+
+func TestNextImpossible(t *testing.T) {
+	for _, expr := range []string{"0 0 30 2 *", "0 0 31 4,6,9,11 *"} {
+		c, err := Parse(expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if next := c.Next(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)); !next.IsZero() {
+			t.Errorf("%q matched %s, want the zero time", expr, next)
+		}
+	}
+}
+
+func TestNextDST(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skipf("no timezone database: %v", err)
+	}
+	format := "2006-01-02 15:04 MST"
+	tests := []struct {
+		name, expr string
+		from       time.Time
+		want       []string
+	}{
+		{
+			// 2024-03-31: 02:00 CET becomes 03:00 CEST, 02:30 doesn't exist that day
+			name: "skipped time",
+			expr: "30 2 * * *",
+			from: time.Date(2024, 3, 30, 12, 0, 0, 0, paris),
+			want: []string{"2024-04-01 02:30 CEST", "2024-04-02 02:30 CEST"},
+		},
+		{
+			name: "hourly across the skipped hour",
+			expr: "0 * * * *",
+			from: time.Date(2024, 3, 31, 0, 30, 0, 0, paris),
+			want: []string{"2024-03-31 01:00 CET", "2024-03-31 03:00 CEST", "2024-03-31 04:00 CEST"},
+		},
+		{
+			name: "daily in the skipped day",
+			expr: "0 9 * * *",
+			from: time.Date(2024, 3, 30, 9, 0, 0, 0, paris),
+			want: []string{"2024-03-31 09:00 CEST", "2024-04-01 09:00 CEST"},
+		},
+		{
+			// 2024-10-27: 03:00 CEST becomes 02:00 CET, 02:30 happens twice but matches once
+			name: "repeated time",
+			expr: "30 2 * * *",
+			from: time.Date(2024, 10, 27, 0, 0, 0, 0, paris),
+			want: []string{"2024-10-27 02:30 CEST", "2024-10-28 02:30 CET"},
+		},
+		{
+			// Not again at 02:30 CET, e.g. for the next digest after the one sent at 02:30 CEST
+			name: "after a repeated time",
+			expr: "30 2 * * *",
+			from: time.Date(2024, 10, 27, 0, 30, 0, 0, time.UTC).In(paris),
+			want: []string{"2024-10-28 02:30 CET"},
+		},
+		{
+			name: "every 20 minutes across the repeated hour",
+			expr: "*/20 * * * *",
+			from: time.Date(2024, 10, 27, 0, 30, 0, 0, time.UTC).In(paris),
+			want: []string{"2024-10-27 02:40 CEST", "2024-10-27 03:00 CET"},
+		},
+		{
+			name: "daily in the repeated day",
+			expr: "0 9 * * *",
+			from: time.Date(2024, 10, 26, 9, 0, 0, 0, paris),
+			want: []string{"2024-10-27 09:00 CET", "2024-10-28 09:00 CET"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := Parse(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			from := tt.from
+			var got []string
+			for range tt.want {
+				from = c.Next(from)
+				got = append(got, from.Format(format))
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("%q from %s = %v, want %v", tt.expr, tt.from.Format(format), got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package settings
+
+import (
+	"time"
+
+	"github.com/flthibaud/TwitchLiveNotifier/internal/quiet"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/storage"
+)
+
+const guildBucket = "guilds"
+
+// Guild holds the settings of a Discord guild
+type Guild struct {
+	Language   string      `json:"language,omitempty"`     // language of announcements and bot messages
+	LiveRoleID string      `json:"live_role_id,omitempty"` // role given to linked streamers while they are live
+	Timezone   string      `json:"timezone,omitempty"`     // IANA timezone of the guild (e.g. Europe/Paris)
+	QuietHours quiet.Hours `json:"quiet_hours"`            // windows during which announcements are held back
+	Digest     Digest      `json:"digest"`                 // periodic summary of the streams
+}
+
+// Digest configures the periodic summary of the streams posted in a guild
+type Digest struct {
+	ChannelID string    `json:"channel_id,omitempty"` // channel of the digest, empty when disabled
+	Schedule  string    `json:"schedule,omitempty"`   // cron expression, in the timezone of the guild
+	Period    string    `json:"period,omitempty"`     // "day" or "week", the time span summarized
+	LastRun   time.Time `json:"last_run"`             // time of the last scheduled digest
+}
+
+// Location returns the timezone of the guild, the local timezone of the bot when not set
+func (g *Guild) Location() *time.Location {
+	if g.Timezone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(g.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// Manager reads and updates guild settings
+type Manager struct {
+	store *storage.Store
+}
+
+// NewManager creates a settings manager backed by the given store
+func NewManager(store *storage.Store) *Manager {
+	return &Manager{store: store}
+}
+
+// Guild returns the settings of a guild (zero values when not configured)
+func (m *Manager) Guild(guildID string) (Guild, error) {
+	var g Guild
+	_, err := m.store.Get(guildBucket, guildID, &g)
+	return g, err
+}
+
+// GuildIDs returns the IDs of the guilds having settings
+func (m *Manager) GuildIDs() []string {
+	return m.store.Keys(guildBucket)
+}
+
+// UpdateGuild loads the settings of a guild, applies fn and saves the result
+func (m *Manager) UpdateGuild(guildID string, fn func(g *Guild)) (Guild, error) {
+	var g Guild
+	err := m.store.Update(guildBucket, guildID, &g, func(bool) error {
+		fn(&g)
+		return nil
+	})
+	return g, err
+}
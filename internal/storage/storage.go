@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Store is a small key/value store organised in buckets and persisted as a JSON file.
+// Values are JSON encoded, so any serializable type can be stored.
+type Store struct {
+	path string
+	mu   sync.RWMutex
+	data map[string]map[string]json.RawMessage
+}
+
+// Open loads the store from path, creating it on first write.
+// An empty path returns an in-memory store.
+func Open(path string) (*Store, error) {
+	s := &Store{
+		path: path,
+		data: make(map[string]map[string]json.RawMessage),
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	raw, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read store: %w", err)
+	}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &s.data); err != nil {
+			return nil, fmt.Errorf("cannot decode store %s: %w", path, err)
+		}
+	}
+	return s, nil
+}
+
+// Get decodes the value stored under bucket/key into v. It reports whether the key exists.
+func (s *Store) Get(bucket, key string, v interface{}) (bool, error) {
+	s.mu.RLock()
+	raw, ok := s.data[bucket][key]
+	s.mu.RUnlock()
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(raw, v)
+}
+
+// Put stores v under bucket/key and persists the store
+func (s *Store) Put(bucket, key string, v interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data[bucket] == nil {
+		s.data[bucket] = make(map[string]json.RawMessage)
+	}
+	s.data[bucket][key] = raw
+	return s.save()
+}
+
+// Delete removes bucket/key and persists the store
+func (s *Store) Delete(bucket, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.data[bucket][key]; !ok {
+		return nil
+	}
+	delete(s.data[bucket], key)
+	return s.save()
+}
+
+// ErrDelete is returned by an Update function to delete the key instead of storing the value
+var ErrDelete = errors.New("delete key")
+
+// Update decodes the value stored under bucket/key into v, calls fn and stores v back, as one
+// atomic operation: concurrent updates of the same store can't overwrite each other.
+// v keeps its initial value when the key doesn't exist. When fn returns ErrDelete the key is
+// deleted, any other error aborts the update. fn must not call the store.
+func (s *Store) Update(bucket, key string, v interface{}, fn func(exists bool) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	raw, ok := s.data[bucket][key]
+	if ok {
+		if err := json.Unmarshal(raw, v); err != nil {
+			return err
+		}
+	}
+
+	err := fn(ok)
+	if errors.Is(err, ErrDelete) {
+		if !ok {
+			return nil
+		}
+		delete(s.data[bucket], key)
+		return s.save()
+	}
+	if err != nil {
+		return err
+	}
+
+	if raw, err = json.Marshal(v); err != nil {
+		return err
+	}
+	if s.data[bucket] == nil {
+		s.data[bucket] = make(map[string]json.RawMessage)
+	}
+	s.data[bucket][key] = raw
+	return s.save()
+}
+
+// Keys returns the sorted keys of a bucket
+func (s *Store) Keys(bucket string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]string, 0, len(s.data[bucket]))
+	for k := range s.data[bucket] {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Each calls fn with the raw value of every key of a bucket, in key order
+func (s *Store) Each(bucket string, fn func(key string, raw json.RawMessage) error) error {
+	for _, key := range s.Keys(bucket) {
+		s.mu.RLock()
+		raw, ok := s.data[bucket][key]
+		s.mu.RUnlock()
+		if !ok {
+			continue
+		}
+		if err := fn(key, raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// save writes the store atomically (temporary file + rename). Caller must hold the lock.
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+	raw, err := json.MarshalIndent(s.data, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return fmt.Errorf("cannot create store directory: %w", err)
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0o600); err != nil {
+		return fmt.Errorf("cannot write store: %w", err)
+	}
+	return os.Rename(tmp, s.path)
+}
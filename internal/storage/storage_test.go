@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func openTestStore(t *testing.T) (*Store, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "store.json")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, path
+}
+
+func TestUpdate(t *testing.T) {
+	s, path := openTestStore(t)
+
+	var created bool
+	err := s.Update("bucket", "key", &[]string{}, func(exists bool) error {
+		created = !exists
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created {
+		t.Error("missing key reported as existing")
+	}
+
+	var list []string
+	if err := s.Update("bucket", "key", &list, func(exists bool) error {
+		if !exists {
+			t.Error("stored key reported as missing")
+		}
+		list = append(list, "a")
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	failure := errors.New("failure")
+	if err := s.Update("bucket", "key", &list, func(bool) error {
+		list = append(list, "b")
+		return failure
+	}); !errors.Is(err, failure) {
+		t.Errorf("Update() error = %v, want %v", err, failure)
+	}
+
+	// The value is persisted, without the aborted update
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	if ok, err := reopened.Get("bucket", "key", &got); !ok || err != nil {
+		t.Fatalf("Get() = %v, %v, want the stored value", ok, err)
+	}
+	if len(got) != 1 || got[0] != "a" {
+		t.Errorf("stored value = %v, want [a]", got)
+	}
+
+	if err := s.Update("bucket", "key", &list, func(bool) error { return ErrDelete }); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := s.Get("bucket", "key", &got); ok {
+		t.Error("key not deleted")
+	}
+	if err := s.Update("bucket", "missing", &list, func(bool) error { return ErrDelete }); err != nil {
+		t.Errorf("deleting a missing key: %v", err)
+	}
+}
+
+func TestConcurrentUpdates(t *testing.T) {
+	// Saving the store to a file makes every write slow enough for lost updates to show
+	s, _ := openTestStore(t)
+	const n = 50
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			values := map[string]int{}
+			if err := s.Update("bucket", "key", &values, func(bool) error {
+				values[strconv.Itoa(i)] = i
+				return nil
+			}); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	var values map[string]int
+	if _, err := s.Get("bucket", "key", &values); err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != n {
+		t.Errorf("value has %d entries, want %d: updates were lost", len(values), n)
+	}
+}
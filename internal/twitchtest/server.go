@@ -0,0 +1,765 @@
+// Package twitchtest runs a fake Twitch Helix API and OAuth server, for tests of the code calling Twitch.
+//
+// The server issues app tokens for one set of client credentials and serves /users, /streams, /schedule,
+// /clips and /eventsub/subscriptions from its state, with the Ratelimit-* headers of Helix. Failures can be
+// injected per endpoint, and new webhook subscriptions are verified with a signed challenge like Twitch does.
+package twitchtest
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Default client credentials accepted by the server
+const (
+	ClientID     = "test-client-id"
+	ClientSecret = "test-client-secret"
+)
+
+// Subscription statuses
+const (
+	StatusEnabled             = "enabled"
+	StatusVerificationPending = "webhook_callback_verification_pending"
+	StatusVerificationFailed  = "webhook_callback_verification_failed"
+)
+
+// User is a Twitch user known by the server
+type User struct {
+	ID          string `json:"id"`
+	Login       string `json:"login"`
+	DisplayName string `json:"display_name"`
+}
+
+// Stream is a live stream of a user
+type Stream struct {
+	ID          string    `json:"id"`
+	UserID      string    `json:"user_id"`
+	UserLogin   string    `json:"user_login"`
+	UserName    string    `json:"user_name"`
+	GameID      string    `json:"game_id"`
+	GameName    string    `json:"game_name"`
+	Type        string    `json:"type"`
+	Title       string    `json:"title"`
+	ViewerCount int       `json:"viewer_count"`
+	StartedAt   time.Time `json:"started_at"`
+	Language    string    `json:"language"`
+}
+
+// Segment is a stream planned in the schedule of a user
+type Segment struct {
+	ID            string     `json:"id"`
+	StartTime     time.Time  `json:"start_time"`
+	EndTime       *time.Time `json:"end_time"`
+	Title         string     `json:"title"`
+	CanceledUntil *time.Time `json:"canceled_until"`
+	Category      *Category  `json:"category"`
+	IsRecurring   bool       `json:"is_recurring"`
+}
+
+// Category is the game of a schedule segment
+type Category struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// Clip is a clip of a broadcaster
+type Clip struct {
+	ID              string    `json:"id"`
+	URL             string    `json:"url"`
+	BroadcasterID   string    `json:"broadcaster_id"`
+	BroadcasterName string    `json:"broadcaster_name"`
+	CreatorName     string    `json:"creator_name"`
+	Title           string    `json:"title"`
+	ViewCount       int       `json:"view_count"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// Transport is the delivery method of a subscription
+type Transport struct {
+	Method   string `json:"method"`
+	Callback string `json:"callback,omitempty"`
+	Secret   string `json:"-"` // never returned by Helix
+}
+
+// Subscription is an EventSub subscription held by the server
+type Subscription struct {
+	ID        string            `json:"id"`
+	Status    string            `json:"status"`
+	Type      string            `json:"type"`
+	Version   string            `json:"version"`
+	Cost      int               `json:"cost"`
+	Condition map[string]string `json:"condition"`
+	Transport Transport         `json:"transport"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// failure is an error answered instead of the response of an endpoint
+type failure struct {
+	method, path string
+	status       int
+	times        int // remaining failures, not positive to fail until cleared
+}
+
+// Server is a fake Twitch API. Its URL is the base of both AuthURL (URL + "/oauth2")
+// and HelixURL (URL + "/helix").
+type Server struct {
+	*httptest.Server
+
+	// PageSize is the number of subscriptions per page of /eventsub/subscriptions
+	PageSize int
+	// MaxTotalCost is the cost limit of the subscriptions of the application
+	MaxTotalCost int
+	// VerifyCallbacks sends a signed challenge to the callback of the created webhook subscriptions,
+	// which are enabled when the callback answers it. Otherwise they stay pending.
+	VerifyCallbacks bool
+
+	mu        sync.Mutex
+	users     map[string]User
+	streams   map[string]Stream
+	schedules map[string][]Segment // by broadcaster ID
+	clips     []Clip
+	subs      []*Subscription
+	tokens    map[string]bool
+	failures  []*failure
+	requests  []string
+	rateLimit int // requests per window, 0 for no limit
+	remaining int
+	verifying sync.WaitGroup
+}
+
+// NewServer starts a fake Twitch API, closed at the end of the test
+func NewServer(tb testing.TB) *Server {
+	s := &Server{
+		PageSize:        100,
+		MaxTotalCost:    10000,
+		VerifyCallbacks: true,
+		users:           map[string]User{},
+		streams:         map[string]Stream{},
+		schedules:       map[string][]Segment{},
+		tokens:          map[string]bool{},
+	}
+	s.Server = httptest.NewServer(s)
+	tb.Cleanup(func() {
+		s.verifying.Wait()
+		s.Close()
+	})
+	return s
+}
+
+// AuthURL returns the base URL of the OAuth endpoints
+func (s *Server) AuthURL() string {
+	return s.URL + "/oauth2"
+}
+
+// HelixURL returns the base URL of the Helix API
+func (s *Server) HelixURL() string {
+	return s.URL + "/helix"
+}
+
+// AddUser adds a user to the server
+func (s *Server) AddUser(u User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[u.ID] = u
+}
+
+// SetLive starts a stream of its user, replacing the current one
+func (s *Server) SetLive(st Stream) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if st.ID == "" {
+		st.ID = randomDigits()
+	}
+	if st.Type == "" {
+		st.Type = "live"
+	}
+	if st.StartedAt.IsZero() {
+		st.StartedAt = time.Now().UTC().Truncate(time.Second)
+	}
+	s.streams[st.UserID] = st
+}
+
+// SetOffline ends the stream of a user
+func (s *Server) SetOffline(userID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.streams, userID)
+}
+
+// SetSchedule replaces the schedule of a user. A user without schedule is answered with 404 Not Found, like Twitch does.
+func (s *Server) SetSchedule(userID string, segments []Segment) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.schedules[userID] = append([]Segment(nil), segments...)
+}
+
+// AddClip adds a clip, its URL is set when empty
+func (s *Server) AddClip(c Clip) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c.URL == "" {
+		c.URL = "https://clips.twitch.tv/" + c.ID
+	}
+	s.clips = append(s.clips, c)
+}
+
+// AddSubscription adds an existing subscription, e.g. left by a previous run. The ID, status,
+// version and creation date are set when empty. It returns the added subscription.
+func (s *Server) AddSubscription(sub Subscription) Subscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sub.ID == "" {
+		sub.ID = randomID()
+	}
+	if sub.Status == "" {
+		sub.Status = StatusEnabled
+	}
+	if sub.Version == "" {
+		sub.Version = "1"
+	}
+	if sub.Transport.Method == "" {
+		sub.Transport.Method = "webhook"
+	}
+	if sub.CreatedAt.IsZero() {
+		sub.CreatedAt = time.Now().UTC()
+	}
+	s.subs = append(s.subs, &sub)
+	return sub
+}
+
+// Subscriptions returns the subscriptions of the server, in creation order
+func (s *Server) Subscriptions() []Subscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	subs := make([]Subscription, len(s.subs))
+	for n, sub := range s.subs {
+		subs[n] = *sub
+	}
+	return subs
+}
+
+// WaitVerified waits for the challenges sent to the callbacks of the created subscriptions
+func (s *Server) WaitVerified() {
+	s.verifying.Wait()
+}
+
+// SetRateLimit limits the number of Helix requests, answered with 429 Too Many Requests once exhausted.
+// 0 removes the limit.
+func (s *Server) SetRateLimit(limit int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rateLimit, s.remaining = limit, limit
+}
+
+// Fail answers the next times requests of method on path (e.g. "/helix/streams") with status,
+// or every request until ClearFailures when times is not positive
+func (s *Server) Fail(method, path string, status, times int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, &failure{method: method, path: path, status: status, times: times})
+}
+
+// ClearFailures removes the failures added by Fail
+func (s *Server) ClearFailures() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = nil
+}
+
+// Requests returns the requests received by the server, as "METHOD /path?query"
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.RequestURI())
+	status := s.injectedFailure(r)
+	s.mu.Unlock()
+	if status != 0 {
+		writeError(w, status, "injected failure")
+		return
+	}
+
+	switch r.URL.Path {
+	case "/oauth2/token":
+		s.handleToken(w, r)
+		return
+	case "/oauth2/revoke":
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, "/helix/") {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if !s.authorized(r) {
+		writeError(w, http.StatusUnauthorized, "invalid access token")
+		return
+	}
+	if !s.takeRequest(w) {
+		writeError(w, http.StatusTooManyRequests, "too many requests")
+		return
+	}
+	switch r.URL.Path {
+	case "/helix/users":
+		s.handleUsers(w, r)
+	case "/helix/streams":
+		s.handleStreams(w, r)
+	case "/helix/schedule":
+		s.handleSchedule(w, r)
+	case "/helix/clips":
+		s.handleClips(w, r)
+	case "/helix/eventsub/subscriptions":
+		switch r.Method {
+		case "GET":
+			s.handleListSubscriptions(w, r)
+		case "POST":
+			s.handleCreateSubscription(w, r)
+		case "DELETE":
+			s.handleDeleteSubscription(w, r)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// injectedFailure returns the status of the failure matching a request, or 0
+func (s *Server) injectedFailure(r *http.Request) int {
+	for n, f := range s.failures {
+		if f.method != r.Method || f.path != r.URL.Path {
+			continue
+		}
+		if f.times > 0 {
+			f.times--
+			if f.times == 0 {
+				s.failures = append(s.failures[:n], s.failures[n+1:]...)
+			}
+		}
+		return f.status
+	}
+	return 0
+}
+
+// authorized reports whether a Helix request carries the client ID and a token issued by the server
+func (s *Server) authorized(r *http.Request) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return r.Header.Get("Client-Id") == ClientID && s.tokens[token]
+}
+
+// takeRequest counts a Helix request against the rate limit and sets the Ratelimit-* headers.
+// It reports whether the request is allowed.
+func (s *Server) takeRequest(w http.ResponseWriter) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	limit, remaining := s.rateLimit, s.remaining
+	if limit == 0 {
+		limit, remaining = 800, 800
+	}
+	allowed := remaining > 0
+	if allowed && s.rateLimit > 0 {
+		s.remaining--
+		remaining--
+	}
+	w.Header().Set("Ratelimit-Limit", strconv.Itoa(limit))
+	w.Header().Set("Ratelimit-Remaining", strconv.Itoa(remaining))
+	w.Header().Set("Ratelimit-Reset", strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10))
+	return allowed
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	if r.Form.Get("client_id") != ClientID || r.Form.Get("client_secret") != ClientSecret {
+		writeError(w, http.StatusForbidden, "invalid client secret")
+		return
+	}
+	if grant := r.Form.Get("grant_type"); grant != "client_credentials" {
+		writeError(w, http.StatusBadRequest, "unsupported grant type "+grant)
+		return
+	}
+	token := "app-" + randomID()
+	s.mu.Lock()
+	s.tokens[token] = true
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": token,
+		"expires_in":   5000000,
+		"token_type":   "bearer",
+	})
+}
+
+func (s *Server) handleUsers(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	s.mu.Lock()
+	users := []User{}
+	for _, u := range s.sortedUsers() {
+		if contains(q["id"], u.ID) || contains(q["login"], u.Login) {
+			users = append(users, u)
+		}
+	}
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": users})
+}
+
+func (s *Server) handleStreams(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	s.mu.Lock()
+	streams := []Stream{}
+	for _, st := range s.streams {
+		if len(q["user_id"]) > 0 && !contains(q["user_id"], st.UserID) {
+			continue
+		}
+		if len(q["user_login"]) > 0 && !contains(q["user_login"], st.UserLogin) {
+			continue
+		}
+		if len(q["game_id"]) > 0 && !contains(q["game_id"], st.GameID) {
+			continue
+		}
+		streams = append(streams, st)
+	}
+	s.mu.Unlock()
+	sort.Slice(streams, func(i, j int) bool { return streams[i].UserID < streams[j].UserID })
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": streams, "pagination": map[string]string{}})
+}
+
+func (s *Server) handleSchedule(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	start := time.Now()
+	if v := q.Get("start_time"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid start_time")
+			return
+		}
+		start = t
+	}
+	id := q.Get("broadcaster_id")
+	s.mu.Lock()
+	schedule, ok := s.schedules[id]
+	user := s.users[id]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "segments were either not found or canceled")
+		return
+	}
+	segments := []Segment{}
+	for _, seg := range schedule {
+		if seg.EndTime == nil || seg.EndTime.After(start) {
+			segments = append(segments, seg)
+		}
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].StartTime.Before(segments[j].StartTime) })
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data": map[string]interface{}{
+			"segments":          segments,
+			"broadcaster_id":    id,
+			"broadcaster_name":  user.DisplayName,
+			"broadcaster_login": user.Login,
+		},
+		"pagination": map[string]string{},
+	})
+}
+
+func (s *Server) handleClips(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var start, end time.Time
+	if v := q.Get("started_at"); v != "" {
+		start, _ = time.Parse(time.RFC3339, v)
+	}
+	if v := q.Get("ended_at"); v != "" {
+		end, _ = time.Parse(time.RFC3339, v)
+	}
+	s.mu.Lock()
+	clips := []Clip{}
+	for _, c := range s.clips {
+		if c.BroadcasterID != q.Get("broadcaster_id") || c.CreatedAt.Before(start) || (!end.IsZero() && c.CreatedAt.After(end)) {
+			continue
+		}
+		clips = append(clips, c)
+	}
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": clips, "pagination": map[string]string{}})
+}
+
+func (s *Server) handleListSubscriptions(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	s.mu.Lock()
+	var matching []Subscription
+	for _, sub := range s.subs {
+		if t := q.Get("type"); t != "" && sub.Type != t {
+			continue
+		}
+		if st := q.Get("status"); st != "" && sub.Status != st {
+			continue
+		}
+		if id := q.Get("condition[broadcaster_user_id]"); id != "" && sub.Condition["broadcaster_user_id"] != id {
+			continue
+		}
+		if id := q.Get("user_id"); id != "" && !hasUser(sub.Condition, id) {
+			continue
+		}
+		matching = append(matching, *sub)
+	}
+	total, totalCost := len(s.subs), 0
+	for _, sub := range s.subs {
+		totalCost += sub.Cost
+	}
+	pageSize, maxCost := s.PageSize, s.MaxTotalCost
+	s.mu.Unlock()
+
+	// The cursor is the index of the first subscription of the page
+	start := 0
+	if after := q.Get("after"); after != "" {
+		n, err := strconv.Atoi(after)
+		if err != nil || n < 0 || n > len(matching) {
+			writeError(w, http.StatusBadRequest, "invalid cursor")
+			return
+		}
+		start = n
+	}
+	end := start + pageSize
+	pagination := map[string]string{}
+	if end < len(matching) {
+		pagination["cursor"] = strconv.Itoa(end)
+	} else {
+		end = len(matching)
+	}
+	data := append([]Subscription{}, matching[start:end]...)
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data":           data,
+		"total":          total,
+		"total_cost":     totalCost,
+		"max_total_cost": maxCost,
+		"pagination":     pagination,
+	})
+}
+
+func (s *Server) handleCreateSubscription(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Type      string            `json:"type"`
+		Version   string            `json:"version"`
+		Condition map[string]string `json:"condition"`
+		Transport struct {
+			Method   string `json:"method"`
+			Callback string `json:"callback"`
+			Secret   string `json:"secret"`
+		} `json:"transport"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid body: "+err.Error())
+		return
+	}
+	switch {
+	case body.Type == "" || body.Version == "" || len(body.Condition) == 0:
+		writeError(w, http.StatusBadRequest, "type, version and condition are required")
+		return
+	case body.Transport.Method != "webhook" || body.Transport.Callback == "":
+		writeError(w, http.StatusBadRequest, "only webhook transports with a callback are supported")
+		return
+	case len(body.Transport.Secret) < 10 || len(body.Transport.Secret) > 100:
+		writeError(w, http.StatusBadRequest, "the secret must be between 10 and 100 characters")
+		return
+	}
+
+	s.mu.Lock()
+	for _, sub := range s.subs {
+		if sub.Type == body.Type && sub.Transport.Callback == body.Transport.Callback && sameCondition(sub.Condition, body.Condition) {
+			s.mu.Unlock()
+			writeError(w, http.StatusConflict, "subscription already exists")
+			return
+		}
+	}
+	sub := &Subscription{
+		ID:        randomID(),
+		Status:    StatusVerificationPending,
+		Type:      body.Type,
+		Version:   body.Version,
+		Cost:      1,
+		Condition: body.Condition,
+		Transport: Transport{Method: "webhook", Callback: body.Transport.Callback, Secret: body.Transport.Secret},
+		CreatedAt: time.Now().UTC(),
+	}
+	s.subs = append(s.subs, sub)
+	created := *sub
+	total, totalCost := len(s.subs), 0
+	for _, sub := range s.subs {
+		totalCost += sub.Cost
+	}
+	if s.VerifyCallbacks {
+		// Twitch verifies the callback once the subscription is created
+		s.verifying.Add(1)
+		go s.verify(created)
+	}
+	maxCost := s.MaxTotalCost
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{
+		"data":           []Subscription{created},
+		"total":          total,
+		"total_cost":     totalCost,
+		"max_total_cost": maxCost,
+	})
+}
+
+func (s *Server) handleDeleteSubscription(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		writeError(w, http.StatusBadRequest, "missing required parameter id")
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for n, sub := range s.subs {
+		if sub.ID == id {
+			s.subs = append(s.subs[:n], s.subs[n+1:]...)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "subscription not found")
+}
+
+// verify sends the verification challenge of a subscription to its callback, and enables it when
+// the callback answers the challenge
+func (s *Server) verify(sub Subscription) {
+	defer s.verifying.Done()
+	challenge := randomID()
+	status := StatusVerificationFailed
+	resp, err := s.Send(sub, "webhook_callback_verification", map[string]interface{}{"challenge": challenge})
+	if err == nil {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK && string(body) == challenge {
+			status = StatusEnabled
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, cur := range s.subs {
+		if cur.ID == sub.ID {
+			cur.Status = status
+		}
+	}
+}
+
+// Send posts a message of a subscription to its callback, signed with its secret, with the fields
+// of payload besides the subscription (e.g. event or challenge)
+func (s *Server) Send(sub Subscription, messageType string, payload map[string]interface{}) (*http.Response, error) {
+	fields := map[string]interface{}{"subscription": sub}
+	for k, v := range payload {
+		fields[k] = v
+	}
+	body, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	msgID := randomID()
+	timestamp := time.Now().UTC().Format(time.RFC3339Nano)
+	mac := hmac.New(sha256.New, []byte(sub.Transport.Secret))
+	mac.Write([]byte(msgID + timestamp + string(body)))
+
+	req, err := http.NewRequest("POST", sub.Transport.Callback, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Twitch-Eventsub-Message-Id", msgID)
+	req.Header.Set("Twitch-Eventsub-Message-Retry", "0")
+	req.Header.Set("Twitch-Eventsub-Message-Type", messageType)
+	req.Header.Set("Twitch-Eventsub-Message-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	req.Header.Set("Twitch-Eventsub-Message-Timestamp", timestamp)
+	req.Header.Set("Twitch-Eventsub-Subscription-Type", sub.Type)
+	req.Header.Set("Twitch-Eventsub-Subscription-Version", sub.Version)
+	return (&http.Client{Timeout: 10 * time.Second}).Do(req)
+}
+
+// sortedUsers returns the users ordered by ID, for stable responses
+func (s *Server) sortedUsers() []User {
+	users := make([]User, 0, len(s.users))
+	for _, u := range s.users {
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users
+}
+
+// writeJSON writes a JSON response with a status
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes an error in the format of Helix
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"error":   http.StatusText(status),
+		"status":  status,
+		"message": message,
+	})
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// hasUser reports whether a condition refers to a user, as broadcaster, raider or user
+func hasUser(condition map[string]string, id string) bool {
+	for _, v := range condition {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+func sameCondition(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// randomID returns a random UUID-like identifier
+func randomID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// randomDigits returns a random numeric identifier, like the IDs of streams
+func randomDigits() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return strconv.FormatUint(uint64(binary.BigEndian.Uint32(b))+1e10, 10)
+}
@@ -0,0 +1,96 @@
+package twitchtest_test
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/flthibaud/TwitchLiveNotifier/internal/discord/twitch"
+	"github.com/flthibaud/TwitchLiveNotifier/internal/twitchtest"
+)
+
+func newClient(s *twitchtest.Server) *twitch.Client {
+	c := twitch.NewClient(twitchtest.ClientID, twitchtest.ClientSecret)
+	c.AuthURL = s.AuthURL()
+	c.HelixURL = s.HelixURL()
+	return c
+}
+
+func TestSubscriptionPages(t *testing.T) {
+	s := twitchtest.NewServer(t)
+	s.PageSize = 2
+	s.VerifyCallbacks = false
+	c := newClient(s)
+
+	for _, id := range []string{"1", "2", "3", "4", "5"} {
+		if _, err := c.CreateSubscription("stream.online", twitch.SubscriptionCondition("stream.online", id), "https://bot.example/webhook", "0123456789"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := c.CreateSubscription("stream.online", twitch.SubscriptionCondition("stream.online", "1"), "https://bot.example/webhook", "0123456789"); err == nil {
+		t.Error("duplicate subscription created")
+	}
+
+	list, err := c.Subscriptions(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Data) != 5 || list.Total != 5 || list.TotalCost != 5 {
+		t.Fatalf("listed %d subscriptions, total %d, cost %d", len(list.Data), list.Total, list.TotalCost)
+	}
+	if list.Data[0].Status != twitchtest.StatusVerificationPending {
+		t.Errorf("status = %s, want pending without verification", list.Data[0].Status)
+	}
+
+	if err := c.DeleteSubscription(list.Data[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	filtered, err := c.Subscriptions(url.Values{"condition[broadcaster_user_id]": {"1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filtered.Data) != 0 {
+		t.Errorf("deleted subscription still listed: %+v", filtered.Data)
+	}
+}
+
+func TestRateLimitAndFailures(t *testing.T) {
+	s := twitchtest.NewServer(t)
+	s.AddUser(twitchtest.User{ID: "141981764", Login: "twitchdev", DisplayName: "TwitchDev"})
+	s.SetRateLimit(2)
+	c := newClient(s)
+
+	token, err := c.AppToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", s.HelixURL()+"/users?login=twitchdev", nil)
+	req.Header.Set("Client-Id", twitchtest.ClientID)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.Header.Get("Ratelimit-Limit") != "2" || resp.Header.Get("Ratelimit-Remaining") != "1" || resp.Header.Get("Ratelimit-Reset") == "" {
+		t.Errorf("rate limit headers = %v", resp.Header)
+	}
+
+	if users, err := c.GetUsers("", []string{"141981764"}, nil); err != nil || len(users) != 1 || users[0].Login != "twitchdev" {
+		t.Fatalf("GetUsers = %+v, %v", users, err)
+	}
+	var apiErr *twitch.APIError
+	if _, err := c.GetUsers("", nil, []string{"twitchdev"}); !errors.As(err, &apiErr) || apiErr.Status != http.StatusTooManyRequests {
+		t.Errorf("request over the limit: %v, want 429", err)
+	}
+
+	s.SetRateLimit(0)
+	s.Fail("GET", "/helix/streams", http.StatusServiceUnavailable, 1)
+	if _, err := c.GetStreamInfo("141981764"); !errors.As(err, &apiErr) || apiErr.Status != http.StatusServiceUnavailable {
+		t.Errorf("injected failure: %v, want 503", err)
+	}
+	if stream, err := c.GetStreamInfo("141981764"); err != nil || stream != nil {
+		t.Errorf("after the failure: %+v, %v, want offline", stream, err)
+	}
+}
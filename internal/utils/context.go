@@ -0,0 +1,25 @@
+package utils
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+)
+
+type loggerKey struct{}
+
+// WithLogger returns a copy of ctx carrying a logger entry, so the fields of a request
+// (message ID, broadcaster, guild, ...) follow it through the call chain
+func WithLogger(ctx context.Context, entry *logrus.Entry) context.Context {
+	return context.WithValue(ctx, loggerKey{}, entry)
+}
+
+// Log returns the logger entry carried by ctx, or an entry of fallback when there is none
+func Log(ctx context.Context, fallback *logrus.Logger) *logrus.Entry {
+	if ctx != nil {
+		if entry, ok := ctx.Value(loggerKey{}).(*logrus.Entry); ok {
+			return entry
+		}
+	}
+	return logrus.NewEntry(fallback)
+}
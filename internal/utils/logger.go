@@ -15,21 +15,33 @@ func NewLogger(cfg *config.Config) *logrus.Logger {
 	// Output to stdout for container-friendly logging
 	logger.Out = os.Stdout
 
-	// Set log level from environment or default to Info
-	if lvl, err := logrus.ParseLevel(os.Getenv("LOG_LEVEL")); err == nil {
-		logger.SetLevel(lvl)
+	// Set log level from the configuration or default to Info
+	SetLevel(logger, cfg.LogLevel)
+
+	// JSON for log collectors, text with timestamps otherwise
+	if cfg.LogFormat == config.LogFormatJSON {
+		logger.SetFormatter(&logrus.JSONFormatter{
+			TimestampFormat: time.RFC3339,
+		})
 	} else {
-		logger.SetLevel(logrus.InfoLevel)
+		logger.SetFormatter(&logrus.TextFormatter{
+			TimestampFormat: time.RFC3339,
+			FullTimestamp:   true,
+		})
 	}
 
-	// Use a text formatter with timestamps
-	logger.SetFormatter(&logrus.TextFormatter{
-		TimestampFormat: time.RFC3339,
-		FullTimestamp:   true,
-	})
-
-	// Example of using config values if needed (e.g., adding fields)
-	// logger = logger.WithField("app", cfg.AppName).Logger
+	// Never write credentials to the logs
+	logger.AddHook(NewRedactHook(cfg.BotToken, cfg.TwitchClientSecret, cfg.TwitchWebhookSecret, cfg.APIToken, cfg.DashboardToken))
 
 	return logger
 }
+
+// SetLevel sets the level of logger, Info when level is invalid. It can be called while
+// the logger is in use, e.g. when the configuration is reloaded.
+func SetLevel(logger *logrus.Logger, level string) {
+	if lvl, err := logrus.ParseLevel(level); err == nil {
+		logger.SetLevel(lvl)
+	} else {
+		logger.SetLevel(logrus.InfoLevel)
+	}
+}
@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// LogEntry is a log line kept by RecentErrors
+type LogEntry struct {
+	Time    time.Time         `json:"time"`
+	Level   string            `json:"level"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+// FieldList returns the fields of the entry as key=value, sorted by key
+func (e LogEntry) FieldList() []string {
+	list := make([]string, 0, len(e.Fields))
+	for key, value := range e.Fields {
+		list = append(list, key+"="+value)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// RecentErrors is a logrus hook keeping the last warnings and errors in memory, so they can be
+// shown without access to the logs. Add it after the RedactHook: it keeps the masked lines.
+type RecentErrors struct {
+	mu      sync.Mutex
+	size    int
+	entries []LogEntry
+}
+
+// NewRecentErrors creates a hook keeping the last size warnings and errors
+func NewRecentErrors(size int) *RecentErrors {
+	return &RecentErrors{size: size}
+}
+
+// Levels returns the warning and error levels
+func (h *RecentErrors) Levels() []logrus.Level {
+	return []logrus.Level{logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel, logrus.WarnLevel}
+}
+
+// Fire keeps the entry, dropping the oldest one when the buffer is full
+func (h *RecentErrors) Fire(e *logrus.Entry) error {
+	entry := LogEntry{Time: e.Time, Level: e.Level.String(), Message: e.Message}
+	if len(e.Data) > 0 {
+		entry.Fields = make(map[string]string, len(e.Data))
+		for key, value := range e.Data {
+			entry.Fields[key] = fmt.Sprint(value)
+		}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.entries = append(h.entries, entry)
+	if len(h.entries) > h.size {
+		h.entries = h.entries[len(h.entries)-h.size:]
+	}
+	return nil
+}
+
+// Entries returns the kept entries, newest first
+func (h *RecentErrors) Entries() []LogEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	entries := make([]LogEntry, len(h.entries))
+	for n, e := range h.entries {
+		entries[len(h.entries)-1-n] = e
+	}
+	return entries
+}
@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// redacted replaces the secrets masked in logs
+const redacted = "[REDACTED]"
+
+// secretPatterns match credentials wherever they appear: signatures, authorization headers,
+// Discord bot tokens, OAuth codes of callback URLs, and tokens or secrets in JSON bodies,
+// query strings and key=value pairs. Words like "bot" or "code" alone are left alone, they are
+// common in messages (e.g. the "code" of a Discord API error).
+var secretPatterns = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	{regexp.MustCompile(`sha256=[0-9a-fA-F]+`), "sha256=" + redacted},
+	{regexp.MustCompile(`(?i)\b(bearer +|oauth:|authorization\W{1,4}bot +)[A-Za-z0-9._~+/=-]{8,}`), "${1}" + redacted},
+	{regexp.MustCompile(`\b[A-Za-z0-9_-]{23,28}\.[A-Za-z0-9_-]{6,7}\.[A-Za-z0-9_-]{27,}`), redacted},
+	{regexp.MustCompile(`([?&]code=)[^&\s"]+`), "${1}" + redacted},
+	{regexp.MustCompile(`(?i)("?\b(access_token|refresh_token|client_secret|secret|token|password)"?\s*[:=]\s*"?)[^"&\s,}]+`), "${1}" + redacted},
+}
+
+// RedactHook masks secrets in the message and the fields of log entries: the configured
+// secret values, and anything looking like a token, a secret or a signature
+type RedactHook struct {
+	secrets []string
+}
+
+// NewRedactHook creates a hook masking the given secret values (short values are ignored,
+// they would mask unrelated text)
+func NewRedactHook(secrets ...string) *RedactHook {
+	h := &RedactHook{}
+	for _, s := range secrets {
+		if len(s) >= 8 {
+			h.secrets = append(h.secrets, s)
+		}
+	}
+	return h
+}
+
+// Levels returns every level, secrets must be masked in debug logs too
+func (h *RedactHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire masks the secrets of an entry before it is formatted
+func (h *RedactHook) Fire(e *logrus.Entry) error {
+	e.Message = h.Redact(e.Message)
+	for key, value := range e.Data {
+		switch v := value.(type) {
+		case string:
+			e.Data[key] = h.Redact(v)
+		case error:
+			e.Data[key] = h.Redact(v.Error())
+		}
+	}
+	return nil
+}
+
+// Redact masks the secrets of a string
+func (h *RedactHook) Redact(s string) string {
+	for _, secret := range h.secrets {
+		s = strings.ReplaceAll(s, secret, redacted)
+	}
+	for _, p := range secretPatterns {
+		s = p.re.ReplaceAllString(s, p.repl)
+	}
+	return s
+}
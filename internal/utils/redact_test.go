@@ -0,0 +1,33 @@
+package utils
+
+import "testing"
+
+func TestRedact(t *testing.T) {
+	h := NewRedactHook("configured-secret", "short")
+	tests := []struct {
+		in, want string
+	}{
+		{"signature sha256=0a1b2c3d4e", "signature sha256=[REDACTED]"},
+		{"Authorization: Bearer abcdefgh12345678", "Authorization: Bearer [REDACTED]"},
+		{"map[Authorization:[Bot MTIzNDU2Nzg5MDEyMzQ1Njc4.GaBcDe.abcdefghijklmnopqrstuvwxyz0123]]", "map[Authorization:[Bot [REDACTED]]]"},
+		{"token MTIzNDU2Nzg5MDEyMzQ1Njc4.GaBcDe.abcdefghijklmnopqrstuvwxyz0123 leaked", "token [REDACTED] leaked"},
+		{"PASS oauth:abcdefgh12345678", "PASS oauth:[REDACTED]"},
+		{"GET /oauth/callback?code=abc123&state=xyz", "GET /oauth/callback?code=[REDACTED]&state=xyz"},
+		{"GET /oauth/callback?state=xyz&code=abc123", "GET /oauth/callback?state=xyz&code=[REDACTED]"},
+		{`{"access_token": "abc", "refresh_token":"def"}`, `{"access_token": "[REDACTED]", "refresh_token":"[REDACTED]"}`},
+		{"client_secret=abc&grant_type=client_credentials", "client_secret=[REDACTED]&grant_type=client_credentials"},
+		{"failed with configured-secret", "failed with [REDACTED]"},
+
+		// Ordinary text is kept
+		{"bot announcement queued", "bot announcement queued"},
+		{"Bot connected, OAuth callback ready", "Bot connected, OAuth callback ready"},
+		{`HTTP 403 Forbidden, {"message": "Missing Permissions", "code": 50013}`, `HTTP 403 Forbidden, {"message": "Missing Permissions", "code": 50013}`},
+		{"exit code=2", "exit code=2"},
+		{"short words stay short", "short words stay short"},
+	}
+	for _, tt := range tests {
+		if got := h.Redact(tt.in); got != tt.want {
+			t.Errorf("Redact(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}